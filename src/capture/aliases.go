@@ -0,0 +1,74 @@
+package capture
+
+import "strings"
+
+/* Schema evolution */
+
+// renaming is the renaming of a field of a collector, in a version of its schema.
+type renaming struct {
+	version int
+	oldName string // without the kind suffix, as "cpu:user"
+	newName string
+}
+
+// renamings is keyed by collector, in increasing order of version. A field renamed must be added
+// here, with the version of the schema incremented, for the captures written with the old name
+// to be read with the new one, and for the old name to remain accepted where fields are named
+// (as in the transforms).
+var renamings = map[string][]renaming{
+	"cgroupstat": {
+		{1, "io:rbytes", "io:read_bytes"}, // as named by nfsdstat
+		{1, "io:wbytes", "io:write_bytes"},
+	},
+}
+
+// SchemaVersion returns the current version of the schema of the collector, 0 if its fields
+// were never renamed.
+func SchemaVersion(collector string) int {
+	list := renamings[collector]
+	if len(list) == 0 {
+		return 0
+	}
+	return list[len(list)-1].version
+}
+
+// Upgrade returns the schema with the names of the fields renamed since its version, in the
+// current version.
+func Upgrade(schema Schema) Schema {
+	upgraded := false
+	for _, r := range renamings[schema.Collector] {
+		if r.version <= schema.Version {
+			continue
+		}
+		if !upgraded {
+			schema = copyFields(schema)
+			upgraded = true
+		}
+		for i, field := range schema.Fields {
+			if strings.TrimSuffix(field, suffix(field)) == r.oldName {
+				schema.Fields[i] = r.newName + suffix(field)
+			}
+		}
+	}
+	schema.Version = SchemaVersion(schema.Collector)
+	return schema
+}
+
+// Aliases returns the names of a field of the collector, given without its kind suffix: its name
+// in the current version, followed by its names in the previous versions, most recent first.
+// A field never renamed has its name as only alias.
+func Aliases(collector, name string) []string {
+	list := renamings[collector]
+	for _, r := range list {
+		if r.oldName == name {
+			name = r.newName // given by an old name
+		}
+	}
+	aliases := []string{name}
+	for i := len(list) - 1; i >= 0; i-- {
+		if list[i].newName == aliases[len(aliases)-1] {
+			aliases = append(aliases, list[i].oldName)
+		}
+	}
+	return aliases
+}
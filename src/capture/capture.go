@@ -0,0 +1,242 @@
+// Package capture defines the typed form of the records produced by the monitoring tools,
+// and readers/writers to exchange them without text parsing.
+package capture
+
+import (
+	"crypto/rand"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* Schema */
+
+// Schema describes the records of a capture: the collector that produced them and
+// the names of their fields (as in the text header, e.g. "cpu:user/a").
+// Keyframes is only used in gob streams, where it is non-zero if the values are delta-encoded.
+// RunID identifies the run, to correlate the captures of the tools started together.
+// Version is the version of the schema of the collector, incremented when fields are renamed
+// (see Upgrade), 0 in the streams written before.
+type Schema struct {
+	Collector string
+	Fields    []string
+	Keyframes int
+	RunID     string
+	Version   int
+}
+
+// SignedSuffix is the kind suffix of the instant fields of which the values may be negative (as
+// the priority of a swap device), instead of "/i". Their values are stored in the samples as
+// two's complement, and written as text with their sign.
+const SignedSuffix = "/si"
+
+// Signed returns, for each field of the schema, whether its values are signed.
+func (schema Schema) Signed() []bool {
+	signed := make([]bool, len(schema.Fields))
+	for i, field := range schema.Fields {
+		signed[i] = strings.HasSuffix(field, SignedSuffix)
+	}
+	return signed
+}
+
+// FormatValue formats a value of a field, signed or not.
+func FormatValue(value uint64, signed bool) string {
+	if signed {
+		return strconv.FormatInt(int64(value), 10)
+	}
+	return strconv.FormatUint(value, 10)
+}
+
+// Float returns a value of a field as a float, for the computations, signed or not.
+func Float(value uint64, signed bool) float64 {
+	if signed {
+		return float64(int64(value))
+	}
+	return float64(value)
+}
+
+// FromFloat returns the value of a field, signed or not, of a float rounded to the nearest
+// integer, the negative floats being rounded to zero for the unsigned fields.
+func FromFloat(f float64, signed bool) uint64 {
+	f = math.Round(f)
+	if signed {
+		return uint64(int64(f))
+	}
+	if f < 0 {
+		return 0
+	}
+	return uint64(f)
+}
+
+// NewRunID returns a new random run identifier, as a version 4 UUID.
+func NewRunID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40 // version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+/* Sample */
+
+// Sample is one typed record line.
+// Instance is the name of the measured instance (e.g. the network interface), empty if
+// the collector has only one instance.
+// Kind is "a" for cumulative values, "d" for deltas, and "p" for percentages.
+type Sample struct {
+	Time     time.Time
+	Instance string
+	Kind     string
+	Values   []uint64
+	Flags    string // from the flags column of the text output, empty if none
+}
+
+/* Record info */
+
+// RecordInfo is what the collectors know of a record besides its values, embedded in their
+// records.
+// ReadTime is the time spent opening, reading and parsing the sources of the record.
+// CounterReset is set by the collectors of which the records may not be deltas (as percentages),
+// when an accumulator went backwards since the previous record, on the values read.
+// PartialParse is set by the collectors that leave out a part of the sources of a record they
+// failed to read or parse (as a mount point of which statfs failed), instead of the whole record.
+type RecordInfo struct {
+	Time         time.Time
+	ReadTime     time.Duration
+	CounterReset bool
+	PartialParse bool
+}
+
+// Info returns the info of the record.
+func (info RecordInfo) Info() RecordInfo {
+	return info
+}
+
+// EndRead sets the read time of the record, its sources having been read since start.
+// It is deferred at the start of the parsing: defer recordPtr.EndRead(time.Now()).
+func (info *RecordInfo) EndRead(start time.Time) {
+	info.ReadTime = time.Since(start)
+}
+
+/* Gob stream */
+
+// wireSample is the form of the samples in a gob stream.
+// Delta is true if the values are the zigzag-encoded differences with the previous sample of
+// the same instance, which gob then writes in fewer bytes when the values change little.
+type wireSample struct {
+	Time     time.Time
+	Instance string
+	Kind     string
+	Values   []uint64
+	Delta    bool
+}
+
+func zigzag(d int64) uint64 {
+	return uint64((d << 1) ^ (d >> 63))
+}
+
+func unzigzag(z uint64) int64 {
+	return int64(z>>1) ^ -int64(z&1)
+}
+
+// deltaState keeps the previous values of each instance, on both sides of a delta-encoded stream.
+type deltaState struct {
+	prev  map[string][]uint64
+	count map[string]int // samples since the last keyframe
+}
+
+func newDeltaState() *deltaState {
+	return &deltaState{make(map[string][]uint64), make(map[string]int)}
+}
+
+// GobWriter writes a gob stream made of a Schema followed by Samples.
+type GobWriter struct {
+	enc       *gob.Encoder
+	keyframes int
+	state     *deltaState
+}
+
+// NewGobWriter writes the schema to w and returns a writer for the samples.
+func NewGobWriter(w io.Writer, schema Schema) (gw *GobWriter, err error) {
+	return NewDeltaGobWriter(w, schema, 0)
+}
+
+// NewDeltaGobWriter is like NewGobWriter, but the values of each instance are delta-encoded
+// against its previous sample, with a full keyframe every keyframes samples (no delta encoding
+// if zero).
+func NewDeltaGobWriter(w io.Writer, schema Schema, keyframes int) (gw *GobWriter, err error) {
+	gw = &GobWriter{gob.NewEncoder(w), keyframes, newDeltaState()}
+	schema.Keyframes = keyframes
+	schema.Version = SchemaVersion(schema.Collector)
+	err = gw.enc.Encode(schema)
+	return
+}
+
+func (gw *GobWriter) Write(sample Sample) error {
+	ws := wireSample{sample.Time, sample.Instance, sample.Kind, sample.Values, false}
+	if gw.keyframes > 0 {
+		prev, ok := gw.state.prev[sample.Instance]
+		count := gw.state.count[sample.Instance]
+		if ok && len(prev) == len(sample.Values) && count < gw.keyframes {
+			ws.Values = make([]uint64, len(sample.Values))
+			for i, value := range sample.Values {
+				ws.Values[i] = zigzag(int64(value - prev[i]))
+			}
+			ws.Delta = true
+			gw.state.count[sample.Instance] = count + 1
+		} else {
+			gw.state.count[sample.Instance] = 1
+		}
+		gw.state.prev[sample.Instance] = append([]uint64(nil), sample.Values...)
+	}
+	return gw.enc.Encode(ws)
+}
+
+// GobReader reads a gob stream written by a GobWriter.
+type GobReader struct {
+	Schema Schema
+	dec    *gob.Decoder
+	state  *deltaState
+}
+
+// NewGobReader reads the schema from r and returns a reader for the samples.
+// The schema is upgraded to the current version, the fields renamed since being given their
+// current names.
+func NewGobReader(r io.Reader) (gr *GobReader, err error) {
+	gr = &GobReader{dec: gob.NewDecoder(r), state: newDeltaState()}
+	err = gr.dec.Decode(&gr.Schema)
+	gr.Schema = Upgrade(gr.Schema)
+	return
+}
+
+// Read returns the next sample, or io.EOF at the end of the stream.
+// Delta-encoded values are decoded.
+func (gr *GobReader) Read() (sample Sample, err error) {
+	var ws wireSample
+	err = gr.dec.Decode(&ws)
+	if err != nil {
+		return
+	}
+	sample = Sample{Time: ws.Time, Instance: ws.Instance, Kind: ws.Kind, Values: ws.Values}
+	if ws.Delta {
+		prev, ok := gr.state.prev[ws.Instance]
+		if !ok || len(prev) != len(ws.Values) {
+			err = fmt.Errorf("delta sample of %q without a previous keyframe", ws.Instance)
+			return
+		}
+		for i, z := range ws.Values {
+			sample.Values[i] = prev[i] + uint64(unzigzag(z))
+		}
+	}
+	if gr.Schema.Keyframes > 0 {
+		gr.state.prev[ws.Instance] = append([]uint64(nil), sample.Values...)
+	}
+	return
+}
@@ -0,0 +1,114 @@
+package capture
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestGobDeltaKeyframes writes samples delta-encoded with every spacing of the keyframes and
+// reads them back, checking the values read and which samples were written as keyframes.
+func TestGobDeltaKeyframes(t *testing.T) {
+	when := time.Date(2026, 10, 16, 12, 34, 56, 0, time.UTC)
+	samples := []Sample{
+		{Instance: "eth0", Kind: "a", Values: []uint64{100, 1<<64 - 2}},
+		{Instance: "eth1", Kind: "a", Values: []uint64{5, 5}},
+		{Instance: "eth0", Kind: "d", Values: []uint64{90, 3}}, // wrapped, and going back
+		{Instance: "eth0", Kind: "d", Values: []uint64{1<<64 - 1, 0}},
+		{Instance: "eth1", Kind: "d", Values: []uint64{5, 5, 7}}, // another number of values
+		{Instance: "eth0", Kind: "d", Values: []uint64{0, 1 << 63}},
+		{Instance: "eth1", Kind: "d", Values: []uint64{6, 4, 7}},
+		{Instance: "eth0", Kind: "d", Values: []uint64{42, 42}},
+	}
+	for _, test := range []struct {
+		keyframes int
+		frames    string // k for a keyframe, d for a delta, per sample
+	}{
+		{0, "kkkkkkkk"},
+		{1, "kkkkkkkk"},
+		{2, "kkdkkddk"},
+		{3, "kkddkkdd"},
+		{100, "kkddkddd"},
+	} {
+		var stream bytes.Buffer
+		gw, err := NewDeltaGobWriter(&stream, Schema{Collector: "netstat", Fields: []string{"net:a/a", "net:b/a"}}, test.keyframes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, sample := range samples {
+			sample.Time = when.Add(time.Duration(i) * time.Second)
+			err = gw.Write(sample)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		data := stream.Bytes()
+
+		dec := gob.NewDecoder(bytes.NewReader(data))
+		var schema Schema
+		err = dec.Decode(&schema)
+		if err != nil || schema.Keyframes != test.keyframes {
+			t.Errorf("keyframes %d: schema %+v, %v", test.keyframes, schema, err)
+		}
+		var frames strings.Builder
+		for {
+			var ws wireSample
+			err = dec.Decode(&ws)
+			if err != nil {
+				break
+			}
+			if ws.Delta {
+				frames.WriteString("d")
+			} else {
+				frames.WriteString("k")
+			}
+		}
+		if frames.String() != test.frames {
+			t.Errorf("keyframes %d: frames %s instead of %s", test.keyframes, frames.String(), test.frames)
+		}
+
+		gr, err := NewGobReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, want := range samples {
+			sample, err := gr.Read()
+			if err != nil {
+				t.Fatalf("keyframes %d: sample %d: %v", test.keyframes, i, err)
+			}
+			if sample.Instance != want.Instance || sample.Kind != want.Kind || fmt.Sprint(sample.Values) != fmt.Sprint(want.Values) ||
+				!sample.Time.Equal(when.Add(time.Duration(i)*time.Second)) {
+				t.Errorf("keyframes %d: sample %d: read %+v instead of %+v", test.keyframes, i, sample, want)
+			}
+		}
+		_, err = gr.Read()
+		if err != io.EOF {
+			t.Errorf("keyframes %d: %v instead of EOF", test.keyframes, err)
+		}
+	}
+}
+
+// TestGobDeltaWithoutKeyframe reads a delta sample of an instance of which no keyframe was read.
+func TestGobDeltaWithoutKeyframe(t *testing.T) {
+	var stream bytes.Buffer
+	enc := gob.NewEncoder(&stream)
+	enc.Encode(Schema{Collector: "netstat", Fields: []string{"net:a/a"}, Keyframes: 2})
+	enc.Encode(wireSample{Instance: "eth0", Kind: "a", Values: []uint64{1}})
+	enc.Encode(wireSample{Instance: "eth1", Kind: "d", Values: []uint64{2}, Delta: true})
+	gr, err := NewGobReader(&stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = gr.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = gr.Read()
+	if err == nil {
+		t.Error("delta sample without keyframe read")
+	}
+}
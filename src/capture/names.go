@@ -0,0 +1,136 @@
+package capture
+
+import "strings"
+
+/* Field names mapping */
+
+// Convention is a naming convention of other monitoring tools, into which the field names of
+// the collectors can be translated, to reuse existing dashboards.
+type Convention int
+
+const (
+	NodeExporter Convention = iota // Prometheus node_exporter metric names
+	Sar                            // sysstat sar column names
+	PerfMon                        // Windows Performance Monitor counter paths
+)
+
+// equivalent gives the names of a field in each convention, empty if it has no equivalent.
+// The units may differ: node_exporter uses seconds and bytes where the collectors use ticks,
+// sectors or kB, sar and PerfMon give rates or percentages where the collectors give counts.
+type equivalent [3]string
+
+// equivalents is keyed by collector, then by field name without its kind suffix, as "cpu:user".
+var equivalents = map[string]map[string]equivalent{
+	"cpustat": {
+		"procs:forks":    {"node_forks_total", "proc/s", `\System\Processes`},
+		"procs:running":  {"node_procs_running", "runq-sz", `\System\Processor Queue Length`},
+		"procs:blocked":  {"node_procs_blocked", "blocked", ""},
+		"intr:total":     {"node_intr_total", "intr/s", `\Processor(_Total)\Interrupts/sec`},
+		"ctxt:total":     {"node_context_switches_total", "cswch/s", `\System\Context Switches/sec`},
+		"cpu:user":       {`node_cpu_seconds_total{mode="user"}`, "%user", `\Processor(_Total)\% User Time`},
+		"cpu:nice":       {`node_cpu_seconds_total{mode="nice"}`, "%nice", ""},
+		"cpu:system":     {`node_cpu_seconds_total{mode="system"}`, "%system", `\Processor(_Total)\% Privileged Time`},
+		"cpu:idle":       {`node_cpu_seconds_total{mode="idle"}`, "%idle", `\Processor(_Total)\% Idle Time`},
+		"cpu:iowait":     {`node_cpu_seconds_total{mode="iowait"}`, "%iowait", ""},
+		"cpu:irq":        {`node_cpu_seconds_total{mode="irq"}`, "%irq", `\Processor(_Total)\% Interrupt Time`},
+		"cpu:softirq":    {`node_cpu_seconds_total{mode="softirq"}`, "%soft", `\Processor(_Total)\% DPC Time`},
+		"cpu:steal":      {`node_cpu_seconds_total{mode="steal"}`, "%steal", ""},
+		"cpu:guest":      {`node_cpu_guest_seconds_total{mode="user"}`, "%guest", ""},
+		"cpu:guest_nice": {`node_cpu_guest_seconds_total{mode="nice"}`, "%gnice", ""},
+	},
+	"meminfo": {
+		"mem:total":     {"node_memory_MemTotal_bytes", "", ""},
+		"mem:free":      {"node_memory_MemFree_bytes", "kbmemfree", `\Memory\Free & Zero Page List Bytes`},
+		"mem:available": {"node_memory_MemAvailable_bytes", "kbavail", `\Memory\Available KBytes`},
+		"mem:used":      {"", "kbmemused", ""},
+		"mem:buffers":   {"node_memory_Buffers_bytes", "kbbuffers", ""},
+		"mem:cached":    {"node_memory_Cached_bytes", "kbcached", `\Memory\Cache Bytes`},
+		"mem:active":    {"node_memory_Active_bytes", "kbactive", ""},
+		"mem:inactive":  {"node_memory_Inactive_bytes", "kbinact", ""},
+		"mem:dirty":     {"node_memory_Dirty_bytes", "kbdirty", `\Memory\Modified Page List Bytes`},
+		"mem:writeback": {"node_memory_Writeback_bytes", "", ""},
+		"mem:anon":      {"node_memory_AnonPages_bytes", "kbanonpg", ""},
+		"mem:mapped":    {"node_memory_Mapped_bytes", "", ""},
+		"mem:shmem":     {"node_memory_Shmem_bytes", "", ""},
+		"mem:slab":      {"node_memory_Slab_bytes", "kbslab", `\Memory\Pool Nonpaged Bytes`},
+		"mem:committed": {"node_memory_Committed_AS_bytes", "kbcommit", `\Memory\Committed Bytes`},
+		"swap:total":    {"node_memory_SwapTotal_bytes", "", ""},
+		"swap:free":     {"node_memory_SwapFree_bytes", "kbswpfree", ""},
+		"swap:cached":   {"node_memory_SwapCached_bytes", "kbswpcad", ""},
+	},
+	"loadavg": {
+		"load:avg1_x100":  {"node_load1", "ldavg-1", ""},
+		"load:avg5_x100":  {"node_load5", "ldavg-5", ""},
+		"load:avg15_x100": {"node_load15", "ldavg-15", ""},
+		"tasks:runnable":  {"node_procs_running", "runq-sz", `\System\Processor Queue Length`},
+		"tasks:total":     {"", "plist-sz", `\System\Threads`},
+	},
+	"diskstat": {
+		"rd:ios":       {"node_disk_reads_completed_total", "r/s", `\PhysicalDisk(*)\Disk Reads/sec`},
+		"rd:merges":    {"node_disk_reads_merged_total", "rrqm/s", ""},
+		"rd:sectors":   {"node_disk_read_bytes_total", "rkB/s", `\PhysicalDisk(*)\Disk Read Bytes/sec`},
+		"rd:ms":        {"node_disk_read_time_seconds_total", "r_await", `\PhysicalDisk(*)\Avg. Disk sec/Read`},
+		"wr:ios":       {"node_disk_writes_completed_total", "w/s", `\PhysicalDisk(*)\Disk Writes/sec`},
+		"wr:merges":    {"node_disk_writes_merged_total", "wrqm/s", ""},
+		"wr:sectors":   {"node_disk_written_bytes_total", "wkB/s", `\PhysicalDisk(*)\Disk Write Bytes/sec`},
+		"wr:ms":        {"node_disk_write_time_seconds_total", "w_await", `\PhysicalDisk(*)\Avg. Disk sec/Write`},
+		"io:in_flight": {"node_disk_io_now", "", `\PhysicalDisk(*)\Current Disk Queue Length`},
+		"io:ms":        {"node_disk_io_time_seconds_total", "%util", `\PhysicalDisk(*)\% Disk Time`},
+		"io:queue_ms":  {"node_disk_io_time_weighted_seconds_total", "aqu-sz", `\PhysicalDisk(*)\Avg. Disk Queue Length`},
+	},
+	"netstat": {
+		"rx:bytes":      {"node_network_receive_bytes_total", "rxkB/s", `\Network Interface(*)\Bytes Received/sec`},
+		"rx:packets":    {"node_network_receive_packets_total", "rxpck/s", `\Network Interface(*)\Packets Received/sec`},
+		"rx:errs":       {"node_network_receive_errs_total", "rxerr/s", `\Network Interface(*)\Packets Received Errors`},
+		"rx:drops":      {"node_network_receive_drop_total", "rxdrop/s", `\Network Interface(*)\Packets Received Discarded`},
+		"rx:fifo":       {"node_network_receive_fifo_total", "rxfifo/s", ""},
+		"rx:frame":      {"node_network_receive_frame_total", "rxfram/s", ""},
+		"rx:compressed": {"node_network_receive_compressed_total", "rxcmp/s", ""},
+		"rx:multicast":  {"node_network_receive_multicast_total", "rxmcst/s", `\Network Interface(*)\Packets Received Non-Unicast/sec`},
+		"tx:bytes":      {"node_network_transmit_bytes_total", "txkB/s", `\Network Interface(*)\Bytes Sent/sec`},
+		"tx:packets":    {"node_network_transmit_packets_total", "txpck/s", `\Network Interface(*)\Packets Sent/sec`},
+		"tx:errs":       {"node_network_transmit_errs_total", "txerr/s", `\Network Interface(*)\Packets Outbound Errors`},
+		"tx:drops":      {"node_network_transmit_drop_total", "txdrop/s", `\Network Interface(*)\Packets Outbound Discarded`},
+		"tx:fifo":       {"node_network_transmit_fifo_total", "txfifo/s", ""},
+		"tx:colls":      {"node_network_transmit_colls_total", "coll/s", ""},
+		"tx:carrier":    {"node_network_transmit_carrier_total", "txcarr/s", ""},
+		"tx:compressed": {"node_network_transmit_compressed_total", "txcmp/s", ""},
+	},
+	"vmstat": {
+		"page:in_kb":   {"node_vmstat_pgpgin", "pgpgin/s", `\Memory\Pages Input/sec`},
+		"page:out_kb":  {"node_vmstat_pgpgout", "pgpgout/s", `\Memory\Pages Output/sec`},
+		"swap:in":      {"node_vmstat_pswpin", "pswpin/s", ""},
+		"swap:out":     {"node_vmstat_pswpout", "pswpout/s", ""},
+		"fault:all":    {"node_vmstat_pgfault", "fault/s", `\Memory\Page Faults/sec`},
+		"fault:major":  {"node_vmstat_pgmajfault", "majflt/s", ""},
+		"scan:kswapd":  {"node_vmstat_pgscan_kswapd", "pgscank/s", ""},
+		"scan:direct":  {"node_vmstat_pgscan_direct", "pgscand/s", ""},
+		"steal:kswapd": {"node_vmstat_pgsteal_kswapd", "pgsteal/s", ""},
+		"steal:direct": {"node_vmstat_pgsteal_direct", "", ""},
+		"oom:kills":    {"node_vmstat_oom_kill", "", ""},
+	},
+}
+
+// EquivalentName returns the name, in the convention, of the field of the collector, given as
+// in the schema (e.g. "cpu:user/a"), or false if there is no known equivalent.
+func EquivalentName(collector, field string, convention Convention) (string, bool) {
+	if i := strings.LastIndexByte(field, '/'); i >= 0 {
+		field = field[:i]
+	}
+	name := equivalents[collector][field][convention]
+	return name, name != ""
+}
+
+// EquivalentNames returns the names, in the convention, of the fields of the schema, keeping
+// the field name itself when there is no known equivalent.
+func EquivalentNames(schema Schema, convention Convention) []string {
+	names := make([]string, len(schema.Fields))
+	for i, field := range schema.Fields {
+		name, ok := EquivalentName(schema.Collector, field, convention)
+		if !ok {
+			name = field
+		}
+		names[i] = name
+	}
+	return names
+}
@@ -0,0 +1,58 @@
+package capture
+
+import "sort"
+
+/* Schema registry */
+
+// Model is what is known of the fields of a collector besides their names, for the tools
+// generating or checking captures. The fields are named without their kind suffix.
+// Totals gives the fields that are the sums of other fields (as cpu:total of the cpu times), and
+// Capacities the instant fields constant on a host (as mem:total).
+type Model struct {
+	Totals     map[string][]string
+	Capacities []string
+}
+
+type registration struct {
+	schema Schema
+	model  Model
+}
+
+// registry is keyed by collector.
+var registry = make(map[string]registration)
+
+// Register registers the schema of a collector, with its default fields, for the tools of which
+// the captures are of any collector, and returns it. It is called when the collector package is
+// initialized (as var Schema = capture.Register(...)).
+func Register(schema Schema) Schema {
+	r := registry[schema.Collector]
+	r.schema = schema
+	registry[schema.Collector] = r
+	return schema
+}
+
+// RegisterModel registers the model of the fields of a collector.
+func RegisterModel(collector string, model Model) {
+	r := registry[collector]
+	r.model = model
+	registry[collector] = r
+}
+
+// Registered returns the schema and the model of the fields of the collector, or false if its
+// schema is not registered, its package not being imported.
+func Registered(collector string) (Schema, Model, bool) {
+	r, ok := registry[collector]
+	return r.schema, r.model, ok && r.schema.Collector != ""
+}
+
+// Collectors returns the names of the collectors of which the schema is registered, sorted.
+func Collectors() []string {
+	var names []string
+	for collector, r := range registry {
+		if r.schema.Collector != "" {
+			names = append(names, collector)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
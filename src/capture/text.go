@@ -0,0 +1,384 @@
+package capture
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TextTimeFormat is the format of the time column of the text output.
+const TextTimeFormat = "2006-01-02T15:04:05.000-0700"
+
+// RunIDComment starts the comment line giving the run identifier, before the header.
+const RunIDComment = "# run_id: "
+
+// SchemaComment starts the comment line giving the collector and the version of its schema, as
+// "# schema: cpustat 2", before the header. It is only written for the collectors of which
+// fields were renamed, the text output without it being of version 0.
+const SchemaComment = "# schema: "
+
+// WriteSchemaComment writes the schema comment line of the collector, if its schema has a
+// version.
+func WriteSchemaComment(w io.Writer, collector string) error {
+	version := SchemaVersion(collector)
+	if version == 0 {
+		return nil
+	}
+	_, err := fmt.Fprint(w, SchemaComment, collector, " ", version, "\n")
+	return err
+}
+
+/* Integrity suffix */
+
+// LineSuffix returns the integrity suffix of the kind ("crc32" or "len") of a line of the text
+// output, given without the separator of the suffix and the suffix, or false if the kind is
+// unknown.
+func LineSuffix(kind string, line []byte) (string, bool) {
+	switch kind {
+	case "crc32":
+		return fmt.Sprintf("%08x", crc32.ChecksumIEEE(line)), true
+	case "len":
+		return strconv.Itoa(len(line)), true
+	}
+	return "", false
+}
+
+// ErrCorruptLine is the error of the lines of which the integrity suffix does not match, as a
+// line truncated by a full disk or a killed tool.
+var ErrCorruptLine = errors.New("integrity suffix mismatch")
+
+// checkSuffix returns the line without its integrity suffix of the kind, or ErrCorruptLine if
+// the suffix does not match.
+func checkSuffix(kind, line string) (string, error) {
+	i := strings.LastIndexByte(line, ' ')
+	if i < 0 {
+		return "", fmt.Errorf("%w: %q", ErrCorruptLine, line)
+	}
+	suffix, _ := LineSuffix(kind, []byte(line[:i]))
+	if line[i+1:] != suffix {
+		return "", fmt.Errorf("%w: %q", ErrCorruptLine, line)
+	}
+	return line[:i], nil
+}
+
+/* Text stream */
+
+// TextReader reads the text output of the tools: optional "#" comment lines (the run
+// identifier and the schema version being read into the schema), a header line,
+// then record lines. The time, clock synchronization, flags, read time and integrity suffix
+// columns are recognized from the header. Lines without the time column (the following
+// instances of multi-instance records) take the time of the previous line.
+// The integrity suffix of every line is checked, Read returning ErrCorruptLine for a line of
+// which it does not match, the following lines being read by the next calls.
+type TextReader struct {
+	Schema      Schema
+	scanner     *bufio.Scanner
+	hasTime     bool
+	syncColumns int
+	hasFlags    bool
+	hasReadTime bool
+	hasInstance bool
+	suffix      string // the kind of integrity suffix, empty if none
+	signed      []bool
+	lastTime    time.Time
+	lastFlags   string
+}
+
+// NewTextReader reads the header from r and returns a reader for the samples.
+// If the collector is given by a schema comment, the schema is upgraded to the current version,
+// as by NewGobReader; otherwise the collector is left empty, for the caller to name it and
+// upgrade the schema.
+func NewTextReader(r io.Reader) (tr *TextReader, err error) {
+	tr = &TextReader{scanner: bufio.NewScanner(r)}
+	var comments []string // read once the kind of suffix is known from the header
+	for tr.scanner.Scan() {
+		line := tr.scanner.Text()
+		if strings.HasPrefix(line, "#") || strings.TrimSpace(line) == "" {
+			comments = append(comments, line)
+			continue
+		}
+		err = tr.parseHeader(line)
+		if err != nil {
+			return
+		}
+		for _, comment := range comments {
+			err = tr.parseComment(comment)
+			if err != nil {
+				return
+			}
+		}
+		if tr.Schema.Collector != "" {
+			tr.Schema = Upgrade(tr.Schema)
+		}
+		return
+	}
+	err = tr.scanner.Err()
+	if err == nil {
+		err = io.ErrUnexpectedEOF
+	}
+	return
+}
+
+// parseComment reads the run identifier or the schema version of a comment line.
+func (tr *TextReader) parseComment(line string) (err error) {
+	if tr.suffix != "" && strings.TrimSpace(line) != "" {
+		line, err = checkSuffix(tr.suffix, line)
+		if err != nil {
+			return
+		}
+	}
+	if strings.HasPrefix(line, RunIDComment) {
+		tr.Schema.RunID = strings.TrimSpace(line[len(RunIDComment):])
+	}
+	if strings.HasPrefix(line, SchemaComment) {
+		_, err = fmt.Sscan(line[len(SchemaComment):], &tr.Schema.Collector, &tr.Schema.Version)
+		if err != nil {
+			return fmt.Errorf("invalid schema comment: %q: %v", line, err)
+		}
+	}
+	return
+}
+
+// parseHeader recognizes the columns of the header line, and the kind of its integrity suffix,
+// if any, from the suffix of the header itself.
+func (tr *TextReader) parseHeader(line string) error {
+	columns := strings.Fields(line)
+	kindIdx := -1
+	for i, column := range columns {
+		if column == "h" {
+			kindIdx = i
+			break
+		}
+	}
+	if kindIdx < 0 {
+		return fmt.Errorf("no kind column (h) in header: %q", strings.Join(columns, " "))
+	}
+	for _, column := range columns[:kindIdx] {
+		switch {
+		case column == "time":
+			tr.hasTime = true
+		case strings.HasPrefix(column, "sync:"):
+			tr.syncColumns++
+		case column == "flags":
+			tr.hasFlags = true
+		case strings.HasPrefix(column, "read:"):
+			tr.hasReadTime = true
+		default:
+			tr.hasInstance = true
+		}
+	}
+	fields := columns[kindIdx+1:]
+	if len(fields) > 0 && !strings.Contains(fields[len(fields)-1], "/") {
+		for _, kind := range []string{"crc32", "len"} {
+			if _, err := checkSuffix(kind, line); err == nil {
+				tr.suffix = kind
+			}
+		}
+		if tr.suffix == "" {
+			return fmt.Errorf("header ending with neither a field nor an integrity suffix: %q", line)
+		}
+		fields = fields[:len(fields)-1]
+	}
+	tr.Schema.Fields = fields
+	tr.signed = tr.Schema.Signed()
+	return nil
+}
+
+// Read returns the next sample, or io.EOF at the end of the stream.
+func (tr *TextReader) Read() (sample Sample, err error) {
+	var columns []string
+	for len(columns) == 0 {
+		if !tr.scanner.Scan() {
+			err = tr.scanner.Err()
+			if err == nil {
+				err = io.EOF
+			}
+			return
+		}
+		line := tr.scanner.Text()
+		if strings.HasPrefix(line, "#") || strings.TrimSpace(line) == "" {
+			continue
+		}
+		if tr.suffix != "" {
+			line, err = checkSuffix(tr.suffix, line)
+			if err != nil {
+				return
+			}
+		}
+		columns = strings.Fields(line)
+	}
+	valuesCount := len(tr.Schema.Fields) + 1 // with the kind
+	if tr.hasInstance {
+		valuesCount++
+	}
+	prefixCount := 1 + tr.syncColumns // time, sync:*, flags, read:*
+	if tr.hasFlags {
+		prefixCount++
+	}
+	if tr.hasReadTime {
+		prefixCount++
+	}
+	switch len(columns) {
+	case valuesCount: // following instance of a record
+		sample.Time = tr.lastTime
+		sample.Flags = tr.lastFlags
+	case valuesCount + prefixCount:
+		if !tr.hasTime {
+			err = fmt.Errorf("unexpected number of columns: %q", tr.scanner.Text())
+			return
+		}
+		sample.Time, err = time.Parse(TextTimeFormat, columns[0])
+		if err != nil {
+			return
+		}
+		if tr.hasFlags {
+			sample.Flags = columns[1+tr.syncColumns]
+		}
+		tr.lastTime = sample.Time
+		tr.lastFlags = sample.Flags
+		columns = columns[prefixCount:]
+	default:
+		err = fmt.Errorf("unexpected number of columns: %q", tr.scanner.Text())
+		return
+	}
+	if tr.hasInstance {
+		if columns[0] != "-" { // as written by a TextWriter for the samples without instance
+			sample.Instance = columns[0]
+		}
+		columns = columns[1:]
+	}
+	sample.Kind = columns[0]
+	sample.Values = make([]uint64, len(columns)-1)
+	for i, column := range columns[1:] {
+		sample.Values[i], err = parseValue(column, tr.signed[i])
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// parseValue parses a value of a field, those of the signed fields being returned as two's
+// complement.
+func parseValue(str string, signed bool) (uint64, error) {
+	if signed {
+		value, err := strconv.ParseInt(str, 10, 64)
+		return uint64(value), err
+	}
+	return strconv.ParseUint(str, 10, 64)
+}
+
+// HasFlags reports whether the text output has a flags column.
+func (tr *TextReader) HasFlags() bool {
+	return tr.hasFlags
+}
+
+// TextWriter writes samples as text, to be read by a TextReader: after the comment lines of the
+// schema and the header, a line per sample, with its time, its flags (if withFlags), its instance
+// ("-" if none, unless withoutInstance), its kind and its values.
+type TextWriter struct {
+	w               io.Writer
+	withFlags       bool
+	withoutInstance bool
+	signed          []bool
+}
+
+// NewTextWriter writes the comment lines of the schema (run identifier and version) and the
+// header to w, and returns a writer for the samples of the schema. The instance column is
+// omitted if withoutInstance is true, as for the samples of one instance.
+func NewTextWriter(w io.Writer, schema Schema, withFlags, withoutInstance bool) (tw *TextWriter, err error) {
+	tw = &TextWriter{w, withFlags, withoutInstance, schema.Signed()}
+	if schema.RunID != "" {
+		_, err = fmt.Fprint(w, RunIDComment, schema.RunID, "\n")
+		if err != nil {
+			return
+		}
+	}
+	err = WriteSchemaComment(w, schema.Collector)
+	if err != nil {
+		return
+	}
+	columns := []string{"time"}
+	if withFlags {
+		columns = append(columns, "flags")
+	}
+	if !withoutInstance {
+		columns = append(columns, "instance")
+	}
+	columns = append(columns, "h")
+	_, err = fmt.Fprintln(w, strings.Join(append(columns, schema.Fields...), " "))
+	return
+}
+
+func (tw *TextWriter) Write(sample Sample) error {
+	columns := []string{sample.Time.Format(TextTimeFormat)}
+	if tw.withFlags {
+		flags := sample.Flags
+		if flags == "" {
+			flags = "-"
+		}
+		columns = append(columns, flags)
+	}
+	if !tw.withoutInstance {
+		instance := sample.Instance
+		if instance == "" {
+			instance = "-"
+		}
+		columns = append(columns, instance)
+	}
+	columns = append(columns, sample.Kind)
+	for i, value := range sample.Values {
+		columns = append(columns, FormatValue(value, i < len(tw.signed) && tw.signed[i]))
+	}
+	_, err := fmt.Fprintln(tw.w, strings.Join(columns, " "))
+	return err
+}
+
+/* Any stream */
+
+// Reader reads the samples of a capture, whatever its format.
+type Reader interface {
+	Read() (Sample, error)
+}
+
+// isText reports whether the first line of the stream is made of printable characters
+// (gob messages holding control bytes from the start).
+func isText(br *bufio.Reader) bool {
+	head, _ := br.Peek(512)
+	if len(head) == 0 {
+		return false
+	}
+	for _, b := range head {
+		if b == '\n' {
+			return true
+		}
+		if (b < 0x20 || b >= 0x7f) && b != '\t' && b != '\r' {
+			return false
+		}
+	}
+	return true
+}
+
+// NewReader detects the format of the capture read from r, gob or text, reads its header and
+// returns a reader for the samples, with the schema of the capture.
+func NewReader(r io.Reader) (reader Reader, schema Schema, err error) {
+	br := bufio.NewReader(r)
+	if isText(br) {
+		var tr *TextReader
+		tr, err = NewTextReader(br)
+		if err != nil {
+			return
+		}
+		return tr, tr.Schema, nil
+	}
+	gr, err := NewGobReader(br)
+	if err != nil {
+		return
+	}
+	return gr, gr.Schema, nil
+}
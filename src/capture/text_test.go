@@ -0,0 +1,249 @@
+package capture
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestTextReaderPrefixColumns reads back the text output written with every combination of the
+// optional columns written before the kind column, checking that none is taken for another.
+func TestTextReaderPrefixColumns(t *testing.T) {
+	prefixes := []struct {
+		header string
+		value  string
+	}{
+		{"sync:synced/i sync:offset_us/i", "1 -42"},
+		{"flags", "counter-reset,clock-jump"},
+		{"read:us/i", "1234"},
+	}
+	when := time.Date(2026, 10, 16, 12, 34, 56, 789e6, time.UTC)
+	for mask := 0; mask < 1<<len(prefixes); mask++ {
+		for _, instances := range []bool{false, true} {
+			for _, suffix := range []bool{false, true} {
+				header := []string{"time"}
+				prefix := []string{when.Format(TextTimeFormat)}
+				flags := ""
+				for i, p := range prefixes {
+					if mask&(1<<i) != 0 {
+						header = append(header, p.header)
+						prefix = append(prefix, p.value)
+						if p.header == "flags" {
+							flags = p.value
+						}
+					}
+				}
+				if instances {
+					header = append(header, "interface")
+				}
+				header = append(header, "h", "net:bytes/a", "net:up/i")
+				lines := []string{strings.Join(header, " ")}
+				names := []string{""}
+				if instances {
+					names = []string{"eth0", "eth1"}
+				}
+				for i, name := range names {
+					columns := []string{}
+					if i == 0 {
+						columns = append(columns, prefix...)
+					}
+					if name != "" {
+						columns = append(columns, name)
+					}
+					columns = append(columns, "d", "100", "1")
+					lines = append(lines, strings.Join(columns, " "))
+				}
+				if suffix {
+					for i := range lines {
+						crc, _ := LineSuffix("crc32", []byte(lines[i]))
+						lines[i] += " " + crc
+					}
+				}
+				capture := strings.Join(lines, "\n") + "\n"
+				tr, err := NewTextReader(strings.NewReader(capture))
+				if err != nil {
+					t.Fatalf("%q: %v", capture, err)
+				}
+				if strings.Join(tr.Schema.Fields, " ") != "net:bytes/a net:up/i" {
+					t.Errorf("%q: fields %v", capture, tr.Schema.Fields)
+				}
+				for _, name := range names {
+					sample, err := tr.Read()
+					if err != nil {
+						t.Fatalf("%q: %v", capture, err)
+					}
+					if !sample.Time.Equal(when) || sample.Instance != name || sample.Flags != flags ||
+						sample.Kind != "d" || len(sample.Values) != 2 || sample.Values[0] != 100 || sample.Values[1] != 1 {
+						t.Errorf("%q: read %+v", capture, sample)
+					}
+				}
+				_, err = tr.Read()
+				if err != io.EOF {
+					t.Errorf("%q: %v instead of EOF", capture, err)
+				}
+			}
+		}
+	}
+}
+
+// TestTextReaderSchemaComment reads back a text output of a collector of which a field was
+// renamed, written before and after the renaming.
+func TestTextReaderSchemaComment(t *testing.T) {
+	renamings["test"] = []renaming{{1, "cpu:busy", "cpu:used"}}
+	defer delete(renamings, "test")
+	var comment strings.Builder
+	WriteSchemaComment(&comment, "test")
+	for _, capture := range []string{
+		"# schema: test 0\ntime h cpu:busy/i\n",
+		comment.String() + "time h cpu:used/i\n",
+	} {
+		tr, err := NewTextReader(strings.NewReader(capture))
+		if err != nil {
+			t.Fatalf("%q: %v", capture, err)
+		}
+		if tr.Schema.Collector != "test" || tr.Schema.Version != 1 || strings.Join(tr.Schema.Fields, " ") != "cpu:used/i" {
+			t.Errorf("%q: schema %+v", capture, tr.Schema)
+		}
+	}
+}
+
+// TestTextSignedValues writes samples of signed and unsigned fields as text and reads them back,
+// a negative value being rejected in an unsigned field.
+func TestTextSignedValues(t *testing.T) {
+	schema := Schema{Collector: "swapstat", Fields: []string{"swap:used_kb/i", "swap:priority/si"}}
+	when := time.Date(2026, 10, 16, 12, 34, 56, 0, time.UTC)
+	for _, values := range [][]uint64{{0, 0}, {1024, 5}, {1024, 1<<64 - 2}, {1<<64 - 1, 1 << 63}} {
+		var text strings.Builder
+		tw, err := NewTextWriter(&text, schema, false, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = tw.Write(Sample{Time: when, Instance: "/dev/sda2", Kind: "d", Values: values})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr, err := NewTextReader(strings.NewReader(text.String()))
+		if err != nil {
+			t.Fatalf("%q: %v", text.String(), err)
+		}
+		sample, err := tr.Read()
+		if err != nil {
+			t.Fatalf("%q: %v", text.String(), err)
+		}
+		if len(sample.Values) != 2 || sample.Values[0] != values[0] || sample.Values[1] != values[1] {
+			t.Errorf("%q: read %v instead of %v", text.String(), sample.Values, values)
+		}
+	}
+	for _, test := range []struct {
+		line     string
+		priority int64
+		valid    bool
+	}{
+		{"sda2 d 1024 -2", -2, true},
+		{"sda2 d -1024 2", 0, false},
+	} {
+		capture := "time instance h swap:used_kb/i swap:priority/si\n" + when.Format(TextTimeFormat) + " " + test.line + "\n"
+		tr, err := NewTextReader(strings.NewReader(capture))
+		if err != nil {
+			t.Fatalf("%q: %v", capture, err)
+		}
+		sample, err := tr.Read()
+		if (err == nil) != test.valid || test.valid && int64(sample.Values[1]) != test.priority {
+			t.Errorf("%q: read %v, %v", capture, sample.Values, err)
+		}
+	}
+}
+
+// TestTextReaderSuffix reads back text outputs with integrity suffixes, of which some lines are
+// corrupt, checking that those are reported and the others read.
+func TestTextReaderSuffix(t *testing.T) {
+	when := time.Date(2026, 10, 16, 12, 34, 56, 0, time.UTC).Format(TextTimeFormat)
+	for _, kind := range []string{"crc32", "len"} {
+		for _, test := range []struct {
+			name    string
+			corrupt func(line string) string // of the second record line
+			valid   bool
+		}{
+			{"intact", func(line string) string { return line }, true},
+			{"truncated", func(line string) string { return line[:len(line)-3] }, false},
+			{"altered", func(line string) string { return strings.Replace(line, " 200 ", " 2000 ", 1) }, false},
+			{"suffix only", func(line string) string { return line[strings.LastIndexByte(line, ' ')+1:] }, false},
+		} {
+			var text strings.Builder
+			for i, line := range []string{
+				RunIDComment + "1234",
+				"time h net:bytes/a",
+				when + " d 100",
+				when + " d 200",
+				when + " d 300",
+			} {
+				suffix, _ := LineSuffix(kind, []byte(line))
+				line += " " + suffix
+				if i == 3 {
+					line = test.corrupt(line)
+				}
+				text.WriteString(line + "\n")
+			}
+			tr, err := NewTextReader(strings.NewReader(text.String()))
+			if err != nil {
+				t.Fatalf("%s %s: %v", kind, test.name, err)
+			}
+			if tr.Schema.RunID != "1234" || strings.Join(tr.Schema.Fields, " ") != "net:bytes/a" {
+				t.Errorf("%s %s: schema %+v", kind, test.name, tr.Schema)
+			}
+			var values []uint64
+			for {
+				sample, err := tr.Read()
+				if err == io.EOF {
+					break
+				}
+				if errors.Is(err, ErrCorruptLine) {
+					if test.valid {
+						t.Errorf("%s %s: %v", kind, test.name, err)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("%s %s: %v", kind, test.name, err)
+				}
+				values = append(values, sample.Values[0])
+			}
+			want := "[100 300]"
+			if test.valid {
+				want = "[100 200 300]"
+			}
+			if fmt.Sprint(values) != want {
+				t.Errorf("%s %s: read %v instead of %s", kind, test.name, values, want)
+			}
+		}
+	}
+}
+
+// TestTextReaderRenamedFields reads back a text output of cgroupstat written before its io fields
+// were renamed, and transforms its fields by their old and new names.
+func TestTextReaderRenamedFields(t *testing.T) {
+	capture := "time h cpu:usage_ns/a io:rbytes/a io:wbytes/a\n" +
+		"2026-10-16T12:34:56.000+0000 d 10 20 30\n"
+	tr, err := NewTextReader(strings.NewReader(capture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.Schema.Collector = "cgroupstat" // named by the caller, without schema comment
+	schema := Upgrade(tr.Schema)
+	if schema.Version != 1 || strings.Join(schema.Fields, " ") != "cpu:usage_ns/a io:read_bytes/a io:write_bytes/a" {
+		t.Errorf("schema %+v", schema)
+	}
+	for _, list := range []string{"drop:io:rbytes,drop:io:write_bytes", "drop:io:read_bytes,drop:io:wbytes"} {
+		chain, err := ParseChain(list)
+		if err != nil {
+			t.Fatal(err)
+		}
+		transformed, _, err := chain.Bind(schema)
+		if err != nil || strings.Join(transformed.Fields, " ") != "cpu:usage_ns/a" {
+			t.Errorf("%q: fields %v, %v", list, transformed.Fields, err)
+		}
+	}
+}
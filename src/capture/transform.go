@@ -0,0 +1,234 @@
+package capture
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/* Transformations */
+
+// Transform is a step of the transformation of the samples between a collector and a sink, as
+// the renaming of fields, the conversion of their units, the filtering of instances or their
+// enrichment. Bound to the schema of the samples, it returns the schema of the samples
+// transformed, and the function transforming a sample into none (filtered out), one or several.
+type Transform func(schema Schema) (Schema, func(Sample) []Sample, error)
+
+// Chain is a list of transforms, applied in order.
+type Chain []Transform
+
+// suffix returns the kind suffix of a field, as "/a", empty if none.
+func suffix(field string) string {
+	if i := strings.LastIndexByte(field, '/'); i >= 0 {
+		return field[i:]
+	}
+	return ""
+}
+
+// fieldIndex returns the index of the field in the schema, given without its kind suffix
+// (as "mem:rss_kb") or with it, by its current name or an old one (see Aliases), or -1 if
+// the schema has no such field.
+func fieldIndex(schema Schema, name string) int {
+	for i, field := range schema.Fields {
+		if field == name {
+			return i
+		}
+	}
+	for _, alias := range Aliases(schema.Collector, strings.TrimSuffix(name, suffix(name))) {
+		for i, field := range schema.Fields {
+			if strings.TrimSuffix(field, suffix(field)) == alias {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func keep(sample Sample) []Sample {
+	return []Sample{sample}
+}
+
+// copyFields returns a copy of the fields of the schema, not to modify those of the collector.
+func copyFields(schema Schema) Schema {
+	schema.Fields = append([]string(nil), schema.Fields...)
+	return schema
+}
+
+// Rename renames a field, keeping its kind suffix.
+// The transforms of a field leave the samples of the schemas without it unchanged, so that a
+// chain applies to the captures of several collectors.
+func Rename(name, newName string) Transform {
+	return func(schema Schema) (Schema, func(Sample) []Sample, error) {
+		i := fieldIndex(schema, name)
+		if i < 0 {
+			return schema, keep, nil
+		}
+		schema = copyFields(schema)
+		schema.Fields[i] = newName + suffix(schema.Fields[i])
+		return schema, keep, nil
+	}
+}
+
+// Scale multiplies the values of a field by mul, then divides them by div, to convert their
+// units (as kB to bytes), keeping the sign of the values of a signed field.
+func Scale(name string, mul, div uint64) Transform {
+	return func(schema Schema) (Schema, func(Sample) []Sample, error) {
+		if div == 0 {
+			return schema, nil, fmt.Errorf("division by zero of %q", name)
+		}
+		i := fieldIndex(schema, name)
+		if i < 0 {
+			return schema, keep, nil
+		}
+		signed := schema.Signed()[i]
+		return schema, func(sample Sample) []Sample {
+			values := append([]uint64(nil), sample.Values...)
+			switch {
+			case i >= len(values):
+			case signed:
+				values[i] = uint64(int64(values[i]) * int64(mul) / int64(div))
+			default:
+				values[i] = values[i] * mul / div
+			}
+			sample.Values = values
+			return []Sample{sample}
+		}, nil
+	}
+}
+
+// Drop removes a field.
+func Drop(name string) Transform {
+	return func(schema Schema) (Schema, func(Sample) []Sample, error) {
+		i := fieldIndex(schema, name)
+		if i < 0 {
+			return schema, keep, nil
+		}
+		schema = copyFields(schema)
+		schema.Fields = append(schema.Fields[:i], schema.Fields[i+1:]...)
+		return schema, func(sample Sample) []Sample {
+			if i < len(sample.Values) {
+				values := make([]uint64, 0, len(sample.Values)-1)
+				sample.Values = append(append(values, sample.Values[:i]...), sample.Values[i+1:]...)
+			}
+			return []Sample{sample}
+		}, nil
+	}
+}
+
+// Filter keeps the samples of the instances matching the regular expression, or those not
+// matching it if exclude is true.
+func Filter(instances *regexp.Regexp, exclude bool) Transform {
+	return func(schema Schema) (Schema, func(Sample) []Sample, error) {
+		return schema, func(sample Sample) []Sample {
+			if instances.MatchString(sample.Instance) == exclude {
+				return nil
+			}
+			return []Sample{sample}
+		}, nil
+	}
+}
+
+// Prefix prefixes the instance of the samples, as with the host name to merge the captures of
+// several hosts, the samples of a collector of one instance getting the prefix alone.
+func Prefix(prefix string) Transform {
+	return func(schema Schema) (Schema, func(Sample) []Sample, error) {
+		return schema, func(sample Sample) []Sample {
+			sample.Instance = prefix + sample.Instance
+			return []Sample{sample}
+		}, nil
+	}
+}
+
+// Bind binds the transforms of the chain to the schema, returning the schema of the samples
+// transformed and the function transforming a sample.
+func (chain Chain) Bind(schema Schema) (Schema, func(Sample) []Sample, error) {
+	applies := make([]func(Sample) []Sample, len(chain))
+	for i, transform := range chain {
+		var err error
+		schema, applies[i], err = transform(schema)
+		if err != nil {
+			return schema, nil, err
+		}
+	}
+	return schema, func(sample Sample) []Sample {
+		samples := []Sample{sample}
+		for _, apply := range applies {
+			var next []Sample
+			for _, s := range samples {
+				next = append(next, apply(s)...)
+			}
+			samples = next
+		}
+		return samples
+	}, nil
+}
+
+// ParseChain parses a comma separated list of transforms, as
+// rename:mem:rss_kb=mem:rss,scale:mem:rss*1024,drop:mem:vsz_kb,only:^eth,skip:^lo$,prefix:web1.
+// The fields are given without their kind suffix, the scale as *mul, /div or *mul/div.
+func ParseChain(list string) (chain Chain, err error) {
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		kv := strings.SplitN(item, ":", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("%q: not step:argument", item)
+		}
+		var transform Transform
+		switch step, arg := kv[0], kv[1]; step {
+		case "rename":
+			names := strings.SplitN(arg, "=", 2)
+			if len(names) != 2 || names[1] == "" {
+				return nil, fmt.Errorf("%q: not rename:field=name", item)
+			}
+			transform = Rename(names[0], names[1])
+		case "scale":
+			transform, err = parseScale(arg)
+		case "drop":
+			transform = Drop(arg)
+		case "only", "skip":
+			var instances *regexp.Regexp
+			instances, err = regexp.Compile(arg)
+			transform = Filter(instances, step == "skip")
+		case "prefix":
+			transform = Prefix(arg)
+		default:
+			return nil, fmt.Errorf("%q: unknown step %q (rename, scale, drop, only, skip or prefix)", item, step)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%q: %s", item, err)
+		}
+		chain = append(chain, transform)
+	}
+	return
+}
+
+// parseScale parses the argument of a scale, as mem:rss_kb*1024 or net:bytes*8/1000.
+func parseScale(arg string) (Transform, error) {
+	i := strings.IndexAny(arg, "*/")
+	if i <= 0 {
+		return nil, fmt.Errorf("no *mul or /div")
+	}
+	name, ops := arg[:i], arg[i:]
+	var mul, div uint64 = 1, 1
+	for ops != "" {
+		j := strings.IndexAny(ops[1:], "*/") + 1
+		if j == 0 {
+			j = len(ops)
+		}
+		n, err := strconv.ParseUint(ops[1:j], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		if ops[0] == '*' {
+			mul *= n
+		} else {
+			div *= n
+		}
+		ops = ops[j:]
+	}
+	return Scale(name, mul, div), nil
+}
@@ -0,0 +1,93 @@
+package capture
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestChain binds chains of transforms to a schema and applies them to samples, checking the
+// schema and the samples transformed.
+func TestChain(t *testing.T) {
+	schema := Schema{Collector: "test", Fields: []string{"mem:rss_kb/i", "net:bytes/a", "swap:priority/si"}}
+	samples := []Sample{
+		{Instance: "eth0", Kind: "d", Values: []uint64{2, 100, uint64(1<<64 - 3)}},
+		{Instance: "lo", Kind: "d", Values: []uint64{3, 7, 4}},
+		{Instance: "", Kind: "d", Values: []uint64{5, 9, 0}},
+	}
+	for _, test := range []struct {
+		chain   string
+		fields  string
+		samples string // of the samples transformed, as instance:values
+	}{
+		{"", "mem:rss_kb/i net:bytes/a swap:priority/si", "eth0:2,100,-3 lo:3,7,4 :5,9,0"},
+		{"rename:mem:rss_kb=mem:rss", "mem:rss/i net:bytes/a swap:priority/si", "eth0:2,100,-3 lo:3,7,4 :5,9,0"},
+		{"rename:mem:rss_kb/i=mem:rss", "mem:rss/i net:bytes/a swap:priority/si", "eth0:2,100,-3 lo:3,7,4 :5,9,0"},
+		{"rename:mem:vsz_kb=mem:vsz", "mem:rss_kb/i net:bytes/a swap:priority/si", "eth0:2,100,-3 lo:3,7,4 :5,9,0"},
+		{"scale:mem:rss_kb*1024", "mem:rss_kb/i net:bytes/a swap:priority/si", "eth0:2048,100,-3 lo:3072,7,4 :5120,9,0"},
+		{"scale:net:bytes*8/1000", "mem:rss_kb/i net:bytes/a swap:priority/si", "eth0:2,0,-3 lo:3,0,4 :5,0,0"},
+		{"scale:swap:priority*10/4", "mem:rss_kb/i net:bytes/a swap:priority/si", "eth0:2,100,-7 lo:3,7,10 :5,9,0"},
+		{"drop:net:bytes", "mem:rss_kb/i swap:priority/si", "eth0:2,-3 lo:3,4 :5,0"},
+		{"drop:net:bytes,drop:mem:rss_kb", "swap:priority/si", "eth0:-3 lo:4 :0"},
+		{"only:^eth", "mem:rss_kb/i net:bytes/a swap:priority/si", "eth0:2,100,-3"},
+		{"skip:^lo$", "mem:rss_kb/i net:bytes/a swap:priority/si", "eth0:2,100,-3 :5,9,0"},
+		{"prefix:web1.", "mem:rss_kb/i net:bytes/a swap:priority/si", "web1.eth0:2,100,-3 web1.lo:3,7,4 web1.:5,9,0"},
+		{"skip:^lo$,prefix:web1.,only:^web1.eth", "mem:rss_kb/i net:bytes/a swap:priority/si", "web1.eth0:2,100,-3"},
+		{"rename:net:bytes=net:octets,scale:net:octets*2,drop:mem:rss_kb", "net:octets/a swap:priority/si", "eth0:200,-3 lo:14,4 :18,0"},
+	} {
+		chain, err := ParseChain(test.chain)
+		if err != nil {
+			t.Fatalf("%q: %v", test.chain, err)
+		}
+		transformed, transform, err := chain.Bind(schema)
+		if err != nil {
+			t.Fatalf("%q: %v", test.chain, err)
+		}
+		if got := strings.Join(transformed.Fields, " "); got != test.fields {
+			t.Errorf("%q: fields %s instead of %s", test.chain, got, test.fields)
+		}
+		signed := transformed.Signed()
+		var got []string
+		for _, sample := range samples {
+			for _, s := range transform(sample) {
+				values := make([]string, len(s.Values))
+				for i, value := range s.Values {
+					values[i] = FormatValue(value, signed[i])
+				}
+				got = append(got, s.Instance+":"+strings.Join(values, ","))
+			}
+		}
+		if strings.Join(got, " ") != test.samples {
+			t.Errorf("%q: samples %s instead of %s", test.chain, strings.Join(got, " "), test.samples)
+		}
+		if strings.Join(schema.Fields, " ") != "mem:rss_kb/i net:bytes/a swap:priority/si" {
+			t.Fatalf("%q: fields of the schema bound changed to %v", test.chain, schema.Fields)
+		}
+	}
+}
+
+// TestParseChainErrors parses invalid chains.
+func TestParseChainErrors(t *testing.T) {
+	for _, chain := range []string{
+		"rename",
+		"rename:mem:rss_kb",
+		"rename:mem:rss_kb=",
+		"scale:mem:rss_kb",
+		"scale:mem:rss_kb*x",
+		"scale:*2",
+		"only:(",
+		"split:eth0",
+	} {
+		_, err := ParseChain(chain)
+		if err == nil {
+			t.Errorf("%q: no error", chain)
+		}
+	}
+	chain, err := ParseChain("scale:mem:rss_kb/0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = chain.Bind(Schema{Fields: []string{"mem:rss_kb/i"}})
+	if err == nil {
+		t.Errorf("%q: bound", "scale:mem:rss_kb/0")
+	}
+}
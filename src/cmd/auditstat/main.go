@@ -0,0 +1,20 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	"internal/auditstat"
+	"internal/run"
+)
+
+func main() {
+	tool := run.New("auditstat", auditstat.Separator)
+	filePtr := flag.String("file", auditstat.DefaultLogFile, "audit log to follow, reopened when rotated")
+	backlogPtr := flag.Bool("backlog", false, "count the records already in the audit log at start")
+	tool.Parse()
+	tool.Start()
+	cout := make(chan auditstat.Record)
+	go auditstat.Poll(tool.Schedule, *filePtr, *backlogPtr, tool.Cumul, cout)
+	os.Exit(run.Run(tool, auditstat.Schema, auditstat.Header, cout))
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"os"
+
+	"internal/blkstat"
+	"internal/run"
+)
+
+func main() {
+	tool := run.New("blkstat", blkstat.Separator)
+	tool.Parse()
+	tool.Start()
+	cout := make(chan blkstat.Record)
+	go blkstat.Poll(tool.Schedule, tool.Cumul, cout)
+	os.Exit(run.Run(tool, blkstat.Schema, blkstat.Header, cout))
+}
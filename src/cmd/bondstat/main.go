@@ -0,0 +1,25 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+
+	"internal/bondstat"
+	"internal/run"
+)
+
+func main() {
+	tool := run.New("bondstat", bondstat.Separator)
+	tool.Parse()
+	if !bondstat.IsAvailable() {
+		log.Fatal("No bonds found (bonding driver not loaded)")
+	}
+	tool.Start()
+	tool.Annotate = func(w io.Writer, record run.Record) error {
+		return record.(bondstat.Record).WriteFailovers(w)
+	}
+	cout := make(chan bondstat.Record)
+	go bondstat.Poll(tool.Schedule, tool.Cumul, cout)
+	os.Exit(run.Run(tool, bondstat.Schema, bondstat.Header, cout))
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"capture"
+	"internal/output"
+)
+
+// splitter writes the samples of each part of the captures in its own file, created at the
+// first sample of the part.
+// A part of captures of different schemas (as those of a collector run with different options)
+// or, in text, with and without flags, gets a file per schema, the first one named after the
+// part, the next ones numbered, as "cpustat.1.gob".
+type splitter struct {
+	outdir     string
+	byInstance bool
+	text       bool
+	files      map[string]io.WriteCloser
+	writers    map[partKey]func(capture.Sample) error
+	counts     map[string]int // of the files of each part
+}
+
+// partKey identifies the file of a part.
+type partKey struct {
+	name      string
+	fields    string
+	withFlags bool
+}
+
+// partName returns the name of the file of the sample, as "netstat" or "netstat.eth0".
+// The collector of text captures, not given in the stream, is the base name of the input file.
+func (sp *splitter) partName(collector string, sample capture.Sample) string {
+	if !sp.byInstance || sample.Instance == "" {
+		return collector
+	}
+	return collector + "." + strings.Replace(sample.Instance, "/", "_", -1)
+}
+
+// write writes the sample of the schema, with its flags if withFlags (text only).
+func (sp *splitter) write(schema capture.Schema, withFlags bool, sample capture.Sample) (err error) {
+	name := sp.partName(schema.Collector, sample)
+	key := partKey{name, strings.Join(schema.Fields, " "), withFlags && sp.text}
+	write, ok := sp.writers[key]
+	if !ok {
+		count := sp.counts[name]
+		sp.counts[name]++
+		if count > 0 {
+			name = fmt.Sprint(name, ".", count)
+		}
+		if sp.text {
+			write, err = sp.openText(name, schema, key.withFlags)
+		} else {
+			write, err = sp.openGob(name, schema)
+		}
+		if err != nil {
+			return
+		}
+		sp.writers[key] = write
+	}
+	return write(sample)
+}
+
+func (sp *splitter) open(fileName string) (io.Writer, error) {
+	w, err := output.Open(path.Join(sp.outdir, fileName))
+	if err != nil {
+		return nil, err
+	}
+	sp.files[fileName] = w
+	return w, nil
+}
+
+func (sp *splitter) openGob(name string, schema capture.Schema) (func(capture.Sample) error, error) {
+	w, err := sp.open(name + ".gob")
+	if err != nil {
+		return nil, err
+	}
+	gw, err := capture.NewGobWriter(w, schema)
+	if err != nil {
+		return nil, err
+	}
+	return gw.Write, nil
+}
+
+// openText writes the header of a text file, with the flags column if withFlags, without the
+// instance column if split by instance.
+func (sp *splitter) openText(name string, schema capture.Schema, withFlags bool) (func(capture.Sample) error, error) {
+	w, err := sp.open(name + ".log")
+	if err != nil {
+		return nil, err
+	}
+	tw, err := capture.NewTextWriter(w, schema, withFlags, sp.byInstance)
+	if err != nil {
+		return nil, err
+	}
+	return tw.Write, nil
+}
+
+func (sp *splitter) close() {
+	for _, w := range sp.files {
+		w.Close()
+	}
+}
+
+// split reads the capture of r, in text or gob, into the parts, the samples being transformed
+// by the chain. The text lines of which the integrity suffix does not match are skipped.
+func (sp *splitter) split(r io.Reader, defaultCollector string, chain capture.Chain) error {
+	reader, schema, err := capture.NewReader(r)
+	if err != nil {
+		return err
+	}
+	if schema.Collector == "" {
+		schema.Collector = defaultCollector
+		schema = capture.Upgrade(schema) // text, named from the file
+	}
+	tr, isText := reader.(*capture.TextReader)
+	withFlags := isText && tr.HasFlags()
+	schema, transform, err := chain.Bind(schema)
+	if err != nil {
+		return err
+	}
+	for {
+		sample, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if errors.Is(err, capture.ErrCorruptLine) {
+			log.Print("WARNING: Skipping line: ", err)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, sample := range transform(sample) {
+			err = sp.write(schema, withFlags, sample)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func main() {
+	var usage bool
+	flag.BoolVar(&usage, "usage", false, "prints this usage description")
+	// -h, -help, --help also automatically recognised
+	outdirPtr := flag.String("outdir", ".", "write the parts to outdir/<collector>.gob, or outdir/<collector>.<instance>.gob if split by instance (numbered, as <collector>.1.gob, for the captures of another schema)")
+	byPtr := flag.String("by", "collector", "split the captures by collector, or by instance (one file per device, interface...)")
+	textPtr := flag.Bool("text", false, "write the parts in text (.log) instead of gob")
+	transformPtr := flag.String("transform", "", "transform the samples, renaming, scaling or dropping fields, keeping only or skipping instances matching a regexp, or prefixing the instances, as rename:mem:rss_kb=mem:rss,scale:mem:rss*1024,drop:mem:vsz_kb,only:^eth,skip:^lo$,prefix:web1. (fields without their kind suffix)")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: capsplit [options] <capture files> (or the standard input if none)")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if usage {
+		flag.Usage()
+		return
+	}
+	if *byPtr != "collector" && *byPtr != "instance" {
+		log.Fatalf("Invalid by: %q (collector or instance)", *byPtr)
+	}
+	chain, err := capture.ParseChain(*transformPtr)
+	if err != nil {
+		log.Fatal("Invalid transform: ", err)
+	}
+	sp := &splitter{*outdirPtr, *byPtr == "instance", *textPtr, make(map[string]io.WriteCloser), make(map[partKey]func(capture.Sample) error), make(map[string]int)}
+	defer sp.close()
+	if flag.NArg() == 0 {
+		err := sp.split(os.Stdin, "capture", chain)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	for _, fileName := range flag.Args() {
+		inFile, err := os.Open(fileName)
+		if err != nil {
+			log.Fatal(err)
+		}
+		base := path.Base(fileName)
+		err = sp.split(inFile, strings.TrimSuffix(base, path.Ext(base)), chain)
+		inFile.Close()
+		if err != nil {
+			log.Fatal(fileName, ": ", err)
+		}
+	}
+}
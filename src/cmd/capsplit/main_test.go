@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"capture"
+)
+
+// gobCapture returns a gob capture of the schema, of a sample per instance.
+func gobCapture(t *testing.T, schema capture.Schema, instances ...string) []byte {
+	var buf bytes.Buffer
+	gw, err := capture.NewGobWriter(&buf, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, instance := range instances {
+		err = gw.Write(capture.Sample{Time: time.Unix(int64(i), 0), Instance: instance, Kind: "d", Values: make([]uint64, len(schema.Fields))})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+// readParts returns the parts written in dir, as file:instances read.
+func readParts(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parts []string
+	for _, entry := range entries {
+		inFile, err := os.Open(path.Join(dir, entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader, _, err := capture.NewReader(inFile)
+		if err != nil {
+			t.Fatalf("%s: %v", entry.Name(), err)
+		}
+		var instances []string
+		for {
+			sample, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s: %v", entry.Name(), err)
+			}
+			instances = append(instances, sample.Instance)
+		}
+		inFile.Close()
+		parts = append(parts, entry.Name()+":"+strings.Join(instances, ","))
+	}
+	sort.Strings(parts)
+	return parts
+}
+
+// TestSplit splits captures by collector and by instance, in gob and text, checking the parts.
+func TestSplit(t *testing.T) {
+	netstat := capture.Schema{Collector: "netstat", Fields: []string{"net:bytes/a"}}
+	netstatMore := capture.Schema{Collector: "netstat", Fields: []string{"net:bytes/a", "net:packets/a"}}
+	cpustat := capture.Schema{Collector: "cpustat", Fields: []string{"cpu:user/a"}}
+	inputs := [][]byte{
+		gobCapture(t, netstat, "eth0", "lo", "eth0"),
+		gobCapture(t, cpustat, "", ""),
+		gobCapture(t, netstatMore, "eth0"),
+		gobCapture(t, netstat, "eth1"),
+	}
+	for _, test := range []struct {
+		byInstance, text bool
+		transform        string
+		parts            string
+	}{
+		{false, false, "", "cpustat.gob:, netstat.1.gob:eth0 netstat.gob:eth0,lo,eth0,eth1"},
+		{false, true, "", "cpustat.log:, netstat.1.log:eth0 netstat.log:eth0,lo,eth0,eth1"},
+		{true, false, "", "cpustat.gob:, netstat.eth0.1.gob:eth0 netstat.eth0.gob:eth0,eth0 netstat.eth1.gob:eth1 netstat.lo.gob:lo"},
+		{true, true, "", "cpustat.log:, netstat.eth0.1.log: netstat.eth0.log:, netstat.eth1.log: netstat.lo.log:"}, // without instance column
+		{false, false, "skip:^lo$,drop:net:packets", "cpustat.gob:, netstat.gob:eth0,eth0,eth0,eth1"},
+		{true, false, "only:^eth,prefix:web1.", "netstat.web1.eth0.1.gob:web1.eth0 netstat.web1.eth0.gob:web1.eth0,web1.eth0 netstat.web1.eth1.gob:web1.eth1"},
+	} {
+		dir := t.TempDir()
+		chain, err := capture.ParseChain(test.transform)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sp := &splitter{dir, test.byInstance, test.text, make(map[string]io.WriteCloser), make(map[partKey]func(capture.Sample) error), make(map[string]int)}
+		for _, input := range inputs {
+			err := sp.split(bytes.NewReader(input), "capture", chain)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		sp.close()
+		parts := strings.Join(readParts(t, dir), " ")
+		if parts != test.parts {
+			t.Errorf("by instance %v, text %v, %q: parts %s instead of %s", test.byInstance, test.text, test.transform, parts, test.parts)
+		}
+	}
+}
+
+// TestSplitText splits text captures, the collector being named after the input, checking that
+// the flags are kept and the corrupt lines skipped.
+func TestSplitText(t *testing.T) {
+	suffixed := func(line string) string {
+		suffix, _ := capture.LineSuffix("crc32", []byte(line))
+		return line + " " + suffix + "\n"
+	}
+	input := suffixed("time flags interface h net:bytes/a") +
+		suffixed("2026-10-16T12:00:00.000+0000 ok eth0 d 10") +
+		suffixed("lo d 1") +
+		"2026-10-16T12:00:01.000+0000 counter-reset eth0 d 2" // truncated
+	dir := t.TempDir()
+	sp := &splitter{dir, false, true, make(map[string]io.WriteCloser), make(map[partKey]func(capture.Sample) error), make(map[string]int)}
+	err := sp.split(strings.NewReader(input), "netstat", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sp.close()
+	data, err := os.ReadFile(path.Join(dir, "netstat.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "time flags instance h net:bytes/a\n" +
+		"2026-10-16T12:00:00.000+0000 ok eth0 d 10\n" +
+		"2026-10-16T12:00:00.000+0000 ok lo d 1\n"
+	if string(data) != want {
+		t.Errorf("split into %q instead of %q", data, want)
+	}
+}
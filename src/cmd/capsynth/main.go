@@ -0,0 +1,262 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"capture"
+	_ "internal/collectors" // registering their schemas
+	"internal/output"
+)
+
+// generator generates the values of the fields: a rate (per interval) for an accumulator, added
+// to its counter, or the value itself for an instant value.
+type generator struct {
+	trend  float64 // relative change per sample
+	noise  float64 // relative standard deviation
+	spikes float64 // probability of a spike per sample
+	spike  float64 // factor of the spikes
+	rnd    *rand.Rand
+}
+
+func (g *generator) value(base float64, i int) float64 {
+	v := base * (1 + g.trend*float64(i))
+	v *= 1 + g.noise*g.rnd.NormFloat64()
+	if g.rnd.Float64() < g.spikes {
+		v *= g.spike
+	}
+	return math.Max(v, 0)
+}
+
+// synthesizer generates the samples of the instances of a schema.
+// The capacities keep their base value, and the totals are the sums of their components.
+type synthesizer struct {
+	schema     capture.Schema
+	gen        *generator
+	bases      []float64 // per field
+	isCapacity []bool
+	components [][]int             // of the totals, nil for the other fields
+	mask       uint64              // of the counters, wrapping at their width
+	counters   map[string][]uint64 // per instance
+}
+
+// fieldName returns the name of the field without its kind suffix.
+func fieldName(field string) string {
+	if i := strings.LastIndexByte(field, '/'); i >= 0 {
+		return field[:i]
+	}
+	return field
+}
+
+// newSynthesizer returns a synthesizer of the schema, of which the fields follow the model, with
+// their mean values given by bases, or base for those not given.
+func newSynthesizer(schema capture.Schema, model capture.Model, gen *generator, base float64, bases map[string]float64, mask uint64) (*synthesizer, error) {
+	sy := &synthesizer{schema: schema, gen: gen, mask: mask, counters: make(map[string][]uint64)}
+	indices := make(map[string]int, len(schema.Fields))
+	for j, field := range schema.Fields {
+		indices[fieldName(field)] = j
+	}
+	for name := range bases {
+		if _, ok := indices[name]; !ok {
+			return nil, fmt.Errorf("unknown field %q in bases", name)
+		}
+	}
+	sy.bases = make([]float64, len(schema.Fields))
+	sy.isCapacity = make([]bool, len(schema.Fields))
+	sy.components = make([][]int, len(schema.Fields))
+	for j, field := range schema.Fields {
+		sy.bases[j] = base
+		if b, ok := bases[fieldName(field)]; ok {
+			sy.bases[j] = b
+		}
+	}
+	for _, name := range model.Capacities {
+		if j, ok := indices[name]; ok {
+			sy.isCapacity[j] = true
+		}
+	}
+	for name, components := range model.Totals {
+		j, ok := indices[name]
+		if !ok {
+			continue
+		}
+		for _, component := range components {
+			if k, ok := indices[component]; ok {
+				sy.components[j] = append(sy.components[j], k)
+			}
+		}
+	}
+	return sy, nil
+}
+
+func (sy *synthesizer) sample(t time.Time, i int, instance string) capture.Sample {
+	counters, ok := sy.counters[instance]
+	if !ok {
+		counters = make([]uint64, len(sy.schema.Fields))
+		for j := range counters {
+			counters[j] = uint64(sy.gen.rnd.Int63()) & sy.mask // counters started long ago, near their wrap
+		}
+		for j, components := range sy.components {
+			if components != nil {
+				counters[j] = 0
+				for _, k := range components {
+					counters[j] += counters[k]
+				}
+				counters[j] &= sy.mask
+			}
+		}
+		sy.counters[instance] = counters
+	}
+	increments := make([]uint64, len(sy.schema.Fields))
+	for j := range sy.schema.Fields {
+		switch {
+		case sy.isCapacity[j]:
+			increments[j] = uint64(sy.bases[j])
+		case sy.components[j] == nil:
+			increments[j] = uint64(sy.gen.value(sy.bases[j], i))
+		}
+	}
+	for j, components := range sy.components {
+		for _, k := range components {
+			increments[j] += increments[k]
+		}
+	}
+	values := make([]uint64, len(sy.schema.Fields))
+	for j, field := range sy.schema.Fields {
+		v := increments[j]
+		if strings.HasSuffix(field, "/a") {
+			counters[j] = (counters[j] + v) & sy.mask
+			v = counters[j]
+		}
+		values[j] = v
+	}
+	return capture.Sample{Time: t, Instance: instance, Kind: "a", Values: values}
+}
+
+// schemaOf returns the schema of a known collector and the model of its fields, or the schema of
+// the fields given.
+func schemaOf(collector, fields string) (capture.Schema, capture.Model, error) {
+	if fields != "" {
+		return capture.Schema{Collector: collector, Fields: strings.Split(fields, ",")}, capture.Model{}, nil
+	}
+	schema, model, ok := capture.Registered(collector)
+	if !ok {
+		return schema, model, fmt.Errorf("unknown collector %q (%s), give its fields", collector, strings.Join(capture.Collectors(), ", "))
+	}
+	return schema, model, nil
+}
+
+// parseBases parses the mean values of the fields, as "cpu:idle=9000,cpu:user=800".
+func parseBases(spec string) (map[string]float64, error) {
+	bases := make(map[string]float64)
+	if spec == "" {
+		return bases, nil
+	}
+	for _, item := range strings.Split(spec, ",") {
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("%q: not field=value", item)
+		}
+		value, err := strconv.ParseFloat(kv[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %s", item, err)
+		}
+		bases[fieldName(kv[0])] = value
+	}
+	return bases, nil
+}
+
+func main() {
+	var usage bool
+	flag.BoolVar(&usage, "usage", false, "prints this usage description")
+	// -h, -help, --help also automatically recognised
+	collectorPtr := flag.String("collector", "cpustat", "collector of the capture, of which the fields are known if one of the tools")
+	fieldsPtr := flag.String("fields", "", "fields of the capture, as cpu:user/a,mem:used/i (accumulators with the /a suffix), instead of those of the collector")
+	instancesPtr := flag.String("instances", "-", "instances of the capture, as eth0,eth1 (- for a collector of one instance)")
+	startPtr := flag.String("start", "", "time of the first sample, as 2006-01-02T15:04:05.000-0700 (now less duration if empty)")
+	periodPtr := flag.Duration("interval", 1e9, "interval between the samples")
+	durationPtr := flag.Duration("duration", 3600e9, "duration of the capture")
+	basePtr := flag.Float64("base", 1000, "mean value of the instant values and of the increase of the accumulators per interval, at the start")
+	basesPtr := flag.String("bases", "", "mean values of some fields, instead of base, as cpu:idle=9000,cpu:user=800 (the capacities, as mem:total, keep their value, and the totals, as cpu:total, are the sums of their components)")
+	trendPtr := flag.Float64("trend", 0, "relative change of the mean per sample, as 0.001 for a growth of 0.1% per sample")
+	noisePtr := flag.Float64("noise", 0.1, "relative standard deviation of the values around the mean")
+	spikesPtr := flag.Float64("spikes", 0.01, "probability of a spike per sample")
+	spikePtr := flag.Float64("spike", 10, "factor of the values during a spike")
+	wrapPtr := flag.Uint("wrap", 32, "width in bits of the accumulators, wrapping at 2^wrap (64 for no wrap)")
+	seedPtr := flag.Int64("seed", 1, "seed of the random values, the same seed giving the same capture")
+	gobPtr := flag.String("gob", "", "write a gob stream to this destination (file, '-', tcp:host:port or unix:path) instead of text")
+	flag.Parse()
+	if usage {
+		flag.PrintDefaults()
+		return
+	}
+	if *periodPtr <= 0 || *durationPtr < *periodPtr {
+		log.Fatal("Invalid interval or duration")
+	}
+	if *wrapPtr == 0 || *wrapPtr > 64 {
+		log.Fatal("Invalid wrap: ", *wrapPtr)
+	}
+	schema, model, err := schemaOf(*collectorPtr, *fieldsPtr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	bases, err := parseBases(*basesPtr)
+	if err != nil {
+		log.Fatal("Invalid bases: ", err)
+	}
+	schema.RunID, err = capture.NewRunID()
+	if err != nil {
+		log.Fatal(err)
+	}
+	start := time.Now().Add(-*durationPtr)
+	if *startPtr != "" {
+		start, err = time.Parse(capture.TextTimeFormat, *startPtr)
+		if err != nil {
+			log.Fatal("Invalid start: ", err)
+		}
+	}
+	var write func(capture.Sample) error
+	if *gobPtr != "" {
+		w, err := output.Open(*gobPtr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer w.Close()
+		gw, err := capture.NewGobWriter(w, schema)
+		if err != nil {
+			log.Fatal(err)
+		}
+		write = gw.Write
+	} else {
+		tw, err := capture.NewTextWriter(os.Stdout, schema, false, false)
+		if err != nil {
+			log.Fatal(err)
+		}
+		write = tw.Write
+	}
+	gen := &generator{*trendPtr, *noisePtr, *spikesPtr, *spikePtr, rand.New(rand.NewSource(*seedPtr))}
+	sy, err := newSynthesizer(schema, model, gen, *basePtr, bases, math.MaxUint64>>(64-*wrapPtr))
+	if err != nil {
+		log.Fatal("Invalid bases: ", err)
+	}
+	instances := strings.Split(*instancesPtr, ",")
+	for i := 0; time.Duration(i)**periodPtr <= *durationPtr; i++ {
+		t := start.Add(time.Duration(i) * *periodPtr)
+		for _, instance := range instances {
+			if instance == "-" {
+				instance = ""
+			}
+			err = write(sy.sample(t, i, instance))
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"internal/cgroupstat"
+	"internal/run"
+)
+
+func main() {
+	tool := run.New("cgroupstat", cgroupstat.Separator)
+	cgroupPtr := flag.String("cgroup", "/", "path of the cgroup in the hierarchies, as /system.slice/nginx.service (cgroup v1 only)")
+	tool.Parse()
+	if !cgroupstat.IsV1() {
+		log.Fatal("No cgroup v1 hierarchies found (cgroup v2 not supported yet)")
+	}
+	tool.Start()
+	cout := make(chan cgroupstat.Record)
+	go cgroupstat.Poll(tool.Schedule, *cgroupPtr, tool.Cumul, cout)
+	os.Exit(run.Run(tool, cgroupstat.Schema, cgroupstat.Header, cout))
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"os"
+
+	"internal/cpufreq"
+	"internal/run"
+)
+
+func main() {
+	tool := run.New("cpufreq", cpufreq.Separator)
+	tool.Parse()
+	tool.Start()
+	cout := make(chan cpufreq.Record)
+	go cpufreq.Poll(tool.Schedule, tool.Cumul, cout)
+	os.Exit(run.Run(tool, cpufreq.Schema, cpufreq.Header, cout))
+}
@@ -2,44 +2,23 @@ package main
 
 import (
 	"flag"
-	"fmt"
-	"io"
 	"os"
 
 	"internal/cpustat"
+	"internal/run"
 )
 
-func printLine(wt io.WriterTo) {
-	wt.WriteTo(os.Stdout)
-	os.Stdout.Write([]byte{'\n'})
-}
-
-const RFC3339Millis = "2006-01-02T15:04:05.000-0700"
-
 func main() {
-	var usage bool
-	flag.BoolVar(&usage, "usage", false, "prints this usage description")
-	// -h, -help, --help also automatically recognised
-	periodPtr := flag.Duration("interval", 1e9, "poll interval")                           // defaults to 1e9ns = 1s
-	durationPtr := flag.Duration("duration", 0, "monitoring duration (unlimited if zero)") // defaults to unlimited
-	cumulPtr := flag.Bool("cumul", false, "log cumulative counters instead of delta")
+	tool := run.New("cpustat", cpustat.Separator)
 	relPtr := flag.Bool("rel", true, "relative cpu usage (in pct), ignored if cumul is true")
-	timePtr := flag.Bool("time", true, "add timestamp prefix")
-	flag.Parse()
-	if usage {
-		flag.PrintDefaults()
-		return
-	}
+	availPtr := flag.Bool("avail", false, "relative cpu usage against available (non-stolen) capacity instead of total, ignored if rel is false")
+	msPtr := flag.Bool("ms", false, "cpu times in milliseconds instead of USER_HZ ticks (CLK_TCK), named with the _ms suffix")
+	irqsPtr := flag.Int("irqs", 0, "add the number and count of the given number of busiest irqs")
+	numaPtr := flag.Bool("numa", false, "add a line per NUMA node (cpu times of the node, other fields system-wide)")
+	tool.Parse()
+	tool.Start()
 	cout := make(chan cpustat.Record)
-	go cpustat.Poll(*periodPtr, *durationPtr, *cumulPtr, *relPtr, cout)
-	if *timePtr {
-		fmt.Print("time", cpustat.Separator)
-	}
-	printLine(cpustat.Header)
-	for dat := range cout {
-		if *timePtr {
-			fmt.Print(dat.Time.Format(RFC3339Millis), cpustat.Separator)
-		}
-		printLine(dat)
-	}
+	go cpustat.Poll(tool.Schedule, tool.Cumul, *relPtr, *availPtr, *msPtr, *irqsPtr, *numaPtr, cout)
+	rel := *relPtr && !tool.Cumul
+	os.Exit(run.Run(tool, cpustat.NewSchema(*irqsPtr, *msPtr, rel), cpustat.NewHeader(*irqsPtr, *numaPtr, *msPtr, rel), cout))
 }
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	"internal/dfstat"
+	"internal/run"
+)
+
+func main() {
+	tool := run.New("dfstat", dfstat.Separator)
+	windowPtr := flag.Duration("window", 600e9, "predict the time to full of the filesystems from the growth of the space used over this last window")
+	tool.Parse()
+	tool.Start()
+	cout := make(chan dfstat.Record)
+	go dfstat.Poll(tool.Schedule, *windowPtr, tool.Cumul, cout)
+	os.Exit(run.Run(tool, dfstat.Schema, dfstat.Header, cout))
+}
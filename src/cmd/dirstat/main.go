@@ -0,0 +1,27 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"internal/dirstat"
+	"internal/run"
+)
+
+func main() {
+	tool := run.New("dirstat", dirstat.Separator)
+	dirsPtr := flag.String("dirs", "", "directories to report, as globs, as /var/spool/*,/var/log (required)")
+	tool.Parse()
+	globs, err := dirstat.ParseGlobs(*dirsPtr)
+	if err != nil {
+		log.Fatal("Invalid dirs: ", err)
+	}
+	if len(globs) == 0 {
+		log.Fatal("No directories given (see dirs)")
+	}
+	tool.Start()
+	cout := make(chan dirstat.Record)
+	go dirstat.Poll(tool.Schedule, globs, tool.Cumul, cout)
+	os.Exit(run.Run(tool, dirstat.Schema, dirstat.Header, cout))
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"os"
+
+	"internal/diskstat"
+	"internal/run"
+	"system/blockqueue"
+)
+
+func main() {
+	tool := run.New("diskstat", diskstat.Separator)
+	queuePtr := flag.Bool("queue", false, "print the queue settings of the devices (scheduler, nr_requests, read_ahead_kb) as comment lines before the header, and again before a record when they changed (text only)")
+	mountsPtr := flag.Bool("mounts", false, "add the I/O of the filesystems mounted on block devices, on lines of their mount points, and print the devices of the mount points (LVM and md resolved to their physical devices) as comment lines before the header (mounts read at start)")
+	dmnamesPtr := flag.Bool("dmnames", false, "give the device mapper devices (LVM, multipath) their names, as vg0-data, instead of their numbers, as dm-0, which may change from one boot to the other")
+	tool.Parse()
+	tool.Start()
+	var mounts []diskstat.Mount
+	if *mountsPtr {
+		var err error
+		mounts, err = diskstat.ReadMounts(*dmnamesPtr)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	var queue blockqueue.Settings
+	tool.Comments = func(w io.Writer) (err error) {
+		if *mountsPtr {
+			err = diskstat.WriteMounts(w, mounts)
+			if err != nil {
+				return
+			}
+		}
+		if *queuePtr {
+			queue, err = blockqueue.Read()
+			if err != nil {
+				return
+			}
+			err = queue.Write(w, queue.Changed(nil))
+		}
+		return
+	}
+	tool.Annotate = func(w io.Writer, record run.Record) error {
+		if !*queuePtr {
+			return nil
+		}
+		current, err := blockqueue.Read()
+		if err != nil {
+			log.Print("WARNING: Error reading queue settings, ignoring: ", err)
+			return nil
+		}
+		err = current.Write(w, current.Changed(queue))
+		queue = current
+		return err
+	}
+	cout := make(chan diskstat.Record)
+	go diskstat.Poll(tool.Schedule, mounts, *dmnamesPtr, tool.Cumul, cout)
+	os.Exit(run.Run(tool, diskstat.Schema, diskstat.Header, cout))
+}
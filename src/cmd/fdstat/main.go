@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"internal/fdstat"
+	"internal/run"
+)
+
+// parsePids parses a comma-separated list of pids.
+func parsePids(list string) (pids []int, err error) {
+	if list == "" {
+		return
+	}
+	for _, str := range strings.Split(list, ",") {
+		var pid int
+		pid, err = strconv.Atoi(strings.TrimSpace(str))
+		if err != nil {
+			return
+		}
+		pids = append(pids, pid)
+	}
+	return
+}
+
+func main() {
+	tool := run.New("fdstat", fdstat.Separator)
+	pidsPtr := flag.String("pids", "", "add the number of open file descriptors of these processes (comma-separated pids), with their soft and hard limits and the usage in pct of the soft limit")
+	tool.Parse()
+	pids, err := parsePids(*pidsPtr)
+	if err != nil {
+		log.Fatal("Invalid pids: ", err)
+	}
+	tool.Start()
+	cout := make(chan fdstat.Record)
+	go fdstat.Poll(tool.Schedule, tool.Cumul, pids, cout)
+	os.Exit(run.Run(tool, fdstat.NewSchema(pids), fdstat.NewHeader(pids), cout))
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"internal/filestat"
+	"internal/run"
+)
+
+func main() {
+	tool := run.New("filestat", filestat.Separator)
+	filesPtr := flag.String("files", "", "files to report, as globs, as /var/log/app.log,/var/log/nginx/*.log (required)")
+	tool.Parse()
+	globs, err := filestat.ParseGlobs(*filesPtr)
+	if err != nil {
+		log.Fatal("Invalid files: ", err)
+	}
+	if len(globs) == 0 {
+		log.Fatal("No files given (see files)")
+	}
+	tool.Start()
+	cout := make(chan filestat.Record)
+	go filestat.Poll(tool.Schedule, globs, tool.Cumul, cout)
+	os.Exit(run.Run(tool, filestat.Schema, filestat.Header, cout))
+}
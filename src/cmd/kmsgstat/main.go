@@ -0,0 +1,29 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"os"
+
+	"internal/kmsgstat"
+	"internal/run"
+)
+
+func main() {
+	tool := run.New("kmsgstat", kmsgstat.Separator)
+	patternsPtr := flag.String("patterns", "", "count the messages matching these patterns, and print them as comment lines before the record (text only), as oom,io_error,link_down,segfault=segfault at (predefined or name=regexp)")
+	backlogPtr := flag.Bool("backlog", false, "count the messages already in the kernel ring buffer at start")
+	tool.Parse()
+	patterns, err := kmsgstat.ParsePatterns(*patternsPtr)
+	if err != nil {
+		log.Fatal("Invalid patterns: ", err)
+	}
+	tool.Start()
+	tool.Annotate = func(w io.Writer, record run.Record) error {
+		return record.(kmsgstat.Record).WriteEvents(w)
+	}
+	cout := make(chan kmsgstat.Record)
+	go kmsgstat.Poll(tool.Schedule, patterns, *backlogPtr, tool.Cumul, cout)
+	os.Exit(run.Run(tool, kmsgstat.NewSchema(patterns), kmsgstat.NewHeader(patterns), cout))
+}
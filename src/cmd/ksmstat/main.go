@@ -0,0 +1,21 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"internal/ksmstat"
+	"internal/run"
+)
+
+func main() {
+	tool := run.New("ksmstat", ksmstat.Separator)
+	tool.Parse()
+	if !ksmstat.IsAvailable() {
+		log.Fatal("No ksm counters found (kernel without CONFIG_KSM)")
+	}
+	tool.Start()
+	cout := make(chan ksmstat.Record)
+	go ksmstat.Poll(tool.Schedule, tool.Cumul, cout)
+	os.Exit(run.Run(tool, ksmstat.Schema, ksmstat.Header, cout))
+}
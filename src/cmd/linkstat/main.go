@@ -0,0 +1,17 @@
+package main
+
+import (
+	"os"
+
+	"internal/linkstat"
+	"internal/run"
+)
+
+func main() {
+	tool := run.New("linkstat", linkstat.Separator)
+	tool.Parse()
+	tool.Start()
+	cout := make(chan linkstat.Record)
+	go linkstat.Poll(tool.Schedule, tool.Cumul, cout)
+	os.Exit(run.Run(tool, linkstat.Schema, linkstat.Header, cout))
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"os"
+
+	"internal/loadavg"
+	"internal/run"
+)
+
+func main() {
+	tool := run.New("loadavg", loadavg.Separator)
+	tool.Parse()
+	tool.Start()
+	cout := make(chan loadavg.Record)
+	go loadavg.Poll(tool.Schedule, tool.Cumul, cout)
+	os.Exit(run.Run(tool, loadavg.Schema, loadavg.Header, cout))
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"os"
+
+	"internal/meminfo"
+	"internal/run"
+)
+
+func main() {
+	tool := run.New("memstat", meminfo.Separator)
+	tool.Parse()
+	tool.Start()
+	cout := make(chan meminfo.Record)
+	go meminfo.Poll(tool.Schedule, tool.Cumul, cout)
+	os.Exit(run.Run(tool, meminfo.Schema, meminfo.Header, cout))
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"os"
+
+	"internal/neighstat"
+	"internal/run"
+)
+
+func main() {
+	tool := run.New("neighstat", neighstat.Separator)
+	tool.Parse()
+	tool.Start()
+	cout := make(chan neighstat.Record)
+	go neighstat.Poll(tool.Schedule, tool.Cumul, cout)
+	os.Exit(run.Run(tool, neighstat.Schema, neighstat.Header, cout))
+}
@@ -2,43 +2,19 @@ package main
 
 import (
 	"flag"
-	"fmt"
-	"io"
 	"os"
 
 	"internal/netstat"
+	"internal/run"
 )
 
-func printLine(wt io.WriterTo) {
-	wt.WriteTo(os.Stdout)
-	os.Stdout.Write([]byte{'\n'})
-}
-
-const RFC3339Millis = "2006-01-02T15:04:05.000-0700"
-
 func main() {
-	var usage bool
-	flag.BoolVar(&usage, "usage", false, "prints this usage description")
-	// -h, -help, --help also automatically recognised
-	periodPtr := flag.Duration("interval", 1e9, "poll interval")                           // defaults to 1e9ns = 1s
-	durationPtr := flag.Duration("duration", 0, "monitoring duration (unlimited if zero)") // defaults to unlimited
-	cumulPtr := flag.Bool("cumul", false, "log cumulative counters instead of delta")
-	timePtr := flag.Bool("time", true, "add timestamp prefix")
-	flag.Parse()
-	if usage {
-		flag.PrintDefaults()
-		return
-	}
+	tool := run.New("netstat", netstat.Separator)
+	netnsPtr := flag.Bool("netns", false, "add the interfaces of the other network namespaces, as namespace/interface")
+	sysfsPtr := flag.Bool("sysfs", false, "read the counters of the interfaces in /sys/class/net, in 64 bits, instead of /proc/net/dev (32 bits with some drivers), those of the other namespaces excepted")
+	tool.Parse()
+	tool.Start()
 	cout := make(chan netstat.Record)
-	go netstat.Poll(*periodPtr, *durationPtr, *cumulPtr, cout)
-	if *timePtr {
-		fmt.Print("time", netstat.Separator)
-	}
-	printLine(netstat.Header)
-	for dat := range cout {
-		if *timePtr {
-			fmt.Print(dat.Time.Format(RFC3339Millis), netstat.Separator)
-		}
-		printLine(dat)
-	}
+	go netstat.Poll(tool.Schedule, tool.Cumul, *netnsPtr, *sysfsPtr, cout)
+	os.Exit(run.Run(tool, netstat.Schema, netstat.Header, cout))
 }
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"internal/nfsdstat"
+	"internal/run"
+)
+
+func main() {
+	tool := run.New("nfsdstat", nfsdstat.Separator)
+	tool.Parse()
+	if !nfsdstat.IsAvailable() {
+		log.Fatal("No NFS server statistics found (nfsd not loaded)")
+	}
+	tool.Start()
+	cout := make(chan nfsdstat.Record)
+	go nfsdstat.Poll(tool.Schedule, tool.Cumul, cout)
+	os.Exit(run.Run(tool, nfsdstat.Schema, nfsdstat.Header, cout))
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"os"
+
+	"internal/nftstat"
+	"internal/run"
+)
+
+func main() {
+	tool := run.New("nftstat", nftstat.Separator)
+	tool.Parse()
+	tool.Start()
+	cout := make(chan nftstat.Record)
+	go nftstat.Poll(tool.Schedule, tool.Cumul, cout)
+	os.Exit(run.Run(tool, nftstat.Schema, nftstat.Header, cout))
+}
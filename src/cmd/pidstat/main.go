@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"regexp"
+
+	"internal/pidstat"
+	"internal/run"
+)
+
+func main() {
+	tool := run.New("pidstat", pidstat.Separator)
+	relPtr := flag.Bool("rel", true, "relative cpu times (in pct of the elapsed time), ignored if cumul is true")
+	labelsPtr := flag.Bool("labels", false, "label the line of each process with its user, systemd slice and unit, as 1234:alice:user-1000.slice:session-2.scope")
+	smapsPtr := flag.Bool("smaps", false, "add the pss, uss (private), swap and shared memory of /proc/<pid>/smaps_rollup (Linux 4.14+)")
+	pidPtr := flag.Int("pid", 0, "id of the process to monitor (the wrapped command if zero)")
+	pidfilePtr := flag.String("pidfile", "", "monitor the process whose pid is in this file, read again at each sampling time to follow restarts")
+	namePtr := flag.String("name", "", "monitor the processes with this command name, found at each sampling time, instead of a single process")
+	cmdlinePtr := flag.String("cmdline-regex", "", "monitor the processes with a command line matching this regular expression, found at each sampling time, instead of a single process")
+	tool.Parse()
+	tool.Start()
+	target := pidstat.Target{Pid: *pidPtr, Pidfile: *pidfilePtr, Name: *namePtr}
+	if *cmdlinePtr != "" {
+		var err error
+		target.Cmdline, err = regexp.Compile(*cmdlinePtr)
+		if err != nil {
+			log.Fatal("Invalid cmdline-regex: ", err)
+		}
+	}
+	if target.Pid == 0 && target.Pidfile == "" && target.Name == "" && target.Cmdline == nil && tool.Command != nil {
+		target.Pid = tool.Command.Pid()
+	}
+	if target.Pid < 0 || target.Pid == 0 && target.Pidfile == "" && target.Name == "" && target.Cmdline == nil {
+		log.Fatal("No process to monitor: give a pid, a pidfile, a name, a cmdline-regex or a command to run")
+	}
+	cout := make(chan pidstat.Record)
+	go pidstat.Poll(tool.Schedule, target, tool.Cumul, *relPtr, *smapsPtr, *labelsPtr, cout)
+	os.Exit(run.Run(tool, pidstat.NewSchema(*smapsPtr), pidstat.NewHeader(target, *smapsPtr, *labelsPtr), cout))
+}
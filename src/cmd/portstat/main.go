@@ -0,0 +1,24 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+
+	"internal/portstat"
+	"internal/run"
+)
+
+func main() {
+	tool := run.New("portstat", portstat.Separator)
+	alertPtr := flag.Uint64("alert", portstat.DefaultAlertPct, "print an alert as a comment line before the record when this pct of the ephemeral ports are used, and when back under it (text only, no alert if zero)")
+	tool.Parse()
+	tool.Start()
+	alerter := portstat.NewAlerter(*alertPtr)
+	tool.Annotate = func(w io.Writer, record run.Record) error {
+		return alerter.Write(w, record.(portstat.Record))
+	}
+	cout := make(chan portstat.Record)
+	go portstat.Poll(tool.Schedule, tool.Cumul, cout)
+	os.Exit(run.Run(tool, portstat.Schema, portstat.Header, cout))
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	"internal/procevents"
+	"internal/run"
+)
+
+func main() {
+	tool := run.New("procevents", procevents.Separator)
+	shortPtr := flag.Duration("short", 1e9, "lifetime under which an exited task is counted as short-lived")
+	tool.Parse()
+	tool.Start()
+	cout := make(chan procevents.Record)
+	go procevents.Poll(tool.Schedule, *shortPtr, tool.Cumul, cout)
+	os.Exit(run.Run(tool, procevents.Schema, procevents.Header, cout))
+}
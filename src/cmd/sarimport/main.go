@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+
+	"capture"
+	"internal/output"
+	"internal/sarimport"
+)
+
+// importer writes the samples of each collector in its own gob file, created at the first sample.
+type importer struct {
+	outdir    string
+	runID     string
+	keyframes int
+	files     map[string]io.WriteCloser
+	writers   map[string]*capture.GobWriter
+}
+
+func (imp *importer) write(schema capture.Schema, sample capture.Sample) (err error) {
+	gw, ok := imp.writers[schema.Collector]
+	if !ok {
+		w, err := output.Open(path.Join(imp.outdir, schema.Collector+".gob"))
+		if err != nil {
+			return err
+		}
+		imp.files[schema.Collector] = w
+		schema.RunID = imp.runID
+		gw, err = capture.NewDeltaGobWriter(w, schema, imp.keyframes)
+		if err != nil {
+			return err
+		}
+		imp.writers[schema.Collector] = gw
+	}
+	return gw.Write(sample)
+}
+
+func (imp *importer) close() {
+	for _, w := range imp.files {
+		w.Close()
+	}
+}
+
+func main() {
+	var usage bool
+	flag.BoolVar(&usage, "usage", false, "prints this usage description")
+	// -h, -help, --help also automatically recognised
+	outdirPtr := flag.String("outdir", ".", "write the gob stream of each collector to outdir/<collector>.gob")
+	runidPtr := flag.String("runid", "", "identifier of the run, given in the gob streams (a new UUID if empty)")
+	keyframesPtr := flag.Int("keyframes", 0, "delta-encode the values, with full values every this number of samples (no delta encoding if zero)")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: sadf -j <sa file> -- -A | sarimport [options] (or sarimport [options] <json files>)")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if usage {
+		flag.Usage()
+		return
+	}
+	runID := *runidPtr
+	if runID == "" {
+		var err error
+		runID, err = capture.NewRunID()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	imp := &importer{*outdirPtr, runID, *keyframesPtr, make(map[string]io.WriteCloser), make(map[string]*capture.GobWriter)}
+	defer imp.close()
+	if flag.NArg() == 0 {
+		err := sarimport.Read(os.Stdin, imp.write)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	for _, fileName := range flag.Args() {
+		inFile, err := os.Open(fileName)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = sarimport.Read(inFile, imp.write)
+		inFile.Close()
+		if err != nil {
+			log.Fatal(fileName, ": ", err)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	"internal/run"
+	"internal/schedstat"
+)
+
+func main() {
+	tool := run.New("schedstat", schedstat.Separator)
+	relPtr := flag.Bool("rel", true, "relative run and wait times (in pct of the elapsed time), ignored if cumul is true")
+	tool.Parse()
+	tool.Start()
+	cout := make(chan schedstat.Record)
+	go schedstat.Poll(tool.Schedule, tool.Cumul, *relPtr, cout)
+	os.Exit(run.Run(tool, schedstat.Schema, schedstat.Header, cout))
+}
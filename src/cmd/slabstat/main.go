@@ -0,0 +1,23 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"internal/run"
+	"internal/slabstat"
+)
+
+func main() {
+	tool := run.New("slabstat", slabstat.Separator)
+	topPtr := flag.Int("top", 5, "add the given number of biggest caches, after the totals")
+	tool.Parse()
+	if *topPtr < 0 {
+		log.Fatal("Invalid top: ", *topPtr)
+	}
+	tool.Start()
+	cout := make(chan slabstat.Record)
+	go slabstat.Poll(tool.Schedule, tool.Cumul, *topPtr, cout)
+	os.Exit(run.Run(tool, slabstat.Schema, slabstat.Header, cout))
+}
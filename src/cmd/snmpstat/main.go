@@ -0,0 +1,19 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	"internal/run"
+	"internal/snmpstat"
+)
+
+func main() {
+	tool := run.New("snmpstat", snmpstat.Separator)
+	extPtr := flag.Bool("ext", false, "add the extended TCP and IP counters (TcpExt, IpExt of /proc/net/netstat)")
+	tool.Parse()
+	tool.Start()
+	cout := make(chan snmpstat.Record)
+	go snmpstat.Poll(tool.Schedule, tool.Cumul, *extPtr, cout)
+	os.Exit(run.Run(tool, snmpstat.NewSchema(*extPtr), snmpstat.NewHeader(*extPtr), cout))
+}
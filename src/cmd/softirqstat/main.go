@@ -0,0 +1,19 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	"internal/run"
+	"internal/softirqstat"
+)
+
+func main() {
+	tool := run.New("softirqstat", softirqstat.Separator)
+	percpuPtr := flag.Bool("percpu", false, "add a line per cpu, after the line summed over all cpus")
+	tool.Parse()
+	tool.Start()
+	cout := make(chan softirqstat.Record)
+	go softirqstat.Poll(tool.Schedule, tool.Cumul, *percpuPtr, cout)
+	os.Exit(run.Run(tool, softirqstat.Schema, softirqstat.NewHeader(*percpuPtr), cout))
+}
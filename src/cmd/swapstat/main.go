@@ -0,0 +1,17 @@
+package main
+
+import (
+	"os"
+
+	"internal/run"
+	"internal/swapstat"
+)
+
+func main() {
+	tool := run.New("swapstat", swapstat.Separator)
+	tool.Parse()
+	tool.Start()
+	cout := make(chan swapstat.Record)
+	go swapstat.Poll(tool.Schedule, tool.Cumul, cout)
+	os.Exit(run.Run(tool, swapstat.Schema, swapstat.Header, cout))
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"internal/run"
+	"internal/tcprtt"
+)
+
+func main() {
+	tool := run.New("tcprtt", tcprtt.Separator)
+	portsPtr := flag.String("ports", "", "only the connections of which the local or remote port is one of these, as 443,5432 (all if empty)")
+	tool.Parse()
+	ports, err := tcprtt.ParsePorts(*portsPtr)
+	if err != nil {
+		log.Fatal("Invalid ports: ", err)
+	}
+	tool.Start()
+	cout := make(chan tcprtt.Record)
+	go tcprtt.Poll(tool.Schedule, ports, tool.Cumul, cout)
+	os.Exit(run.Run(tool, tcprtt.Schema, tcprtt.Header, cout))
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"internal/run"
+	"internal/udpstat"
+)
+
+func main() {
+	tool := run.New("udpstat", udpstat.Separator)
+	topPtr := flag.Int("top", udpstat.DefaultTop, "number of ports of the longest receive queues given after the line of all the ports")
+	tool.Parse()
+	if *topPtr < 0 {
+		log.Fatal("Invalid top: ", *topPtr)
+	}
+	tool.Start()
+	cout := make(chan udpstat.Record)
+	go udpstat.Poll(tool.Schedule, *topPtr, tool.Cumul, cout)
+	os.Exit(run.Run(tool, udpstat.Schema, udpstat.Header, cout))
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	"internal/run"
+	"internal/userstat"
+)
+
+func main() {
+	tool := run.New("userstat", userstat.Separator)
+	relPtr := flag.Bool("rel", true, "relative cpu times (in pct of the elapsed time of one cpu), ignored if cumul is true")
+	tool.Parse()
+	tool.Start()
+	cout := make(chan userstat.Record)
+	go userstat.Poll(tool.Schedule, tool.Cumul, *relPtr, cout)
+	os.Exit(run.Run(tool, userstat.Schema, userstat.Header, cout))
+}
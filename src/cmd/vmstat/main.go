@@ -0,0 +1,17 @@
+package main
+
+import (
+	"os"
+
+	"internal/run"
+	"internal/vmstat"
+)
+
+func main() {
+	tool := run.New("vmstat", vmstat.Separator)
+	tool.Parse()
+	tool.Start()
+	cout := make(chan vmstat.Record)
+	go vmstat.Poll(tool.Schedule, tool.Cumul, cout)
+	os.Exit(run.Run(tool, vmstat.Schema, vmstat.Header, cout))
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"internal/run"
+	"internal/wifistat"
+)
+
+func main() {
+	tool := run.New("wifistat", wifistat.Separator)
+	tool.Parse()
+	if !wifistat.IsAvailable() {
+		log.Fatal("No wireless statistics found (kernel without wireless extensions)")
+	}
+	tool.Start()
+	cout := make(chan wifistat.Record)
+	go wifistat.Poll(tool.Schedule, tool.Cumul, cout)
+	os.Exit(run.Run(tool, wifistat.Schema, wifistat.Header, cout))
+}
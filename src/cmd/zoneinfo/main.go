@@ -0,0 +1,17 @@
+package main
+
+import (
+	"os"
+
+	"internal/run"
+	"internal/zoneinfo"
+)
+
+func main() {
+	tool := run.New("zoneinfo", zoneinfo.Separator)
+	tool.Parse()
+	tool.Start()
+	cout := make(chan zoneinfo.Record)
+	go zoneinfo.Poll(tool.Schedule, tool.Cumul, cout)
+	os.Exit(run.Run(tool, zoneinfo.Schema, zoneinfo.Header, cout))
+}
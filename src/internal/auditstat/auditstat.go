@@ -0,0 +1,364 @@
+package auditstat
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"capture"
+	"internal/schedule"
+)
+
+const (
+	DefaultLogFile = "/var/log/audit/audit.log"
+	Separator      = " "
+	allType        = "all"
+)
+
+const (
+	recordsIdx  = iota
+	fieldsCount = iota
+)
+
+// The records of the log are counted per type, an event (as a system call) being logged in
+// several records of different types (SYSCALL, PATH, CWD, PROCTITLE).
+var allFieldsDefs = []fieldDef{
+	fieldDef{"audit", "records", true},
+}
+
+/* Header is a list of field names. */
+
+type header []string
+
+func makeHeader(fdl []fieldDef) header {
+	h := header(make([]string, 2+len(fdl)))
+	h[0] = "type"
+	h[1] = "h"
+	for i, d := range fdl {
+		h[i+2] = d.String()
+	}
+	return h
+}
+
+func (h header) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	err = writeTo(w, strings.Join(h, Separator), &n)
+	return
+}
+
+var fsRoot string
+
+func warn(v ...interface{}) {
+	log.Print("WARNING: ", fmt.Sprint(v...))
+}
+
+func init() {
+	fsRoot = os.Getenv("FS_ROOT")
+}
+
+func writeTo(w io.Writer, v interface{}, p *int64) (err error) {
+	m, err := w.Write([]byte(fmt.Sprint(v)))
+	*p += int64(m)
+	return
+}
+
+/* Field Definition */
+
+type fieldDef struct {
+	category      string
+	name          string
+	isAccumulator bool
+}
+
+func (fd fieldDef) String() string { // implements fmt.Stringer
+	if fd.isAccumulator {
+		return fd.category + ":" + fd.name + "/a"
+	} else {
+		return fd.category + ":" + fd.name + "/i"
+	}
+}
+
+/* Log */
+
+// auditLog follows the audit log as tail -F does, reading the lines appended since the previous
+// read, and reopening the log when it was rotated or truncated.
+type auditLog struct {
+	fileName string
+	file     *os.File
+	offset   int64
+	partial  string // last line, not terminated yet
+}
+
+func openLog(fileName string, backlog bool) (l *auditLog, err error) {
+	if fsRoot != "" {
+		fileName = path.Join(fsRoot, fileName)
+	}
+	l = &auditLog{fileName: fileName}
+	l.file, err = os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if !backlog {
+		l.offset, err = l.file.Seek(0, io.SeekEnd)
+		if err != nil {
+			l.file.Close()
+			return nil, err
+		}
+	}
+	return
+}
+
+// reopen reopens the log if it was replaced by a new file (rotation) or truncated, the lines
+// appended to the old file since the previous read being read first.
+func (l *auditLog) reopen(count func(line string)) (err error) {
+	info, err := os.Stat(l.fileName)
+	if os.IsNotExist(err) {
+		return nil // being rotated
+	}
+	if err != nil {
+		return
+	}
+	openInfo, err := l.file.Stat()
+	if err != nil {
+		return
+	}
+	if os.SameFile(info, openInfo) {
+		if info.Size() < l.offset {
+			warn("Audit log truncated, reading it from the start")
+			l.offset, l.partial = 0, ""
+			_, err = l.file.Seek(0, io.SeekStart)
+		}
+		return
+	}
+	err = l.read(count)
+	if err != nil {
+		return
+	}
+	file, err := os.Open(l.fileName)
+	if err != nil {
+		return
+	}
+	l.file.Close()
+	l.file, l.offset, l.partial = file, 0, ""
+	return
+}
+
+// read counts the lines appended since the previous read.
+func (l *auditLog) read(count func(line string)) (err error) {
+	reader := bufio.NewReader(l.file)
+	for {
+		line, err := reader.ReadString('\n')
+		l.offset += int64(len(line))
+		if err == io.EOF {
+			l.partial += line
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		count(l.partial + strings.TrimSuffix(line, "\n"))
+		l.partial = ""
+	}
+}
+
+// recordType returns the type of a record of the log, as "SYSCALL" in
+// "type=SYSCALL msg=audit(1364481363.243:24287): arch=c000003e syscall=2 ...".
+func recordType(line string) string {
+	if !strings.HasPrefix(line, "type=") {
+		return ""
+	}
+	line = line[len("type="):]
+	if end := strings.IndexByte(line, ' '); end >= 0 {
+		line = line[:end]
+	}
+	return line
+}
+
+/* Record */
+
+var Header = makeHeader(allFieldsDefs)
+
+// Schema describes the fields of the records, for typed output.
+var Schema = capture.Register(capture.Schema{Collector: "auditstat", Fields: Header[2:]})
+
+type Record struct {
+	capture.RecordInfo
+	isCumul     bool
+	typesFields map[string][]uint64 // per type of audit record, all types included
+}
+
+func newRecord(isCumul bool) *Record {
+	recordPtr := new(Record)
+	recordPtr.isCumul = isCumul
+	recordPtr.typesFields = make(map[string][]uint64)
+	return recordPtr
+}
+
+// types returns the types of audit records seen since the start, after the "all" line.
+func (record Record) types() []string {
+	types := make([]string, 0, len(record.typesFields))
+	for typ := range record.typesFields {
+		if typ != allType {
+			types = append(types, typ)
+		}
+	}
+	sort.Strings(types)
+	return append([]string{allType}, types...)
+}
+
+func (recordPtr *Record) String() string { // implements fmt.Stringer
+	buf := new(bytes.Buffer)
+	recordPtr.WriteTo(buf)
+	return buf.String()
+}
+func (record Record) kind() string {
+	if record.isCumul {
+		return "a"
+	}
+	return "d"
+}
+
+// WriteTo writes one line for all the types, then one line per type.
+func (record Record) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	for i, typ := range record.types() {
+		if i > 0 {
+			err = writeTo(w, "\n", &n)
+			if err != nil {
+				return
+			}
+		}
+		err = writeTo(w, typ+Separator+record.kind(), &n)
+		if err != nil {
+			return
+		}
+		for _, field := range record.fieldsOf(typ) {
+			err = writeTo(w, Separator, &n)
+			if err != nil {
+				return
+			}
+			err = writeTo(w, field, &n)
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+func (record Record) fieldsOf(typ string) []uint64 {
+	fields, ok := record.typesFields[typ]
+	if !ok {
+		fields = make([]uint64, fieldsCount)
+	}
+	return fields
+}
+
+// Samples returns the typed form of the record, one sample per type, after the "all" sample.
+func (record Record) Samples() []capture.Sample {
+	types := record.types()
+	samples := make([]capture.Sample, 0, len(types))
+	for _, typ := range types {
+		values := make([]uint64, fieldsCount)
+		copy(values, record.fieldsOf(typ))
+		samples = append(samples, capture.Sample{Time: record.Time, Instance: typ, Kind: record.kind(), Values: values})
+	}
+	return samples
+}
+
+// count counts a line of the log, in its type and in all.
+func (recordPtr *Record) count(line string) {
+	typ := recordType(line)
+	if typ == "" {
+		return
+	}
+	for _, t := range []string{typ, allType} {
+		fields, ok := recordPtr.typesFields[t]
+		if !ok {
+			fields = make([]uint64, fieldsCount)
+			recordPtr.typesFields[t] = fields
+		}
+		fields[recordsIdx]++
+	}
+}
+
+// copyFrom copies the counters of another record, to add the lines read since it.
+func (recordPtr *Record) copyFrom(record *Record) {
+	recordPtr.typesFields = make(map[string][]uint64, len(record.typesFields))
+	for typ, fields := range record.typesFields {
+		recordPtr.typesFields[typ] = append([]uint64(nil), fields...)
+	}
+}
+
+// diff computes the diffs of the counters, a type seen since the previous record having all
+// its records counted in the interval.
+func (recordPtr *Record) diff(prevRecord, diffRecord *Record) {
+	diffRecord.RecordInfo = recordPtr.RecordInfo
+	diffRecord.typesFields = make(map[string][]uint64, len(recordPtr.typesFields))
+	for typ, fields := range recordPtr.typesFields {
+		prevFields := prevRecord.fieldsOf(typ)
+		diffFields := make([]uint64, fieldsCount)
+		for i, field := range fields {
+			if allFieldsDefs[i].isAccumulator {
+				diffFields[i] = field - prevFields[i]
+			} else {
+				diffFields[i] = field
+			}
+		}
+		diffRecord.typesFields[typ] = diffFields
+	}
+	return
+}
+
+func (recordPtr *Record) parse(l *auditLog) (err error) {
+	defer recordPtr.EndRead(time.Now())
+	recordPtr.Time = time.Now()
+	err = l.reopen(recordPtr.count)
+	if err != nil {
+		return
+	}
+	return l.read(recordPtr.count)
+}
+
+/* Polling */
+
+// Poll follows the audit log, from its end unless backlog is true, and sends a Record in the
+// channel at each sampling time of the schedule.
+// If cumul is false, it prints the diff of the accumulators, instead of the accumulators themselves
+func Poll(sched *schedule.Schedule, logFile string, backlog bool, cumul bool, cout chan Record) {
+	l, err := openLog(logFile, backlog)
+	if err != nil {
+		warn("Error opening audit log (root privileges may be required): ", err)
+		close(cout)
+		return
+	}
+	defer l.file.Close()
+	recordPtr := newRecord(true)
+	oldRecordPtr := newRecord(true)
+	diffRecordPtr := newRecord(false)
+	for i := 0; sched.Next(); i++ {
+		recordPtr.copyFrom(oldRecordPtr)
+		err := recordPtr.parse(l)
+		if err != nil {
+			warn("Error parsing record, ignoring: ", err)
+			continue
+		}
+		if cumul {
+			cout <- *recordPtr
+		} else {
+			if i < 1 {
+				cout <- *recordPtr
+			} else {
+				recordPtr.diff(oldRecordPtr, diffRecordPtr)
+				cout <- *diffRecordPtr
+			}
+		}
+		oldRecordPtr, recordPtr = recordPtr, oldRecordPtr
+	}
+	close(cout)
+}
@@ -0,0 +1,332 @@
+package blkstat
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"capture"
+	"internal/schedule"
+)
+
+const (
+	defaultSysBlockDir = "/sys/block"
+	Separator          = " "
+)
+
+const (
+	rdIosIdx        = iota
+	rdTicksIdx      = iota
+	wrIosIdx        = iota
+	wrTicksIdx      = iota
+	inFlightRdIdx   = iota
+	inFlightWrIdx   = iota
+	ioTicksIdx      = iota
+	ioQueueIdx      = iota
+	awaitIdx        = iota
+	utilIdx         = iota
+	queueDepthIdx   = iota
+	fieldsCount     = iota
+	derivedFirstIdx = awaitIdx
+)
+
+// The in-flight I/Os are read from inflight, split between reads and writes. The average
+// latency, utilization and queue depth are derived from the diffs of the interval, and are
+// zero in the cumulative records.
+var allFieldsDefs = []fieldDef{
+	fieldDef{"rd", "ios", true},
+	fieldDef{"rd", "ms", true},
+	fieldDef{"wr", "ios", true},
+	fieldDef{"wr", "ms", true},
+	fieldDef{"io", "in_flight_rd", false},
+	fieldDef{"io", "in_flight_wr", false},
+	fieldDef{"io", "ms", true},
+	fieldDef{"io", "queue_ms", true},
+	fieldDef{"io", "await_us", false},
+	fieldDef{"io", "util_pct", false},
+	fieldDef{"io", "queue_depth_x100", false},
+}
+
+// columns of /sys/block/<dev>/stat, as those of /proc/diskstats after the device name
+const (
+	rdIosCol   = 0
+	rdTicksCol = 3
+	wrIosCol   = 4
+	wrTicksCol = 7
+	ioTicksCol = 9
+	ioQueueCol = 10
+)
+
+/* Header is a list of field names. */
+
+type header []string
+
+func makeHeader(fdl []fieldDef) header {
+	h := header(make([]string, 2+len(fdl)))
+	h[0] = "device"
+	h[1] = "h"
+	for i, d := range fdl {
+		h[i+2] = d.String()
+	}
+	return h
+}
+
+func (h header) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	err = writeTo(w, strings.Join(h, Separator), &n)
+	return
+}
+
+var sysBlockDir string = defaultSysBlockDir
+
+func warn(v ...interface{}) {
+	log.Print("WARNING: ", fmt.Sprint(v...))
+}
+
+func init() {
+	fsRoot := os.Getenv("FS_ROOT")
+	if fsRoot != "" {
+		sysBlockDir = path.Join(fsRoot, defaultSysBlockDir)
+	}
+}
+
+func writeTo(w io.Writer, v interface{}, p *int64) (err error) {
+	m, err := w.Write([]byte(fmt.Sprint(v)))
+	*p += int64(m)
+	return
+}
+
+/* Field Definition */
+
+type fieldDef struct {
+	category      string
+	name          string
+	isAccumulator bool
+}
+
+func (fd fieldDef) String() string { // implements fmt.Stringer
+	if fd.isAccumulator {
+		return fd.category + ":" + fd.name + "/a"
+	} else {
+		return fd.category + ":" + fd.name + "/i"
+	}
+}
+
+/* Device files */
+
+func readUints(fileName string) (values []uint64, err error) {
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return
+	}
+	for _, str := range strings.Fields(string(content)) {
+		value, err := strconv.ParseUint(str, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return
+}
+
+// parseDevice reads the stat and inflight files of the device, the devices without any I/O
+// since boot being left out (nil fields).
+func parseDevice(device string) (fields []uint64, err error) {
+	stat, err := readUints(path.Join(sysBlockDir, device, "stat"))
+	if err != nil {
+		return
+	}
+	if len(stat) <= ioQueueCol {
+		return nil, fmt.Errorf("unexpected content of %s stat: %v", device, stat)
+	}
+	inflight, err := readUints(path.Join(sysBlockDir, device, "inflight"))
+	if err != nil {
+		return
+	}
+	if len(inflight) != 2 {
+		return nil, fmt.Errorf("unexpected content of %s inflight: %v", device, inflight)
+	}
+	if stat[rdIosCol] == 0 && stat[wrIosCol] == 0 {
+		return nil, nil
+	}
+	fields = make([]uint64, fieldsCount)
+	fields[rdIosIdx] = stat[rdIosCol]
+	fields[rdTicksIdx] = stat[rdTicksCol]
+	fields[wrIosIdx] = stat[wrIosCol]
+	fields[wrTicksIdx] = stat[wrTicksCol]
+	fields[inFlightRdIdx] = inflight[0]
+	fields[inFlightWrIdx] = inflight[1]
+	fields[ioTicksIdx] = stat[ioTicksCol]
+	fields[ioQueueIdx] = stat[ioQueueCol]
+	return
+}
+
+/* Record */
+
+var Header = makeHeader(allFieldsDefs)
+
+// Schema describes the fields of the records, for typed output.
+var Schema = capture.Register(capture.Schema{Collector: "blkstat", Fields: Header[2:]})
+
+type Record struct {
+	capture.RecordInfo
+	isCumul   bool
+	fieldsMap map[string][]uint64 // key is the device name
+}
+
+func newRecord(isCumul bool) *Record {
+	recordPtr := new(Record)
+	recordPtr.isCumul = isCumul
+	recordPtr.fieldsMap = make(map[string][]uint64)
+	return recordPtr
+}
+
+func (record Record) deviceNames() []string {
+	names := make([]string, 0, len(record.fieldsMap))
+	for name := range record.fieldsMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (recordPtr *Record) String() string { // implements fmt.Stringer
+	buf := new(bytes.Buffer)
+	recordPtr.WriteTo(buf)
+	return buf.String()
+}
+func (record Record) kind() string {
+	if record.isCumul {
+		return "a"
+	}
+	return "d"
+}
+func (record Record) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	for i, device := range record.deviceNames() {
+		if i > 0 {
+			err = writeTo(w, "\n", &n)
+			if err != nil {
+				return
+			}
+		}
+		err = writeTo(w, device+Separator+record.kind(), &n)
+		if err != nil {
+			return
+		}
+		for _, field := range record.fieldsMap[device] {
+			err = writeTo(w, Separator, &n)
+			if err != nil {
+				return
+			}
+			err = writeTo(w, field, &n)
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// Samples returns the typed form of the record, one sample per device.
+func (record Record) Samples() []capture.Sample {
+	samples := make([]capture.Sample, 0, len(record.fieldsMap))
+	for _, device := range record.deviceNames() {
+		values := make([]uint64, fieldsCount)
+		copy(values, record.fieldsMap[device])
+		samples = append(samples, capture.Sample{Time: record.Time, Instance: device, Kind: record.kind(), Values: values})
+	}
+	return samples
+}
+
+// diff computes the diffs of the accumulators, then derives from them the average latency of
+// the I/Os completed (as await of iostat), the utilization and the average queue depth (as
+// aqu-sz of iostat) over the interval.
+func (recordPtr *Record) diff(prevRecord, diffRecord *Record) {
+	diffRecord.RecordInfo = recordPtr.RecordInfo
+	diffRecord.fieldsMap = make(map[string][]uint64, len(recordPtr.fieldsMap))
+	elapsedMs := uint64(recordPtr.Time.Sub(prevRecord.Time) / time.Millisecond)
+	for device, fields := range recordPtr.fieldsMap {
+		prevFields, ok := prevRecord.fieldsMap[device]
+		if !ok {
+			prevFields = make([]uint64, fieldsCount)
+		}
+		diffFields := make([]uint64, fieldsCount)
+		for i, field := range fields[:derivedFirstIdx] {
+			if allFieldsDefs[i].isAccumulator {
+				diffFields[i] = field - prevFields[i]
+			} else {
+				diffFields[i] = field
+			}
+		}
+		ios := diffFields[rdIosIdx] + diffFields[wrIosIdx]
+		if ios > 0 {
+			diffFields[awaitIdx] = (diffFields[rdTicksIdx] + diffFields[wrTicksIdx]) * 1000 / ios
+		}
+		if elapsedMs > 0 {
+			diffFields[utilIdx] = diffFields[ioTicksIdx] * 100 / elapsedMs
+			diffFields[queueDepthIdx] = diffFields[ioQueueIdx] * 100 / elapsedMs
+		}
+		diffRecord.fieldsMap[device] = diffFields
+	}
+	return
+}
+
+func (recordPtr *Record) parse() (err error) {
+	defer recordPtr.EndRead(time.Now())
+	entries, err := ioutil.ReadDir(sysBlockDir)
+	if err != nil {
+		return
+	}
+	recordPtr.Time = time.Now()
+	recordPtr.fieldsMap = make(map[string][]uint64, len(recordPtr.fieldsMap))
+	for _, entry := range entries {
+		fields, err := parseDevice(entry.Name())
+		if os.IsNotExist(err) {
+			continue // removed since listed
+		}
+		if err != nil {
+			return err
+		}
+		if fields != nil {
+			recordPtr.fieldsMap[entry.Name()] = fields
+		}
+	}
+	return
+}
+
+/* Polling */
+
+// Poll sends a Record in the channel at each sampling time of the schedule.
+// If cumul is false, it prints the diff of the accumulators, instead of the accumulators themselves,
+// with the derived fields.
+func Poll(sched *schedule.Schedule, cumul bool, cout chan Record) {
+	recordPtr := newRecord(true)
+	oldRecordPtr := newRecord(true)
+	diffRecordPtr := newRecord(false)
+	for i := 0; sched.Next(); i++ {
+		err := recordPtr.parse()
+		if err != nil {
+			warn("Error parsing record, ignoring: ", err)
+			continue
+		}
+		if cumul {
+			cout <- *recordPtr
+		} else {
+			if i < 1 {
+				cout <- *recordPtr
+			} else {
+				recordPtr.diff(oldRecordPtr, diffRecordPtr)
+				cout <- *diffRecordPtr
+			}
+			oldRecordPtr, recordPtr = recordPtr, oldRecordPtr
+		}
+	}
+	close(cout)
+}
@@ -0,0 +1,347 @@
+package bondstat
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"capture"
+	"internal/schedule"
+)
+
+const (
+	defaultProcNetBonding = "/proc/net/bonding"
+	Separator             = " "
+	FailoverPrefix        = "# failover:"
+)
+
+const (
+	miiUpIdx    = iota
+	activeIdx   = iota
+	slavesUpIdx = iota
+	failuresIdx = iota
+	fieldsCount = iota
+)
+
+// Each bond is given on a line, followed by one line per slave, as "bond0/eth1".
+// The slaves up and the link failures of a bond are the sums of those of its slaves, the
+// active slave being only known in the active-backup modes.
+var allFieldsDefs = []fieldDef{
+	fieldDef{"mii", "up", false},
+	fieldDef{"slave", "active", false},
+	fieldDef{"slaves", "up", false},
+	fieldDef{"link", "failures", true},
+}
+
+/* Header is a list of field names. */
+
+type header []string
+
+func makeHeader(fdl []fieldDef) header {
+	h := header(make([]string, 2+len(fdl)))
+	h[0] = "bond"
+	h[1] = "h"
+	for i, d := range fdl {
+		h[i+2] = d.String()
+	}
+	return h
+}
+
+func (h header) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	err = writeTo(w, strings.Join(h, Separator), &n)
+	return
+}
+
+var procNetBonding string = defaultProcNetBonding
+
+func warn(v ...interface{}) {
+	log.Print("WARNING: ", fmt.Sprint(v...))
+}
+
+func init() {
+	fsRoot := os.Getenv("FS_ROOT")
+	if fsRoot != "" {
+		procNetBonding = path.Join(fsRoot, defaultProcNetBonding)
+	}
+}
+
+// IsAvailable returns true if the bonding driver is loaded.
+func IsAvailable() bool {
+	_, err := os.Stat(procNetBonding)
+	return err == nil
+}
+
+func writeTo(w io.Writer, v interface{}, p *int64) (err error) {
+	m, err := w.Write([]byte(fmt.Sprint(v)))
+	*p += int64(m)
+	return
+}
+
+/* Field Definition */
+
+type fieldDef struct {
+	category      string
+	name          string
+	isAccumulator bool
+}
+
+func (fd fieldDef) String() string { // implements fmt.Stringer
+	if fd.isAccumulator {
+		return fd.category + ":" + fd.name + "/a"
+	} else {
+		return fd.category + ":" + fd.name + "/i"
+	}
+}
+
+/* Record */
+
+var Header = makeHeader(allFieldsDefs)
+
+// Schema describes the fields of the records, for typed output.
+var Schema = capture.Register(capture.Schema{Collector: "bondstat", Fields: Header[2:]})
+
+type Record struct {
+	capture.RecordInfo
+	isCumul   bool
+	fieldsMap map[string][]uint64 // key is the bond, or the bond and the slave as "bond0/eth1"
+	active    map[string]string   // active slave per bond, empty if none
+	failovers []string            // changes of the active slave since the previous record
+}
+
+func newRecord(isCumul bool) *Record {
+	recordPtr := new(Record)
+	recordPtr.isCumul = isCumul
+	recordPtr.fieldsMap = make(map[string][]uint64)
+	recordPtr.active = make(map[string]string)
+	return recordPtr
+}
+
+// names returns the bonds, each followed by its slaves.
+func (record Record) names() []string {
+	names := make([]string, 0, len(record.fieldsMap))
+	for name := range record.fieldsMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (recordPtr *Record) String() string { // implements fmt.Stringer
+	buf := new(bytes.Buffer)
+	recordPtr.WriteTo(buf)
+	return buf.String()
+}
+func (record Record) kind() string {
+	if record.isCumul {
+		return "a"
+	}
+	return "d"
+}
+func (record Record) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	for i, name := range record.names() {
+		if i > 0 {
+			err = writeTo(w, "\n", &n)
+			if err != nil {
+				return
+			}
+		}
+		err = writeTo(w, name+Separator+record.kind(), &n)
+		if err != nil {
+			return
+		}
+		for _, field := range record.fieldsMap[name] {
+			err = writeTo(w, Separator, &n)
+			if err != nil {
+				return
+			}
+			err = writeTo(w, field, &n)
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// WriteFailovers writes the changes of the active slave of the bonds since the previous record
+// ("# failover: bond0: eth0 -> eth1") as comment lines, to be written before the record.
+func (record Record) WriteFailovers(w io.Writer) (err error) {
+	for _, failover := range record.failovers {
+		_, err = fmt.Fprint(w, FailoverPrefix, " ", failover, "\n")
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Samples returns the typed form of the record, one sample per bond and per slave.
+func (record Record) Samples() []capture.Sample {
+	samples := make([]capture.Sample, 0, len(record.fieldsMap))
+	for _, name := range record.names() {
+		values := make([]uint64, fieldsCount)
+		copy(values, record.fieldsMap[name])
+		samples = append(samples, capture.Sample{Time: record.Time, Instance: name, Kind: record.kind(), Values: values})
+	}
+	return samples
+}
+
+// diff computes the diffs of the link failures, a slave enslaved since the previous record
+// having all its failures counted in the interval.
+func (recordPtr *Record) diff(prevRecord, diffRecord *Record) {
+	diffRecord.RecordInfo = recordPtr.RecordInfo
+	diffRecord.failovers = recordPtr.failovers
+	diffRecord.fieldsMap = make(map[string][]uint64, len(recordPtr.fieldsMap))
+	for name, fields := range recordPtr.fieldsMap {
+		prevFields, ok := prevRecord.fieldsMap[name]
+		diffFields := make([]uint64, fieldsCount)
+		for i, field := range fields {
+			if allFieldsDefs[i].isAccumulator && ok && field >= prevFields[i] {
+				diffFields[i] = field - prevFields[i]
+			} else {
+				diffFields[i] = field
+			}
+		}
+		diffRecord.fieldsMap[name] = diffFields
+	}
+	return
+}
+
+// parseBond parses the status of a bond, as
+//
+//	Currently Active Slave: eth0
+//	MII Status: up
+//	...
+//	Slave Interface: eth0
+//	MII Status: up
+//	Link Failure Count: 0
+//
+// the lines following "Slave Interface" being those of the slave.
+func (recordPtr *Record) parseBond(bond string) (err error) {
+	inFile, err := os.Open(path.Join(procNetBonding, bond))
+	if err != nil {
+		return
+	}
+	defer inFile.Close()
+	bondFields := make([]uint64, fieldsCount)
+	recordPtr.fieldsMap[bond] = bondFields
+	fields := bondFields
+	slave := false
+	active := ""
+	scanner := bufio.NewScanner(inFile)
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(kv[1])
+		switch kv[0] {
+		case "Currently Active Slave":
+			if value != "None" {
+				active = value
+			}
+		case "Slave Interface":
+			fields = make([]uint64, fieldsCount)
+			slave = true
+			if value == active {
+				fields[activeIdx] = 1
+			}
+			recordPtr.fieldsMap[bond+"/"+value] = fields
+		case "MII Status":
+			if value == "up" {
+				fields[miiUpIdx] = 1
+				if slave {
+					fields[slavesUpIdx] = 1
+					bondFields[slavesUpIdx]++
+				}
+			}
+		case "Link Failure Count":
+			var count uint64
+			count, err = strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return
+			}
+			fields[failuresIdx] = count
+			bondFields[failuresIdx] += count
+		}
+	}
+	recordPtr.active[bond] = active
+	err = scanner.Err()
+	return
+}
+
+func slaveName(slave string) string {
+	if slave == "" {
+		return "none"
+	}
+	return slave
+}
+
+// parse reads the bonds of /proc/net/bonding, the failovers being the changes of their active
+// slave since the previous record (prevActive).
+func (recordPtr *Record) parse(prevActive map[string]string) (err error) {
+	defer recordPtr.EndRead(time.Now())
+	entries, err := ioutil.ReadDir(procNetBonding)
+	if err != nil {
+		return
+	}
+	recordPtr.Time = time.Now()
+	recordPtr.fieldsMap = make(map[string][]uint64, len(recordPtr.fieldsMap))
+	recordPtr.active = make(map[string]string, len(entries))
+	recordPtr.failovers = nil
+	for _, entry := range entries {
+		err = recordPtr.parseBond(entry.Name())
+		if os.IsNotExist(err) {
+			continue // removed since listed
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s", entry.Name(), err)
+		}
+		prev, ok := prevActive[entry.Name()]
+		if active := recordPtr.active[entry.Name()]; ok && active != prev {
+			recordPtr.failovers = append(recordPtr.failovers, entry.Name()+": "+slaveName(prev)+" -> "+slaveName(active))
+		}
+	}
+	return
+}
+
+/* Polling */
+
+// Poll sends a Record in the channel at each sampling time of the schedule.
+// If cumul is false, it prints the diff of the accumulators, instead of the accumulators themselves
+// (only the link failures, the other fields being instant values).
+func Poll(sched *schedule.Schedule, cumul bool, cout chan Record) {
+	recordPtr := newRecord(true)
+	oldRecordPtr := newRecord(true)
+	diffRecordPtr := newRecord(false)
+	var active map[string]string
+	for i := 0; sched.Next(); i++ {
+		err := recordPtr.parse(active)
+		if err != nil {
+			warn("Error parsing record, ignoring: ", err)
+			continue
+		}
+		active = recordPtr.active
+		if cumul {
+			cout <- *recordPtr
+		} else {
+			if i < 1 {
+				cout <- *recordPtr
+			} else {
+				recordPtr.diff(oldRecordPtr, diffRecordPtr)
+				cout <- *diffRecordPtr
+			}
+			oldRecordPtr, recordPtr = recordPtr, oldRecordPtr
+		}
+	}
+	close(cout)
+}
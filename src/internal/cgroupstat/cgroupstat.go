@@ -0,0 +1,316 @@
+package cgroupstat
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"capture"
+	"internal/schedule"
+)
+
+const (
+	defaultCgroupDir = "/sys/fs/cgroup"
+	Separator        = " "
+)
+
+const (
+	cpuUsageIdx = iota
+	memUsageIdx = iota
+	memRssIdx   = iota
+	memCacheIdx = iota
+	memSwapIdx  = iota
+	ioReadIdx   = iota
+	ioWriteIdx  = iota
+	fieldsCount = iota
+)
+
+// The fields are named after those of cgroup v2 (cpu.stat usage_usec, memory.current,
+// memory.stat anon/file), in the units of v1, the bytes of io.stat rbytes/wbytes as those of
+// the other collectors (renamed in version 1 of the schema, see capture.Upgrade).
+var allFieldsDefs = []fieldDef{
+	fieldDef{"cpu", "usage_ns", true},
+	fieldDef{"mem", "current_kb", false},
+	fieldDef{"mem", "anon_kb", false},
+	fieldDef{"mem", "file_kb", false},
+	fieldDef{"mem", "swap_kb", false},
+	fieldDef{"io", "read_bytes", true},
+	fieldDef{"io", "write_bytes", true},
+}
+
+/* Header is a list of field names. */
+
+type header []string
+
+func makeHeader(fdl []fieldDef) header {
+	h := header(make([]string, 1+len(fdl)))
+	h[0] = "h"
+	for i, d := range fdl {
+		h[i+1] = d.String()
+	}
+	return h
+}
+
+func (h header) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	err = writeTo(w, strings.Join(h, Separator), &n)
+	return
+}
+
+var cgroupDir string = defaultCgroupDir
+
+func warn(v ...interface{}) {
+	log.Print("WARNING: ", fmt.Sprint(v...))
+}
+
+func init() {
+	fsRoot := os.Getenv("FS_ROOT")
+	if fsRoot != "" {
+		cgroupDir = path.Join(fsRoot, defaultCgroupDir)
+	}
+}
+
+func writeTo(w io.Writer, v interface{}, p *int64) (err error) {
+	m, err := w.Write([]byte(fmt.Sprint(v)))
+	*p += int64(m)
+	return
+}
+
+/* Field Definition */
+
+type fieldDef struct {
+	category      string
+	name          string
+	isAccumulator bool
+}
+
+func (fd fieldDef) String() string { // implements fmt.Stringer
+	if fd.isAccumulator {
+		return fd.category + ":" + fd.name + "/a"
+	} else {
+		return fd.category + ":" + fd.name + "/i"
+	}
+}
+
+/* cgroup v1 */
+
+// IsV1 reports whether the cgroup v1 controllers are mounted, with one hierarchy per controller.
+func IsV1() bool {
+	_, err := os.Stat(path.Join(cgroupDir, "cpuacct", "cpuacct.usage"))
+	return err == nil
+}
+
+// v1File returns the path of a file of the cgroup in the hierarchy of the controller.
+func v1File(controller, cgroup, name string) string {
+	return path.Join(cgroupDir, controller, cgroup, name)
+}
+
+func readUint(fileName string) (value uint64, err error) {
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64)
+}
+
+// parseV1MemoryStat parses the hierarchical counters of memory.stat, as "total_rss 1232896".
+// The swap is only given if swap accounting is enabled.
+func parseV1MemoryStat(fileName string, fields []uint64) (err error) {
+	inFile, err := os.Open(fileName)
+	if err != nil {
+		return
+	}
+	defer inFile.Close()
+	fields[memRssIdx] = 0
+	fields[memCacheIdx] = 0
+	fields[memSwapIdx] = 0
+	scanner := bufio.NewScanner(inFile)
+	for scanner.Scan() {
+		parsedFields := strings.Fields(scanner.Text())
+		if len(parsedFields) != 2 {
+			continue
+		}
+		var idx int
+		switch parsedFields[0] {
+		case "total_rss":
+			idx = memRssIdx
+		case "total_cache":
+			idx = memCacheIdx
+		case "total_swap":
+			idx = memSwapIdx
+		default:
+			continue
+		}
+		value, err := strconv.ParseUint(parsedFields[1], 10, 64)
+		if err != nil {
+			return err
+		}
+		fields[idx] = value / 1024
+	}
+	err = scanner.Err()
+	return
+}
+
+// parseV1IoServiceBytes sums the bytes read and written on all the devices, from the lines of
+// blkio.throttle.io_service_bytes as "8:0 Read 4096".
+func parseV1IoServiceBytes(fileName string, fields []uint64) (err error) {
+	inFile, err := os.Open(fileName)
+	if err != nil {
+		return
+	}
+	defer inFile.Close()
+	fields[ioReadIdx] = 0
+	fields[ioWriteIdx] = 0
+	scanner := bufio.NewScanner(inFile)
+	for scanner.Scan() {
+		parsedFields := strings.Fields(scanner.Text())
+		if len(parsedFields) != 3 {
+			continue
+		}
+		var idx int
+		switch parsedFields[1] {
+		case "Read":
+			idx = ioReadIdx
+		case "Write":
+			idx = ioWriteIdx
+		default:
+			continue
+		}
+		value, err := strconv.ParseUint(parsedFields[2], 10, 64)
+		if err != nil {
+			return err
+		}
+		fields[idx] += value
+	}
+	err = scanner.Err()
+	return
+}
+
+func parseV1(cgroup string, fields []uint64) (err error) {
+	fields[cpuUsageIdx], err = readUint(v1File("cpuacct", cgroup, "cpuacct.usage"))
+	if err != nil {
+		return
+	}
+	usage, err := readUint(v1File("memory", cgroup, "memory.usage_in_bytes"))
+	if err != nil {
+		return
+	}
+	fields[memUsageIdx] = usage / 1024
+	err = parseV1MemoryStat(v1File("memory", cgroup, "memory.stat"), fields)
+	if err != nil {
+		return
+	}
+	err = parseV1IoServiceBytes(v1File("blkio", cgroup, "blkio.throttle.io_service_bytes"), fields)
+	return
+}
+
+/* Record */
+
+var Header = makeHeader(allFieldsDefs)
+
+// Schema describes the fields of the records, for typed output.
+var Schema = capture.Register(capture.Schema{Collector: "cgroupstat", Fields: Header[1:]})
+
+type Record struct {
+	capture.RecordInfo
+	isCumul bool
+	fields  []uint64
+}
+
+func newRecord(isCumul bool) *Record {
+	recordPtr := new(Record)
+	recordPtr.isCumul = isCumul
+	recordPtr.fields = make([]uint64, fieldsCount)
+	return recordPtr
+}
+
+func (recordPtr *Record) String() string { // implements fmt.Stringer
+	buf := new(bytes.Buffer)
+	recordPtr.WriteTo(buf)
+	return buf.String()
+}
+func (record Record) kind() string {
+	if record.isCumul {
+		return "a"
+	}
+	return "d"
+}
+func (record Record) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	err = writeTo(w, record.kind(), &n)
+	if err != nil {
+		return
+	}
+	for _, field := range record.fields {
+		err = writeTo(w, Separator, &n)
+		if err != nil {
+			return
+		}
+		err = writeTo(w, field, &n)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Samples returns the typed form of the record.
+func (record Record) Samples() []capture.Sample {
+	values := make([]uint64, fieldsCount)
+	copy(values, record.fields)
+	return []capture.Sample{{Time: record.Time, Kind: record.kind(), Values: values}}
+}
+func (recordPtr *Record) diff(prevRecord, diffRecord *Record) {
+	diffRecord.RecordInfo = recordPtr.RecordInfo
+	for i, field := range recordPtr.fields {
+		if allFieldsDefs[i].isAccumulator {
+			diffRecord.fields[i] = field - prevRecord.fields[i]
+		} else {
+			diffRecord.fields[i] = field
+		}
+	}
+	return
+}
+
+func (recordPtr *Record) parse(cgroup string) (err error) {
+	defer recordPtr.EndRead(time.Now())
+	recordPtr.Time = time.Now()
+	return parseV1(cgroup, recordPtr.fields)
+}
+
+/* Polling */
+
+// Poll sends a Record of the cgroup, given by its path in the hierarchies (as "/" or
+// "/system.slice/nginx.service"), in the channel at each sampling time of the schedule.
+// Only cgroup v1 is read for now.
+// If cumul is false, it prints the diff of the accumulators, instead of the accumulators themselves
+func Poll(sched *schedule.Schedule, cgroup string, cumul bool, cout chan Record) {
+	recordPtr := newRecord(true)
+	oldRecordPtr := newRecord(true)
+	diffRecordPtr := newRecord(false)
+	for i := 0; sched.Next(); i++ {
+		err := recordPtr.parse(cgroup)
+		if err != nil {
+			warn("Error parsing record, ignoring: ", err)
+			continue
+		}
+		if cumul {
+			cout <- *recordPtr
+		} else {
+			if i < 1 {
+				cout <- *recordPtr
+			} else {
+				recordPtr.diff(oldRecordPtr, diffRecordPtr)
+				cout <- *diffRecordPtr
+			}
+			oldRecordPtr, recordPtr = recordPtr, oldRecordPtr
+		}
+	}
+	close(cout)
+}
@@ -0,0 +1,41 @@
+// Package collectors imports all the collectors, for their schemas to be registered in capture,
+// as needed by the tools of which the captures may be of any collector.
+package collectors
+
+import (
+	_ "internal/auditstat"
+	_ "internal/blkstat"
+	_ "internal/bondstat"
+	_ "internal/cgroupstat"
+	_ "internal/cpufreq"
+	_ "internal/cpustat"
+	_ "internal/dfstat"
+	_ "internal/dirstat"
+	_ "internal/diskstat"
+	_ "internal/fdstat"
+	_ "internal/filestat"
+	_ "internal/kmsgstat"
+	_ "internal/ksmstat"
+	_ "internal/linescount"
+	_ "internal/linkstat"
+	_ "internal/loadavg"
+	_ "internal/meminfo"
+	_ "internal/neighstat"
+	_ "internal/netstat"
+	_ "internal/nfsdstat"
+	_ "internal/nftstat"
+	_ "internal/pidstat"
+	_ "internal/portstat"
+	_ "internal/procevents"
+	_ "internal/schedstat"
+	_ "internal/slabstat"
+	_ "internal/snmpstat"
+	_ "internal/softirqstat"
+	_ "internal/swapstat"
+	_ "internal/tcprtt"
+	_ "internal/udpstat"
+	_ "internal/userstat"
+	_ "internal/vmstat"
+	_ "internal/wifistat"
+	_ "internal/zoneinfo"
+)
@@ -0,0 +1,283 @@
+package cpufreq
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"capture"
+	"internal/schedule"
+)
+
+const (
+	defaultSysCpu = "/sys/devices/system/cpu"
+	allCpus       = "all" // instance of the average over all cpus
+	Separator     = " "
+)
+
+const (
+	curIdx      = iota
+	minIdx      = iota
+	maxIdx      = iota
+	fieldsCount = iota
+)
+
+var allFieldsDefs = []fieldDef{
+	fieldDef{"freq", "cur_khz", false, "scaling_cur_freq"},
+	fieldDef{"freq", "min_khz", false, "scaling_min_freq"},
+	fieldDef{"freq", "max_khz", false, "scaling_max_freq"},
+}
+
+/* Header is a list of field names. */
+
+type header []string
+
+func makeHeader(fdl []fieldDef) header {
+	h := header(make([]string, 2+len(fdl)))
+	h[0] = "cpu"
+	h[1] = "h"
+	for i, d := range fdl {
+		h[i+2] = d.String()
+	}
+	return h
+}
+
+func (h header) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	err = writeTo(w, strings.Join(h, Separator), &n)
+	return
+}
+
+var sysCpu string = defaultSysCpu
+
+func warn(v ...interface{}) {
+	log.Print("WARNING: ", fmt.Sprint(v...))
+}
+
+func init() {
+	fsRoot := os.Getenv("FS_ROOT")
+	if fsRoot != "" {
+		sysCpu = path.Join(fsRoot, defaultSysCpu)
+	}
+	capture.RegisterModel("cpufreq", capture.Model{Capacities: []string{"freq:max_khz"}})
+}
+
+func writeTo(w io.Writer, v interface{}, p *int64) (err error) {
+	m, err := w.Write([]byte(fmt.Sprint(v)))
+	*p += int64(m)
+	return
+}
+
+/* Field Definition */
+
+type fieldDef struct {
+	category      string
+	name          string
+	isAccumulator bool
+	fileName      string // in the cpufreq directory of each cpu
+}
+
+func (fd fieldDef) String() string { // implements fmt.Stringer
+	if fd.isAccumulator {
+		return fd.category + ":" + fd.name + "/a"
+	} else {
+		return fd.category + ":" + fd.name + "/i"
+	}
+}
+
+/* Record */
+
+var Header = makeHeader(allFieldsDefs)
+
+// Schema describes the fields of the records, for typed output.
+var Schema = capture.Register(capture.Schema{Collector: "cpufreq", Fields: Header[2:]})
+
+type Record struct {
+	capture.RecordInfo
+	isCumul   bool
+	fieldsMap map[string][]uint64 // key is the cpu name, or "all" for the average
+}
+
+func newRecord(isCumul bool) *Record {
+	recordPtr := new(Record)
+	recordPtr.isCumul = isCumul
+	recordPtr.fieldsMap = make(map[string][]uint64)
+	return recordPtr
+}
+
+func cpuNumber(name string) int {
+	number, err := strconv.Atoi(strings.TrimPrefix(name, "cpu"))
+	if err != nil {
+		return -1
+	}
+	return number
+}
+
+// cpuNames returns "all" followed by the cpu names, in numerical order.
+func (record Record) cpuNames() []string {
+	names := make([]string, 0, len(record.fieldsMap))
+	for name := range record.fieldsMap {
+		if name != allCpus {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(a, b int) bool { return cpuNumber(names[a]) < cpuNumber(names[b]) })
+	return append([]string{allCpus}, names...)
+}
+
+func (recordPtr *Record) String() string { // implements fmt.Stringer
+	buf := new(bytes.Buffer)
+	recordPtr.WriteTo(buf)
+	return buf.String()
+}
+func (record Record) kind() string {
+	if record.isCumul {
+		return "a"
+	}
+	return "d"
+}
+func (record Record) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	for i, cpu := range record.cpuNames() {
+		if i > 0 {
+			err = writeTo(w, "\n", &n)
+			if err != nil {
+				return
+			}
+		}
+		err = writeTo(w, cpu+Separator+record.kind(), &n)
+		if err != nil {
+			return
+		}
+		for _, field := range record.fieldsMap[cpu] {
+			err = writeTo(w, Separator, &n)
+			if err != nil {
+				return
+			}
+			err = writeTo(w, field, &n)
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// Samples returns the typed form of the record, one sample for the average, then one per cpu.
+func (record Record) Samples() []capture.Sample {
+	samples := make([]capture.Sample, 0, len(record.fieldsMap))
+	for _, cpu := range record.cpuNames() {
+		values := make([]uint64, fieldsCount)
+		copy(values, record.fieldsMap[cpu])
+		samples = append(samples, capture.Sample{Time: record.Time, Instance: cpu, Kind: record.kind(), Values: values})
+	}
+	return samples
+}
+func (recordPtr *Record) diff(prevRecord, diffRecord *Record) {
+	diffRecord.RecordInfo = recordPtr.RecordInfo
+	diffRecord.fieldsMap = make(map[string][]uint64, len(recordPtr.fieldsMap))
+	for cpu, fields := range recordPtr.fieldsMap {
+		prevFields, ok := prevRecord.fieldsMap[cpu]
+		if !ok {
+			prevFields = make([]uint64, fieldsCount)
+		}
+		diffFields := make([]uint64, fieldsCount)
+		for i, field := range fields {
+			if allFieldsDefs[i].isAccumulator {
+				diffFields[i] = field - prevFields[i]
+			} else {
+				diffFields[i] = field
+			}
+		}
+		diffRecord.fieldsMap[cpu] = diffFields
+	}
+	return
+}
+
+// parseCpu reads the frequencies, in kHz, from the cpufreq directory of a cpu.
+func parseCpu(dir string) (fields []uint64, err error) {
+	fields = make([]uint64, fieldsCount)
+	for i, fd := range allFieldsDefs {
+		var content []byte
+		content, err = ioutil.ReadFile(filepath.Join(dir, fd.fileName))
+		if err != nil {
+			return
+		}
+		fields[i], err = strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// parse reads the frequencies of the online cpus having a cpufreq directory, and their average.
+func (recordPtr *Record) parse() (err error) {
+	defer recordPtr.EndRead(time.Now())
+	dirs, err := filepath.Glob(filepath.Join(sysCpu, "cpu[0-9]*", "cpufreq"))
+	if err != nil {
+		return
+	}
+	if len(dirs) == 0 {
+		return fmt.Errorf("no cpufreq directory in %s", sysCpu)
+	}
+	recordPtr.Time = time.Now()
+	recordPtr.fieldsMap = make(map[string][]uint64, len(dirs)+1)
+	sums := make([]uint64, fieldsCount)
+	for _, dir := range dirs {
+		fields, cpuErr := parseCpu(dir)
+		if cpuErr != nil { // cpu gone offline
+			continue
+		}
+		recordPtr.fieldsMap[filepath.Base(filepath.Dir(dir))] = fields
+		for i, field := range fields {
+			sums[i] += field
+		}
+	}
+	count := uint64(len(recordPtr.fieldsMap))
+	if count == 0 {
+		return fmt.Errorf("no readable cpufreq directory in %s", sysCpu)
+	}
+	for i := range sums {
+		sums[i] /= count
+	}
+	recordPtr.fieldsMap[allCpus] = sums
+	return
+}
+
+/* Polling */
+
+// Poll sends a Record in the channel at each sampling time of the schedule.
+// If cumul is false, it prints the diff of the accumulators, instead of the accumulators themselves
+// (all the fields being instant values, it only changes the kind of the records).
+func Poll(sched *schedule.Schedule, cumul bool, cout chan Record) {
+	recordPtr := newRecord(true)
+	oldRecordPtr := newRecord(true)
+	diffRecordPtr := newRecord(false)
+	for i := 0; sched.Next(); i++ {
+		err := recordPtr.parse()
+		if err != nil {
+			warn("Error parsing record, ignoring: ", err)
+			continue
+		}
+		if cumul {
+			cout <- *recordPtr
+		} else {
+			if i < 1 {
+				cout <- *recordPtr
+			} else {
+				recordPtr.diff(oldRecordPtr, diffRecordPtr)
+				cout <- *diffRecordPtr
+			}
+			oldRecordPtr, recordPtr = recordPtr, oldRecordPtr
+		}
+	}
+	close(cout)
+}
@@ -8,16 +8,20 @@ import (
 	"log"
 	"os"
 	"path"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"capture"
+	"internal/schedule"
 	"system/getconf"
 )
 
 const (
-	defaultProcStat = "/proc/stat"
-	Separator       = " "
+	defaultProcStat   = "/proc/stat"
+	defaultSysNodeDir = "/sys/devices/system/node"
+	Separator         = " "
 )
 
 const (
@@ -135,19 +139,24 @@ func init() {
 	addLineDef("processes", procsForksIdx)       // Process/Threads
 	addLineDef("procs_running", procsRunningIdx) // Process/Threads
 	addLineDef("procs_blocked", procsBlockedIdx) // Process/Threads
+	components := make([]string, len(cpuIndicesForTotal))
+	for i, j := range cpuIndicesForTotal {
+		components[i] = allFieldsDefs[j].category + ":" + allFieldsDefs[j].name
+	}
+	capture.RegisterModel("cpustat", capture.Model{
+		Totals:     map[string][]string{"cpu:total": components},
+		Capacities: []string{"cpu:max"},
+	})
 }
 
 /* Header is a list of field names. */
 
 type header []string
 
-func makeHeader(fdl []fieldDef) header {
-	h := header(make([]string, 1+len(fdl)))
+func makeHeader(fdl []fieldDef, topIrqs int, millis, rel bool) header {
+	h := header(make([]string, 1, 1+len(fdl)+2*topIrqs))
 	h[0] = "h"
-	for i, d := range fdl {
-		h[i+1] = d.String()
-	}
-	return h
+	return append(h, fieldNames(fdl, topIrqs, millis, rel)...)
 }
 
 func (h header) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
@@ -155,7 +164,25 @@ func (h header) WriteTo(w io.Writer) (n int64, err error) { // implements io.Wri
 	return
 }
 
+// fieldNames returns the names of the fields, the cpu times being named with the _ms suffix if
+// they are converted to milliseconds (as cpu:user_ms/a): all of them, or only max and total if
+// the others are relative (rel).
+func fieldNames(fdl []fieldDef, topIrqs int, millis, rel bool) []string {
+	names := make([]string, len(fdl), len(fdl)+2*topIrqs)
+	for i, d := range fdl {
+		if millis && isTicks(i, rel) {
+			d.name += "_ms"
+		}
+		names[i] = d.String()
+	}
+	for k := 1; k <= topIrqs; k++ {
+		names = append(names, fmt.Sprintf("intr:top%d_irq/i", k), fmt.Sprintf("intr:top%d_count/a", k))
+	}
+	return names
+}
+
 var procStat string = defaultProcStat
+var sysNodeDir string = defaultSysNodeDir
 var clkTck uint = 100
 var nprocs uint = 1
 
@@ -171,6 +198,7 @@ func init() {
 	fsRoot := os.Getenv("FS_ROOT")
 	if fsRoot != "" {
 		procStat = path.Join(fsRoot, defaultProcStat)
+		sysNodeDir = path.Join(fsRoot, defaultSysNodeDir)
 	}
 	res, err := getconf.GetClkTck()
 	if err != nil {
@@ -253,73 +281,298 @@ func addLineDef(prefix string, fieldsIdx ...uint) {
 
 /* Record */
 
-var Header = makeHeader(allFieldsDefs)
+var Header = NewHeader(0, false, false, false)
+
+// Schema describes the fields of the records, for typed output.
+var Schema = capture.Register(NewSchema(0, false, false))
+
+// NewHeader returns the header of the records with the given number of top irqs columns,
+// prefixed by the node column in numa mode, the cpu times being in milliseconds if millis is
+// true, and relative, max and total excepted, if rel is true.
+func NewHeader(topIrqs int, numa bool, millis, rel bool) io.WriterTo {
+	h := makeHeader(allFieldsDefs, topIrqs, millis, rel)
+	if numa {
+		h = append(header{"node"}, h...)
+	}
+	return h
+}
+
+// NewSchema returns the schema of the records with the given number of top irqs columns, the
+// cpu times being in milliseconds if millis is true, and relative, max and total excepted, if
+// rel is true.
+func NewSchema(topIrqs int, millis, rel bool) capture.Schema {
+	return capture.Schema{Collector: "cpustat", Fields: fieldNames(allFieldsDefs, topIrqs, millis, rel)}
+}
 
 type Record struct {
-	Time           time.Time
+	capture.RecordInfo
 	isCumul, isRel bool
+	isMillis       bool
 	fields         []uint
+	topIrqs        int
+	irqs           []uint            // counts per irq number, only parsed if topIrqs > 0
+	nodes          map[string][]uint // fields per NUMA node, only parsed in numa mode
+	nodeCpus       map[string]uint   // number of online cpus per NUMA node
 }
 
-func newRecord(isCumul, isRel bool) *Record {
+func newRecord(isCumul, isRel, isMillis bool, topIrqs int, numa bool) *Record {
 	recordPtr := new(Record)
 	recordPtr.isCumul = isCumul
 	recordPtr.isRel = isRel
+	recordPtr.isMillis = isMillis
 	recordPtr.fields = make([]uint, fieldsCount)
+	recordPtr.topIrqs = topIrqs
+	if numa {
+		recordPtr.nodes = make(map[string][]uint)
+		recordPtr.nodeCpus = make(map[string]uint)
+	}
 	return recordPtr
 }
 
+func (recordPtr *Record) getNodeFields(node string) (fields []uint) {
+	fields, ok := recordPtr.nodes[node]
+	if ok {
+		return
+	}
+	fields = make([]uint, fieldsCount)
+	recordPtr.nodes[node] = fields
+	return
+}
+
+func (record Record) nodeNames() []string {
+	names := make([]string, 0, len(record.nodes))
+	for node := range record.nodes {
+		names = append(names, node)
+	}
+	sort.Strings(names)
+	return names
+}
+
 func (recordPtr *Record) String() string { // implements fmt.Stringer
 	buf := new(bytes.Buffer)
 	recordPtr.WriteTo(buf)
 	return buf.String()
 }
-func (record Record) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+func (record Record) kind() string {
 	if record.isCumul {
-		err = writeTo(w, "a", &n)
-	} else {
-		if record.isRel {
-			err = writeTo(w, "p", &n)
-		} else {
-			err = writeTo(w, "d", &n)
+		return "a"
+	}
+	if record.isRel {
+		return "p"
+	}
+	return "d"
+}
+// isTicks reports whether field i is a cpu time measured in USER_HZ ticks, in a record of
+// relative cpu times if rel is true.
+func isTicks(i int, rel bool) bool {
+	if i < cpuMaxIdx || i > lastCpuIdx {
+		return false
+	}
+	return !rel || i == cpuMaxIdx || i == cpuTotalIdx
+}
+// value returns field i, with cpu times converted to milliseconds if required.
+func (record Record) value(fields []uint, i int) uint {
+	if record.isMillis && isTicks(i, record.isRel) {
+		return fields[i] * 1000 / clkTck
+	}
+	return fields[i]
+}
+// topIrqsValues returns the irq number and count of the busiest irqs, padded with zeros
+// if there are less irqs than requested.
+func (record Record) topIrqsValues() []uint {
+	idx := make([]int, len(record.irqs))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(a, b int) bool { return record.irqs[idx[a]] > record.irqs[idx[b]] })
+	values := make([]uint, 2*record.topIrqs)
+	for k := 0; k < record.topIrqs && k < len(idx); k++ {
+		values[2*k] = uint(idx[k])
+		values[2*k+1] = record.irqs[idx[k]]
+	}
+	return values
+}
+func (record Record) values(fields []uint) []uint {
+	values := make([]uint, len(fields), len(fields)+2*record.topIrqs)
+	for i := range fields {
+		values[i] = record.value(fields, i)
+	}
+	if record.topIrqs > 0 {
+		values = append(values, record.topIrqsValues()...)
+	}
+	return values
+}
+func (record Record) writeFieldsTo(w io.Writer, fields []uint, p *int64) (err error) {
+	err = writeTo(w, record.kind(), p)
+	if err != nil {
+		return
+	}
+	for _, value := range record.values(fields) {
+		err = writeTo(w, Separator, p)
+		if err != nil {
+			return
+		}
+		err = writeTo(w, value, p)
+		if err != nil {
+			return
 		}
 	}
+	return
+}
+// WriteTo writes the record on one line or, in numa mode, on one line for the whole system
+// ("all") followed by one line per node.
+func (record Record) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	if record.nodes == nil {
+		err = record.writeFieldsTo(w, record.fields, &n)
+		return
+	}
+	err = writeTo(w, "all"+Separator, &n)
 	if err != nil {
 		return
 	}
-	for _, field := range record.fields {
-		err = writeTo(w, Separator, &n)
+	err = record.writeFieldsTo(w, record.fields, &n)
+	if err != nil {
+		return
+	}
+	for _, node := range record.nodeNames() {
+		err = writeTo(w, "\n"+node+Separator, &n)
 		if err != nil {
 			return
 		}
-		err = writeTo(w, field, &n)
+		err = record.writeFieldsTo(w, record.nodes[node], &n)
 		if err != nil {
 			return
 		}
 	}
 	return
 }
-func (recordPtr *Record) diff(prevRecord, diffRecord *Record) {
-	diffRecord.Time = recordPtr.Time
-	for i, field := range recordPtr.fields {
+func (record Record) sample(instance string, fields []uint) capture.Sample {
+	values := record.values(fields)
+	values64 := make([]uint64, len(values))
+	for i, value := range values {
+		values64[i] = uint64(value)
+	}
+	return capture.Sample{Time: record.Time, Instance: instance, Kind: record.kind(), Values: values64}
+}
+// Samples returns the typed form of the record.
+func (record Record) Samples() []capture.Sample {
+	if record.nodes == nil {
+		return []capture.Sample{record.sample("", record.fields)}
+	}
+	samples := []capture.Sample{record.sample("all", record.fields)}
+	for _, node := range record.nodeNames() {
+		samples = append(samples, record.sample(node, record.nodes[node]))
+	}
+	return samples
+}
+
+// diffFields computes the diffs of the fields, returning whether an accumulator went backwards.
+func diffFields(fields, prevFields, diffFields []uint) (reset bool) {
+	for i, field := range fields {
 		if allFieldsDefs[i].isAccumulator {
-			diffRecord.fields[i] = field - prevRecord.fields[i]
+			reset = reset || field < prevFields[i]
+			diffFields[i] = field - prevFields[i]
 		} else {
-			diffRecord.fields[i] = field
+			diffFields[i] = field
+		}
+	}
+	return
+}
+func (recordPtr *Record) diff(prevRecord, diffRecord *Record) {
+	diffRecord.RecordInfo = recordPtr.RecordInfo
+	diffRecord.CounterReset = diffFields(recordPtr.fields, prevRecord.fields, diffRecord.fields)
+	if len(diffRecord.irqs) != len(recordPtr.irqs) {
+		diffRecord.irqs = make([]uint, len(recordPtr.irqs))
+	}
+	for i, count := range recordPtr.irqs {
+		if i < len(prevRecord.irqs) {
+			diffRecord.CounterReset = diffRecord.CounterReset || count < prevRecord.irqs[i]
+			count -= prevRecord.irqs[i]
+		}
+		diffRecord.irqs[i] = count
+	}
+	for node, fields := range recordPtr.nodes {
+		if diffFields(fields, prevRecord.getNodeFields(node), diffRecord.getNodeFields(node)) {
+			diffRecord.CounterReset = true
 		}
 	}
 	return
 }
-func (diffRecordPtr *Record) rel() {
+// relFields converts the cpu times into percentages of the total cpu time or, if avail is true,
+// of the available (non-stolen) cpu time. Steal time itself is always relative to the total.
+func relFields(fields []uint, avail bool) {
+	total := fields[cpuTotalIdx]
+	capacity := total
+	if avail {
+		capacity -= fields[cpuStealIdx]
+	}
 	for _, i := range cpuIndices {
-		if diffRecordPtr.fields[i] != 0 {
-			diffRecordPtr.fields[i] = diffRecordPtr.fields[i] * 100 / diffRecordPtr.fields[cpuTotalIdx]
+		base := capacity
+		if i == cpuStealIdx {
+			base = total
+		}
+		if fields[i] != 0 && base != 0 {
+			fields[i] = fields[i] * 100 / base
+		}
+	}
+}
+func (diffRecordPtr *Record) rel(avail bool) {
+	relFields(diffRecordPtr.fields, avail)
+	for _, fields := range diffRecordPtr.nodes {
+		relFields(fields, avail)
+	}
+	return
+}
+
+// parseIrqs parses the counts per irq of the intr line (following the total).
+func (recordPtr *Record) parseIrqs(line string) (err error) {
+	fields := strings.Fields(line)[2:]
+	if len(recordPtr.irqs) != len(fields) {
+		recordPtr.irqs = make([]uint, len(fields))
+	}
+	var uint64field uint64
+	for i, field := range fields {
+		uint64field, err = strconv.ParseUint(field, 10, 0)
+		if err != nil {
+			return
 		}
+		recordPtr.irqs[i] = uint(uint64field)
+	}
+	return
+}
+
+// parseCpuLine adds the times of a single cpu line ("cpuN ...") to the fields of its node.
+func (recordPtr *Record) parseCpuLine(cpu string, line string) (err error) {
+	cpuFields := make([]uint, fieldsCount)
+	err = parseLineToFields(lineDef{cpu, cpuIndices}, line, cpuFields)
+	if err != nil {
+		return
 	}
+	node := nodeOf(cpu)
+	fields := recordPtr.getNodeFields(node)
+	for _, i := range cpuIndices {
+		fields[i] += cpuFields[i]
+	}
+	recordPtr.nodeCpus[node]++
 	return
 }
 
+// completeNodes computes the calculated fields of the nodes. Fields other than cpu times
+// are the system-wide values.
+func (recordPtr *Record) completeNodes() {
+	for node, fields := range recordPtr.nodes {
+		copy(fields[:cpuMaxIdx], recordPtr.fields[:cpuMaxIdx])
+		for i, fd := range allFieldsDefs {
+			if fd.calculator != nil {
+				fields[i] = fd.calculator(fields)
+			}
+		}
+		fields[cpuMaxIdx] = clkTck * recordPtr.nodeCpus[node]
+	}
+}
+
 func (recordPtr *Record) parse() (err error) {
+	defer recordPtr.EndRead(time.Now())
 	inFile, err := os.Open(procStat)
 	if err != nil {
 		return
@@ -329,6 +582,12 @@ func (recordPtr *Record) parse() (err error) {
 	for i, _ := range recordPtr.fields {
 		recordPtr.fields[i] = 0
 	}
+	for node, fields := range recordPtr.nodes {
+		for i, _ := range fields {
+			fields[i] = 0
+		}
+		recordPtr.nodeCpus[node] = 0
+	}
 	scanner := bufio.NewScanner(inFile)
 	for j := 0; scanner.Scan(); j++ {
 		line := scanner.Text()
@@ -340,6 +599,18 @@ func (recordPtr *Record) parse() (err error) {
 				return
 			}
 		}
+		if linePrefix == "intr" && recordPtr.topIrqs > 0 {
+			err = recordPtr.parseIrqs(line)
+			if err != nil {
+				return
+			}
+		}
+		if recordPtr.nodes != nil && !ok && strings.HasPrefix(linePrefix, "cpu") {
+			err = recordPtr.parseCpuLine(linePrefix, line)
+			if err != nil {
+				return
+			}
+		}
 	}
 	err = scanner.Err()
 	if err != nil {
@@ -350,30 +621,29 @@ func (recordPtr *Record) parse() (err error) {
 			recordPtr.fields[i] = fd.calculator(recordPtr.fields)
 		}
 	}
+	recordPtr.completeNodes()
 	return
 }
 
 /* Polling */
 
-// Poll sends a Record in the channel every period until duration.
+// Poll sends a Record in the channel at each sampling time of the schedule.
 // If cumul is false, it prints the diff of the accumulators, instead of the accumulators themselves
-func Poll(period time.Duration, duration time.Duration, cumul bool, rel bool, cout chan Record) {
-	startTime := time.Now()
-	recordPtr := newRecord(true, false)
-	oldRecordPtr := newRecord(true, false)
-	diffRecordPtr := newRecord(false, rel)
-	var lastTime, nextTime time.Time
-	for i := 0; (0 == duration) || (time.Since(startTime) <= duration); i++ {
-		if i > 0 {
-			nextTime = lastTime.Add(period)
-			toWait := nextTime.Sub(time.Now())
-			if toWait > 0 {
-				time.Sleep(toWait)
-			}
-		} else {
-			nextTime = time.Now()
+// If avail is true, relative cpu usage is computed against the available (non-stolen) cpu time
+// If millis is true, cpu times are converted from USER_HZ ticks to milliseconds
+// If topIrqs is positive, the numbers and counts of the topIrqs busiest irqs are added
+// If numa is true, the record has an additional line per NUMA node
+func Poll(sched *schedule.Schedule, cumul bool, rel bool, avail bool, millis bool, topIrqs int, numa bool, cout chan Record) {
+	if numa {
+		err := loadCpuNodes()
+		if err != nil {
+			warn("Error reading NUMA topology, assuming a single node: ", err)
 		}
-		lastTime = nextTime
+	}
+	recordPtr := newRecord(true, false, millis, topIrqs, numa)
+	oldRecordPtr := newRecord(true, false, millis, topIrqs, numa)
+	diffRecordPtr := newRecord(false, rel, millis, topIrqs, numa)
+	for i := 0; sched.Next(); i++ {
 		err := recordPtr.parse()
 		if err != nil {
 			warn("Error parsing record, ignoring: ", err)
@@ -387,7 +657,7 @@ func Poll(period time.Duration, duration time.Duration, cumul bool, rel bool, co
 			} else {
 				recordPtr.diff(oldRecordPtr, diffRecordPtr)
 				if rel {
-					diffRecordPtr.rel()
+					diffRecordPtr.rel(avail)
 				}
 				cout <- *diffRecordPtr
 			}
@@ -0,0 +1,75 @@
+package cpustat
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+/* NUMA topology */
+
+// cpuNodes maps the cpu line prefixes ("cpu3") to the name of their NUMA node ("node0").
+var cpuNodes = make(map[string]string)
+
+// parseCpuList parses a cpu list such as "0-3,8,10-11".
+func parseCpuList(list string) (cpus []int, err error) {
+	if list == "" {
+		return
+	}
+	for _, item := range strings.Split(list, ",") {
+		bounds := strings.SplitN(item, "-", 2)
+		var first, last int
+		first, err = strconv.Atoi(bounds[0])
+		if err != nil {
+			return
+		}
+		last = first
+		if len(bounds) == 2 {
+			last, err = strconv.Atoi(bounds[1])
+			if err != nil {
+				return
+			}
+		}
+		for cpu := first; cpu <= last; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return
+}
+
+// loadCpuNodes reads the cpu list of each node from sysfs.
+func loadCpuNodes() error {
+	dirs, err := filepath.Glob(path.Join(sysNodeDir, "node[0-9]*"))
+	if err != nil {
+		return err
+	}
+	if len(dirs) == 0 {
+		return fmt.Errorf("No node found in '%s'", sysNodeDir)
+	}
+	for _, dir := range dirs {
+		b, err := ioutil.ReadFile(path.Join(dir, "cpulist"))
+		if err != nil {
+			return err
+		}
+		cpus, err := parseCpuList(strings.TrimSpace(string(b)))
+		if err != nil {
+			return err
+		}
+		for _, cpu := range cpus {
+			cpuNodes["cpu"+strconv.Itoa(cpu)] = path.Base(dir)
+		}
+	}
+	return nil
+}
+
+// nodeOf returns the node of a cpu, node0 if unknown.
+func nodeOf(cpu string) string {
+	node, ok := cpuNodes[cpu]
+	if !ok {
+		return "node0"
+	}
+	return node
+}
@@ -0,0 +1,378 @@
+package dfstat
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"capture"
+	"internal/schedule"
+)
+
+const (
+	defaultProcMounts = "/proc/mounts"
+	Separator         = " "
+)
+
+const (
+	sizeIdx       = iota
+	availIdx      = iota
+	usedPctIdx    = iota
+	inodesIdx     = iota
+	inodesFreeIdx = iota
+	inodesUsedIdx = iota
+	fullIdx       = iota
+	fieldsCount   = iota
+)
+
+// The used pct are computed as by df, the space reserved to root being excluded, so that a
+// filesystem is full at 100%. A filesystem may be full of inodes with free space left.
+// The time to full is predicted from the growth of the space used (see predictor), NeverFull if
+// the space used is not growing or would take longer to fill, 0 if not known yet.
+var allFieldsDefs = []fieldDef{
+	fieldDef{"space", "size_kb", false},
+	fieldDef{"space", "avail_kb", false},
+	fieldDef{"space", "used_pct", false},
+	fieldDef{"inodes", "total", false},
+	fieldDef{"inodes", "free", false},
+	fieldDef{"inodes", "used_pct", false},
+	fieldDef{"space", "full_h", false},
+}
+
+// NeverFull is the time to full of the filesystems of which the available space is not
+// decreasing, in hours (10 years): the predicted times being capped at it, it keeps the averages
+// and baselines of the time to full meaningful.
+const NeverFull = 10 * 365 * 24
+
+/* Header is a list of field names. */
+
+type header []string
+
+func makeHeader(fdl []fieldDef) header {
+	h := header(make([]string, 2+len(fdl)))
+	h[0] = "mount"
+	h[1] = "h"
+	for i, d := range fdl {
+		h[i+2] = d.String()
+	}
+	return h
+}
+
+func (h header) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	err = writeTo(w, strings.Join(h, Separator), &n)
+	return
+}
+
+var procMounts string = defaultProcMounts
+var fsRoot string
+
+func warn(v ...interface{}) {
+	log.Print("WARNING: ", fmt.Sprint(v...))
+}
+
+func init() {
+	fsRoot = os.Getenv("FS_ROOT")
+	if fsRoot != "" {
+		procMounts = path.Join(fsRoot, defaultProcMounts)
+	}
+	capture.RegisterModel("dfstat", capture.Model{Capacities: []string{"space:size_kb", "inodes:total"}})
+}
+
+func writeTo(w io.Writer, v interface{}, p *int64) (err error) {
+	m, err := w.Write([]byte(fmt.Sprint(v)))
+	*p += int64(m)
+	return
+}
+
+/* Field Definition */
+
+type fieldDef struct {
+	category      string
+	name          string
+	isAccumulator bool
+}
+
+func (fd fieldDef) String() string { // implements fmt.Stringer
+	if fd.isAccumulator {
+		return fd.category + ":" + fd.name + "/a"
+	} else {
+		return fd.category + ":" + fd.name + "/i"
+	}
+}
+
+/* Mounts */
+
+// unescape decodes the octal escapes of the mount points, as "\040" for a space.
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// pct returns the part in pct of the total, rounded up as by df.
+func pct(part, total uint64) uint64 {
+	if total == 0 {
+		return 0
+	}
+	return (part*100 + total - 1) / total
+}
+
+/* Record */
+
+var Header = makeHeader(allFieldsDefs)
+
+// Schema describes the fields of the records, for typed output.
+var Schema = capture.Register(capture.Schema{Collector: "dfstat", Fields: Header[2:]})
+
+type Record struct {
+	capture.RecordInfo
+	isCumul   bool
+	fieldsMap map[string][]uint64 // key is the mount point
+}
+
+func newRecord(isCumul bool) *Record {
+	recordPtr := new(Record)
+	recordPtr.isCumul = isCumul
+	recordPtr.fieldsMap = make(map[string][]uint64)
+	return recordPtr
+}
+
+func (record Record) mountPoints() []string {
+	names := make([]string, 0, len(record.fieldsMap))
+	for name := range record.fieldsMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (recordPtr *Record) String() string { // implements fmt.Stringer
+	buf := new(bytes.Buffer)
+	recordPtr.WriteTo(buf)
+	return buf.String()
+}
+func (record Record) kind() string {
+	if record.isCumul {
+		return "a"
+	}
+	return "d"
+}
+func (record Record) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	for i, mountPoint := range record.mountPoints() {
+		if i > 0 {
+			err = writeTo(w, "\n", &n)
+			if err != nil {
+				return
+			}
+		}
+		err = writeTo(w, mountPoint+Separator+record.kind(), &n)
+		if err != nil {
+			return
+		}
+		for _, field := range record.fieldsMap[mountPoint] {
+			err = writeTo(w, Separator, &n)
+			if err != nil {
+				return
+			}
+			err = writeTo(w, field, &n)
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// Samples returns the typed form of the record, one sample per mount point.
+func (record Record) Samples() []capture.Sample {
+	samples := make([]capture.Sample, 0, len(record.fieldsMap))
+	for _, mountPoint := range record.mountPoints() {
+		values := make([]uint64, fieldsCount)
+		copy(values, record.fieldsMap[mountPoint])
+		samples = append(samples, capture.Sample{Time: record.Time, Instance: mountPoint, Kind: record.kind(), Values: values})
+	}
+	return samples
+}
+
+// diff copies the fields, all instant values.
+func (recordPtr *Record) diff(prevRecord, diffRecord *Record) {
+	diffRecord.RecordInfo = recordPtr.RecordInfo
+	diffRecord.fieldsMap = recordPtr.fieldsMap
+	return
+}
+
+// parseMount reads the sizes of the filesystem mounted at the mount point, the pseudo
+// filesystems (proc, sysfs, cgroup...) having no blocks being left out. The mount point is
+// given escaped, as in /proc/mounts, not to have spaces in the text output.
+func (recordPtr *Record) parseMount(mountPoint string) (err error) {
+	size, free, avail, files, ffree, err := statfs(path.Join(fsRoot, unescape(mountPoint)))
+	if err != nil || size == 0 {
+		return
+	}
+	fields := make([]uint64, fieldsCount)
+	fields[sizeIdx] = size
+	fields[availIdx] = avail
+	fields[usedPctIdx] = pct(size-free, size-free+avail)
+	fields[inodesIdx] = files
+	fields[inodesFreeIdx] = ffree
+	fields[inodesUsedIdx] = pct(files-ffree, files)
+	recordPtr.fieldsMap[mountPoint] = fields
+	return
+}
+
+// parse reads the mount points of /proc/mounts, as "/dev/sda1 / ext4 rw,relatime 0 0", a mount
+// point mounted over being given once. The mount points not accessible are left out, and so are
+// those of which statfs failed otherwise, the record being then flagged as partially parsed.
+func (recordPtr *Record) parse() (err error) {
+	defer recordPtr.EndRead(time.Now())
+	inFile, err := os.Open(procMounts)
+	if err != nil {
+		return
+	}
+	defer inFile.Close()
+	recordPtr.Time = time.Now()
+	recordPtr.PartialParse = false
+	recordPtr.fieldsMap = make(map[string][]uint64, len(recordPtr.fieldsMap))
+	scanner := bufio.NewScanner(inFile)
+	for scanner.Scan() {
+		parsedFields := strings.Fields(scanner.Text())
+		if len(parsedFields) < 3 {
+			continue
+		}
+		mountPoint := parsedFields[1]
+		err := recordPtr.parseMount(mountPoint)
+		if os.IsNotExist(err) || os.IsPermission(err) {
+			continue
+		}
+		if err != nil {
+			warn("Error reading ", mountPoint, ", leaving it out: ", err)
+			recordPtr.PartialParse = true
+		}
+	}
+	err = scanner.Err()
+	return
+}
+
+/* Prediction */
+
+type point struct {
+	t     time.Time
+	avail float64
+}
+
+// predictor predicts the time to full of the filesystems, from the linear fit (least squares)
+// of their available space over the last window of the run.
+type predictor struct {
+	window time.Duration
+	points map[string][]point // per mount point, in order
+}
+
+func newPredictor(window time.Duration) *predictor {
+	return &predictor{window: window, points: make(map[string][]point)}
+}
+
+// predict sets the time to full of the filesystems of the record, in hours rounded up (at least
+// 1, at most NeverFull), NeverFull if their available space is not decreasing over the window,
+// or 0 if it is not known for long enough.
+func (p *predictor) predict(record *Record) {
+	for mountPoint := range p.points {
+		if _, ok := record.fieldsMap[mountPoint]; !ok {
+			delete(p.points, mountPoint) // unmounted
+		}
+	}
+	start := record.Time.Add(-p.window)
+	for mountPoint, fields := range record.fieldsMap {
+		points := append(p.points[mountPoint], point{record.Time, float64(fields[availIdx])})
+		for len(points) > 0 && points[0].t.Before(start) {
+			points = points[1:]
+		}
+		p.points[mountPoint] = points
+		fields[fullIdx] = 0
+		if len(points) < 2 {
+			continue
+		}
+		var meanT, meanAvail float64
+		for _, pt := range points {
+			meanT += pt.t.Sub(points[0].t).Seconds()
+			meanAvail += pt.avail
+		}
+		meanT /= float64(len(points))
+		meanAvail /= float64(len(points))
+		var cov, variance float64
+		for _, pt := range points {
+			dt := pt.t.Sub(points[0].t).Seconds() - meanT
+			cov += dt * (pt.avail - meanAvail)
+			variance += dt * dt
+		}
+		if variance == 0 {
+			continue
+		}
+		slope := cov / variance // kB/s
+		if slope >= 0 {
+			fields[fullIdx] = NeverFull
+			continue
+		}
+		hours := math.Ceil(float64(fields[availIdx]) / -slope / 3600)
+		switch {
+		case hours < 1:
+			fields[fullIdx] = 1
+		case hours >= NeverFull:
+			fields[fullIdx] = NeverFull
+		default:
+			fields[fullIdx] = uint64(hours)
+		}
+	}
+}
+
+/* Polling */
+
+// Poll sends a Record in the channel at each sampling time of the schedule, with the time to
+// full of the filesystems predicted over the last window.
+// All the fields being instant values, cumul only changes the kind of the records.
+func Poll(sched *schedule.Schedule, window time.Duration, cumul bool, cout chan Record) {
+	predictor := newPredictor(window)
+	recordPtr := newRecord(true)
+	oldRecordPtr := newRecord(true)
+	diffRecordPtr := newRecord(false)
+	for i := 0; sched.Next(); i++ {
+		err := recordPtr.parse()
+		if err != nil {
+			warn("Error parsing record, ignoring: ", err)
+			continue
+		}
+		predictor.predict(recordPtr)
+		if cumul {
+			cout <- *recordPtr
+		} else {
+			if i < 1 {
+				cout <- *recordPtr
+			} else {
+				recordPtr.diff(oldRecordPtr, diffRecordPtr)
+				cout <- *diffRecordPtr
+			}
+			oldRecordPtr, recordPtr = recordPtr, oldRecordPtr
+		}
+	}
+	close(cout)
+}
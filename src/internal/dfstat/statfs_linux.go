@@ -0,0 +1,14 @@
+package dfstat
+
+import "syscall"
+
+// statfs returns the sizes of the filesystem mounted at the directory, in kB and in inodes.
+func statfs(dir string) (size, free, avail, files, ffree uint64, err error) {
+	var st syscall.Statfs_t
+	err = syscall.Statfs(dir, &st)
+	if err != nil {
+		return
+	}
+	bsizeKb := uint64(st.Bsize) / 1024
+	return st.Blocks * bsizeKb, st.Bfree * bsizeKb, st.Bavail * bsizeKb, st.Files, st.Ffree, nil
+}
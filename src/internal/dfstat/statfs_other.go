@@ -0,0 +1,10 @@
+//go:build !linux
+
+package dfstat
+
+import "errors"
+
+func statfs(dir string) (size, free, avail, files, ffree uint64, err error) {
+	err = errors.New("Not supported on this platform")
+	return
+}
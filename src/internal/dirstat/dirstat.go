@@ -0,0 +1,302 @@
+package dirstat
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"capture"
+	"internal/schedule"
+)
+
+const (
+	Separator = " "
+)
+
+const (
+	// counts and sizes of the files, then the computed changes
+	filesIdx      = iota
+	sizeIdx       = iota
+	filesDeltaIdx = iota
+	sizeDeltaIdx  = iota
+	fieldsCount   = iota
+)
+
+// The files of the subdirectories are included, the directories themselves not being counted.
+var allFieldsDefs = []fieldDef{
+	fieldDef{"dir", "files", false, false},
+	fieldDef{"dir", "size_kb", false, false},
+	fieldDef{"dir", "files_delta", false, true},
+	fieldDef{"dir", "size_delta_kb", false, true},
+}
+
+/* Header is a list of field names. */
+
+type header []string
+
+func makeHeader(fdl []fieldDef) header {
+	h := header(make([]string, 2+len(fdl)))
+	h[0] = "dir"
+	h[1] = "h"
+	for i, d := range fdl {
+		h[i+2] = d.String()
+	}
+	return h
+}
+
+func (h header) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	err = writeTo(w, strings.Join(h, Separator), &n)
+	return
+}
+
+var fsRoot string
+
+func warn(v ...interface{}) {
+	log.Print("WARNING: ", fmt.Sprint(v...))
+}
+
+func init() {
+	fsRoot = os.Getenv("FS_ROOT")
+}
+
+func writeTo(w io.Writer, v interface{}, p *int64) (err error) {
+	m, err := w.Write([]byte(fmt.Sprint(v)))
+	*p += int64(m)
+	return
+}
+
+/* Field Definition */
+
+type fieldDef struct {
+	category      string
+	name          string
+	isAccumulator bool
+	isSigned      bool
+}
+
+func (fd fieldDef) String() string { // implements fmt.Stringer
+	if fd.isAccumulator {
+		return fd.category + ":" + fd.name + "/a"
+	} else if fd.isSigned {
+		return fd.category + ":" + fd.name + capture.SignedSuffix
+	} else {
+		return fd.category + ":" + fd.name + "/i"
+	}
+}
+
+/* Directories */
+
+// ParseGlobs returns the globs of a comma separated list, as "/var/spool/*,/var/log".
+func ParseGlobs(list string) (globs []string, err error) {
+	for _, glob := range strings.Split(list, ",") {
+		if glob == "" {
+			continue
+		}
+		_, err = filepath.Match(glob, "")
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", glob, err)
+		}
+		globs = append(globs, glob)
+	}
+	return
+}
+
+// expand returns the directories matching the globs, without the FS_ROOT prefix.
+func expand(globs []string) (dirs []string) {
+	seen := make(map[string]bool)
+	for _, glob := range globs {
+		matches, _ := filepath.Glob(filepath.Join(fsRoot, glob)) // syntax checked by ParseGlobs
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil || !info.IsDir() {
+				continue
+			}
+			dir := strings.TrimPrefix(match, fsRoot)
+			if fsRoot != "" && dir == "" {
+				dir = "/"
+			}
+			if !seen[dir] {
+				seen[dir] = true
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+	return
+}
+
+// walk counts the regular files of the directory and of its subdirectories, and sums their
+// sizes. The entries removed or not readable meanwhile are left out, as the files of a spool.
+func walk(dir string) (files, size int64) {
+	filepath.Walk(filepath.Join(fsRoot, dir), func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			if info != nil && info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode().IsRegular() {
+			files++
+			size += info.Size()
+		}
+		return nil
+	})
+	return
+}
+
+// instance returns the directory as given in the text output, the spaces being escaped as in
+// /proc/mounts.
+func instance(dir string) string {
+	return strings.Replace(dir, " ", `\040`, -1)
+}
+
+/* Record */
+
+var Header = makeHeader(allFieldsDefs)
+
+// Schema describes the fields of the records, for typed output.
+// The changes of the counts and sizes may be negative: they are signed fields.
+var Schema = capture.Register(capture.Schema{Collector: "dirstat", Fields: Header[2:]})
+
+type Record struct {
+	capture.RecordInfo
+	isCumul   bool
+	fieldsMap map[string][]int64 // key is the directory, escaped
+}
+
+func newRecord(isCumul bool) *Record {
+	recordPtr := new(Record)
+	recordPtr.isCumul = isCumul
+	recordPtr.fieldsMap = make(map[string][]int64)
+	return recordPtr
+}
+
+func (record Record) dirNames() []string {
+	names := make([]string, 0, len(record.fieldsMap))
+	for name := range record.fieldsMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (recordPtr *Record) String() string { // implements fmt.Stringer
+	buf := new(bytes.Buffer)
+	recordPtr.WriteTo(buf)
+	return buf.String()
+}
+func (record Record) kind() string {
+	if record.isCumul {
+		return "a"
+	}
+	return "d"
+}
+func (record Record) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	for i, dir := range record.dirNames() {
+		if i > 0 {
+			err = writeTo(w, "\n", &n)
+			if err != nil {
+				return
+			}
+		}
+		err = writeTo(w, dir+Separator+record.kind(), &n)
+		if err != nil {
+			return
+		}
+		for _, field := range record.fieldsMap[dir] {
+			err = writeTo(w, Separator, &n)
+			if err != nil {
+				return
+			}
+			err = writeTo(w, field, &n)
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// Samples returns the typed form of the record, one sample per directory.
+func (record Record) Samples() []capture.Sample {
+	samples := make([]capture.Sample, 0, len(record.fieldsMap))
+	for _, dir := range record.dirNames() {
+		values := make([]uint64, fieldsCount)
+		for i, field := range record.fieldsMap[dir] {
+			values[i] = uint64(field)
+		}
+		samples = append(samples, capture.Sample{Time: record.Time, Instance: dir, Kind: record.kind(), Values: values})
+	}
+	return samples
+}
+
+// diff copies the fields, all instant values, and sets the changes of the count and size of
+// the files since the previous record (the whole count and size for a directory just matched).
+func (recordPtr *Record) diff(prevRecord, diffRecord *Record) {
+	diffRecord.RecordInfo = recordPtr.RecordInfo
+	diffRecord.fieldsMap = make(map[string][]int64, len(recordPtr.fieldsMap))
+	for dir, fields := range recordPtr.fieldsMap {
+		diffFields := make([]int64, fieldsCount)
+		copy(diffFields, fields)
+		diffFields[filesDeltaIdx] = fields[filesIdx]
+		diffFields[sizeDeltaIdx] = fields[sizeIdx]
+		prevFields, ok := prevRecord.fieldsMap[dir]
+		if ok {
+			diffFields[filesDeltaIdx] -= prevFields[filesIdx]
+			diffFields[sizeDeltaIdx] -= prevFields[sizeIdx]
+		}
+		diffRecord.fieldsMap[dir] = diffFields
+	}
+	return
+}
+
+// parse expands the globs again, for the directories created since the previous record.
+func (recordPtr *Record) parse(globs []string) (err error) {
+	defer recordPtr.EndRead(time.Now())
+	recordPtr.Time = time.Now()
+	recordPtr.fieldsMap = make(map[string][]int64, len(recordPtr.fieldsMap))
+	for _, dir := range expand(globs) {
+		files, size := walk(dir)
+		fields := make([]int64, fieldsCount)
+		fields[filesIdx] = files
+		fields[sizeIdx] = size / 1024
+		recordPtr.fieldsMap[instance(dir)] = fields
+	}
+	return
+}
+
+/* Polling */
+
+// Poll sends a Record of the directories matching the globs in the channel at each sampling
+// time of the schedule.
+// If cumul is false, it prints the diff of the accumulators, instead of the accumulators themselves
+// (all the fields being instant values, only the changes of the counts and sizes are then computed).
+func Poll(sched *schedule.Schedule, globs []string, cumul bool, cout chan Record) {
+	recordPtr := newRecord(true)
+	oldRecordPtr := newRecord(true)
+	diffRecordPtr := newRecord(false)
+	for i := 0; sched.Next(); i++ {
+		err := recordPtr.parse(globs)
+		if err != nil {
+			warn("Error parsing record, ignoring: ", err)
+			continue
+		}
+		if cumul {
+			cout <- *recordPtr
+		} else {
+			if i < 1 {
+				cout <- *recordPtr
+			} else {
+				recordPtr.diff(oldRecordPtr, diffRecordPtr)
+				cout <- *diffRecordPtr
+			}
+			oldRecordPtr, recordPtr = recordPtr, oldRecordPtr
+		}
+	}
+	close(cout)
+}
@@ -0,0 +1,300 @@
+package diskstat
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"capture"
+	"internal/schedule"
+)
+
+const (
+	defaultProcDiskstats = "/proc/diskstats"
+	firstFieldsCol       = 3 // after major, minor and device name
+	Separator            = " "
+)
+
+const (
+	// major minor device, then:
+	// reads merged sectors ms | writes merged sectors ms | in_progress ms weighted_ms
+	rdIosIdx      = iota
+	rdMergesIdx   = iota
+	rdSectorsIdx  = iota
+	rdTicksIdx    = iota
+	wrIosIdx      = iota
+	wrMergesIdx   = iota
+	wrSectorsIdx  = iota
+	wrTicksIdx    = iota
+	ioInFlightIdx = iota
+	ioTicksIdx    = iota
+	ioQueueIdx    = iota
+	fieldsCount   = iota
+)
+
+var allFieldsDefs = []fieldDef{
+	fieldDef{"rd", "ios", true},
+	fieldDef{"rd", "merges", true},
+	fieldDef{"rd", "sectors", true},
+	fieldDef{"rd", "ms", true},
+	fieldDef{"wr", "ios", true},
+	fieldDef{"wr", "merges", true},
+	fieldDef{"wr", "sectors", true},
+	fieldDef{"wr", "ms", true},
+	fieldDef{"io", "in_flight", false},
+	fieldDef{"io", "ms", true},
+	fieldDef{"io", "queue_ms", true},
+}
+
+/* Header is a list of field names. */
+
+type header []string
+
+func makeHeader(fdl []fieldDef) header {
+	h := header(make([]string, 2+len(fdl)))
+	h[0] = "device"
+	h[1] = "h"
+	for i, d := range fdl {
+		h[i+2] = d.String()
+	}
+	return h
+}
+
+func (h header) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	err = writeTo(w, strings.Join(h, Separator), &n)
+	return
+}
+
+var procDiskstats string = defaultProcDiskstats
+
+func init() {
+	fsRoot := os.Getenv("FS_ROOT")
+	if fsRoot != "" {
+		procDiskstats = path.Join(fsRoot, defaultProcDiskstats)
+	}
+}
+
+// parseLineToFields parses a device line, the device being renamed if found in the names.
+// Devices without any I/O since boot are skipped.
+func (recordPtr *Record) parseLineToFields(line string, names map[string]string) (err error) {
+	parsedFields := strings.Fields(line)
+	if len(parsedFields) < firstFieldsCol+fieldsCount {
+		return
+	}
+	fields := make([]uint64, fieldsCount)
+	idle := true
+	var uint64field uint64
+	for i, str := range parsedFields[firstFieldsCol : firstFieldsCol+fieldsCount] {
+		uint64field, err = strconv.ParseUint(str, 10, 0)
+		if err != nil {
+			return
+		}
+		fields[i] = uint64field
+		if uint64field != 0 {
+			idle = false
+		}
+	}
+	if !idle {
+		recordPtr.fieldsMap[rename(parsedFields[2], names)] = fields
+	}
+	return
+}
+
+func writeTo(w io.Writer, v interface{}, p *int64) (err error) {
+	m, err := w.Write([]byte(fmt.Sprint(v)))
+	*p += int64(m)
+	return
+}
+
+/* Field Definition */
+
+type fieldDef struct {
+	category      string
+	name          string
+	isAccumulator bool
+}
+
+func (fd fieldDef) String() string { // implements fmt.Stringer
+	if fd.isAccumulator {
+		return fd.category + ":" + fd.name + "/a"
+	} else {
+		return fd.category + ":" + fd.name + "/i"
+	}
+}
+
+/* Record */
+
+var Header = makeHeader(allFieldsDefs)
+
+// Schema describes the fields of the records, for typed output.
+var Schema = capture.Register(capture.Schema{Collector: "diskstat", Fields: Header[2:]})
+
+type Record struct {
+	capture.RecordInfo
+	isCumul   bool
+	fieldsMap map[string][]uint64 // key is the device name, or the mount point of a filesystem
+}
+
+func newRecord(isCumul bool) *Record {
+	recordPtr := new(Record)
+	recordPtr.isCumul = isCumul
+	recordPtr.fieldsMap = make(map[string][]uint64)
+	return recordPtr
+}
+
+func (recordPtr *Record) getFields(device string) (fields []uint64) {
+	fields, ok := recordPtr.fieldsMap[device]
+	if ok {
+		return
+	}
+	fields = make([]uint64, fieldsCount)
+	recordPtr.fieldsMap[device] = fields
+	return
+}
+
+func (record Record) deviceNames() []string {
+	names := make([]string, 0, len(record.fieldsMap))
+	for name := range record.fieldsMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (recordPtr *Record) String() string { // implements fmt.Stringer
+	buf := new(bytes.Buffer)
+	recordPtr.WriteTo(buf)
+	return buf.String()
+}
+func (record Record) kind() string {
+	if record.isCumul {
+		return "a"
+	}
+	return "d"
+}
+func (record Record) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	for i, device := range record.deviceNames() {
+		if i > 0 {
+			err = writeTo(w, "\n", &n)
+			if err != nil {
+				return
+			}
+		}
+		err = writeTo(w, device+Separator+record.kind(), &n)
+		if err != nil {
+			return
+		}
+		for _, field := range record.fieldsMap[device] {
+			err = writeTo(w, Separator, &n)
+			if err != nil {
+				return
+			}
+			err = writeTo(w, field, &n)
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// Samples returns the typed form of the record, one sample per device and per mount point.
+func (record Record) Samples() []capture.Sample {
+	samples := make([]capture.Sample, 0, len(record.fieldsMap))
+	for _, device := range record.deviceNames() {
+		values := make([]uint64, fieldsCount)
+		copy(values, record.fieldsMap[device])
+		samples = append(samples, capture.Sample{Time: record.Time, Instance: device, Kind: record.kind(), Values: values})
+	}
+	return samples
+}
+func (recordPtr *Record) diff(prevRecord, diffRecord *Record) {
+	diffRecord.RecordInfo = recordPtr.RecordInfo
+	diffRecord.fieldsMap = make(map[string][]uint64, len(recordPtr.fieldsMap))
+	for device, fields := range recordPtr.fieldsMap {
+		prevFields := prevRecord.getFields(device)
+		diffFields := diffRecord.getFields(device)
+		for i, field := range fields {
+			if allFieldsDefs[i].isAccumulator {
+				diffFields[i] = field - prevFields[i]
+			} else {
+				diffFields[i] = field
+			}
+		}
+	}
+	return
+}
+
+// parse reads the devices, then gives the fields of the device of each filesystem to its mount
+// point. The device mapper devices are given their names if dmNames is true.
+func (recordPtr *Record) parse(mounts []Mount, dmNames bool) (err error) {
+	defer recordPtr.EndRead(time.Now())
+	inFile, err := os.Open(procDiskstats)
+	if err != nil {
+		return
+	}
+	defer inFile.Close()
+	recordPtr.Time = time.Now()
+	recordPtr.fieldsMap = make(map[string][]uint64, len(recordPtr.fieldsMap))
+	var names map[string]string
+	if dmNames {
+		names = DMNames()
+	}
+	scanner := bufio.NewScanner(inFile)
+	for scanner.Scan() {
+		err = recordPtr.parseLineToFields(scanner.Text(), names)
+		if err != nil {
+			return
+		}
+	}
+	err = scanner.Err()
+	if err != nil {
+		return
+	}
+	for _, mount := range mounts {
+		if fields, ok := recordPtr.fieldsMap[mount.Device]; ok {
+			recordPtr.fieldsMap[mount.Point] = append([]uint64(nil), fields...)
+		}
+	}
+	return
+}
+
+/* Polling */
+
+// Poll sends a Record in the channel at each sampling time of the schedule, with the I/O of
+// the filesystems of the mounts on the lines of their mount points.
+// If dmNames is true, the device mapper devices are given their names (as vg0-data) instead of
+// their numbers (as dm-0), read again at each record.
+// If cumul is false, it prints the diff of the accumulators, instead of the accumulators themselves
+func Poll(sched *schedule.Schedule, mounts []Mount, dmNames bool, cumul bool, cout chan Record) {
+	recordPtr := newRecord(true)
+	oldRecordPtr := newRecord(true)
+	diffRecordPtr := newRecord(false)
+	for i := 0; sched.Next(); i++ {
+		err := recordPtr.parse(mounts, dmNames)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if cumul {
+			cout <- *recordPtr
+		} else {
+			if i < 1 {
+				cout <- *recordPtr
+			} else {
+				recordPtr.diff(oldRecordPtr, diffRecordPtr)
+				cout <- *diffRecordPtr
+			}
+			oldRecordPtr, recordPtr = recordPtr, oldRecordPtr
+		}
+	}
+	close(cout)
+}
@@ -0,0 +1,51 @@
+package diskstat
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+const defaultSysBlockDir = "/sys/block"
+
+var sysBlockDir string = defaultSysBlockDir
+
+func init() {
+	fsRoot := os.Getenv("FS_ROOT")
+	if fsRoot != "" {
+		sysBlockDir = path.Join(fsRoot, defaultSysBlockDir)
+	}
+}
+
+// DMNames returns the names of the device mapper devices per kernel name, as "vg0-data" (LVM,
+// as in /dev/mapper) or "mpatha" (multipath) for "dm-0", from /sys/block/dm-*/dm/name. The dm
+// numbers are given in the order of activation, which may differ from one boot to the other.
+func DMNames() (names map[string]string) {
+	names = make(map[string]string)
+	entries, err := ioutil.ReadDir(sysBlockDir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), "dm-") {
+			continue
+		}
+		content, err := ioutil.ReadFile(path.Join(sysBlockDir, entry.Name(), "dm", "name"))
+		if err != nil {
+			continue
+		}
+		if name := strings.TrimSpace(string(content)); name != "" {
+			names[entry.Name()] = name
+		}
+	}
+	return
+}
+
+// rename returns the name of the device in the names, or the device itself if not found.
+func rename(device string, names map[string]string) string {
+	if name, ok := names[device]; ok {
+		return name
+	}
+	return device
+}
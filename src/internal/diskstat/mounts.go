@@ -0,0 +1,141 @@
+package diskstat
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+const (
+	defaultProcMountinfo = "/proc/self/mountinfo"
+	defaultSysClassBlock = "/sys/class/block"
+	MountPrefix          = "# mount:"
+)
+
+var procMountinfo string = defaultProcMountinfo
+var sysClassBlock string = defaultSysClassBlock
+
+func init() {
+	fsRoot := os.Getenv("FS_ROOT")
+	if fsRoot != "" {
+		procMountinfo = path.Join(fsRoot, defaultProcMountinfo)
+		sysClassBlock = path.Join(fsRoot, defaultSysClassBlock)
+	}
+}
+
+// Mount is a filesystem mounted on a block device, of which the I/O are those of the device.
+// The physical devices are the ones under the device when it is a device mapper (LVM, dm-crypt)
+// or md device, as found in the slaves of the devices.
+type Mount struct {
+	Point    string // escaped, as in /proc/mounts
+	Device   string
+	Physical []string
+}
+
+// deviceNames returns the names of the block devices per number, as "8:1", from /proc/diskstats.
+func deviceNames() (names map[string]string, err error) {
+	inFile, err := os.Open(procDiskstats)
+	if err != nil {
+		return
+	}
+	defer inFile.Close()
+	names = make(map[string]string)
+	scanner := bufio.NewScanner(inFile)
+	for scanner.Scan() {
+		parsedFields := strings.Fields(scanner.Text())
+		if len(parsedFields) < firstFieldsCol {
+			continue
+		}
+		names[parsedFields[0]+":"+parsedFields[1]] = parsedFields[2]
+	}
+	err = scanner.Err()
+	return
+}
+
+// physical returns the devices under the device, in order, or the device itself if it has no
+// slaves.
+func physical(device string) (devices []string) {
+	entries, err := ioutil.ReadDir(path.Join(sysClassBlock, device, "slaves"))
+	if err != nil || len(entries) == 0 {
+		return []string{device}
+	}
+	for _, entry := range entries {
+		devices = append(devices, physical(entry.Name())...)
+	}
+	sort.Strings(devices)
+	return
+}
+
+// ReadMounts returns the filesystems mounted on block devices, in order of mount point, from
+// the lines of mountinfo as "36 25 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw". The
+// filesystems without a block device (tmpfs, nfs, btrfs subvolumes...) are left out, as a
+// mount point mounted over. The device mapper devices are given their names if dmNames is true.
+func ReadMounts(dmNames bool) (mounts []Mount, err error) {
+	names, err := deviceNames()
+	if err != nil {
+		return
+	}
+	inFile, err := os.Open(procMountinfo)
+	if err != nil {
+		return
+	}
+	defer inFile.Close()
+	devices := make(map[string]string) // per mount point
+	scanner := bufio.NewScanner(inFile)
+	for scanner.Scan() {
+		parsedFields := strings.Fields(scanner.Text())
+		if len(parsedFields) < 5 {
+			continue
+		}
+		device, ok := names[parsedFields[2]]
+		if !ok {
+			delete(devices, parsedFields[4])
+			continue
+		}
+		devices[parsedFields[4]] = device
+	}
+	err = scanner.Err()
+	if err != nil {
+		return
+	}
+	var dmNamesMap map[string]string
+	if dmNames {
+		dmNamesMap = DMNames()
+	}
+	for point, device := range devices {
+		mount := Mount{point, rename(device, dmNamesMap), physical(device)}
+		for i, device := range mount.Physical {
+			mount.Physical[i] = rename(device, dmNamesMap)
+		}
+		mounts = append(mounts, mount)
+	}
+	sort.Slice(mounts, func(i, j int) bool { return mounts[i].Point < mounts[j].Point })
+	return
+}
+
+// WriteMounts writes the devices of the mount points as comment lines, as
+// "# mount:/data: dm-0 (sdb sdc)", the physical devices being given in brackets if they differ.
+func WriteMounts(w io.Writer, mounts []Mount) (err error) {
+	for _, mount := range mounts {
+		_, err = fmt.Fprint(w, MountPrefix, mount.Point, ": ", mount.Device)
+		if err != nil {
+			return
+		}
+		if len(mount.Physical) != 1 || mount.Physical[0] != mount.Device {
+			_, err = fmt.Fprint(w, " (", strings.Join(mount.Physical, " "), ")")
+			if err != nil {
+				return
+			}
+		}
+		_, err = fmt.Fprint(w, "\n")
+		if err != nil {
+			return
+		}
+	}
+	return
+}
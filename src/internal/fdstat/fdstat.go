@@ -0,0 +1,319 @@
+package fdstat
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"capture"
+	"internal/schedule"
+)
+
+const (
+	defaultProcFileNr = "/proc/sys/fs/file-nr"
+	defaultProcDir    = "/proc"
+	Separator         = " "
+)
+
+const (
+	// allocated free max
+	allocatedIdx = iota
+	freeIdx      = iota
+	maxIdx       = iota
+	fieldsCount  = iota
+)
+
+const (
+	// per pid
+	pidFdsIdx      = iota
+	pidSoftIdx     = iota
+	pidHardIdx     = iota
+	pidPctIdx      = iota
+	pidFieldsCount = iota
+)
+
+// suffixes of the per pid field names, as "fd:pid1234_soft/i"
+var pidFieldsSuffixes = []string{"", "_soft", "_hard", "_pct"}
+
+var allFieldsDefs = []fieldDef{
+	fieldDef{"file", "allocated", false},
+	fieldDef{"file", "free", false},
+	fieldDef{"file", "max", false},
+}
+
+/* Header is a list of field names. */
+
+type header []string
+
+func makeHeader(fdl []fieldDef, pids []int) header {
+	h := header(make([]string, 1, 1+len(fdl)+len(pids)))
+	h[0] = "h"
+	return append(h, fieldNames(fdl, pids)...)
+}
+
+func (h header) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	err = writeTo(w, strings.Join(h, Separator), &n)
+	return
+}
+
+func fieldNames(fdl []fieldDef, pids []int) []string {
+	names := make([]string, len(fdl), len(fdl)+pidFieldsCount*len(pids))
+	for i, d := range fdl {
+		names[i] = d.String()
+	}
+	for _, pid := range pids {
+		for _, suffix := range pidFieldsSuffixes {
+			names = append(names, fmt.Sprintf("fd:pid%d%s/i", pid, suffix))
+		}
+	}
+	return names
+}
+
+var procFileNr string = defaultProcFileNr
+var procDir string = defaultProcDir
+
+func warn(v ...interface{}) {
+	log.Print("WARNING: ", fmt.Sprint(v...))
+}
+
+func init() {
+	fsRoot := os.Getenv("FS_ROOT")
+	if fsRoot != "" {
+		procFileNr = path.Join(fsRoot, defaultProcFileNr)
+		procDir = path.Join(fsRoot, defaultProcDir)
+	}
+	capture.RegisterModel("fdstat", capture.Model{Capacities: []string{"file:max"}})
+}
+
+func writeTo(w io.Writer, v interface{}, p *int64) (err error) {
+	m, err := w.Write([]byte(fmt.Sprint(v)))
+	*p += int64(m)
+	return
+}
+
+/* Field Definition */
+
+type fieldDef struct {
+	category      string
+	name          string
+	isAccumulator bool
+}
+
+func (fd fieldDef) String() string { // implements fmt.Stringer
+	if fd.isAccumulator {
+		return fd.category + ":" + fd.name + "/a"
+	} else {
+		return fd.category + ":" + fd.name + "/i"
+	}
+}
+
+/* Record */
+
+var Header = makeHeader(allFieldsDefs, nil)
+
+// Schema describes the fields of the records, for typed output.
+var Schema = capture.Register(NewSchema(nil))
+
+// NewHeader returns the header of the records, with the fd count, soft and hard limits, and usage
+// in pct of the soft limit, of each pid.
+func NewHeader(pids []int) io.WriterTo {
+	return makeHeader(allFieldsDefs, pids)
+}
+
+// NewSchema returns the schema of the records, with the fd count, soft and hard limits, and usage
+// in pct of the soft limit, of each pid.
+func NewSchema(pids []int) capture.Schema {
+	return capture.Schema{Collector: "fdstat", Fields: fieldNames(allFieldsDefs, pids)}
+}
+
+type Record struct {
+	capture.RecordInfo
+	isCumul bool
+	fields  []uint64
+	pids    []int
+	fds     []uint64 // pidFieldsCount fields per pid, 0 if the process is gone
+}
+
+func newRecord(isCumul bool, pids []int) *Record {
+	recordPtr := new(Record)
+	recordPtr.isCumul = isCumul
+	recordPtr.fields = make([]uint64, fieldsCount)
+	recordPtr.pids = pids
+	recordPtr.fds = make([]uint64, pidFieldsCount*len(pids))
+	return recordPtr
+}
+
+func (recordPtr *Record) String() string { // implements fmt.Stringer
+	buf := new(bytes.Buffer)
+	recordPtr.WriteTo(buf)
+	return buf.String()
+}
+func (record Record) kind() string {
+	if record.isCumul {
+		return "a"
+	}
+	return "d"
+}
+func (record Record) values() []uint64 {
+	values := make([]uint64, 0, fieldsCount+len(record.fds))
+	values = append(values, record.fields...)
+	return append(values, record.fds...)
+}
+func (record Record) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	err = writeTo(w, record.kind(), &n)
+	if err != nil {
+		return
+	}
+	for _, field := range record.values() {
+		err = writeTo(w, Separator, &n)
+		if err != nil {
+			return
+		}
+		err = writeTo(w, field, &n)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Samples returns the typed form of the record.
+func (record Record) Samples() []capture.Sample {
+	return []capture.Sample{{Time: record.Time, Kind: record.kind(), Values: record.values()}}
+}
+func (recordPtr *Record) diff(prevRecord, diffRecord *Record) {
+	diffRecord.RecordInfo = recordPtr.RecordInfo
+	for i, field := range recordPtr.fields {
+		if allFieldsDefs[i].isAccumulator {
+			diffRecord.fields[i] = field - prevRecord.fields[i]
+		} else {
+			diffRecord.fields[i] = field
+		}
+	}
+	copy(diffRecord.fds, recordPtr.fds) // instant values
+	return
+}
+
+// countFds returns the number of open file descriptors of a process, 0 if it is gone.
+func countFds(pid int) (count uint64, err error) {
+	entries, err := ioutil.ReadDir(path.Join(procDir, strconv.Itoa(pid), "fd"))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	return uint64(len(entries)), err
+}
+
+// readNofileLimits returns the soft and hard limits of open files of a process, from the
+// "Max open files            1024                 1048576              files" line of its limits,
+// 0 if unlimited or if the process is gone.
+func readNofileLimits(pid int) (soft, hard uint64, err error) {
+	content, err := ioutil.ReadFile(path.Join(procDir, strconv.Itoa(pid), "limits"))
+	if os.IsNotExist(err) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		if !strings.HasPrefix(line, "Max open files") {
+			continue
+		}
+		parsedFields := strings.Fields(line[len("Max open files"):])
+		if len(parsedFields) < 2 {
+			break
+		}
+		limits := make([]uint64, 2)
+		for i, str := range parsedFields[:2] {
+			if str == "unlimited" {
+				continue
+			}
+			limits[i], err = strconv.ParseUint(str, 10, 64)
+			if err != nil {
+				return
+			}
+		}
+		return limits[0], limits[1], nil
+	}
+	return 0, 0, fmt.Errorf("no open files limit in limits of process %d", pid)
+}
+
+// parsePid reads the open file descriptors of a process, and their limits.
+func parsePid(pid int, fields []uint64) (err error) {
+	fields[pidFdsIdx], err = countFds(pid)
+	if err != nil {
+		return
+	}
+	fields[pidSoftIdx], fields[pidHardIdx], err = readNofileLimits(pid)
+	if err != nil {
+		return
+	}
+	fields[pidPctIdx] = 0
+	if fields[pidSoftIdx] != 0 {
+		fields[pidPctIdx] = fields[pidFdsIdx] * 100 / fields[pidSoftIdx]
+	}
+	return
+}
+
+func (recordPtr *Record) parse() (err error) {
+	defer recordPtr.EndRead(time.Now())
+	content, err := ioutil.ReadFile(procFileNr)
+	if err != nil {
+		return
+	}
+	recordPtr.Time = time.Now()
+	parsedFields := strings.Fields(string(content))
+	if len(parsedFields) < fieldsCount {
+		return fmt.Errorf("unexpected content of %s: %q", procFileNr, content)
+	}
+	for i, str := range parsedFields[:fieldsCount] {
+		recordPtr.fields[i], err = strconv.ParseUint(str, 10, 64)
+		if err != nil {
+			return
+		}
+	}
+	for i, pid := range recordPtr.pids {
+		err = parsePid(pid, recordPtr.fds[i*pidFieldsCount:(i+1)*pidFieldsCount])
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+/* Polling */
+
+// Poll sends a Record in the channel at each sampling time of the schedule.
+// If cumul is false, it prints the diff of the accumulators, instead of the accumulators themselves
+// The number of open file descriptors of each of the pids, with its limits and its usage in pct of
+// the soft limit, is added after the system-wide counts.
+func Poll(sched *schedule.Schedule, cumul bool, pids []int, cout chan Record) {
+	recordPtr := newRecord(true, pids)
+	oldRecordPtr := newRecord(true, pids)
+	diffRecordPtr := newRecord(false, pids)
+	for i := 0; sched.Next(); i++ {
+		err := recordPtr.parse()
+		if err != nil {
+			warn("Error parsing record, ignoring: ", err)
+			continue
+		}
+		if cumul {
+			cout <- *recordPtr
+		} else {
+			if i < 1 {
+				cout <- *recordPtr
+			} else {
+				recordPtr.diff(oldRecordPtr, diffRecordPtr)
+				cout <- *diffRecordPtr
+			}
+			oldRecordPtr, recordPtr = recordPtr, oldRecordPtr
+		}
+	}
+	close(cout)
+}
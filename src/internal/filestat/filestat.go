@@ -0,0 +1,276 @@
+package filestat
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"capture"
+	"internal/schedule"
+)
+
+const (
+	Separator = " "
+)
+
+const (
+	// size and age, then the computed change of the size
+	sizeIdx      = iota
+	idleIdx      = iota
+	sizeDeltaIdx = iota
+	fieldsCount  = iota
+)
+
+// The idle time is the time since the last modification of the file, to detect a stalled
+// writer. The size of a file truncated or rotated decreases.
+var allFieldsDefs = []fieldDef{
+	fieldDef{"file", "size", false, false},
+	fieldDef{"file", "idle_s", false, false},
+	fieldDef{"file", "size_delta", false, true},
+}
+
+/* Header is a list of field names. */
+
+type header []string
+
+func makeHeader(fdl []fieldDef) header {
+	h := header(make([]string, 2+len(fdl)))
+	h[0] = "file"
+	h[1] = "h"
+	for i, d := range fdl {
+		h[i+2] = d.String()
+	}
+	return h
+}
+
+func (h header) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	err = writeTo(w, strings.Join(h, Separator), &n)
+	return
+}
+
+var fsRoot string
+
+func warn(v ...interface{}) {
+	log.Print("WARNING: ", fmt.Sprint(v...))
+}
+
+func init() {
+	fsRoot = os.Getenv("FS_ROOT")
+}
+
+func writeTo(w io.Writer, v interface{}, p *int64) (err error) {
+	m, err := w.Write([]byte(fmt.Sprint(v)))
+	*p += int64(m)
+	return
+}
+
+/* Field Definition */
+
+type fieldDef struct {
+	category      string
+	name          string
+	isAccumulator bool
+	isSigned      bool
+}
+
+func (fd fieldDef) String() string { // implements fmt.Stringer
+	if fd.isAccumulator {
+		return fd.category + ":" + fd.name + "/a"
+	} else if fd.isSigned {
+		return fd.category + ":" + fd.name + capture.SignedSuffix
+	} else {
+		return fd.category + ":" + fd.name + "/i"
+	}
+}
+
+/* Files */
+
+// ParseGlobs returns the globs of a comma separated list, as "/var/log/app.log,/var/log/nginx/*.log".
+func ParseGlobs(list string) (globs []string, err error) {
+	for _, glob := range strings.Split(list, ",") {
+		if glob == "" {
+			continue
+		}
+		_, err = filepath.Match(glob, "")
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", glob, err)
+		}
+		globs = append(globs, glob)
+	}
+	return
+}
+
+// stat returns the files matching the globs, without the FS_ROOT prefix, the directories being
+// left out.
+func stat(globs []string) (infos map[string]os.FileInfo) {
+	infos = make(map[string]os.FileInfo)
+	for _, glob := range globs {
+		matches, _ := filepath.Glob(filepath.Join(fsRoot, glob)) // syntax checked by ParseGlobs
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil || info.IsDir() {
+				continue
+			}
+			infos[strings.TrimPrefix(match, fsRoot)] = info
+		}
+	}
+	return
+}
+
+// instance returns the file as given in the text output, the spaces being escaped as in
+// /proc/mounts.
+func instance(file string) string {
+	return strings.Replace(file, " ", `\040`, -1)
+}
+
+/* Record */
+
+var Header = makeHeader(allFieldsDefs)
+
+// Schema describes the fields of the records, for typed output.
+// The change of the size may be negative: it is a signed field.
+var Schema = capture.Register(capture.Schema{Collector: "filestat", Fields: Header[2:]})
+
+type Record struct {
+	capture.RecordInfo
+	isCumul   bool
+	fieldsMap map[string][]int64 // key is the file name, escaped
+}
+
+func newRecord(isCumul bool) *Record {
+	recordPtr := new(Record)
+	recordPtr.isCumul = isCumul
+	recordPtr.fieldsMap = make(map[string][]int64)
+	return recordPtr
+}
+
+func (record Record) fileNames() []string {
+	names := make([]string, 0, len(record.fieldsMap))
+	for name := range record.fieldsMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (recordPtr *Record) String() string { // implements fmt.Stringer
+	buf := new(bytes.Buffer)
+	recordPtr.WriteTo(buf)
+	return buf.String()
+}
+func (record Record) kind() string {
+	if record.isCumul {
+		return "a"
+	}
+	return "d"
+}
+func (record Record) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	for i, file := range record.fileNames() {
+		if i > 0 {
+			err = writeTo(w, "\n", &n)
+			if err != nil {
+				return
+			}
+		}
+		err = writeTo(w, file+Separator+record.kind(), &n)
+		if err != nil {
+			return
+		}
+		for _, field := range record.fieldsMap[file] {
+			err = writeTo(w, Separator, &n)
+			if err != nil {
+				return
+			}
+			err = writeTo(w, field, &n)
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// Samples returns the typed form of the record, one sample per file.
+func (record Record) Samples() []capture.Sample {
+	samples := make([]capture.Sample, 0, len(record.fieldsMap))
+	for _, file := range record.fileNames() {
+		values := make([]uint64, fieldsCount)
+		for i, field := range record.fieldsMap[file] {
+			values[i] = uint64(field)
+		}
+		samples = append(samples, capture.Sample{Time: record.Time, Instance: file, Kind: record.kind(), Values: values})
+	}
+	return samples
+}
+
+// diff copies the fields, all instant values, and sets the change of the size since the previous
+// record (the whole size for a file just matched).
+func (recordPtr *Record) diff(prevRecord, diffRecord *Record) {
+	diffRecord.RecordInfo = recordPtr.RecordInfo
+	diffRecord.fieldsMap = make(map[string][]int64, len(recordPtr.fieldsMap))
+	for file, fields := range recordPtr.fieldsMap {
+		diffFields := make([]int64, fieldsCount)
+		copy(diffFields, fields)
+		diffFields[sizeDeltaIdx] = fields[sizeIdx]
+		prevFields, ok := prevRecord.fieldsMap[file]
+		if ok {
+			diffFields[sizeDeltaIdx] -= prevFields[sizeIdx]
+		}
+		diffRecord.fieldsMap[file] = diffFields
+	}
+	return
+}
+
+// parse expands the globs again, for the files created since the previous record.
+func (recordPtr *Record) parse(globs []string) (err error) {
+	defer recordPtr.EndRead(time.Now())
+	recordPtr.Time = time.Now()
+	recordPtr.fieldsMap = make(map[string][]int64, len(recordPtr.fieldsMap))
+	for file, info := range stat(globs) {
+		fields := make([]int64, fieldsCount)
+		fields[sizeIdx] = info.Size()
+		fields[idleIdx] = int64(recordPtr.Time.Sub(info.ModTime()) / time.Second)
+		if fields[idleIdx] < 0 {
+			fields[idleIdx] = 0 // modified in the future, clock skew on NFS
+		}
+		recordPtr.fieldsMap[instance(file)] = fields
+	}
+	return
+}
+
+/* Polling */
+
+// Poll sends a Record of the files matching the globs in the channel at each sampling
+// time of the schedule.
+// If cumul is false, it prints the diff of the accumulators, instead of the accumulators themselves
+// (all the fields being instant values, only the change of the size is then computed).
+func Poll(sched *schedule.Schedule, globs []string, cumul bool, cout chan Record) {
+	recordPtr := newRecord(true)
+	oldRecordPtr := newRecord(true)
+	diffRecordPtr := newRecord(false)
+	for i := 0; sched.Next(); i++ {
+		err := recordPtr.parse(globs)
+		if err != nil {
+			warn("Error parsing record, ignoring: ", err)
+			continue
+		}
+		if cumul {
+			cout <- *recordPtr
+		} else {
+			if i < 1 {
+				cout <- *recordPtr
+			} else {
+				recordPtr.diff(oldRecordPtr, diffRecordPtr)
+				cout <- *diffRecordPtr
+			}
+			oldRecordPtr, recordPtr = recordPtr, oldRecordPtr
+		}
+	}
+	close(cout)
+}
@@ -0,0 +1,401 @@
+package kmsgstat
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"capture"
+	"internal/schedule"
+)
+
+const (
+	defaultKmsgFile = "/dev/kmsg"
+	Separator       = " "
+	CommentPrefix   = "# kmsg:"
+	OOMPrefix       = "# oom-kill:"
+)
+
+const (
+	emergIdx    = iota
+	alertIdx    = iota
+	critIdx     = iota
+	errIdx      = iota
+	warningIdx  = iota
+	noticeIdx   = iota
+	infoIdx     = iota
+	debugIdx    = iota
+	oomKillsIdx = iota
+	lostIdx     = iota
+	fieldsCount = iota
+)
+
+// The messages are counted per severity, the index of a severity being its syslog level.
+// The OOM kills are counted as in the oom_kill counter of /proc/vmstat, but with their victims.
+var allFieldsDefs = []fieldDef{
+	fieldDef{"kmsg", "emerg", true},
+	fieldDef{"kmsg", "alert", true},
+	fieldDef{"kmsg", "crit", true},
+	fieldDef{"kmsg", "err", true},
+	fieldDef{"kmsg", "warning", true},
+	fieldDef{"kmsg", "notice", true},
+	fieldDef{"kmsg", "info", true},
+	fieldDef{"kmsg", "debug", true},
+	fieldDef{"oom", "kills", true},
+	fieldDef{"kmsg", "lost", true},
+}
+
+// maxEvents is the maximum number of matching messages given per record, not to flood the
+// output when the kernel loops on an error.
+const maxEvents = 100
+
+/* Header is a list of field names. */
+
+type header []string
+
+func makeHeader(fdl []fieldDef) header {
+	h := header(make([]string, 1+len(fdl)))
+	h[0] = "h"
+	for i, d := range fdl {
+		h[i+1] = d.String()
+	}
+	return h
+}
+
+func (h header) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	err = writeTo(w, strings.Join(h, Separator), &n)
+	return
+}
+
+var kmsgFile string = defaultKmsgFile
+
+func warn(v ...interface{}) {
+	log.Print("WARNING: ", fmt.Sprint(v...))
+}
+
+func init() {
+	fsRoot := os.Getenv("FS_ROOT")
+	if fsRoot != "" {
+		kmsgFile = path.Join(fsRoot, defaultKmsgFile)
+	}
+}
+
+func writeTo(w io.Writer, v interface{}, p *int64) (err error) {
+	m, err := w.Write([]byte(fmt.Sprint(v)))
+	*p += int64(m)
+	return
+}
+
+/* Field Definition */
+
+type fieldDef struct {
+	category      string
+	name          string
+	isAccumulator bool
+}
+
+func (fd fieldDef) String() string { // implements fmt.Stringer
+	if fd.isAccumulator {
+		return fd.category + ":" + fd.name + "/a"
+	} else {
+		return fd.category + ":" + fd.name + "/i"
+	}
+}
+
+/* Patterns */
+
+// Pattern counts the messages matching its regular expression, in a "pattern:<name>/a" field.
+type Pattern struct {
+	Name   string
+	Regexp *regexp.Regexp
+}
+
+// predefinedPatterns are the patterns that can be given by their name only.
+var predefinedPatterns = map[string]string{
+	"oom":       `Out of memory|invoked oom-killer|oom-kill:`,
+	"io_error":  `I/O error|blk_update_request|critical medium error`,
+	"link_down": `(?i)link (is )?down`,
+}
+
+// ParsePatterns returns the patterns of a comma separated list, of which each is the name of a
+// predefined pattern (oom, io_error, link_down), or a name and a regular expression, as
+// "oom,segfault=segfault at".
+func ParsePatterns(list string) (patterns []Pattern, err error) {
+	for _, item := range strings.Split(list, ",") {
+		if item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, "=", 2)
+		name := parts[0]
+		var expr string
+		if len(parts) == 2 {
+			expr = parts[1]
+		} else {
+			var ok bool
+			expr, ok = predefinedPatterns[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown pattern %q (predefined: oom, io_error, link_down)", name)
+			}
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, Pattern{name, re})
+	}
+	return
+}
+
+// oomKillRegexp matches the message of the OOM killer giving its victim, as "Out of memory:
+// Killed process 4242 (java) total-vm:..." or "Memory cgroup out of memory: Killed process ...".
+var oomKillRegexp = regexp.MustCompile(`Killed process (\d+) \(([^)]*)\)`)
+
+func fieldsDefs(patterns []Pattern) []fieldDef {
+	fdl := make([]fieldDef, len(allFieldsDefs), len(allFieldsDefs)+len(patterns))
+	copy(fdl, allFieldsDefs)
+	for _, pattern := range patterns {
+		fdl = append(fdl, fieldDef{"pattern", pattern.Name, true})
+	}
+	return fdl
+}
+
+/* Listener */
+
+// listener counts the messages read from kmsg, and keeps the OOM kills and the messages
+// matching a pattern until the next record, as comment lines without their "# ".
+type listener struct {
+	mu       sync.Mutex
+	patterns []Pattern
+	counts   []uint64
+	events   []string
+	dropped  int // events beyond maxEvents
+}
+
+// add counts a record of kmsg, as "6,339,5140900,-;NET: Registered protocol family 10", the
+// facility being given in the high bits of the priority.
+func (l *listener) add(line string) {
+	sep := strings.IndexByte(line, ';')
+	if sep < 0 {
+		return
+	}
+	prefix := strings.SplitN(line[:sep], ",", 2)
+	prio, err := strconv.Atoi(prefix[0])
+	if err != nil {
+		return
+	}
+	level := prio & 7
+	message := line[sep+1:]
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.counts[level]++
+	if match := oomKillRegexp.FindStringSubmatch(message); match != nil {
+		l.counts[oomKillsIdx]++
+		l.addEvent(OOMPrefix[2:] + " " + match[1] + " (" + match[2] + ")")
+	}
+	matched := false
+	for i, pattern := range l.patterns {
+		if pattern.Regexp.MatchString(message) {
+			l.counts[fieldsCount+i]++
+			matched = true
+		}
+	}
+	if matched {
+		l.addEvent(CommentPrefix[2:] + allFieldsDefs[level].name + ": " + message)
+	}
+}
+
+func (l *listener) addEvent(event string) {
+	if len(l.events) < maxEvents {
+		l.events = append(l.events, event)
+	} else {
+		l.dropped++
+	}
+}
+
+func (l *listener) lost() {
+	l.mu.Lock()
+	l.counts[lostIdx]++
+	l.mu.Unlock()
+}
+
+// listen reads kmsg until its end, which is only reached if it is a regular file (FS_ROOT),
+// each read of the device returning one message, or EPIPE if messages were overwritten in the
+// ring buffer before being read. The continuation lines (dictionary) are skipped.
+func (l *listener) listen(r io.Reader) {
+	reader := bufio.NewReaderSize(r, 8192)
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			if pathErr, ok := err.(*os.PathError); ok && pathErr.Err == syscall.EPIPE {
+				l.lost()
+				continue
+			}
+			warn("Error reading kernel messages, stopping: ", err)
+			return
+		}
+		if strings.HasPrefix(line, " ") {
+			continue
+		}
+		l.add(strings.TrimSuffix(line, "\n"))
+	}
+}
+
+/* Record */
+
+// Schema describes the fields of the records without patterns, for typed output.
+var Schema = capture.Register(NewSchema(nil))
+
+// NewSchema returns the schema of the records, with a field per pattern after the severities.
+func NewSchema(patterns []Pattern) capture.Schema {
+	return capture.Schema{Collector: "kmsgstat", Fields: makeHeader(fieldsDefs(patterns))[1:]}
+}
+
+// NewHeader returns the header of the records, with a field per pattern after the severities.
+func NewHeader(patterns []Pattern) io.WriterTo {
+	return makeHeader(fieldsDefs(patterns))
+}
+
+type Record struct {
+	capture.RecordInfo
+	isCumul bool
+	fields  []uint64
+	events  []string // OOM kills and messages matching a pattern since the previous record
+	dropped int
+}
+
+func newRecord(isCumul bool, count int) *Record {
+	recordPtr := new(Record)
+	recordPtr.isCumul = isCumul
+	recordPtr.fields = make([]uint64, count)
+	return recordPtr
+}
+
+func (recordPtr *Record) String() string { // implements fmt.Stringer
+	buf := new(bytes.Buffer)
+	recordPtr.WriteTo(buf)
+	return buf.String()
+}
+func (record Record) kind() string {
+	if record.isCumul {
+		return "a"
+	}
+	return "d"
+}
+func (record Record) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	err = writeTo(w, record.kind(), &n)
+	if err != nil {
+		return
+	}
+	for _, field := range record.fields {
+		err = writeTo(w, Separator, &n)
+		if err != nil {
+			return
+		}
+		err = writeTo(w, field, &n)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// WriteEvents writes the OOM kills ("# oom-kill: 4242 (java)") and the messages matching a
+// pattern ("# kmsg:err: Out of memory: Killed process 4242 (java) ...") since the previous
+// record as comment lines, to be written before the record.
+func (record Record) WriteEvents(w io.Writer) (err error) {
+	for _, event := range record.events {
+		_, err = fmt.Fprint(w, "# ", event, "\n")
+		if err != nil {
+			return
+		}
+	}
+	if record.dropped > 0 {
+		_, err = fmt.Fprint(w, CommentPrefix, " ", record.dropped, " more events\n")
+	}
+	return
+}
+
+// Samples returns the typed form of the record.
+func (record Record) Samples() []capture.Sample {
+	values := make([]uint64, len(record.fields))
+	copy(values, record.fields)
+	return []capture.Sample{{Time: record.Time, Kind: record.kind(), Values: values}}
+}
+
+// diff computes the diffs of the counters, all the fields being accumulators.
+func (recordPtr *Record) diff(prevRecord, diffRecord *Record) {
+	diffRecord.RecordInfo = recordPtr.RecordInfo
+	for i, field := range recordPtr.fields {
+		diffRecord.fields[i] = field - prevRecord.fields[i]
+	}
+	diffRecord.events = recordPtr.events
+	diffRecord.dropped = recordPtr.dropped
+	return
+}
+
+// snapshot copies the counters accumulated since the start of the listener, and takes the
+// events kept since the previous snapshot.
+func (recordPtr *Record) snapshot(l *listener) {
+	defer recordPtr.EndRead(time.Now())
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	recordPtr.Time = time.Now()
+	copy(recordPtr.fields, l.counts)
+	recordPtr.events, l.events = l.events, nil
+	recordPtr.dropped, l.dropped = l.dropped, 0
+}
+
+/* Polling */
+
+// Poll starts following the kernel messages, from the end of the ring buffer unless backlog
+// is true, then sends a Record in the channel at each sampling time of the schedule.
+// If cumul is false, it prints the diff of the accumulators, instead of the accumulators themselves
+func Poll(sched *schedule.Schedule, patterns []Pattern, backlog bool, cumul bool, cout chan Record) {
+	inFile, err := os.Open(kmsgFile)
+	if err != nil {
+		warn("Error opening kernel messages (root privileges may be required): ", err)
+		close(cout)
+		return
+	}
+	// not closed, the listener reading it until the end of the process
+	if !backlog {
+		_, err = inFile.Seek(0, io.SeekEnd)
+		if err != nil {
+			warn("Error skipping the kernel messages already logged, counting them: ", err)
+		}
+	}
+	count := fieldsCount + len(patterns)
+	l := &listener{patterns: patterns, counts: make([]uint64, count)}
+	go l.listen(inFile)
+	recordPtr := newRecord(true, count)
+	oldRecordPtr := newRecord(true, count)
+	diffRecordPtr := newRecord(false, count)
+	for i := 0; sched.Next(); i++ {
+		recordPtr.snapshot(l)
+		if cumul {
+			cout <- *recordPtr
+		} else {
+			if i < 1 {
+				cout <- *recordPtr
+			} else {
+				recordPtr.diff(oldRecordPtr, diffRecordPtr)
+				cout <- *diffRecordPtr
+			}
+			oldRecordPtr, recordPtr = recordPtr, oldRecordPtr
+		}
+	}
+	close(cout)
+}
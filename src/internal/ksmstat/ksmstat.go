@@ -0,0 +1,219 @@
+package ksmstat
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"capture"
+	"internal/schedule"
+)
+
+const (
+	defaultKsmDir = "/sys/kernel/mm/ksm"
+	Separator     = " "
+)
+
+const (
+	pagesSharedIdx   = iota
+	pagesSharingIdx  = iota
+	pagesUnsharedIdx = iota
+	pagesVolatileIdx = iota
+	fullScansIdx     = iota
+	fieldsCount      = iota
+)
+
+// The fields are named after the files of the ksm directory, the pages being counted in pages.
+var allFieldsDefs = []fieldDef{
+	fieldDef{"ksm", "pages_shared", false},
+	fieldDef{"ksm", "pages_sharing", false},
+	fieldDef{"ksm", "pages_unshared", false},
+	fieldDef{"ksm", "pages_volatile", false},
+	fieldDef{"ksm", "full_scans", true},
+}
+
+/* Header is a list of field names. */
+
+type header []string
+
+func makeHeader(fdl []fieldDef) header {
+	h := header(make([]string, 1+len(fdl)))
+	h[0] = "h"
+	for i, d := range fdl {
+		h[i+1] = d.String()
+	}
+	return h
+}
+
+func (h header) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	err = writeTo(w, strings.Join(h, Separator), &n)
+	return
+}
+
+var ksmDir string = defaultKsmDir
+
+func warn(v ...interface{}) {
+	log.Print("WARNING: ", fmt.Sprint(v...))
+}
+
+func init() {
+	fsRoot := os.Getenv("FS_ROOT")
+	if fsRoot != "" {
+		ksmDir = path.Join(fsRoot, defaultKsmDir)
+	}
+}
+
+func writeTo(w io.Writer, v interface{}, p *int64) (err error) {
+	m, err := w.Write([]byte(fmt.Sprint(v)))
+	*p += int64(m)
+	return
+}
+
+/* Field Definition */
+
+type fieldDef struct {
+	category      string
+	name          string
+	isAccumulator bool
+}
+
+func (fd fieldDef) String() string { // implements fmt.Stringer
+	if fd.isAccumulator {
+		return fd.category + ":" + fd.name + "/a"
+	} else {
+		return fd.category + ":" + fd.name + "/i"
+	}
+}
+
+// IsAvailable reports whether the kernel exposes the ksm counters (CONFIG_KSM).
+func IsAvailable() bool {
+	_, err := os.Stat(path.Join(ksmDir, "pages_shared"))
+	return err == nil
+}
+
+func readUint(fileName string) (value uint64, err error) {
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64)
+}
+
+/* Record */
+
+var Header = makeHeader(allFieldsDefs)
+
+// Schema describes the fields of the records, for typed output.
+var Schema = capture.Register(capture.Schema{Collector: "ksmstat", Fields: Header[1:]})
+
+type Record struct {
+	capture.RecordInfo
+	isCumul bool
+	fields  []uint64
+}
+
+func newRecord(isCumul bool) *Record {
+	recordPtr := new(Record)
+	recordPtr.isCumul = isCumul
+	recordPtr.fields = make([]uint64, fieldsCount)
+	return recordPtr
+}
+
+func (recordPtr *Record) String() string { // implements fmt.Stringer
+	buf := new(bytes.Buffer)
+	recordPtr.WriteTo(buf)
+	return buf.String()
+}
+func (record Record) kind() string {
+	if record.isCumul {
+		return "a"
+	}
+	return "d"
+}
+func (record Record) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	err = writeTo(w, record.kind(), &n)
+	if err != nil {
+		return
+	}
+	for _, field := range record.fields {
+		err = writeTo(w, Separator, &n)
+		if err != nil {
+			return
+		}
+		err = writeTo(w, field, &n)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Samples returns the typed form of the record.
+func (record Record) Samples() []capture.Sample {
+	values := make([]uint64, fieldsCount)
+	copy(values, record.fields)
+	return []capture.Sample{{Time: record.Time, Kind: record.kind(), Values: values}}
+}
+func (recordPtr *Record) diff(prevRecord, diffRecord *Record) {
+	diffRecord.RecordInfo = recordPtr.RecordInfo
+	for i, field := range recordPtr.fields {
+		if allFieldsDefs[i].isAccumulator {
+			diffRecord.fields[i] = field - prevRecord.fields[i]
+		} else {
+			diffRecord.fields[i] = field
+		}
+	}
+	return
+}
+
+// parse reads one file per field, pages_volatile being left at zero on the kernels not having it.
+func (recordPtr *Record) parse() (err error) {
+	defer recordPtr.EndRead(time.Now())
+	recordPtr.Time = time.Now()
+	for i, d := range allFieldsDefs {
+		recordPtr.fields[i], err = readUint(path.Join(ksmDir, d.name))
+		if os.IsNotExist(err) && i == pagesVolatileIdx {
+			err = nil
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+/* Polling */
+
+// Poll sends a Record in the channel at each sampling time of the schedule.
+// If cumul is false, it prints the diff of the accumulators, instead of the accumulators themselves
+func Poll(sched *schedule.Schedule, cumul bool, cout chan Record) {
+	recordPtr := newRecord(true)
+	oldRecordPtr := newRecord(true)
+	diffRecordPtr := newRecord(false)
+	for i := 0; sched.Next(); i++ {
+		err := recordPtr.parse()
+		if err != nil {
+			warn("Error parsing record, ignoring: ", err)
+			continue
+		}
+		if cumul {
+			cout <- *recordPtr
+		} else {
+			if i < 1 {
+				cout <- *recordPtr
+			} else {
+				recordPtr.diff(oldRecordPtr, diffRecordPtr)
+				cout <- *diffRecordPtr
+			}
+			oldRecordPtr, recordPtr = recordPtr, oldRecordPtr
+		}
+	}
+	close(cout)
+}
@@ -9,6 +9,9 @@ import (
 	"os"
 	"strings"
 	"time"
+
+	"capture"
+	"internal/schedule"
 )
 
 const (
@@ -42,8 +45,11 @@ func writeTo(w io.Writer, v interface{}, p *int64) (err error) {
 
 var Header = makeHeader()
 
+// Schema describes the fields of the records, for typed output.
+var Schema = capture.Register(capture.Schema{Collector: "linescount", Fields: Header[1:]})
+
 type Record struct {
-	Time           time.Time
+	capture.RecordInfo
 	isCumul        bool
 	count          uint64
 	bytes          uint64
@@ -62,12 +68,14 @@ func (recordPtr *Record) String() string { // implements fmt.Stringer
 	recordPtr.WriteTo(buf)
 	return buf.String()
 }
-func (record Record) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+func (record Record) kind() string {
 	if record.isCumul {
-		err = writeTo(w, "a", &n)
-	} else {
-		err = writeTo(w, "d", &n)
+		return "a"
 	}
+	return "d"
+}
+func (record Record) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	err = writeTo(w, record.kind(), &n)
 	if err != nil {
 		return
 	}
@@ -90,8 +98,13 @@ func (record Record) WriteTo(w io.Writer) (n int64, err error) { // implements i
 	return
 }
 
+// Samples returns the typed form of the record.
+func (record Record) Samples() []capture.Sample {
+	return []capture.Sample{{Time: record.Time, Kind: record.kind(), Values: []uint64{record.count, record.bytes}}}
+}
+
 func (recordPtr *Record) diff(prevCount uint64, prevBytes uint64, diffRecord *Record) {
-	diffRecord.Time = recordPtr.Time
+	diffRecord.RecordInfo = recordPtr.RecordInfo
 	diffRecord.count = recordPtr.count - prevCount
 	diffRecord.bytes = recordPtr.bytes - prevBytes
 	return
@@ -118,7 +131,7 @@ func (recordPtr *Record) countlines(cout chan []byte, substring string, invert b
                 break loop
         }
     }
-	recordPtr.Time = time.Now()
+	recordPtr.Time = time.Now() // no source read, the read time staying zero: lines are waited for
 	ok = true
 	return
 }
@@ -139,27 +152,15 @@ func ReadStdin(cout chan []byte) {
 
 /* Polling */
 
-// Poll sends a Record in the channel every period until duration.
+// Poll sends a Record in the channel at each sampling time of the schedule.
 // If cumul is false, it prints the diff of the accumulators, instead of the accumulators themselves
-func Poll(substring string, invert bool, period time.Duration, duration time.Duration, cumul bool, cout chan Record) {
-	startTime := time.Now()
+func Poll(substring string, invert bool, sched *schedule.Schedule, cumul bool, cout chan Record) {
 	recordPtr := newRecord(true)
 	var oldCount, oldBytes uint64
 	diffRecordPtr := newRecord(false)
 	chstdin := make(chan []byte)
 	go ReadStdin(chstdin)
-	var lastTime, nextTime time.Time
-	for i := 0; (0 == duration) || (time.Since(startTime) <= duration); i++ {
-		if i > 0 {
-			nextTime = lastTime.Add(period)
-			toWait := nextTime.Sub(time.Now())
-			if toWait > 0 {
-				time.Sleep(toWait)
-			}
-		} else {
-			nextTime = time.Now()
-		}
-		lastTime = nextTime
+	for i := 0; sched.Next(); i++ {
 		//log.Println("Counting lines")
 		ok := recordPtr.countlines(chstdin, substring, invert)
 		if !ok {
@@ -0,0 +1,282 @@
+package linkstat
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"capture"
+	"internal/schedule"
+)
+
+const (
+	defaultSysClassNet = "/sys/class/net"
+	Separator          = " "
+)
+
+const (
+	operstateIdx      = iota
+	carrierIdx        = iota
+	speedIdx          = iota
+	fullDuplexIdx     = iota
+	carrierChangesIdx = iota
+	fieldsCount       = iota
+)
+
+// The operational state is coded as ifOperStatus of the IF-MIB (RFC 2863), as by SNMP agents.
+// The carrier, speed and duplex are only known for the interfaces up, the speed being zero if
+// not given (as for virtual interfaces). A link flapping shows in the changes of its carrier.
+var allFieldsDefs = []fieldDef{
+	fieldDef{"link", "operstate", false},
+	fieldDef{"link", "carrier", false},
+	fieldDef{"link", "speed_mbps", false},
+	fieldDef{"link", "full_duplex", false},
+	fieldDef{"link", "carrier_changes", true},
+}
+
+// operStatus maps the operstate of sysfs to ifOperStatus.
+var operStatus = map[string]uint64{
+	"up":             1,
+	"down":           2,
+	"testing":        3,
+	"unknown":        4,
+	"dormant":        5,
+	"notpresent":     6,
+	"lowerlayerdown": 7,
+}
+
+/* Header is a list of field names. */
+
+type header []string
+
+func makeHeader(fdl []fieldDef) header {
+	h := header(make([]string, 2+len(fdl)))
+	h[0] = "interface"
+	h[1] = "h"
+	for i, d := range fdl {
+		h[i+2] = d.String()
+	}
+	return h
+}
+
+func (h header) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	err = writeTo(w, strings.Join(h, Separator), &n)
+	return
+}
+
+var sysClassNet string = defaultSysClassNet
+
+func warn(v ...interface{}) {
+	log.Print("WARNING: ", fmt.Sprint(v...))
+}
+
+func init() {
+	fsRoot := os.Getenv("FS_ROOT")
+	if fsRoot != "" {
+		sysClassNet = path.Join(fsRoot, defaultSysClassNet)
+	}
+}
+
+func writeTo(w io.Writer, v interface{}, p *int64) (err error) {
+	m, err := w.Write([]byte(fmt.Sprint(v)))
+	*p += int64(m)
+	return
+}
+
+/* Field Definition */
+
+type fieldDef struct {
+	category      string
+	name          string
+	isAccumulator bool
+}
+
+func (fd fieldDef) String() string { // implements fmt.Stringer
+	if fd.isAccumulator {
+		return fd.category + ":" + fd.name + "/a"
+	} else {
+		return fd.category + ":" + fd.name + "/i"
+	}
+}
+
+/* Record */
+
+var Header = makeHeader(allFieldsDefs)
+
+// Schema describes the fields of the records, for typed output.
+var Schema = capture.Register(capture.Schema{Collector: "linkstat", Fields: Header[2:]})
+
+type Record struct {
+	capture.RecordInfo
+	isCumul   bool
+	fieldsMap map[string][]uint64 // key is the interface
+}
+
+func newRecord(isCumul bool) *Record {
+	recordPtr := new(Record)
+	recordPtr.isCumul = isCumul
+	recordPtr.fieldsMap = make(map[string][]uint64)
+	return recordPtr
+}
+
+func (record Record) interfaces() []string {
+	names := make([]string, 0, len(record.fieldsMap))
+	for name := range record.fieldsMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (recordPtr *Record) String() string { // implements fmt.Stringer
+	buf := new(bytes.Buffer)
+	recordPtr.WriteTo(buf)
+	return buf.String()
+}
+func (record Record) kind() string {
+	if record.isCumul {
+		return "a"
+	}
+	return "d"
+}
+func (record Record) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	for i, iface := range record.interfaces() {
+		if i > 0 {
+			err = writeTo(w, "\n", &n)
+			if err != nil {
+				return
+			}
+		}
+		err = writeTo(w, iface+Separator+record.kind(), &n)
+		if err != nil {
+			return
+		}
+		for _, field := range record.fieldsMap[iface] {
+			err = writeTo(w, Separator, &n)
+			if err != nil {
+				return
+			}
+			err = writeTo(w, field, &n)
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// Samples returns the typed form of the record, one sample per interface.
+func (record Record) Samples() []capture.Sample {
+	samples := make([]capture.Sample, 0, len(record.fieldsMap))
+	for _, iface := range record.interfaces() {
+		values := make([]uint64, fieldsCount)
+		copy(values, record.fieldsMap[iface])
+		samples = append(samples, capture.Sample{Time: record.Time, Instance: iface, Kind: record.kind(), Values: values})
+	}
+	return samples
+}
+
+// diff computes the diffs of the accumulators, an interface added since the previous record
+// having all its changes of carrier counted in the interval.
+func (recordPtr *Record) diff(prevRecord, diffRecord *Record) {
+	diffRecord.RecordInfo = recordPtr.RecordInfo
+	diffRecord.fieldsMap = make(map[string][]uint64, len(recordPtr.fieldsMap))
+	for iface, fields := range recordPtr.fieldsMap {
+		prevFields, ok := prevRecord.fieldsMap[iface]
+		diffFields := make([]uint64, fieldsCount)
+		for i, field := range fields {
+			if allFieldsDefs[i].isAccumulator && ok {
+				diffFields[i] = field - prevFields[i]
+			} else {
+				diffFields[i] = field
+			}
+		}
+		diffRecord.fieldsMap[iface] = diffFields
+	}
+	return
+}
+
+// readAttribute returns the content of an attribute of the interface, empty if not readable,
+// as the speed of an interface down (EINVAL).
+func readAttribute(iface, name string) string {
+	content, err := ioutil.ReadFile(path.Join(sysClassNet, iface, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
+
+// parseInterface reads the attributes of the link of the interface.
+func parseInterface(iface string) []uint64 {
+	fields := make([]uint64, fieldsCount)
+	fields[operstateIdx] = operStatus[readAttribute(iface, "operstate")]
+	if fields[operstateIdx] == 0 {
+		fields[operstateIdx] = operStatus["unknown"]
+	}
+	fields[carrierIdx], _ = strconv.ParseUint(readAttribute(iface, "carrier"), 10, 64)
+	speed, err := strconv.ParseInt(readAttribute(iface, "speed"), 10, 64)
+	if err == nil && speed > 0 { // -1 if unknown
+		fields[speedIdx] = uint64(speed)
+	}
+	if readAttribute(iface, "duplex") == "full" {
+		fields[fullDuplexIdx] = 1
+	}
+	fields[carrierChangesIdx], _ = strconv.ParseUint(readAttribute(iface, "carrier_changes"), 10, 64)
+	return fields
+}
+
+// parse reads the interfaces of /sys/class/net, the interfaces removed meanwhile being dropped.
+func (recordPtr *Record) parse() (err error) {
+	defer recordPtr.EndRead(time.Now())
+	entries, err := ioutil.ReadDir(sysClassNet)
+	if err != nil {
+		return
+	}
+	recordPtr.Time = time.Now()
+	recordPtr.fieldsMap = make(map[string][]uint64, len(recordPtr.fieldsMap))
+	for _, entry := range entries {
+		if _, err := os.Stat(path.Join(sysClassNet, entry.Name(), "operstate")); err != nil {
+			continue // removed since listed, or not an interface (bonding_masters)
+		}
+		recordPtr.fieldsMap[entry.Name()] = parseInterface(entry.Name())
+	}
+	return
+}
+
+/* Polling */
+
+// Poll sends a Record in the channel at each sampling time of the schedule.
+// If cumul is false, it prints the diff of the accumulators, instead of the accumulators themselves
+// (only the changes of carrier, the other fields being instant values).
+func Poll(sched *schedule.Schedule, cumul bool, cout chan Record) {
+	recordPtr := newRecord(true)
+	oldRecordPtr := newRecord(true)
+	diffRecordPtr := newRecord(false)
+	for i := 0; sched.Next(); i++ {
+		err := recordPtr.parse()
+		if err != nil {
+			warn("Error parsing record, ignoring: ", err)
+			continue
+		}
+		if cumul {
+			cout <- *recordPtr
+		} else {
+			if i < 1 {
+				cout <- *recordPtr
+			} else {
+				recordPtr.diff(oldRecordPtr, diffRecordPtr)
+				cout <- *diffRecordPtr
+			}
+			oldRecordPtr, recordPtr = recordPtr, oldRecordPtr
+		}
+	}
+	close(cout)
+}
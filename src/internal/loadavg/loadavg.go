@@ -0,0 +1,243 @@
+package loadavg
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"capture"
+	"internal/schedule"
+)
+
+const (
+	defaultProcLoadavg = "/proc/loadavg"
+	Separator          = " "
+)
+
+const (
+	// 0.19 0.05 0.01 2/71 9776
+	load1Idx         = iota
+	load5Idx         = iota
+	load15Idx        = iota
+	tasksRunnableIdx = iota
+	tasksTotalIdx    = iota
+	lastPidIdx       = iota
+	fieldsCount      = iota
+)
+
+// Load averages are multiplied by 100, to be kept as integers.
+var allFieldsDefs = []fieldDef{
+	fieldDef{"load", "avg1_x100", false},
+	fieldDef{"load", "avg5_x100", false},
+	fieldDef{"load", "avg15_x100", false},
+	fieldDef{"tasks", "runnable", false},
+	fieldDef{"tasks", "total", false},
+	fieldDef{"pid", "last", false},
+}
+
+/* Header is a list of field names. */
+
+type header []string
+
+func makeHeader(fdl []fieldDef) header {
+	h := header(make([]string, 1+len(fdl)))
+	h[0] = "h"
+	for i, d := range fdl {
+		h[i+1] = d.String()
+	}
+	return h
+}
+
+func (h header) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	err = writeTo(w, strings.Join(h, Separator), &n)
+	return
+}
+
+var procLoadavg string = defaultProcLoadavg
+
+func warn(v ...interface{}) {
+	log.Print("WARNING: ", fmt.Sprint(v...))
+}
+
+func init() {
+	fsRoot := os.Getenv("FS_ROOT")
+	if fsRoot != "" {
+		procLoadavg = path.Join(fsRoot, defaultProcLoadavg)
+	}
+}
+
+// parseHundredths parses a load average with 2 decimals, as "0.19", into 19.
+func parseHundredths(str string) (value uint, err error) {
+	f, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		return
+	}
+	value = uint(f*100 + 0.5)
+	return
+}
+
+// parseLineToFields parses the single line of /proc/loadavg.
+func parseLineToFields(line string, targetSlice []uint) (err error) {
+	parsedFields := strings.Fields(line)
+	if len(parsedFields) < 5 {
+		return fmt.Errorf("unexpected content in %s: %q", procLoadavg, line)
+	}
+	for i, idx := range []int{load1Idx, load5Idx, load15Idx} {
+		targetSlice[idx], err = parseHundredths(parsedFields[i])
+		if err != nil {
+			return
+		}
+	}
+	tasks := strings.SplitN(parsedFields[3], "/", 2)
+	if len(tasks) != 2 {
+		return fmt.Errorf("unexpected tasks count in %s: %q", procLoadavg, parsedFields[3])
+	}
+	var uint64field uint64
+	for i, str := range []string{tasks[0], tasks[1], parsedFields[4]} {
+		uint64field, err = strconv.ParseUint(str, 10, 0)
+		if err != nil {
+			return
+		}
+		targetSlice[tasksRunnableIdx+i] = uint(uint64field)
+	}
+	return
+}
+
+func writeTo(w io.Writer, v interface{}, p *int64) (err error) {
+	m, err := w.Write([]byte(fmt.Sprint(v)))
+	*p += int64(m)
+	return
+}
+
+/* Field Definition */
+
+type fieldDef struct {
+	category      string
+	name          string
+	isAccumulator bool
+}
+
+func (fd fieldDef) String() string { // implements fmt.Stringer
+	if fd.isAccumulator {
+		return fd.category + ":" + fd.name + "/a"
+	} else {
+		return fd.category + ":" + fd.name + "/i"
+	}
+}
+
+/* Record */
+
+var Header = makeHeader(allFieldsDefs)
+
+// Schema describes the fields of the records, for typed output.
+var Schema = capture.Register(capture.Schema{Collector: "loadavg", Fields: Header[1:]})
+
+type Record struct {
+	capture.RecordInfo
+	isCumul bool
+	fields  []uint
+}
+
+func newRecord(isCumul bool) *Record {
+	recordPtr := new(Record)
+	recordPtr.isCumul = isCumul
+	recordPtr.fields = make([]uint, fieldsCount)
+	return recordPtr
+}
+
+func (recordPtr *Record) String() string { // implements fmt.Stringer
+	buf := new(bytes.Buffer)
+	recordPtr.WriteTo(buf)
+	return buf.String()
+}
+func (record Record) kind() string {
+	if record.isCumul {
+		return "a"
+	}
+	return "d"
+}
+func (record Record) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	err = writeTo(w, record.kind(), &n)
+	if err != nil {
+		return
+	}
+	for _, field := range record.fields {
+		err = writeTo(w, Separator, &n)
+		if err != nil {
+			return
+		}
+		err = writeTo(w, field, &n)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Samples returns the typed form of the record.
+func (record Record) Samples() []capture.Sample {
+	values := make([]uint64, len(record.fields))
+	for i, field := range record.fields {
+		values[i] = uint64(field)
+	}
+	return []capture.Sample{{Time: record.Time, Kind: record.kind(), Values: values}}
+}
+func (recordPtr *Record) diff(prevRecord, diffRecord *Record) {
+	diffRecord.RecordInfo = recordPtr.RecordInfo
+	for i, field := range recordPtr.fields {
+		if allFieldsDefs[i].isAccumulator {
+			diffRecord.fields[i] = field - prevRecord.fields[i]
+		} else {
+			diffRecord.fields[i] = field
+		}
+	}
+	return
+}
+
+func (recordPtr *Record) parse() (err error) {
+	defer recordPtr.EndRead(time.Now())
+	content, err := ioutil.ReadFile(procLoadavg)
+	if err != nil {
+		return
+	}
+	recordPtr.Time = time.Now()
+	err = parseLineToFields(string(content), recordPtr.fields)
+	return
+}
+
+/* Polling */
+
+// Poll sends a Record in the channel at each sampling time of the schedule.
+// If cumul is false, it prints the diff of the accumulators, instead of the accumulators themselves
+// (all the fields of loadavg being instant values, only the record kind changes).
+func Poll(sched *schedule.Schedule, cumul bool, cout chan Record) {
+	recordPtr := newRecord(true)
+	oldRecordPtr := newRecord(true)
+	diffRecordPtr := newRecord(false)
+	for i := 0; sched.Next(); i++ {
+		err := recordPtr.parse()
+		if err != nil {
+			warn("Error parsing record, ignoring: ", err)
+			continue
+		}
+		if cumul {
+			cout <- *recordPtr
+		} else {
+			if i < 1 {
+				cout <- *recordPtr
+			} else {
+				recordPtr.diff(oldRecordPtr, diffRecordPtr)
+				cout <- *diffRecordPtr
+			}
+			oldRecordPtr, recordPtr = recordPtr, oldRecordPtr
+		}
+	}
+	close(cout)
+}
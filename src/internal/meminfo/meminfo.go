@@ -0,0 +1,310 @@
+package meminfo
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"capture"
+	"internal/schedule"
+)
+
+const (
+	defaultProcMeminfo = "/proc/meminfo"
+	Separator          = " "
+)
+
+const (
+	memTotalIdx     = iota
+	memFreeIdx      = iota
+	memAvailableIdx = iota
+	memUsedIdx      = iota
+	memBuffersIdx   = iota
+	memCachedIdx    = iota
+	memActiveIdx    = iota
+	memInactiveIdx  = iota
+	memDirtyIdx     = iota
+	memWritebackIdx = iota
+	memAnonPagesIdx = iota
+	memMappedIdx    = iota
+	memShmemIdx     = iota
+	memSlabIdx      = iota
+	memCommittedIdx = iota
+	swapTotalIdx    = iota
+	swapFreeIdx     = iota
+	swapCachedIdx   = iota
+	fieldsCount     = iota
+)
+
+// All values are in kB, as in /proc/meminfo.
+var allFieldsDefs = []fieldDef{
+	fieldDef{"mem", "total", false, nil},
+	fieldDef{"mem", "free", false, nil},
+	fieldDef{"mem", "available", false, nil},
+	fieldDef{"mem", "used", false, usedMemCalculator},
+	fieldDef{"mem", "buffers", false, nil},
+	fieldDef{"mem", "cached", false, nil},
+	fieldDef{"mem", "active", false, nil},
+	fieldDef{"mem", "inactive", false, nil},
+	fieldDef{"mem", "dirty", false, nil},
+	fieldDef{"mem", "writeback", false, nil},
+	fieldDef{"mem", "anon", false, nil},
+	fieldDef{"mem", "mapped", false, nil},
+	fieldDef{"mem", "shmem", false, nil},
+	fieldDef{"mem", "slab", false, nil},
+	fieldDef{"mem", "committed", false, nil},
+	fieldDef{"swap", "total", false, nil},
+	fieldDef{"swap", "free", false, nil},
+	fieldDef{"swap", "cached", false, nil},
+}
+
+// usedMemCalculator returns the memory that cannot be reclaimed without swapping.
+func usedMemCalculator(fields []uint) uint {
+	if fields[memAvailableIdx] > fields[memTotalIdx] {
+		return 0
+	}
+	return fields[memTotalIdx] - fields[memAvailableIdx]
+}
+
+func init() {
+	addLineDef("MemTotal", memTotalIdx)
+	addLineDef("MemFree", memFreeIdx)
+	addLineDef("MemAvailable", memAvailableIdx)
+	addLineDef("Buffers", memBuffersIdx)
+	addLineDef("Cached", memCachedIdx)
+	addLineDef("Active", memActiveIdx)
+	addLineDef("Inactive", memInactiveIdx)
+	addLineDef("Dirty", memDirtyIdx)
+	addLineDef("Writeback", memWritebackIdx)
+	addLineDef("AnonPages", memAnonPagesIdx)
+	addLineDef("Mapped", memMappedIdx)
+	addLineDef("Shmem", memShmemIdx)
+	addLineDef("Slab", memSlabIdx)
+	addLineDef("Committed_AS", memCommittedIdx)
+	addLineDef("SwapTotal", swapTotalIdx)
+	addLineDef("SwapFree", swapFreeIdx)
+	addLineDef("SwapCached", swapCachedIdx)
+	capture.RegisterModel("meminfo", capture.Model{Capacities: []string{"mem:total", "swap:total"}})
+}
+
+/* Header is a list of field names. */
+
+type header []string
+
+func makeHeader(fdl []fieldDef) header {
+	h := header(make([]string, 1+len(fdl)))
+	h[0] = "h"
+	for i, d := range fdl {
+		h[i+1] = d.String()
+	}
+	return h
+}
+
+func (h header) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	err = writeTo(w, strings.Join(h, Separator), &n)
+	return
+}
+
+var procMeminfo string = defaultProcMeminfo
+
+func warn(v ...interface{}) {
+	log.Print("WARNING: ", fmt.Sprint(v...))
+}
+
+func init() {
+	fsRoot := os.Getenv("FS_ROOT")
+	if fsRoot != "" {
+		procMeminfo = path.Join(fsRoot, defaultProcMeminfo)
+	}
+}
+
+// parseLineToFields parses a "Name:   value kB" line.
+func parseLineToFields(line string, targetSlice []uint) (err error) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return
+	}
+	ld, ok := linesDefs[strings.TrimSuffix(fields[0], ":")]
+	if !ok {
+		return
+	}
+	uint64field, err := strconv.ParseUint(fields[1], 10, 0)
+	if err != nil {
+		return
+	}
+	targetSlice[ld.fieldIdx] = uint(uint64field)
+	return
+}
+
+func writeTo(w io.Writer, v interface{}, p *int64) (err error) {
+	m, err := w.Write([]byte(fmt.Sprint(v)))
+	*p += int64(m)
+	return
+}
+
+/* Field Definition */
+
+type fieldCalculator func(vals []uint) uint
+
+type fieldDef struct {
+	category      string
+	name          string
+	isAccumulator bool
+	calculator    fieldCalculator
+}
+
+func (fd fieldDef) String() string { // implements fmt.Stringer
+	if fd.isAccumulator {
+		return fd.category + ":" + fd.name + "/a"
+	} else {
+		return fd.category + ":" + fd.name + "/i"
+	}
+}
+
+/* Line definition */
+
+type lineDef struct {
+	name     string
+	fieldIdx uint
+}
+
+var linesDefs = make(map[string]lineDef, fieldsCount)
+
+func addLineDef(name string, fieldIdx uint) {
+	linesDefs[name] = lineDef{name, fieldIdx}
+}
+
+/* Record */
+
+var Header = makeHeader(allFieldsDefs)
+
+// Schema describes the fields of the records, for typed output.
+var Schema = capture.Register(capture.Schema{Collector: "meminfo", Fields: Header[1:]})
+
+type Record struct {
+	capture.RecordInfo
+	isCumul bool
+	fields  []uint
+}
+
+func newRecord(isCumul bool) *Record {
+	recordPtr := new(Record)
+	recordPtr.isCumul = isCumul
+	recordPtr.fields = make([]uint, fieldsCount)
+	return recordPtr
+}
+
+func (recordPtr *Record) String() string { // implements fmt.Stringer
+	buf := new(bytes.Buffer)
+	recordPtr.WriteTo(buf)
+	return buf.String()
+}
+func (record Record) kind() string {
+	if record.isCumul {
+		return "a"
+	}
+	return "d"
+}
+func (record Record) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	err = writeTo(w, record.kind(), &n)
+	if err != nil {
+		return
+	}
+	for _, field := range record.fields {
+		err = writeTo(w, Separator, &n)
+		if err != nil {
+			return
+		}
+		err = writeTo(w, field, &n)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Samples returns the typed form of the record.
+func (record Record) Samples() []capture.Sample {
+	values := make([]uint64, len(record.fields))
+	for i, field := range record.fields {
+		values[i] = uint64(field)
+	}
+	return []capture.Sample{{Time: record.Time, Kind: record.kind(), Values: values}}
+}
+func (recordPtr *Record) diff(prevRecord, diffRecord *Record) {
+	diffRecord.RecordInfo = recordPtr.RecordInfo
+	for i, field := range recordPtr.fields {
+		if allFieldsDefs[i].isAccumulator {
+			diffRecord.fields[i] = field - prevRecord.fields[i]
+		} else {
+			diffRecord.fields[i] = field
+		}
+	}
+	return
+}
+
+func (recordPtr *Record) parse() (err error) {
+	defer recordPtr.EndRead(time.Now())
+	inFile, err := os.Open(procMeminfo)
+	if err != nil {
+		return
+	}
+	defer inFile.Close()
+	recordPtr.Time = time.Now()
+	for i, _ := range recordPtr.fields {
+		recordPtr.fields[i] = 0
+	}
+	scanner := bufio.NewScanner(inFile)
+	for scanner.Scan() {
+		err = parseLineToFields(scanner.Text(), recordPtr.fields)
+		if err != nil {
+			return
+		}
+	}
+	err = scanner.Err()
+	if err != nil {
+		return
+	}
+	for i, fd := range allFieldsDefs {
+		if fd.calculator != nil {
+			recordPtr.fields[i] = fd.calculator(recordPtr.fields)
+		}
+	}
+	return
+}
+
+/* Polling */
+
+// Poll sends a Record in the channel at each sampling time of the schedule.
+// If cumul is false, it prints the diff of the accumulators, instead of the accumulators themselves
+func Poll(sched *schedule.Schedule, cumul bool, cout chan Record) {
+	recordPtr := newRecord(true)
+	oldRecordPtr := newRecord(true)
+	diffRecordPtr := newRecord(false)
+	for i := 0; sched.Next(); i++ {
+		err := recordPtr.parse()
+		if err != nil {
+			warn("Error parsing record, ignoring: ", err)
+			continue
+		}
+		if cumul {
+			cout <- *recordPtr
+		} else {
+			if i < 1 {
+				cout <- *recordPtr
+			} else {
+				recordPtr.diff(oldRecordPtr, diffRecordPtr)
+				cout <- *diffRecordPtr
+			}
+			oldRecordPtr, recordPtr = recordPtr, oldRecordPtr
+		}
+	}
+	close(cout)
+}
@@ -0,0 +1,59 @@
+package neighstat
+
+import (
+	"encoding/binary"
+	"syscall"
+)
+
+const (
+	ndMsgLen   = 12 // sizeof(struct ndmsg)
+	ndStateOff = 8  // offset of ndm_state in struct ndmsg
+)
+
+var nativeEndian = binary.NativeEndian
+
+// dumpStates returns the states (NUD_*) of the entries of the neighbor table of the family, from
+// an RTM_GETNEIGH dump of rtnetlink.
+func dumpStates(family uint8) (states []uint16, err error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_DGRAM, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return
+	}
+	defer syscall.Close(fd)
+	b := make([]byte, syscall.NLMSG_HDRLEN+ndMsgLen)
+	nativeEndian.PutUint32(b[0:4], uint32(len(b)))
+	nativeEndian.PutUint16(b[4:6], syscall.RTM_GETNEIGH)
+	nativeEndian.PutUint16(b[6:8], syscall.NLM_F_REQUEST|syscall.NLM_F_DUMP)
+	b[syscall.NLMSG_HDRLEN] = family
+	err = syscall.Sendto(fd, b, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK})
+	if err != nil {
+		return
+	}
+	buf := make([]byte, 1<<16)
+	for {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return nil, err
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range msgs {
+			switch m.Header.Type {
+			case syscall.NLMSG_DONE:
+				return states, nil
+			case syscall.NLMSG_ERROR:
+				if len(m.Data) >= 4 {
+					if errno := int32(nativeEndian.Uint32(m.Data[0:4])); errno != 0 {
+						return nil, syscall.Errno(-errno)
+					}
+				}
+			case syscall.RTM_NEWNEIGH:
+				if len(m.Data) >= ndMsgLen {
+					states = append(states, nativeEndian.Uint16(m.Data[ndStateOff:]))
+				}
+			}
+		}
+	}
+}
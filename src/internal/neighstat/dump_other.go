@@ -0,0 +1,9 @@
+//go:build !linux
+
+package neighstat
+
+import "errors"
+
+func dumpStates(family uint8) (states []uint16, err error) {
+	return nil, errors.New("Not supported on this platform")
+}
@@ -0,0 +1,304 @@
+package neighstat
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"capture"
+	"internal/schedule"
+)
+
+const (
+	defaultProcSysNetDir = "/proc/sys/net"
+	Separator            = " "
+)
+
+const (
+	entriesIdx    = iota
+	reachableIdx  = iota
+	staleIdx      = iota
+	delayIdx      = iota
+	probeIdx      = iota
+	incompleteIdx = iota
+	failedIdx     = iota
+	noarpIdx      = iota
+	permanentIdx  = iota
+	thresh1Idx    = iota
+	thresh2Idx    = iota
+	thresh3Idx    = iota
+	usedPctIdx    = iota
+	fieldsCount   = iota
+)
+
+// The entries are counted per state (NUD_* of linux/neighbour.h). Over gc_thresh3 entries, the
+// new neighbors are refused ("neighbour table overflow"), the used pct being relative to it.
+var allFieldsDefs = []fieldDef{
+	fieldDef{"neigh", "entries", false},
+	fieldDef{"neigh", "reachable", false},
+	fieldDef{"neigh", "stale", false},
+	fieldDef{"neigh", "delay", false},
+	fieldDef{"neigh", "probe", false},
+	fieldDef{"neigh", "incomplete", false},
+	fieldDef{"neigh", "failed", false},
+	fieldDef{"neigh", "noarp", false},
+	fieldDef{"neigh", "permanent", false},
+	fieldDef{"gc", "thresh1", false},
+	fieldDef{"gc", "thresh2", false},
+	fieldDef{"gc", "thresh3", false},
+	fieldDef{"neigh", "used_pct", false},
+}
+
+// stateIdx gives the field of each state
+var stateIdx = map[uint16]int{
+	0x01: incompleteIdx,
+	0x02: reachableIdx,
+	0x04: staleIdx,
+	0x08: delayIdx,
+	0x10: probeIdx,
+	0x20: failedIdx,
+	0x40: noarpIdx,
+	0x80: permanentIdx,
+}
+
+// families are the neighbor tables, per name of the directory of their parameters
+var families = []struct {
+	name   string
+	family uint8
+}{
+	{"ipv4", syscall.AF_INET},
+	{"ipv6", syscall.AF_INET6},
+}
+
+/* Header is a list of field names. */
+
+type header []string
+
+func makeHeader(fdl []fieldDef) header {
+	h := header(make([]string, 2+len(fdl)))
+	h[0] = "table"
+	h[1] = "h"
+	for i, d := range fdl {
+		h[i+2] = d.String()
+	}
+	return h
+}
+
+func (h header) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	err = writeTo(w, strings.Join(h, Separator), &n)
+	return
+}
+
+var procSysNetDir string = defaultProcSysNetDir
+
+func warn(v ...interface{}) {
+	log.Print("WARNING: ", fmt.Sprint(v...))
+}
+
+func init() {
+	fsRoot := os.Getenv("FS_ROOT")
+	if fsRoot != "" {
+		procSysNetDir = path.Join(fsRoot, defaultProcSysNetDir)
+	}
+}
+
+func writeTo(w io.Writer, v interface{}, p *int64) (err error) {
+	m, err := w.Write([]byte(fmt.Sprint(v)))
+	*p += int64(m)
+	return
+}
+
+/* Field Definition */
+
+type fieldDef struct {
+	category      string
+	name          string
+	isAccumulator bool
+}
+
+func (fd fieldDef) String() string { // implements fmt.Stringer
+	if fd.isAccumulator {
+		return fd.category + ":" + fd.name + "/a"
+	} else {
+		return fd.category + ":" + fd.name + "/i"
+	}
+}
+
+/* Tables */
+
+func readUint(fileName string) (value uint64, err error) {
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64)
+}
+
+// parseTable counts the entries of the table of the family per state, and reads its gc
+// thresholds, as /proc/sys/net/ipv4/neigh/default/gc_thresh3.
+func parseTable(name string, family uint8) (fields []uint64, err error) {
+	states, err := dumpStates(family)
+	if err != nil {
+		return
+	}
+	fields = make([]uint64, fieldsCount)
+	fields[entriesIdx] = uint64(len(states))
+	for _, state := range states {
+		for bit, idx := range stateIdx {
+			if state&bit != 0 {
+				fields[idx]++
+			}
+		}
+	}
+	for i, idx := range []int{thresh1Idx, thresh2Idx, thresh3Idx} {
+		fileName := path.Join(procSysNetDir, name, "neigh", "default", "gc_thresh"+strconv.Itoa(i+1))
+		fields[idx], err = readUint(fileName)
+		if err != nil {
+			return
+		}
+	}
+	if fields[thresh3Idx] > 0 {
+		fields[usedPctIdx] = fields[entriesIdx] * 100 / fields[thresh3Idx]
+	}
+	return
+}
+
+/* Record */
+
+var Header = makeHeader(allFieldsDefs)
+
+// Schema describes the fields of the records, for typed output.
+var Schema = capture.Register(capture.Schema{Collector: "neighstat", Fields: Header[2:]})
+
+type Record struct {
+	capture.RecordInfo
+	isCumul   bool
+	fieldsMap map[string][]uint64 // key is the table (ipv4, ipv6)
+}
+
+func newRecord(isCumul bool) *Record {
+	recordPtr := new(Record)
+	recordPtr.isCumul = isCumul
+	recordPtr.fieldsMap = make(map[string][]uint64)
+	return recordPtr
+}
+
+func (record Record) tableNames() []string {
+	names := make([]string, 0, len(record.fieldsMap))
+	for _, f := range families {
+		if _, ok := record.fieldsMap[f.name]; ok {
+			names = append(names, f.name)
+		}
+	}
+	return names
+}
+
+func (recordPtr *Record) String() string { // implements fmt.Stringer
+	buf := new(bytes.Buffer)
+	recordPtr.WriteTo(buf)
+	return buf.String()
+}
+func (record Record) kind() string {
+	if record.isCumul {
+		return "a"
+	}
+	return "d"
+}
+func (record Record) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	for i, table := range record.tableNames() {
+		if i > 0 {
+			err = writeTo(w, "\n", &n)
+			if err != nil {
+				return
+			}
+		}
+		err = writeTo(w, table+Separator+record.kind(), &n)
+		if err != nil {
+			return
+		}
+		for _, field := range record.fieldsMap[table] {
+			err = writeTo(w, Separator, &n)
+			if err != nil {
+				return
+			}
+			err = writeTo(w, field, &n)
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// Samples returns the typed form of the record, one sample per table.
+func (record Record) Samples() []capture.Sample {
+	samples := make([]capture.Sample, 0, len(record.fieldsMap))
+	for _, table := range record.tableNames() {
+		values := make([]uint64, fieldsCount)
+		copy(values, record.fieldsMap[table])
+		samples = append(samples, capture.Sample{Time: record.Time, Instance: table, Kind: record.kind(), Values: values})
+	}
+	return samples
+}
+
+// diff copies the fields, all instant values.
+func (recordPtr *Record) diff(prevRecord, diffRecord *Record) {
+	diffRecord.RecordInfo = recordPtr.RecordInfo
+	diffRecord.fieldsMap = recordPtr.fieldsMap
+	return
+}
+
+// parse reads the tables, the IPv6 table being left out if IPv6 is disabled.
+func (recordPtr *Record) parse() (err error) {
+	defer recordPtr.EndRead(time.Now())
+	recordPtr.Time = time.Now()
+	recordPtr.fieldsMap = make(map[string][]uint64, len(families))
+	for _, f := range families {
+		fields, err := parseTable(f.name, f.family)
+		if f.name == "ipv6" && os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s", f.name, err)
+		}
+		recordPtr.fieldsMap[f.name] = fields
+	}
+	return
+}
+
+/* Polling */
+
+// Poll sends a Record in the channel at each sampling time of the schedule.
+// All the fields being instant values, cumul only changes the kind of the records.
+func Poll(sched *schedule.Schedule, cumul bool, cout chan Record) {
+	recordPtr := newRecord(true)
+	oldRecordPtr := newRecord(true)
+	diffRecordPtr := newRecord(false)
+	for i := 0; sched.Next(); i++ {
+		err := recordPtr.parse()
+		if err != nil {
+			warn("Error parsing record, ignoring: ", err)
+			continue
+		}
+		if cumul {
+			cout <- *recordPtr
+		} else {
+			if i < 1 {
+				cout <- *recordPtr
+			} else {
+				recordPtr.diff(oldRecordPtr, diffRecordPtr)
+				cout <- *diffRecordPtr
+			}
+			oldRecordPtr, recordPtr = recordPtr, oldRecordPtr
+		}
+	}
+	close(cout)
+}
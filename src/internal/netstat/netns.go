@@ -0,0 +1,95 @@
+package netstat
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+)
+
+const (
+	defaultProcDir   = "/proc"
+	defaultVarRunDir = "/var/run/netns"
+)
+
+var procDir string = defaultProcDir
+var varRunNetnsDir string = defaultVarRunDir
+
+/* Network namespaces */
+
+// netNamespace is a network namespace other than ours, seen through one of its processes.
+type netNamespace struct {
+	name string // name in /var/run/netns, or "pid<N>" after its lowest pid
+	pid  string
+}
+
+// namedNetNamespaces returns the namespaces bound in /var/run/netns (as by "ip netns add").
+func namedNetNamespaces() map[string]os.FileInfo {
+	named := make(map[string]os.FileInfo)
+	entries, err := ioutil.ReadDir(varRunNetnsDir)
+	if err != nil {
+		return named // none
+	}
+	for _, entry := range entries {
+		fi, err := os.Stat(path.Join(varRunNetnsDir, entry.Name()))
+		if err == nil {
+			named[entry.Name()] = fi
+		}
+	}
+	return named
+}
+
+func pids() (pids []int, err error) {
+	entries, err := ioutil.ReadDir(procDir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	sort.Ints(pids)
+	return
+}
+
+// listNetNamespaces returns the network namespaces of the running processes, except ours.
+// Namespaces without any process (only bound in /var/run/netns) cannot be seen.
+func listNetNamespaces() (namespaces []netNamespace, err error) {
+	pids, err := pids()
+	if err != nil {
+		return
+	}
+	named := namedNetNamespaces()
+	var seen []os.FileInfo
+	own, err := os.Stat(path.Join(procDir, "self/ns/net"))
+	if err == nil {
+		seen = append(seen, own)
+	}
+	err = nil
+pidsLoop:
+	for _, pid := range pids {
+		pidStr := strconv.Itoa(pid)
+		fi, err := os.Stat(path.Join(procDir, pidStr, "ns/net"))
+		if err != nil {
+			continue // exited, or not allowed
+		}
+		for _, other := range seen {
+			if os.SameFile(fi, other) {
+				continue pidsLoop
+			}
+		}
+		seen = append(seen, fi)
+		ns := netNamespace{"pid" + pidStr, pidStr}
+		for name, other := range named {
+			if os.SameFile(fi, other) {
+				ns.name = name
+				break
+			}
+		}
+		namespaces = append(namespaces, ns)
+	}
+	return
+}
@@ -5,17 +5,22 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"path"
 	"strconv"
 	"strings"
 	"time"
+
+	"capture"
+	"internal/schedule"
 )
 
 const (
-	defaultProcNetDev = "/proc/net/dev"
-	Separator         = " "
+	defaultProcNetDev  = "/proc/net/dev"
+	defaultSysClassNet = "/sys/class/net"
+	Separator          = " "
 )
 
 const (
@@ -78,30 +83,48 @@ func (h header) WriteTo(w io.Writer) (n int64, err error) { // implements io.Wri
 	return
 }
 
+func fieldNames(fdl []fieldDef) []string {
+	names := make([]string, len(fdl))
+	for i, d := range fdl {
+		names[i] = d.String()
+	}
+	return names
+}
+
+// statisticsNames are the names of the counters of /sys/class/net/<iface>/statistics, in the
+// order of the fields.
+var statisticsNames = []string{
+	"rx_bytes", "rx_packets", "rx_errors", "rx_dropped", "rx_fifo_errors", "rx_frame_errors", "rx_compressed", "multicast",
+	"tx_bytes", "tx_packets", "tx_errors", "tx_dropped", "tx_fifo_errors", "collisions", "tx_carrier_errors", "tx_compressed",
+}
+
 var procNetDev string = defaultProcNetDev
+var sysClassNet string = defaultSysClassNet
 
 func init() {
 	fsRoot := os.Getenv("FS_ROOT")
 	if fsRoot != "" {
 		procNetDev = path.Join(fsRoot, defaultProcNetDev)
+		sysClassNet = path.Join(fsRoot, defaultSysClassNet)
+		procDir = path.Join(fsRoot, defaultProcDir)
+		varRunNetnsDir = path.Join(fsRoot, defaultVarRunDir)
 	}
 }
 
-func (recordPtr *Record) parseLineToFields(line string) (err error) {
+// parseLineToFields parses an interface line, the interface name being prefixed by ifacePrefix.
+func (recordPtr *Record) parseLineToFields(line string, ifacePrefix string) (err error) {
 	parsedFields := strings.Fields(line)
 	prefix := parsedFields[0]
 	if prefix[len(prefix)-1] != ':' {
 		return
 	}
-	iface := prefix[:len(prefix)-1]
+	iface := ifacePrefix + prefix[:len(prefix)-1]
 	recordFields := recordPtr.getFields(iface)
-	var uint64field uint64
 	for i, str := range parsedFields[1:] {
-		uint64field, err = strconv.ParseUint(str, 10, 0)
+		recordFields[i], err = strconv.ParseUint(str, 10, 64)
 		if err != nil {
 			return
 		}
-		recordFields[i] = uint(uint64field)
 	}
 	return
 }
@@ -114,7 +137,7 @@ func writeTo(w io.Writer, v interface{}, p *int64) (err error) {
 
 /* Field Definition */
 
-type fieldCalculator func(vals []uint) uint
+type fieldCalculator func(vals []uint64) uint64
 
 type fieldDef struct {
 	category      string
@@ -148,25 +171,28 @@ func addLineDef(prefix string, fieldsIdx ...uint) {
 
 var Header = makeHeader(allFieldsDefs)
 
+// Schema describes the fields of the records, for typed output.
+var Schema = capture.Register(capture.Schema{Collector: "netstat", Fields: fieldNames(allFieldsDefs)})
+
 type Record struct {
-	Time      time.Time
+	capture.RecordInfo
 	isCumul   bool
-	fieldsMap map[string][]uint // key is the interface
+	fieldsMap map[string][]uint64 // key is the interface, prefixed by "namespace/" for other namespaces
 }
 
 func newRecord(isCumul bool) *Record {
 	recordPtr := new(Record)
 	recordPtr.isCumul = isCumul
-	recordPtr.fieldsMap = make(map[string][]uint)
+	recordPtr.fieldsMap = make(map[string][]uint64)
 	return recordPtr
 }
 
-func (recordPtr *Record) getFields(iface string) (fields []uint) {
+func (recordPtr *Record) getFields(iface string) (fields []uint64) {
 	fields, ok := recordPtr.fieldsMap[iface]
 	if ok {
 		return
 	}
-	fields = make([]uint, fieldsCount)
+	fields = make([]uint64, fieldsCount)
 	recordPtr.fieldsMap[iface] = fields
 	return
 }
@@ -176,6 +202,12 @@ func (recordPtr *Record) String() string { // implements fmt.Stringer
 	recordPtr.WriteTo(buf)
 	return buf.String()
 }
+func (record Record) kind() string {
+	if record.isCumul {
+		return "a"
+	}
+	return "d"
+}
 func (record Record) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
 	for iface, fields := range record.fieldsMap {
 		err = writeTo(w, iface, &n)
@@ -186,11 +218,7 @@ func (record Record) WriteTo(w io.Writer) (n int64, err error) { // implements i
 		if err != nil {
 			return
 		}
-		if record.isCumul {
-			err = writeTo(w, "a", &n)
-		} else {
-			err = writeTo(w, "d", &n)
-		}
+		err = writeTo(w, record.kind(), &n)
 		if err != nil {
 			return
 		}
@@ -211,8 +239,19 @@ func (record Record) WriteTo(w io.Writer) (n int64, err error) { // implements i
 	}
 	return
 }
+// Samples returns the typed form of the record, one sample per interface.
+func (record Record) Samples() []capture.Sample {
+	samples := make([]capture.Sample, 0, len(record.fieldsMap))
+	for iface, fields := range record.fieldsMap {
+		values := make([]uint64, len(fields))
+		copy(values, fields)
+		samples = append(samples, capture.Sample{Time: record.Time, Instance: iface, Kind: record.kind(), Values: values})
+	}
+	return samples
+}
 func (recordPtr *Record) diff(prevRecord, diffRecord *Record) {
-	diffRecord.Time = recordPtr.Time
+	diffRecord.RecordInfo = recordPtr.RecordInfo
+	diffRecord.fieldsMap = make(map[string][]uint64, len(recordPtr.fieldsMap))
 	for iface, fields := range recordPtr.fieldsMap {
 		prevFields := prevRecord.getFields(iface)
 		diffFields := diffRecord.getFields(iface)
@@ -227,30 +266,69 @@ func (recordPtr *Record) diff(prevRecord, diffRecord *Record) {
 	return
 }
 
-func (recordPtr *Record) parse() (err error) {
-	inFile, err := os.Open(procNetDev)
+func (recordPtr *Record) parseFile(fileName string, ifacePrefix string) (err error) {
+	inFile, err := os.Open(fileName)
 	if err != nil {
 		return
 	}
 	defer inFile.Close()
-	recordPtr.Time = time.Now()
-	for _, fields := range recordPtr.fieldsMap {
-		for i, _ := range fields {
-			fields[i] = 0
-		}
-	}
 	scanner := bufio.NewScanner(inFile)
 	for j := 0; scanner.Scan(); j++ {
 		line := scanner.Text()
-		err = recordPtr.parseLineToFields(line)
+		err = recordPtr.parseLineToFields(line, ifacePrefix)
 		if err != nil {
 			return
 		}
 	}
 	err = scanner.Err()
+	return
+}
+
+// parseStatistics reads the counters of an interface of our network namespace in
+// /sys/class/net/<iface>/statistics, kept in 64 bits by all the drivers, where some give
+// /proc/net/dev counters wrapping at 32 bits (in less than an hour for the bytes of a 10GbE
+// link). The counters not readable keep their values of /proc/net/dev.
+func parseStatistics(iface string, fields []uint64) {
+	dir := path.Join(sysClassNet, iface, "statistics")
+	for i, name := range statisticsNames {
+		content, err := ioutil.ReadFile(path.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		value, err := strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64)
+		if err == nil {
+			fields[i] = value
+		}
+	}
+}
+
+// parse parses the interfaces of our network namespace and, if netns is true, those of the
+// other namespaces. Interfaces (or namespaces) that disappeared are dropped.
+// If sysfs is true, the counters of the interfaces of our namespace are read in sysfs.
+func (recordPtr *Record) parse(netns bool, sysfs bool) (err error) {
+	defer recordPtr.EndRead(time.Now())
+	recordPtr.Time = time.Now()
+	recordPtr.fieldsMap = make(map[string][]uint64, len(recordPtr.fieldsMap))
+	err = recordPtr.parseFile(procNetDev, "")
 	if err != nil {
 		return
 	}
+	if sysfs {
+		for iface, fields := range recordPtr.fieldsMap {
+			parseStatistics(iface, fields)
+		}
+	}
+	if netns {
+		var namespaces []netNamespace
+		namespaces, err = listNetNamespaces()
+		if err != nil {
+			return
+		}
+		for _, ns := range namespaces {
+			// errors are ignored, the process may have exited since the listing
+			recordPtr.parseFile(path.Join(procDir, ns.pid, "net/dev"), ns.name+"/")
+		}
+	}
 	for i, fd := range allFieldsDefs {
 		if fd.calculator != nil {
 			for _, fields := range recordPtr.fieldsMap {
@@ -263,26 +341,17 @@ func (recordPtr *Record) parse() (err error) {
 
 /* Polling */
 
-// Poll sends a Record in the channel every period until duration.
+// Poll sends a Record in the channel at each sampling time of the schedule.
 // If cumul is false, it prints the diff of the accumulators, instead of the accumulators themselves
-func Poll(period time.Duration, duration time.Duration, cumul bool, cout chan Record) {
-	startTime := time.Now()
+// If netns is true, the interfaces of the other network namespaces are added, as "namespace/interface"
+// If sysfs is true, the counters of the interfaces of our namespace are read in /sys/class/net,
+// in 64 bits, instead of /proc/net/dev
+func Poll(sched *schedule.Schedule, cumul bool, netns bool, sysfs bool, cout chan Record) {
 	recordPtr := newRecord(true)
 	oldRecordPtr := newRecord(true)
 	diffRecordPtr := newRecord(false)
-	var lastTime, nextTime time.Time
-	for i := 0; (0 == duration) || (time.Since(startTime) <= duration); i++ {
-		if i > 0 {
-			nextTime = lastTime.Add(period)
-			toWait := nextTime.Sub(time.Now())
-			if toWait > 0 {
-				time.Sleep(toWait)
-			}
-		} else {
-			nextTime = time.Now()
-		}
-		lastTime = nextTime
-		err := recordPtr.parse()
+	for i := 0; sched.Next(); i++ {
+		err := recordPtr.parse(netns, sysfs)
 		if err != nil {
 			log.Println(err)
 			continue
@@ -0,0 +1,265 @@
+package nfsdstat
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"capture"
+	"internal/schedule"
+)
+
+const (
+	defaultProcNfsd = "/proc/net/rpc/nfsd"
+	Separator       = " "
+)
+
+const (
+	rcHitsIdx    = iota
+	rcMissesIdx  = iota
+	rcNocacheIdx = iota
+	ioReadIdx    = iota
+	ioWriteIdx   = iota
+	threadsIdx   = iota
+	rpcCallsIdx  = iota
+	rpcBadIdx    = iota
+	v3ReadIdx    = iota
+	v3WriteIdx   = iota
+	v4ReadIdx    = iota
+	v4WriteIdx   = iota
+	fieldsCount  = iota
+)
+
+// The reply cache (rc) counts the non-idempotent requests (as writes) found in the cache of
+// the replies, a retransmission by the client, not found, or not to be cached.
+var allFieldsDefs = []fieldDef{
+	fieldDef{"rc", "hits", true},
+	fieldDef{"rc", "misses", true},
+	fieldDef{"rc", "nocache", true},
+	fieldDef{"io", "read_bytes", true},
+	fieldDef{"io", "write_bytes", true},
+	fieldDef{"th", "threads", false},
+	fieldDef{"rpc", "calls", true},
+	fieldDef{"rpc", "badcalls", true},
+	fieldDef{"v3", "read", true},
+	fieldDef{"v3", "write", true},
+	fieldDef{"v4", "read", true},
+	fieldDef{"v4", "write", true},
+}
+
+// lineDef gives the field of a value of a line of /proc/net/rpc/nfsd, the values of the
+// proc lines being counted after their number.
+type lineDef struct {
+	col int
+	idx int
+}
+
+var lineDefs = map[string][]lineDef{
+	"rc":       {{0, rcHitsIdx}, {1, rcMissesIdx}, {2, rcNocacheIdx}},
+	"io":       {{0, ioReadIdx}, {1, ioWriteIdx}},
+	"th":       {{0, threadsIdx}},
+	"rpc":      {{0, rpcCallsIdx}, {1, rpcBadIdx}},
+	"proc3":    {{1 + 6, v3ReadIdx}, {1 + 7, v3WriteIdx}},   // NFSPROC3_READ, NFSPROC3_WRITE
+	"proc4ops": {{1 + 25, v4ReadIdx}, {1 + 38, v4WriteIdx}}, // OP_READ, OP_WRITE
+}
+
+/* Header is a list of field names. */
+
+type header []string
+
+func makeHeader(fdl []fieldDef) header {
+	h := header(make([]string, 1+len(fdl)))
+	h[0] = "h"
+	for i, d := range fdl {
+		h[i+1] = d.String()
+	}
+	return h
+}
+
+func (h header) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	err = writeTo(w, strings.Join(h, Separator), &n)
+	return
+}
+
+var procNfsd string = defaultProcNfsd
+
+func warn(v ...interface{}) {
+	log.Print("WARNING: ", fmt.Sprint(v...))
+}
+
+func init() {
+	fsRoot := os.Getenv("FS_ROOT")
+	if fsRoot != "" {
+		procNfsd = path.Join(fsRoot, defaultProcNfsd)
+	}
+}
+
+func writeTo(w io.Writer, v interface{}, p *int64) (err error) {
+	m, err := w.Write([]byte(fmt.Sprint(v)))
+	*p += int64(m)
+	return
+}
+
+/* Field Definition */
+
+type fieldDef struct {
+	category      string
+	name          string
+	isAccumulator bool
+}
+
+func (fd fieldDef) String() string { // implements fmt.Stringer
+	if fd.isAccumulator {
+		return fd.category + ":" + fd.name + "/a"
+	} else {
+		return fd.category + ":" + fd.name + "/i"
+	}
+}
+
+// IsAvailable reports whether the NFS server statistics are available (nfsd module loaded).
+func IsAvailable() bool {
+	_, err := os.Stat(procNfsd)
+	return err == nil
+}
+
+// parseLine parses a line of /proc/net/rpc/nfsd, as "rc 0 2034 18932", the lines and the
+// values not known being ignored (the values of proc4ops only exist for the operations known
+// by the kernel).
+func (recordPtr *Record) parseLine(line string) (err error) {
+	parsedFields := strings.Fields(line)
+	if len(parsedFields) < 2 {
+		return
+	}
+	for _, def := range lineDefs[parsedFields[0]] {
+		if def.col+1 >= len(parsedFields) {
+			continue
+		}
+		recordPtr.fields[def.idx], err = strconv.ParseUint(parsedFields[def.col+1], 10, 64)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+/* Record */
+
+var Header = makeHeader(allFieldsDefs)
+
+// Schema describes the fields of the records, for typed output.
+var Schema = capture.Register(capture.Schema{Collector: "nfsdstat", Fields: Header[1:]})
+
+type Record struct {
+	capture.RecordInfo
+	isCumul bool
+	fields  []uint64
+}
+
+func newRecord(isCumul bool) *Record {
+	recordPtr := new(Record)
+	recordPtr.isCumul = isCumul
+	recordPtr.fields = make([]uint64, fieldsCount)
+	return recordPtr
+}
+
+func (recordPtr *Record) String() string { // implements fmt.Stringer
+	buf := new(bytes.Buffer)
+	recordPtr.WriteTo(buf)
+	return buf.String()
+}
+func (record Record) kind() string {
+	if record.isCumul {
+		return "a"
+	}
+	return "d"
+}
+func (record Record) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	err = writeTo(w, record.kind(), &n)
+	if err != nil {
+		return
+	}
+	for _, field := range record.fields {
+		err = writeTo(w, Separator, &n)
+		if err != nil {
+			return
+		}
+		err = writeTo(w, field, &n)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Samples returns the typed form of the record.
+func (record Record) Samples() []capture.Sample {
+	values := make([]uint64, fieldsCount)
+	copy(values, record.fields)
+	return []capture.Sample{{Time: record.Time, Kind: record.kind(), Values: values}}
+}
+func (recordPtr *Record) diff(prevRecord, diffRecord *Record) {
+	diffRecord.RecordInfo = recordPtr.RecordInfo
+	for i, field := range recordPtr.fields {
+		if allFieldsDefs[i].isAccumulator {
+			diffRecord.fields[i] = field - prevRecord.fields[i]
+		} else {
+			diffRecord.fields[i] = field
+		}
+	}
+	return
+}
+
+func (recordPtr *Record) parse() (err error) {
+	defer recordPtr.EndRead(time.Now())
+	inFile, err := os.Open(procNfsd)
+	if err != nil {
+		return
+	}
+	defer inFile.Close()
+	recordPtr.Time = time.Now()
+	scanner := bufio.NewScanner(inFile)
+	for scanner.Scan() {
+		err = recordPtr.parseLine(scanner.Text())
+		if err != nil {
+			return
+		}
+	}
+	err = scanner.Err()
+	return
+}
+
+/* Polling */
+
+// Poll sends a Record in the channel at each sampling time of the schedule.
+// If cumul is false, it prints the diff of the accumulators, instead of the accumulators themselves
+func Poll(sched *schedule.Schedule, cumul bool, cout chan Record) {
+	recordPtr := newRecord(true)
+	oldRecordPtr := newRecord(true)
+	diffRecordPtr := newRecord(false)
+	for i := 0; sched.Next(); i++ {
+		err := recordPtr.parse()
+		if err != nil {
+			warn("Error parsing record, ignoring: ", err)
+			continue
+		}
+		if cumul {
+			cout <- *recordPtr
+		} else {
+			if i < 1 {
+				cout <- *recordPtr
+			} else {
+				recordPtr.diff(oldRecordPtr, diffRecordPtr)
+				cout <- *diffRecordPtr
+			}
+			oldRecordPtr, recordPtr = recordPtr, oldRecordPtr
+		}
+	}
+	close(cout)
+}
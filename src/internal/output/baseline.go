@@ -0,0 +1,177 @@
+package output
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"capture"
+)
+
+// Baseline adds to the samples their fields in pct of a baseline, as companion fields named
+// with the "_pctbase" suffix (e.g. "cpu:user_pctbase/i"). The baseline of each field of an
+// instance is its mean over the first samples of the run, or over a reference capture, the
+// cumulative samples ("a" kind) excepted.
+// The companion fields of the signed fields are signed, a value of the sign opposite to the one
+// of the mean being in negative pct.
+// The companion fields are zero while the baseline is computed, and when the mean is zero.
+// The samples must have the kind of those of the baseline (e.g. both deltas, or both
+// percentages).
+// A nil *Baseline adds nothing.
+type Baseline struct {
+	names  []string
+	signed []bool
+	length time.Duration
+	until  time.Time // end of the baseline window, once started
+	kind   string    // of the samples of the baseline, empty until the first one
+	sums   map[string][]float64
+	counts map[string]int
+	means  map[string][]float64 // nil until computed
+}
+
+// NewBaseline returns a baseline of the records of the schema, computed over the samples of
+// the first length of the run, from its first samples other than cumulative.
+func NewBaseline(schema capture.Schema, length time.Duration) *Baseline {
+	names := make([]string, len(schema.Fields))
+	signed := schema.Signed()
+	for i, field := range schema.Fields {
+		kind := "/i"
+		if signed[i] {
+			kind = capture.SignedSuffix
+		}
+		names[i] = strings.SplitN(field, "/", 2)[0] + "_pctbase" + kind
+	}
+	return &Baseline{names: names, signed: signed, length: length, sums: make(map[string][]float64), counts: make(map[string]int)}
+}
+
+// ReadBaseline returns a baseline of the records of the schema, computed over the samples of
+// the reference capture read from r (text or gob), having the same fields.
+func ReadBaseline(schema capture.Schema, r io.Reader) (*Baseline, error) {
+	reader, refSchema, err := capture.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	if strings.Join(refSchema.Fields, " ") != strings.Join(schema.Fields, " ") {
+		return nil, fmt.Errorf("fields of the reference capture differ from the fields of the records")
+	}
+	b := NewBaseline(schema, 0)
+	for {
+		sample, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		err = b.add(sample)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if b.kind == "" {
+		return nil, fmt.Errorf("no samples other than cumulative in the reference capture")
+	}
+	b.computeMeans()
+	return b, nil
+}
+
+// checkKind returns an error if the sample, unless cumulative, has not the kind of the samples of
+// the baseline.
+func (b *Baseline) checkKind(sample capture.Sample) error {
+	if sample.Kind == "a" || sample.Kind == b.kind {
+		return nil
+	}
+	return fmt.Errorf("samples of kind %s against a baseline of kind %s", sample.Kind, b.kind)
+}
+
+func (b *Baseline) add(sample capture.Sample) error {
+	if sample.Kind == "a" {
+		return nil
+	}
+	if b.kind == "" {
+		b.kind = sample.Kind
+	}
+	err := b.checkKind(sample)
+	if err != nil {
+		return err
+	}
+	sums, ok := b.sums[sample.Instance]
+	if !ok {
+		sums = make([]float64, len(b.names))
+		b.sums[sample.Instance] = sums
+	}
+	for i := range sums {
+		if i < len(sample.Values) {
+			sums[i] += capture.Float(sample.Values[i], b.signed[i])
+		}
+	}
+	b.counts[sample.Instance]++
+	return nil
+}
+
+func (b *Baseline) computeMeans() {
+	b.means = make(map[string][]float64, len(b.sums))
+	for instance, sums := range b.sums {
+		means := make([]float64, len(sums))
+		for i, sum := range sums {
+			means[i] = sum / float64(b.counts[instance])
+		}
+		b.means[instance] = means
+	}
+}
+
+// Fields returns the names of the companion fields, to add to the header or the schema.
+func (b *Baseline) Fields() []string {
+	if b == nil {
+		return nil
+	}
+	return b.names
+}
+
+// Apply returns the samples of the record taken at t with their fields in pct of the baseline
+// appended to their values, the fields being the first values of the samples.
+// It must be called for every record, in order, including those not output, for the baseline
+// not to depend on the records output.
+func (b *Baseline) Apply(t time.Time, samples []capture.Sample) ([]capture.Sample, error) {
+	if b == nil {
+		return samples, nil
+	}
+	if b.means == nil {
+		if b.until.IsZero() && len(samples) > 0 && samples[0].Kind != "a" {
+			b.until = t.Add(b.length) // from the first record of the baseline, not the first one of the run
+		}
+		if b.until.IsZero() || t.Before(b.until) {
+			for _, sample := range samples {
+				err := b.add(sample)
+				if err != nil {
+					return nil, err
+				}
+			}
+		} else {
+			b.computeMeans()
+		}
+	}
+	applied := make([]capture.Sample, len(samples))
+	for i, sample := range samples {
+		if b.means != nil {
+			err := b.checkKind(sample)
+			if err != nil {
+				return nil, err
+			}
+		}
+		values := make([]uint64, len(sample.Values), len(sample.Values)+len(b.names))
+		copy(values, sample.Values)
+		means := b.means[sample.Instance]
+		for j := range b.names {
+			var pct uint64
+			if means != nil && means[j] != 0 && j < len(sample.Values) {
+				pct = capture.FromFloat(capture.Float(sample.Values[j], b.signed[j])*100/means[j], b.signed[j])
+			}
+			values = append(values, pct)
+		}
+		sample.Values = values
+		applied[i] = sample
+	}
+	return applied, nil
+}
@@ -0,0 +1,108 @@
+package output
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"capture"
+)
+
+// TestBaseline applies baselines to sequences of samples taken every second, checking the
+// companion fields appended to their values.
+func TestBaseline(t *testing.T) {
+	schema := capture.Schema{Collector: "test", Fields: []string{"net:bytes/a", "swap:priority/si"}}
+	start := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	neg := func(v int64) uint64 { return uint64(v) }
+	sample := func(kind string, values ...uint64) capture.Sample {
+		return capture.Sample{Instance: "eth0", Kind: kind, Values: values}
+	}
+	for _, test := range []struct {
+		name    string
+		length  time.Duration
+		samples []capture.Sample
+		pcts    []string // appended values of each sample, or the error
+	}{
+		{"deltas", 2 * time.Second, []capture.Sample{
+			sample("a", 1000, 0), // cumulative, not starting the window
+			sample("d", 10, neg(-2)),
+			sample("d", 30, neg(-4)),
+			sample("d", 40, neg(-6)),
+			sample("d", 0, 3),
+		}, []string{"[0 0]", "[0 0]", "[0 0]", "[200 200]", "[0 -100]"}},
+		{"short window", time.Second, []capture.Sample{
+			sample("a", 1000, 0),
+			sample("d", 10, 1),
+			sample("d", 15, 1),
+		}, []string{"[0 0]", "[0 0]", "[150 100]"}},
+		{"zero mean", 2 * time.Second, []capture.Sample{
+			sample("d", 0, 0),
+			sample("d", 0, 0),
+			sample("d", 5, 5),
+		}, []string{"[0 0]", "[0 0]", "[0 0]"}},
+		{"cumulative only", time.Second, []capture.Sample{
+			sample("a", 10, 1),
+			sample("a", 20, 1),
+			sample("a", 30, 1),
+		}, []string{"[0 0]", "[0 0]", "[0 0]"}},
+		{"other kind", time.Second, []capture.Sample{
+			sample("d", 10, 1),
+			sample("p", 10, 1),
+			sample("p", 10, 1),
+		}, []string{"[0 0]", "samples of kind p against a baseline of kind d", "samples of kind p against a baseline of kind d"}},
+	} {
+		b := NewBaseline(schema, test.length)
+		for i, s := range test.samples {
+			applied, err := b.Apply(start.Add(time.Duration(i)*time.Second), []capture.Sample{s})
+			got := ""
+			if err != nil {
+				got = err.Error()
+			} else {
+				values := applied[0].Values
+				var pcts []string
+				for j, value := range values[len(s.Values):] {
+					pcts = append(pcts, capture.FormatValue(value, j == 1))
+				}
+				got = "[" + strings.Join(pcts, " ") + "]"
+			}
+			if got != test.pcts[i] {
+				t.Errorf("%s: sample %d: %s instead of %s", test.name, i, got, test.pcts[i])
+			}
+		}
+	}
+}
+
+// TestReadBaseline reads the baseline from reference captures.
+func TestReadBaseline(t *testing.T) {
+	schema := capture.Schema{Collector: "test", Fields: []string{"net:bytes/a", "swap:priority/si"}}
+	for _, test := range []struct {
+		name      string
+		reference string
+		pcts      string // of a sample of 30 and -3, or the error
+	}{
+		{"mean", "time h net:bytes/a swap:priority/si\n" +
+			"2026-10-16T12:00:00.000+0000 a 1000 0\n" +
+			"2026-10-16T12:00:01.000+0000 d 10 -2\n" +
+			"2026-10-16T12:00:02.000+0000 d 20 -4\n", "[200 100]"},
+		{"other fields", "time h net:bytes/a\n" +
+			"2026-10-16T12:00:01.000+0000 d 10\n", "fields of the reference capture differ from the fields of the records"},
+		{"cumulative only", "time h net:bytes/a swap:priority/si\n" +
+			"2026-10-16T12:00:00.000+0000 a 1000 0\n", "no samples other than cumulative in the reference capture"},
+	} {
+		b, err := ReadBaseline(schema, strings.NewReader(test.reference))
+		got := ""
+		if err != nil {
+			got = err.Error()
+		} else {
+			applied, err := b.Apply(time.Now(), []capture.Sample{{Kind: "d", Values: []uint64{30, uint64(1<<64 - 3)}}})
+			if err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+			values := applied[0].Values
+			got = "[" + capture.FormatValue(values[2], false) + " " + capture.FormatValue(values[3], true) + "]"
+		}
+		if got != test.pcts {
+			t.Errorf("%s: %s instead of %s", test.name, got, test.pcts)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package output
+
+import (
+	"time"
+
+	"capture"
+)
+
+// ChangeFilter drops the records identical to the previous record output, except one every
+// keepalive, to show that the tool is still running.
+// A nil *ChangeFilter keeps all the records.
+type ChangeFilter struct {
+	keepalive  time.Duration // none if zero
+	last       map[string]capture.Sample
+	lastOutput time.Time
+}
+
+func NewChangeFilter(keepalive time.Duration) *ChangeFilter {
+	return &ChangeFilter{keepalive: keepalive}
+}
+
+// sameSamples reports whether the samples have the same kind and values as the previous ones,
+// for the same instances.
+func (cf *ChangeFilter) sameSamples(samples []capture.Sample) bool {
+	if cf.last == nil || len(samples) != len(cf.last) {
+		return false
+	}
+	for _, sample := range samples {
+		prev, ok := cf.last[sample.Instance]
+		if !ok || prev.Kind != sample.Kind || len(prev.Values) != len(sample.Values) {
+			return false
+		}
+		for i, value := range sample.Values {
+			if prev.Values[i] != value {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Keep reports whether the record taken at t, made of samples, is to be output: if any of its
+// instances changed since the previous record output, or if keepalive elapsed since then.
+func (cf *ChangeFilter) Keep(t time.Time, samples []capture.Sample) bool {
+	if cf == nil {
+		return true
+	}
+	elapsed := t.Sub(cf.lastOutput).Round(time.Millisecond) // as output, ignoring sampling jitter
+	if cf.sameSamples(samples) && (cf.keepalive <= 0 || elapsed < cf.keepalive) {
+		return false
+	}
+	cf.last = make(map[string]capture.Sample, len(samples))
+	for _, sample := range samples {
+		cf.last[sample.Instance] = sample
+	}
+	cf.lastOutput = t
+	return true
+}
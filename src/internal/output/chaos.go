@@ -0,0 +1,85 @@
+package output
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrChaos is the error of the writes failed on purpose.
+var ErrChaos = errors.New("chaos: write failure injected")
+
+// ChaosLine is the malformed line written by a Chaos before a record, unreadable as a record or
+// a comment.
+const ChaosLine = "chaos \x00\xff garbled\n"
+
+// Chaos perturbs the output of the records, to check how the tools and the receivers of their
+// output behave when the destination fails, is slow or gets garbage, whatever the destination.
+// Each record fails to be written with the probability fail, is delayed by up to delay, or is
+// preceded with the probability garble by a malformed line (text output only, a gob stream
+// being unreadable past garbage).
+// The sources read by the collectors are not perturbed: each collector opens its own files, with
+// no shared reader to inject malformed lines into. A copy of the sources edited by hand, read
+// with FS_ROOT, is the way to test a collector on malformed lines.
+// A nil *Chaos perturbs nothing.
+type Chaos struct {
+	fail   float64
+	delay  time.Duration
+	garble float64
+	rnd    *rand.Rand
+}
+
+// NewChaos returns the perturbations of the spec, as "fail=0.01,delay=200ms,garble=0.05".
+func NewChaos(spec string) (*Chaos, error) {
+	c := &Chaos{rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}
+	for _, item := range strings.Split(spec, ",") {
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("%q: not name=value", item)
+		}
+		var err error
+		switch kv[0] {
+		case "fail":
+			c.fail, err = strconv.ParseFloat(kv[1], 64)
+		case "delay":
+			c.delay, err = time.ParseDuration(kv[1])
+		case "garble":
+			c.garble, err = strconv.ParseFloat(kv[1], 64)
+		default:
+			err = fmt.Errorf("unknown perturbation (fail, delay or garble)")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%q: %s", item, err)
+		}
+	}
+	return c, nil
+}
+
+// Garbles returns whether malformed lines are written.
+func (c *Chaos) Garbles() bool {
+	return c != nil && c.garble > 0
+}
+
+// Perturb is called before writing a record to w, at a line start. It waits for the delay,
+// returns ErrChaos if the record is not to be written, or writes a malformed line to w.
+// w is nil for the gob output, which is not garbled.
+func (c *Chaos) Perturb(w io.Writer) error {
+	if c == nil {
+		return nil
+	}
+	if c.delay > 0 {
+		time.Sleep(time.Duration(c.rnd.Int63n(int64(c.delay) + 1)))
+	}
+	if c.rnd.Float64() < c.fail {
+		return ErrChaos
+	}
+	if w != nil && c.rnd.Float64() < c.garble {
+		_, err := io.WriteString(w, ChaosLine)
+		return err
+	}
+	return nil
+}
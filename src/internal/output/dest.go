@@ -0,0 +1,29 @@
+package output
+
+import (
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// Open opens an output destination:
+// "-" is the standard output, "tcp:host:port" and "unix:path" are sockets, anything else
+// is a file path (created or truncated).
+func Open(dest string) (io.WriteCloser, error) {
+	if dest == "-" {
+		return nopCloser{os.Stdout}, nil
+	}
+	for _, network := range []string{"tcp", "unix"} {
+		if strings.HasPrefix(dest, network+":") {
+			return net.Dial(network, dest[len(network)+1:])
+		}
+	}
+	return os.Create(dest)
+}
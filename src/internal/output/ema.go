@@ -0,0 +1,118 @@
+package output
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"capture"
+)
+
+// Smoother adds to the samples the exponential moving averages of some of their fields, as
+// companion fields named with the "_ema" suffix (e.g. "cpu:user_ema/i"), signed if the field is.
+// The average of an instance restarts from the current value when the kind of its samples
+// changes, the first record of a run giving the cumulative values.
+// A nil *Smoother adds nothing.
+type Smoother struct {
+	alpha   float64
+	indices []int // of the smoothed fields
+	signed  []bool
+	names   []string
+	kinds   map[string]string // per instance, of the previous sample
+	avgs    map[string][]float64
+}
+
+// NewSmoother returns a smoother of the fields of the schema, given without their suffix (e.g.
+// "cpu:user"), alpha being the weight of the current value, between 0 (excluded) and 1.
+func NewSmoother(schema capture.Schema, fields []string, alpha float64) (*Smoother, error) {
+	if alpha <= 0 || alpha > 1 {
+		return nil, fmt.Errorf("alpha out of ]0,1]: %v", alpha)
+	}
+	sm := &Smoother{alpha: alpha, kinds: make(map[string]string), avgs: make(map[string][]float64)}
+	for _, field := range fields {
+		idx := -1
+		for i, name := range schema.Fields {
+			if strings.SplitN(name, "/", 2)[0] == field {
+				idx = i
+				break
+			}
+		}
+		if idx < 0 {
+			return nil, fmt.Errorf("unknown field: %q", field)
+		}
+		kind := "/i"
+		if schema.Signed()[idx] {
+			kind = capture.SignedSuffix
+		}
+		sm.indices = append(sm.indices, idx)
+		sm.signed = append(sm.signed, kind == capture.SignedSuffix)
+		sm.names = append(sm.names, field+"_ema"+kind)
+	}
+	return sm, nil
+}
+
+// Fields returns the names of the companion fields, to add to the header or the schema.
+func (sm *Smoother) Fields() []string {
+	if sm == nil {
+		return nil
+	}
+	return sm.names
+}
+
+// Smooth returns the samples with the current averages appended to their values.
+// It must be called for every record polled, in order, including those not output, for the
+// averages to follow the sampling interval.
+func (sm *Smoother) Smooth(samples []capture.Sample) []capture.Sample {
+	if sm == nil {
+		return samples
+	}
+	smoothed := make([]capture.Sample, len(samples))
+	for i, sample := range samples {
+		avgs, ok := sm.avgs[sample.Instance]
+		restart := !ok || sm.kinds[sample.Instance] != sample.Kind
+		if !ok {
+			avgs = make([]float64, len(sm.indices))
+			sm.avgs[sample.Instance] = avgs
+		}
+		sm.kinds[sample.Instance] = sample.Kind
+		values := make([]uint64, len(sample.Values), len(sample.Values)+len(avgs))
+		copy(values, sample.Values)
+		for j, idx := range sm.indices {
+			value := capture.Float(sample.Values[idx], sm.signed[j])
+			if restart {
+				avgs[j] = value
+			} else {
+				avgs[j] += sm.alpha * (value - avgs[j])
+			}
+			values = append(values, capture.FromFloat(avgs[j], sm.signed[j]))
+		}
+		sample.Values = values
+		smoothed[i] = sample
+	}
+	return smoothed
+}
+
+// WriteSamples writes the samples of a record as the collectors do: one line per sample, with
+// the instance column if any, without the final newline. Signed gives the fields of which the
+// values are signed (see capture.Schema.Signed).
+func WriteSamples(w io.Writer, samples []capture.Sample, signed []bool, separator string) (err error) {
+	for i, sample := range samples {
+		columns := make([]string, 0, 2+len(sample.Values))
+		if sample.Instance != "" {
+			columns = append(columns, sample.Instance)
+		}
+		columns = append(columns, sample.Kind)
+		for j, value := range sample.Values {
+			columns = append(columns, capture.FormatValue(value, j < len(signed) && signed[j]))
+		}
+		line := strings.Join(columns, separator)
+		if i > 0 {
+			line = "\n" + line
+		}
+		_, err = io.WriteString(w, line)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,102 @@
+package output
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"capture"
+)
+
+// TestSmoother smooths sequences of samples, checking the averages appended to their values.
+func TestSmoother(t *testing.T) {
+	schema := capture.Schema{Collector: "test", Fields: []string{"cpu:user/a", "swap:priority/si", "mem:free/i"}}
+	sample := func(instance, kind string, values ...uint64) capture.Sample {
+		return capture.Sample{Instance: instance, Kind: kind, Values: values}
+	}
+	neg := func(v int64) uint64 { return uint64(v) }
+	for _, test := range []struct {
+		name    string
+		fields  []string
+		alpha   float64
+		samples []capture.Sample
+		avgs    []string // appended values of each sample
+	}{
+		{"deltas", []string{"cpu:user"}, 0.5, []capture.Sample{
+			sample("", "a", 1000, 0, 0), // cumulative, restarting the average at the next kind
+			sample("", "d", 10, 0, 0),
+			sample("", "d", 20, 0, 0),
+			sample("", "d", 0, 0, 0),
+			sample("", "d", 0, 0, 0),
+		}, []string{"[1000]", "[10]", "[15]", "[8]", "[4]"}},
+		{"alpha 1", []string{"cpu:user", "mem:free"}, 1, []capture.Sample{
+			sample("", "d", 10, 0, 7),
+			sample("", "d", 20, 0, 3),
+		}, []string{"[10 7]", "[20 3]"}},
+		{"instances", []string{"mem:free"}, 0.5, []capture.Sample{
+			sample("a", "d", 0, 0, 100),
+			sample("b", "d", 0, 0, 10),
+			sample("a", "d", 0, 0, 0),
+			sample("b", "d", 0, 0, 20),
+		}, []string{"[100]", "[10]", "[50]", "[15]"}},
+		{"signed", []string{"swap:priority"}, 0.5, []capture.Sample{
+			sample("", "d", 0, neg(-2), 0),
+			sample("", "d", 0, neg(-5), 0),
+			sample("", "d", 0, 3, 0),
+			sample("", "d", 0, 3, 0),
+		}, []string{"[-2]", "[-4]", "[0]", "[1]"}}, // -3.5 rounded away from zero, -0.25 to zero
+	} {
+		sm, err := NewSmoother(schema, test.fields, test.alpha)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		signed := append(schema.Signed(), capture.Schema{Fields: sm.Fields()}.Signed()...)
+		for i, sample := range test.samples {
+			smoothed := sm.Smooth([]capture.Sample{sample})
+			values := smoothed[0].Values
+			if len(values) != len(sample.Values)+len(test.fields) {
+				t.Fatalf("%s: sample %d: values %v", test.name, i, values)
+			}
+			var avgs []string
+			for j, value := range values[len(sample.Values):] {
+				avgs = append(avgs, capture.FormatValue(value, signed[len(sample.Values)+j]))
+			}
+			if got := "[" + strings.Join(avgs, " ") + "]"; got != test.avgs[i] {
+				t.Errorf("%s: sample %d: averages %s instead of %s", test.name, i, got, test.avgs[i])
+			}
+			if fmt.Sprint(values[:len(sample.Values)]) != fmt.Sprint(sample.Values) {
+				t.Errorf("%s: sample %d: values %v changed to %v", test.name, i, sample.Values, values)
+			}
+		}
+	}
+}
+
+// TestSmootherFields checks the names of the companion fields and the invalid arguments.
+func TestSmootherFields(t *testing.T) {
+	schema := capture.Schema{Collector: "test", Fields: []string{"cpu:user/a", "swap:priority/si"}}
+	sm, err := NewSmoother(schema, []string{"swap:priority", "cpu:user"}, 0.1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(sm.Fields(), " "); got != "swap:priority_ema/si cpu:user_ema/i" {
+		t.Errorf("fields %s", got)
+	}
+	for _, test := range []struct {
+		fields []string
+		alpha  float64
+	}{
+		{[]string{"cpu:user"}, 0},
+		{[]string{"cpu:user"}, 1.5},
+		{[]string{"cpu:system"}, 0.5},
+		{[]string{"cpu:user/a"}, 0.5}, // given with its suffix
+	} {
+		_, err := NewSmoother(schema, test.fields, test.alpha)
+		if err == nil {
+			t.Errorf("%v, alpha %v: no error", test.fields, test.alpha)
+		}
+	}
+	var nilSm *Smoother
+	if nilSm.Fields() != nil || len(nilSm.Smooth([]capture.Sample{{Values: []uint64{1}}})[0].Values) != 1 {
+		t.Error("nil smoother adding fields")
+	}
+}
@@ -0,0 +1,106 @@
+package output
+
+import (
+	"math"
+	"strings"
+	"time"
+
+	"capture"
+)
+
+// FlagsHeader is the name of the flags column.
+const FlagsHeader = "flags"
+
+// Flags of the records, as written in the flags column, comma-separated.
+const (
+	FlagOk           = "ok"            // nothing abnormal detected
+	FlagCounterReset = "counter-reset" // an accumulator went backwards (counter wrapped or reset)
+	FlagClockJump    = "clock-jump"    // the wall clock was stepped since the previous record
+	FlagPartialParse = "partial-parse" // a part of the sources failed to parse and was left out
+	FlagTimeout      = "timeout"       // reading the sources took longer than the interval
+)
+
+// clockJumpThreshold is the minimum difference between the wall clock and monotonic clock
+// elapsed times for a step of the wall clock to be flagged.
+const clockJumpThreshold = 100 * time.Millisecond
+
+// Flagger flags the suspect records, so that analysis tools can filter them out.
+// A nil *Flagger flags nothing.
+type Flagger struct {
+	isAccumulator []bool
+	timeout       time.Duration
+	last          time.Time
+	cumulated     map[string][]uint64 // previous cumulative values, per instance
+}
+
+// NewFlagger returns a flagger of the records of the schema, the accumulators being the
+// fields named with the "/a" suffix. The instant fields, signed or not, are not checked.
+// The records of which the sources took longer than timeout to read are flagged (none if zero).
+func NewFlagger(schema capture.Schema, timeout time.Duration) *Flagger {
+	isAccumulator := make([]bool, len(schema.Fields))
+	for i, field := range schema.Fields {
+		isAccumulator[i] = strings.HasSuffix(field, "/a")
+	}
+	return &Flagger{isAccumulator: isAccumulator, timeout: timeout, cumulated: make(map[string][]uint64)}
+}
+
+// counterReset reports whether an accumulator went backwards: the delta is negative, once
+// wrapped to uint64, or the cumulative value is less than the previous one of the instance.
+// The collectors flag the other records themselves, on the values read.
+func (fl *Flagger) counterReset(samples []capture.Sample) bool {
+	reset := false
+	for _, sample := range samples {
+		prev := fl.cumulated[sample.Instance]
+		for i, value := range sample.Values {
+			if i >= len(fl.isAccumulator) || !fl.isAccumulator[i] {
+				continue
+			}
+			switch sample.Kind {
+			case "d":
+				reset = reset || value > math.MaxInt64
+			case "a":
+				reset = reset || i < len(prev) && value < prev[i]
+			}
+		}
+		if sample.Kind == "a" {
+			fl.cumulated[sample.Instance] = sample.Values
+		}
+	}
+	return reset
+}
+
+// clockJump reports whether the wall clock elapsed time since the previous record differs from
+// the monotonic clock elapsed time.
+func (fl *Flagger) clockJump(t time.Time) bool {
+	if fl.last.IsZero() {
+		return false
+	}
+	drift := t.Round(0).Sub(fl.last.Round(0)) - t.Sub(fl.last) // Round(0) strips the monotonic reading
+	return drift > clockJumpThreshold || drift < -clockJumpThreshold
+}
+
+// Flags returns the flags of the record of info, made of samples, "ok" if none.
+// It must be called for every record polled, including those not output.
+func (fl *Flagger) Flags(info capture.RecordInfo, samples []capture.Sample) string {
+	if fl == nil {
+		return ""
+	}
+	var flags []string
+	if fl.counterReset(samples) || info.CounterReset {
+		flags = append(flags, FlagCounterReset)
+	}
+	if fl.clockJump(info.Time) {
+		flags = append(flags, FlagClockJump)
+	}
+	if info.PartialParse {
+		flags = append(flags, FlagPartialParse)
+	}
+	if fl.timeout > 0 && info.ReadTime > fl.timeout {
+		flags = append(flags, FlagTimeout)
+	}
+	fl.last = info.Time
+	if len(flags) == 0 {
+		return FlagOk
+	}
+	return strings.Join(flags, ",")
+}
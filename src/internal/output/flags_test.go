@@ -0,0 +1,69 @@
+package output
+
+import (
+	"testing"
+	"time"
+
+	"capture"
+)
+
+// TestFlagger flags sequences of records, checking the flags of each.
+func TestFlagger(t *testing.T) {
+	schema := capture.Schema{Collector: "test", Fields: []string{"net:bytes/a", "net:up/i", "net:level/si"}}
+	start := time.Now()
+	type record struct {
+		info    capture.RecordInfo
+		samples []capture.Sample
+	}
+	at := func(elapsed time.Duration, samples ...capture.Sample) record {
+		return record{capture.RecordInfo{Time: start.Add(elapsed)}, samples}
+	}
+	sample := func(instance, kind string, values ...uint64) capture.Sample {
+		return capture.Sample{Instance: instance, Kind: kind, Values: values}
+	}
+	for _, test := range []struct {
+		name    string
+		records []record
+		flags   []string
+	}{
+		{"deltas", []record{
+			at(0, sample("eth0", "a", 100, 1, 0)),
+			at(time.Second, sample("eth0", "d", 10, 0, 1<<64-1)), // a negative signed instant value
+			at(2*time.Second, sample("eth0", "d", 1<<64-10, 1, 0)),
+			at(3*time.Second, sample("eth0", "d", 0, 1<<64-1, 0)), // an unsigned instant value, not checked
+		}, []string{"ok", "ok", "counter-reset", "ok"}},
+		{"cumulative", []record{
+			at(0, sample("eth0", "a", 100, 1, 0), sample("eth1", "a", 5, 1, 0)),
+			at(time.Second, sample("eth0", "a", 110, 0, 0), sample("eth1", "a", 5, 0, 0)),
+			at(2*time.Second, sample("eth0", "a", 120, 0, 0), sample("eth1", "a", 4, 0, 0)),
+			at(3*time.Second, sample("eth0", "a", 130, 0, 0), sample("eth1", "a", 4, 0, 0)),
+		}, []string{"ok", "ok", "counter-reset", "ok"}},
+		{"new instance", []record{
+			at(0, sample("eth0", "a", 100, 1, 0)),
+			at(time.Second, sample("eth0", "a", 110, 1, 0), sample("eth1", "a", 1, 1, 0)),
+		}, []string{"ok", "ok"}},
+		{"wall clock only", []record{ // as read from a capture, without monotonic clock readings
+			{capture.RecordInfo{Time: start.Round(0)}, []capture.Sample{sample("eth0", "d", 0, 0, 0)}},
+			{capture.RecordInfo{Time: start.Round(0).Add(time.Hour)}, []capture.Sample{sample("eth0", "d", 0, 0, 0)}},
+		}, []string{"ok", "ok"}},
+		{"collector flags", []record{
+			{capture.RecordInfo{Time: start, CounterReset: true}, []capture.Sample{sample("eth0", "p", 0, 0, 0)}},
+			{capture.RecordInfo{Time: start.Add(time.Second), PartialParse: true}, nil},
+			{capture.RecordInfo{Time: start.Add(2 * time.Second), ReadTime: 2 * time.Second}, nil},
+			{capture.RecordInfo{Time: start.Add(3 * time.Second), ReadTime: time.Second}, nil},
+			{capture.RecordInfo{Time: start.Add(4 * time.Second), CounterReset: true, PartialParse: true, ReadTime: 5 * time.Second}, nil},
+		}, []string{"counter-reset", "partial-parse", "timeout", "ok", "counter-reset,partial-parse,timeout"}},
+	} {
+		fl := NewFlagger(schema, time.Second)
+		for i, r := range test.records {
+			flags := fl.Flags(r.info, r.samples)
+			if flags != test.flags[i] {
+				t.Errorf("%s: record %d flagged %q instead of %q", test.name, i, flags, test.flags[i])
+			}
+		}
+	}
+	var fl *Flagger
+	if flags := fl.Flags(capture.RecordInfo{Time: start, CounterReset: true}, nil); flags != "" {
+		t.Errorf("nil flagger: flagged %q", flags)
+	}
+}
@@ -0,0 +1,116 @@
+package output
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const layoutDateFormat = "2006-01-02"
+
+// LayoutWriter writes to outdir/<host>/<date>/<collector>.log, creating the directories as
+// needed and switching to a new file on the first record written after midnight (local time,
+// or UTC), the records being delimited by EndRecord. The header lines are repeated at the top
+// of each new file. If the file of the date already exists (as when a tool is restarted), the
+// records go to a new file, named <collector>.1.log, <collector>.2.log, and so on.
+type LayoutWriter struct {
+	outdir        string
+	host          string
+	collector     string
+	utc           bool
+	date          string
+	file          *os.File
+	header        []byte
+	inHeader      bool
+	atRecordStart bool
+}
+
+func NewLayoutWriter(outdir string, collector string, utc bool) (*LayoutWriter, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	return &LayoutWriter{outdir: outdir, host: host, collector: collector, utc: utc, inHeader: true, atRecordStart: true}, nil
+}
+
+// EndHeader marks the end of the header lines, the lines written so far being repeated at the
+// top of each new file.
+func (lw *LayoutWriter) EndHeader() {
+	lw.inHeader = false
+	lw.atRecordStart = true
+}
+
+// EndRecord marks the end of the lines of a record, the file being switched only between records,
+// not to split a multi-instance record across two files.
+func (lw *LayoutWriter) EndRecord() {
+	lw.atRecordStart = true
+}
+
+// create creates a new file in dir, not to write after the end of an existing one.
+func (lw *LayoutWriter) create(dir string) (file *os.File, err error) {
+	name := lw.collector + ".log"
+	for i := 1; ; i++ {
+		file, err = os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if !os.IsExist(err) {
+			return
+		}
+		name = fmt.Sprintf("%s.%d.log", lw.collector, i)
+	}
+}
+
+// switchFile opens the file of the current date, if not already open.
+func (lw *LayoutWriter) switchFile() (err error) {
+	now := time.Now()
+	if lw.utc {
+		now = now.UTC()
+	}
+	date := now.Format(layoutDateFormat)
+	if lw.file != nil && date == lw.date {
+		return
+	}
+	dir := filepath.Join(lw.outdir, lw.host, date)
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return
+	}
+	file, err := lw.create(dir)
+	if err != nil {
+		return
+	}
+	if lw.file != nil {
+		lw.file.Close()
+		if !lw.inHeader {
+			_, err = file.Write(lw.header)
+			if err != nil {
+				file.Close()
+				lw.file = nil
+				return
+			}
+		}
+	}
+	lw.file = file
+	lw.date = date
+	return
+}
+
+func (lw *LayoutWriter) Write(p []byte) (n int, err error) { // implements io.Writer
+	if lw.atRecordStart {
+		err = lw.switchFile()
+		if err != nil {
+			return
+		}
+		lw.atRecordStart = lw.inHeader
+	}
+	if lw.inHeader {
+		lw.header = append(lw.header, p...)
+	}
+	return lw.file.Write(p)
+}
+
+func (lw *LayoutWriter) Close() error { // implements io.Closer
+	if lw.file == nil {
+		return nil
+	}
+	return lw.file.Close()
+}
@@ -0,0 +1,13 @@
+package output
+
+import "time"
+
+// ReadTimeHeader is the name of the read time column.
+const ReadTimeHeader = "read:us/i"
+
+// ReadTime returns the time spent reading the sources of a record, as measured by its collector,
+// in us. A pressured system or a hung mount inflates it, before the records are late for the
+// interval.
+func ReadTime(d time.Duration) int64 {
+	return int64(d / time.Microsecond)
+}
@@ -0,0 +1,61 @@
+package output
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"capture"
+)
+
+/* Integrity suffix */
+
+// SuffixWriter appends an integrity suffix (crc32 or byte length of the line content)
+// to every line written through it, so that truncated lines can be detected downstream.
+// The suffix is the last field of the line, separated by sep, and covers everything before
+// that separator.
+type SuffixWriter struct {
+	w    io.Writer
+	sep  string
+	kind string
+	buf  []byte
+}
+
+// NewSuffixWriter returns a writer appending the given kind of suffix ("crc32" or "len").
+// If kind is empty, w is returned unchanged.
+func NewSuffixWriter(w io.Writer, kind string, sep string) (io.Writer, error) {
+	if kind == "" {
+		return w, nil
+	}
+	_, ok := capture.LineSuffix(kind, nil)
+	if !ok {
+		return nil, fmt.Errorf("Unknown suffix kind '%s' (expected crc32 or len)", kind)
+	}
+	return &SuffixWriter{w: w, sep: sep, kind: kind}, nil
+}
+
+// Write buffers p until a complete line is available, then writes each complete line
+// with its suffix in a single call to the underlying writer.
+func (sw *SuffixWriter) Write(p []byte) (n int, err error) { // implements io.Writer
+	sw.buf = append(sw.buf, p...)
+	for {
+		i := bytes.IndexByte(sw.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := sw.buf[:i]
+		out := make([]byte, 0, len(line)+len(sw.sep)+16)
+		out = append(out, line...)
+		out = append(out, sw.sep...)
+		suffix, _ := capture.LineSuffix(sw.kind, line)
+		out = append(out, suffix...)
+		out = append(out, '\n')
+		_, err = sw.w.Write(out)
+		sw.buf = sw.buf[i+1:]
+		if err != nil {
+			return
+		}
+	}
+	n = len(p)
+	return
+}
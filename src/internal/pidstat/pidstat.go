@@ -0,0 +1,716 @@
+package pidstat
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"capture"
+	"internal/schedule"
+	"system/hostproc"
+)
+
+const (
+	defaultProcDir = "/proc"
+	Separator      = " "
+)
+
+const (
+	utimeIdx     = iota
+	stimeIdx     = iota
+	minfltIdx    = iota
+	majfltIdx    = iota
+	ctxtVolIdx   = iota
+	ctxtInvolIdx = iota
+	rssIdx       = iota
+	vszIdx       = iota
+	threadsIdx   = iota
+	runningIdx   = iota
+	sleepingIdx  = iota
+	blockedIdx   = iota
+	pssIdx       = iota // first field of smaps_rollup, given only in smaps mode
+	ussIdx       = iota
+	swapIdx      = iota
+	sharedIdx    = iota
+	fieldsCount  = iota
+)
+
+const baseCount = pssIdx
+
+var allFieldsDefs = []fieldDef{
+	fieldDef{"cpu", "utime", true},
+	fieldDef{"cpu", "stime", true},
+	fieldDef{"fault", "minor", true},
+	fieldDef{"fault", "major", true},
+	fieldDef{"ctxt", "voluntary", true},
+	fieldDef{"ctxt", "involuntary", true},
+	fieldDef{"mem", "rss_kb", false},
+	fieldDef{"mem", "vsz_kb", false},
+	fieldDef{"proc", "threads", false},
+	fieldDef{"threads", "running", false},
+	fieldDef{"threads", "sleeping", false},
+	fieldDef{"threads", "blocked", false},
+	fieldDef{"mem", "pss_kb", false},
+	fieldDef{"mem", "uss_kb", false},
+	fieldDef{"mem", "swap_kb", false},
+	fieldDef{"mem", "shared_kb", false},
+}
+
+// fieldsDefs returns the definitions of the fields given, with those of smaps_rollup if smaps is true.
+func fieldsDefs(smaps bool) []fieldDef {
+	if smaps {
+		return allFieldsDefs
+	}
+	return allFieldsDefs[:baseCount]
+}
+
+/* Header is a list of field names. */
+
+type header []string
+
+func makeHeader(fdl []fieldDef) header {
+	h := header(make([]string, 1+len(fdl)))
+	h[0] = "h"
+	for i, d := range fdl {
+		h[i+1] = d.String()
+	}
+	return h
+}
+
+func (h header) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	err = writeTo(w, strings.Join(h, Separator), &n)
+	return
+}
+
+var procDir string = defaultProcDir
+
+func warn(v ...interface{}) {
+	log.Print("WARNING: ", fmt.Sprint(v...))
+}
+
+func warnf(format string, v ...interface{}) {
+	log.Printf("WARNING: "+format, v...)
+}
+
+func init() {
+	fsRoot := os.Getenv("FS_ROOT")
+	if fsRoot != "" {
+		procDir = path.Join(fsRoot, defaultProcDir)
+	}
+}
+
+func writeTo(w io.Writer, v interface{}, p *int64) (err error) {
+	m, err := w.Write([]byte(fmt.Sprint(v)))
+	*p += int64(m)
+	return
+}
+
+/* Field Definition */
+
+type fieldDef struct {
+	category      string
+	name          string
+	isAccumulator bool
+}
+
+func (fd fieldDef) String() string { // implements fmt.Stringer
+	if fd.isAccumulator {
+		return fd.category + ":" + fd.name + "/a"
+	} else {
+		return fd.category + ":" + fd.name + "/i"
+	}
+}
+
+/* Target */
+
+// Target selects the processes to monitor: a single process given by Pid, or by the pid read from
+// Pidfile at each sampling time to follow a daemon across restarts, or else the processes whose
+// command name is Name, or whose command line matches Cmdline, as found at each sampling time.
+type Target struct {
+	Pid     int
+	Pidfile string
+	Name    string
+	Cmdline *regexp.Regexp
+}
+
+func (target Target) isSingle() bool {
+	return target.Pid != 0 || target.Pidfile != ""
+}
+
+// readPidfile returns the pid written in the pidfile, as "1234\n".
+func readPidfile(fileName string) (pid int, err error) {
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return
+	}
+	pid, err = strconv.Atoi(strings.TrimSpace(string(content)))
+	if err == nil && pid <= 0 {
+		err = fmt.Errorf("invalid pid in %s: %d", fileName, pid)
+	}
+	return
+}
+
+// matches reports whether the process pid is selected by the name or command line of target.
+func (target Target) matches(pid int) bool {
+	pidDir := path.Join(procDir, strconv.Itoa(pid))
+	if target.Name != "" {
+		comm, err := ioutil.ReadFile(path.Join(pidDir, "comm"))
+		if err != nil || strings.TrimSpace(string(comm)) != target.Name {
+			return false
+		}
+	}
+	if target.Cmdline != nil {
+		cmdline, err := ioutil.ReadFile(path.Join(pidDir, "cmdline"))
+		if err != nil {
+			return false
+		}
+		args := strings.Replace(strings.TrimRight(string(cmdline), "\x00"), "\x00", " ", -1)
+		if !target.Cmdline.MatchString(args) {
+			return false
+		}
+	}
+	return true
+}
+
+// find returns the pids of the processes selected by target, in increasing order.
+func (target Target) find() (pids []int, err error) {
+	if target.Pidfile != "" {
+		pid, err := readPidfile(target.Pidfile)
+		if err != nil {
+			return nil, err
+		}
+		return []int{pid}, nil
+	}
+	if target.isSingle() {
+		return []int{target.Pid}, nil
+	}
+	entries, err := ioutil.ReadDir(procDir)
+	if err != nil {
+		return
+	}
+	self := hostproc.SelfPid() // not to monitor itself, as seen from the host too
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || pid == self {
+			continue
+		}
+		if target.matches(pid) {
+			pids = append(pids, pid)
+		}
+	}
+	sort.Ints(pids)
+	return
+}
+
+/* Record */
+
+var Header = makeHeader(fieldsDefs(false))
+
+// Schema describes the fields of the records, for typed output.
+var Schema = capture.Register(NewSchema(false))
+
+// NewSchema returns the schema of the records, with the fields of smaps_rollup if smaps is true.
+func NewSchema(smaps bool) capture.Schema {
+	return capture.Schema{Collector: "pidstat", Fields: makeHeader(fieldsDefs(smaps))[1:]}
+}
+
+// NewHeader returns the header of the records, prefixed by the process column when the
+// processes are found by name or command line or are labeled, with the fields of smaps_rollup
+// if smaps is true.
+func NewHeader(target Target, smaps bool, labels bool) io.WriterTo {
+	h := makeHeader(fieldsDefs(smaps))
+	if !target.isSingle() || labels {
+		h = append(header{"process"}, h...)
+	}
+	return h
+}
+
+type Record struct {
+	capture.RecordInfo
+	isCumul, isRel bool
+	isSingle       bool
+	smaps          bool
+	labeled        bool
+	labels         map[int]string   // per pid, if labeled
+	pid            int              // of the single process
+	fields         []uint64         // of the single process, or summed over the processes found
+	pidsFields     map[int][]uint64 // fields per process, if not single
+}
+
+func newRecord(isCumul, isRel bool, target Target, smaps bool, labeled bool) *Record {
+	recordPtr := new(Record)
+	recordPtr.isCumul = isCumul
+	recordPtr.isRel = isRel
+	recordPtr.isSingle = target.isSingle()
+	recordPtr.smaps = smaps
+	recordPtr.labeled = labeled
+	recordPtr.fields = make([]uint64, fieldsCount)
+	recordPtr.pidsFields = make(map[int][]uint64)
+	return recordPtr
+}
+
+func (record Record) pids() []int {
+	pids := make([]int, 0, len(record.pidsFields))
+	for pid := range record.pidsFields {
+		pids = append(pids, pid)
+	}
+	sort.Ints(pids)
+	return pids
+}
+
+func (recordPtr *Record) String() string { // implements fmt.Stringer
+	buf := new(bytes.Buffer)
+	recordPtr.WriteTo(buf)
+	return buf.String()
+}
+func (record Record) kind() string {
+	if record.isCumul {
+		return "a"
+	}
+	if record.isRel {
+		return "p"
+	}
+	return "d"
+}
+func (record Record) writeFieldsTo(w io.Writer, fields []uint64, p *int64) (err error) {
+	err = writeTo(w, record.kind(), p)
+	if err != nil {
+		return
+	}
+	for _, field := range fields[:len(fieldsDefs(record.smaps))] {
+		err = writeTo(w, Separator, p)
+		if err != nil {
+			return
+		}
+		err = writeTo(w, field, p)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// instance returns the name of the line of a process: its pid, followed by its labels if labeled.
+func (record Record) instance(pid int) string {
+	if !record.labeled {
+		return strconv.Itoa(pid)
+	}
+	return strconv.Itoa(pid) + ":" + record.labels[pid]
+}
+
+// WriteTo writes the record of a single process on one line or, for the processes found by
+// name or command line, the sums on an "all" line followed by one line per process.
+func (record Record) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	if record.isSingle {
+		if record.labeled {
+			err = writeTo(w, record.instance(record.pid)+Separator, &n)
+			if err != nil {
+				return
+			}
+		}
+		err = record.writeFieldsTo(w, record.fields, &n)
+		return
+	}
+	err = writeTo(w, "all"+Separator, &n)
+	if err != nil {
+		return
+	}
+	err = record.writeFieldsTo(w, record.fields, &n)
+	if err != nil {
+		return
+	}
+	for _, pid := range record.pids() {
+		err = writeTo(w, "\n"+record.instance(pid)+Separator, &n)
+		if err != nil {
+			return
+		}
+		err = record.writeFieldsTo(w, record.pidsFields[pid], &n)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+func (record Record) sample(instance string, fields []uint64) capture.Sample {
+	values := make([]uint64, len(fieldsDefs(record.smaps)))
+	copy(values, fields)
+	return capture.Sample{Time: record.Time, Instance: instance, Kind: record.kind(), Values: values}
+}
+
+// Samples returns the typed form of the record.
+func (record Record) Samples() []capture.Sample {
+	if record.isSingle {
+		instance := ""
+		if record.labeled {
+			instance = record.instance(record.pid)
+		}
+		return []capture.Sample{record.sample(instance, record.fields)}
+	}
+	samples := []capture.Sample{record.sample("all", record.fields)}
+	for _, pid := range record.pids() {
+		samples = append(samples, record.sample(record.instance(pid), record.pidsFields[pid]))
+	}
+	return samples
+}
+
+// diffFields computes the diffs of the fields, returning whether an accumulator went backwards.
+func diffFields(fields, prevFields, diffFields []uint64) (reset bool) {
+	for i, field := range fields {
+		if allFieldsDefs[i].isAccumulator {
+			reset = reset || field < prevFields[i]
+			diffFields[i] = field - prevFields[i]
+		} else {
+			diffFields[i] = field
+		}
+	}
+	return
+}
+
+// diff computes the diffs of each process, a process started since the previous record having
+// all its accumulators counted in the interval. The diff of the sums is the sum of the diffs,
+// not to be affected by the processes gone.
+func (recordPtr *Record) diff(prevRecord, diffRecord *Record) {
+	diffRecord.RecordInfo = recordPtr.RecordInfo
+	if recordPtr.isSingle {
+		prevFields := prevRecord.fields
+		if recordPtr.pid != prevRecord.pid { // restarted, as read from the pidfile
+			prevFields = make([]uint64, fieldsCount)
+		}
+		diffRecord.CounterReset = diffFields(recordPtr.fields, prevFields, diffRecord.fields)
+		diffRecord.pid = recordPtr.pid
+		diffRecord.labels = recordPtr.labels
+		return
+	}
+	diffRecord.labels = recordPtr.labels
+	diffRecord.pidsFields = make(map[int][]uint64, len(recordPtr.pidsFields))
+	for i := range diffRecord.fields {
+		diffRecord.fields[i] = 0
+	}
+	for pid, fields := range recordPtr.pidsFields {
+		prevFields, ok := prevRecord.pidsFields[pid]
+		if !ok {
+			prevFields = make([]uint64, fieldsCount)
+		}
+		pidDiffFields := make([]uint64, fieldsCount)
+		if diffFields(fields, prevFields, pidDiffFields) {
+			diffRecord.CounterReset = true
+		}
+		diffRecord.pidsFields[pid] = pidDiffFields
+		for i, field := range pidDiffFields {
+			diffRecord.fields[i] += field
+		}
+	}
+	return
+}
+
+// relFields converts the cpu times into percentages of the elapsed time. They may exceed 100%
+// for a multi-threaded process.
+func relFields(fields []uint64, elapsed time.Duration) {
+	base := uint64(elapsed) * hostproc.ClkTck()
+	if base == 0 {
+		return
+	}
+	for _, i := range []int{utimeIdx, stimeIdx} {
+		fields[i] = fields[i] * 100 * uint64(time.Second) / base
+	}
+}
+func (diffRecordPtr *Record) rel(elapsed time.Duration) {
+	relFields(diffRecordPtr.fields, elapsed)
+	for _, fields := range diffRecordPtr.pidsFields {
+		relFields(fields, elapsed)
+	}
+	return
+}
+
+// parseStat parses /proc/<pid>/stat.
+func parseStat(pid int, fields []uint64) (err error) {
+	st, err := hostproc.ReadStat(pid)
+	if err != nil {
+		return
+	}
+	fields[utimeIdx] = st.Utime
+	fields[stimeIdx] = st.Stime
+	fields[minfltIdx] = st.Minflt
+	fields[majfltIdx] = st.Majflt
+	fields[rssIdx] = st.RssKb
+	fields[vszIdx] = st.VszKb
+	fields[threadsIdx] = st.Threads
+	return
+}
+
+// parseStatus parses the context switches counts of /proc/<pid>/status.
+func parseStatus(fileName string, fields []uint64) (err error) {
+	inFile, err := os.Open(fileName)
+	if err != nil {
+		return
+	}
+	defer inFile.Close()
+	scanner := bufio.NewScanner(inFile)
+	for scanner.Scan() {
+		parsedFields := strings.Fields(scanner.Text())
+		if len(parsedFields) != 2 {
+			continue
+		}
+		var idx int
+		switch parsedFields[0] {
+		case "voluntary_ctxt_switches:":
+			idx = ctxtVolIdx
+		case "nonvoluntary_ctxt_switches:":
+			idx = ctxtInvolIdx
+		default:
+			continue
+		}
+		fields[idx], err = strconv.ParseUint(parsedFields[1], 10, 64)
+		if err != nil {
+			return
+		}
+	}
+	err = scanner.Err()
+	return
+}
+
+// parseSmapsRollup parses the memory of /proc/<pid>/smaps_rollup (since Linux 4.14), as
+// "Pss:                 812 kB". The uss is the private memory, and the shared memory the one
+// shared with other processes, of which the pss counts only this process' share.
+func parseSmapsRollup(fileName string, fields []uint64) (err error) {
+	inFile, err := os.Open(fileName)
+	if errors.Is(err, syscall.ESRCH) { // exited, not yet reaped
+		return &os.PathError{Op: "open", Path: fileName, Err: os.ErrNotExist}
+	}
+	if err != nil {
+		return
+	}
+	defer inFile.Close()
+	fields[pssIdx] = 0
+	fields[ussIdx] = 0
+	fields[swapIdx] = 0
+	fields[sharedIdx] = 0
+	scanner := bufio.NewScanner(inFile)
+	for scanner.Scan() {
+		parsedFields := strings.Fields(scanner.Text())
+		if len(parsedFields) != 3 || parsedFields[2] != "kB" {
+			continue
+		}
+		var idx int
+		switch parsedFields[0] {
+		case "Pss:":
+			idx = pssIdx
+		case "Private_Clean:", "Private_Dirty:":
+			idx = ussIdx
+		case "Swap:":
+			idx = swapIdx
+		case "Shared_Clean:", "Shared_Dirty:":
+			idx = sharedIdx
+		default:
+			continue
+		}
+		value, err := strconv.ParseUint(parsedFields[1], 10, 64)
+		if err != nil {
+			return err
+		}
+		fields[idx] += value
+	}
+	err = scanner.Err()
+	return
+}
+
+// parseTasks counts the threads of the process in each state, from the state of
+// /proc/<pid>/task/<tid>/stat: running (R), sleeping (S), and blocked in uninterruptible
+// sleep (D), usually on I/O.
+func parseTasks(taskDir string, fields []uint64) (err error) {
+	entries, err := ioutil.ReadDir(taskDir)
+	if err != nil {
+		return
+	}
+	fields[runningIdx] = 0
+	fields[sleepingIdx] = 0
+	fields[blockedIdx] = 0
+	for _, entry := range entries {
+		content, err := ioutil.ReadFile(path.Join(taskDir, entry.Name(), "stat"))
+		if os.IsNotExist(err) {
+			continue // exited since listed
+		}
+		if err != nil {
+			return err
+		}
+		end := bytes.LastIndexByte(content, ')')
+		if end < 0 || end+2 >= len(content) {
+			return fmt.Errorf("unexpected content of %s/%s/stat: %q", taskDir, entry.Name(), content)
+		}
+		switch content[end+2] {
+		case 'R':
+			fields[runningIdx]++
+		case 'S':
+			fields[sleepingIdx]++
+		case 'D':
+			fields[blockedIdx]++
+		}
+	}
+	return
+}
+
+func parsePid(pid int, smaps bool) (fields []uint64, err error) {
+	pidDir := path.Join(procDir, strconv.Itoa(pid))
+	fields = make([]uint64, fieldsCount)
+	err = parseStat(pid, fields)
+	if err != nil {
+		return
+	}
+	err = parseStatus(path.Join(pidDir, "status"), fields)
+	if err != nil {
+		return
+	}
+	err = parseTasks(path.Join(pidDir, "task"), fields)
+	if err != nil || !smaps {
+		return
+	}
+	err = parseSmapsRollup(path.Join(pidDir, "smaps_rollup"), fields)
+	return
+}
+
+// parse reads the fields of the processes of target. A single process being gone is an error
+// (os.IsNotExist), while processes found by name or command line may come and go, those whose
+// smaps_rollup may not be read (os.IsPermission, owned by another user) being left out.
+func (recordPtr *Record) parse(target Target) (err error) {
+	defer recordPtr.EndRead(time.Now())
+	recordPtr.Time = time.Now()
+	pids, err := target.find()
+	if err != nil {
+		return
+	}
+	recordPtr.pidsFields = make(map[int][]uint64, len(pids))
+	if recordPtr.labeled {
+		recordPtr.labels = make(map[int]string, len(pids))
+	}
+	for i := range recordPtr.fields {
+		recordPtr.fields[i] = 0
+	}
+	for _, pid := range pids {
+		fields, pidErr := parsePid(pid, recordPtr.smaps)
+		if pidErr != nil {
+			if target.isSingle() || !os.IsNotExist(pidErr) && !os.IsPermission(pidErr) {
+				return pidErr
+			}
+			continue // exited since found, or not readable
+		}
+		if recordPtr.labeled {
+			recordPtr.labels[pid] = readLabels(pid)
+		}
+		if target.isSingle() {
+			copy(recordPtr.fields, fields)
+			recordPtr.pid = pid
+			continue
+		}
+		recordPtr.pidsFields[pid] = fields
+		for i, field := range fields {
+			recordPtr.fields[i] += field
+		}
+	}
+	return
+}
+
+/* Labels */
+
+// readUnit returns the systemd slice and unit of a process, from the systemd hierarchy of its
+// cgroup, as "0::/system.slice/nginx.service" (v2) or "1:name=systemd:/user.slice/user-1000.slice/session-2.scope" (v1).
+func readUnit(pid int) (slice, unit string) {
+	content, err := ioutil.ReadFile(path.Join(procDir, strconv.Itoa(pid), "cgroup"))
+	if err != nil {
+		return
+	}
+	var cgroup string
+	for _, line := range strings.Split(string(content), "\n") {
+		parsedFields := strings.SplitN(line, ":", 3)
+		if len(parsedFields) != 3 {
+			continue
+		}
+		if parsedFields[1] == "name=systemd" {
+			cgroup = parsedFields[2]
+			break
+		}
+		if parsedFields[0] == "0" && parsedFields[1] == "" {
+			cgroup = parsedFields[2]
+		}
+	}
+	for _, name := range strings.Split(cgroup, "/") {
+		switch {
+		case strings.HasSuffix(name, ".slice"):
+			slice = name
+		case strings.HasSuffix(name, ".service") || strings.HasSuffix(name, ".scope"):
+			unit = name
+		}
+	}
+	return
+}
+
+// readLabels returns the labels of a process, as "alice:user-1000.slice:session-2.scope",
+// "-" standing for the labels not found.
+func readLabels(pid int) string {
+	labels := []string{"-", "-", "-"}
+	if uid, err := hostproc.ReadUid(pid); err == nil {
+		labels[0] = hostproc.UserName(uid)
+	}
+	slice, unit := readUnit(pid)
+	if slice != "" {
+		labels[1] = slice
+	}
+	if unit != "" {
+		labels[2] = unit
+	}
+	return strings.Join(labels, ":")
+}
+
+/* Polling */
+
+// Poll sends a Record of the processes of target in the channel at each sampling time of the
+// schedule, until the process exits if it is given by its pid. A process given by a pidfile is
+// followed across restarts, the sampling times when it is not running being skipped.
+// If cumul is false, it prints the diff of the accumulators, instead of the accumulators themselves
+// If rel is true, the cpu times of the diffs are given in percentage of the elapsed time
+// If smaps is true, the pss, uss, swap and shared memory of smaps_rollup are added
+// If labels is true, the processes are labeled with their user and systemd slice and unit
+func Poll(sched *schedule.Schedule, target Target, cumul bool, rel bool, smaps bool, labels bool, cout chan Record) {
+	recordPtr := newRecord(true, false, target, smaps, labels)
+	oldRecordPtr := newRecord(true, false, target, smaps, labels)
+	diffRecordPtr := newRecord(false, rel, target, smaps, labels)
+	hasOld := false // the first record parsed is sent cumulative, the sampling times skipped before not counting
+	for sched.Next() {
+		err := recordPtr.parse(target)
+		if target.Pid != 0 && os.IsNotExist(err) {
+			warn("Process ", target.Pid, " not found, stopping")
+			break
+		}
+		if err != nil {
+			warn("Error parsing record, ignoring: ", err)
+			continue
+		}
+		if cumul {
+			cout <- *recordPtr
+		} else {
+			if !hasOld {
+				cout <- *recordPtr
+			} else {
+				recordPtr.diff(oldRecordPtr, diffRecordPtr)
+				if rel {
+					diffRecordPtr.rel(recordPtr.Time.Sub(oldRecordPtr.Time))
+				}
+				cout <- *diffRecordPtr
+			}
+			oldRecordPtr, recordPtr = recordPtr, oldRecordPtr
+			hasOld = true
+		}
+	}
+	close(cout)
+}
@@ -0,0 +1,307 @@
+package portstat
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"capture"
+	"internal/schedule"
+)
+
+const (
+	defaultProcDir = "/proc"
+	Separator      = " "
+	AlertPrefix    = "# alert:"
+)
+
+const (
+	rangeIdx    = iota
+	usedIdx     = iota
+	usedPctIdx  = iota
+	socketsIdx  = iota
+	fieldsCount = iota
+)
+
+// The ports used are the distinct local ports of the TCP sockets (IPv4 and IPv6, in any state,
+// TIME_WAIT included) that are in the ephemeral range (net.ipv4.ip_local_port_range).
+var allFieldsDefs = []fieldDef{
+	fieldDef{"ports", "range", false},
+	fieldDef{"ports", "used", false},
+	fieldDef{"ports", "used_pct", false},
+	fieldDef{"sockets", "ephemeral", false},
+}
+
+// DefaultAlertPct is the pct of the ephemeral ports used over which an alert is given, where
+// connect() starts failing with EADDRNOTAVAIL on the busiest destinations.
+const DefaultAlertPct = 80
+
+/* Header is a list of field names. */
+
+type header []string
+
+func makeHeader(fdl []fieldDef) header {
+	h := header(make([]string, 1+len(fdl)))
+	h[0] = "h"
+	for i, d := range fdl {
+		h[i+1] = d.String()
+	}
+	return h
+}
+
+func (h header) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	err = writeTo(w, strings.Join(h, Separator), &n)
+	return
+}
+
+var procDir string = defaultProcDir
+
+func warn(v ...interface{}) {
+	log.Print("WARNING: ", fmt.Sprint(v...))
+}
+
+func init() {
+	fsRoot := os.Getenv("FS_ROOT")
+	if fsRoot != "" {
+		procDir = path.Join(fsRoot, defaultProcDir)
+	}
+}
+
+func writeTo(w io.Writer, v interface{}, p *int64) (err error) {
+	m, err := w.Write([]byte(fmt.Sprint(v)))
+	*p += int64(m)
+	return
+}
+
+/* Field Definition */
+
+type fieldDef struct {
+	category      string
+	name          string
+	isAccumulator bool
+}
+
+func (fd fieldDef) String() string { // implements fmt.Stringer
+	if fd.isAccumulator {
+		return fd.category + ":" + fd.name + "/a"
+	} else {
+		return fd.category + ":" + fd.name + "/i"
+	}
+}
+
+/* Ports */
+
+// readPortRange reads the ephemeral port range, as "32768	60999".
+func readPortRange() (low, high uint64, err error) {
+	content, err := ioutil.ReadFile(path.Join(procDir, "sys/net/ipv4/ip_local_port_range"))
+	if err != nil {
+		return
+	}
+	parsedFields := strings.Fields(string(content))
+	if len(parsedFields) != 2 {
+		return 0, 0, fmt.Errorf("unexpected content of ip_local_port_range: %q", content)
+	}
+	low, err = strconv.ParseUint(parsedFields[0], 10, 16)
+	if err != nil {
+		return
+	}
+	high, err = strconv.ParseUint(parsedFields[1], 10, 16)
+	return
+}
+
+// parseSockets counts the sockets of /proc/net/tcp or tcp6 of which the local port is in the
+// range, and marks the ports used, from the lines as
+// "0: 0100007F:BC8F 00000000:0000 0A ...", the ports being given in hexadecimal.
+func parseSockets(fileName string, low, high uint64, used map[uint64]bool) (sockets uint64, err error) {
+	inFile, err := os.Open(fileName)
+	if os.IsNotExist(err) {
+		return 0, nil // no IPv6
+	}
+	if err != nil {
+		return
+	}
+	defer inFile.Close()
+	scanner := bufio.NewScanner(inFile)
+	for scanner.Scan() {
+		parsedFields := strings.Fields(scanner.Text())
+		if len(parsedFields) < 4 || parsedFields[0] == "sl" {
+			continue
+		}
+		sep := strings.LastIndexByte(parsedFields[1], ':')
+		if sep < 0 {
+			continue
+		}
+		port, err := strconv.ParseUint(parsedFields[1][sep+1:], 16, 16)
+		if err != nil {
+			return 0, err
+		}
+		if port >= low && port <= high {
+			sockets++
+			used[port] = true
+		}
+	}
+	err = scanner.Err()
+	return
+}
+
+/* Record */
+
+var Header = makeHeader(allFieldsDefs)
+
+// Schema describes the fields of the records, for typed output.
+var Schema = capture.Register(capture.Schema{Collector: "portstat", Fields: Header[1:]})
+
+type Record struct {
+	capture.RecordInfo
+	isCumul bool
+	fields  []uint64
+}
+
+func newRecord(isCumul bool) *Record {
+	recordPtr := new(Record)
+	recordPtr.isCumul = isCumul
+	recordPtr.fields = make([]uint64, fieldsCount)
+	return recordPtr
+}
+
+func (recordPtr *Record) String() string { // implements fmt.Stringer
+	buf := new(bytes.Buffer)
+	recordPtr.WriteTo(buf)
+	return buf.String()
+}
+func (record Record) kind() string {
+	if record.isCumul {
+		return "a"
+	}
+	return "d"
+}
+func (record Record) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	err = writeTo(w, record.kind(), &n)
+	if err != nil {
+		return
+	}
+	for _, field := range record.fields {
+		err = writeTo(w, Separator, &n)
+		if err != nil {
+			return
+		}
+		err = writeTo(w, field, &n)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Samples returns the typed form of the record.
+func (record Record) Samples() []capture.Sample {
+	values := make([]uint64, fieldsCount)
+	copy(values, record.fields)
+	return []capture.Sample{{Time: record.Time, Kind: record.kind(), Values: values}}
+}
+
+// UsedPct returns the pct of the ephemeral ports used.
+func (record Record) UsedPct() uint64 {
+	return record.fields[usedPctIdx]
+}
+
+// diff copies the fields, all instant values.
+func (recordPtr *Record) diff(prevRecord, diffRecord *Record) {
+	diffRecord.RecordInfo = recordPtr.RecordInfo
+	copy(diffRecord.fields, recordPtr.fields)
+	return
+}
+
+func (recordPtr *Record) parse() (err error) {
+	defer recordPtr.EndRead(time.Now())
+	recordPtr.Time = time.Now()
+	low, high, err := readPortRange()
+	if err != nil {
+		return
+	}
+	used := make(map[uint64]bool)
+	var sockets uint64
+	for _, name := range []string{"net/tcp", "net/tcp6"} {
+		count, err := parseSockets(path.Join(procDir, name), low, high, used)
+		if err != nil {
+			return err
+		}
+		sockets += count
+	}
+	recordPtr.fields[rangeIdx] = high - low + 1
+	recordPtr.fields[usedIdx] = uint64(len(used))
+	recordPtr.fields[usedPctIdx] = uint64(len(used)) * 100 / recordPtr.fields[rangeIdx]
+	recordPtr.fields[socketsIdx] = sockets
+	return
+}
+
+/* Alert */
+
+// Alerter gives an alert when the pct of the ephemeral ports used reaches a threshold, then
+// again when it is back under it. A nil *Alerter gives no alert.
+type Alerter struct {
+	threshold uint64
+	raised    bool
+}
+
+// NewAlerter returns an alerter of the threshold, or nil if the threshold is zero.
+func NewAlerter(threshold uint64) *Alerter {
+	if threshold == 0 {
+		return nil
+	}
+	return &Alerter{threshold: threshold}
+}
+
+// Write writes the alert as a comment line ("# alert: ephemeral ports 85% used >= 80%"), if
+// the record raises or clears it, to be written before the record.
+func (a *Alerter) Write(w io.Writer, record Record) (err error) {
+	if a == nil {
+		return
+	}
+	pct := record.UsedPct()
+	if !a.raised && pct >= a.threshold {
+		a.raised = true
+		_, err = fmt.Fprint(w, AlertPrefix, " ephemeral ports ", pct, "% used >= ", a.threshold, "%\n")
+	} else if a.raised && pct < a.threshold {
+		a.raised = false
+		_, err = fmt.Fprint(w, AlertPrefix, " ephemeral ports ", pct, "% used < ", a.threshold, "% (cleared)\n")
+	}
+	return
+}
+
+/* Polling */
+
+// Poll sends a Record in the channel at each sampling time of the schedule.
+// All the fields being instant values, cumul only changes the kind of the records.
+func Poll(sched *schedule.Schedule, cumul bool, cout chan Record) {
+	recordPtr := newRecord(true)
+	oldRecordPtr := newRecord(true)
+	diffRecordPtr := newRecord(false)
+	for i := 0; sched.Next(); i++ {
+		err := recordPtr.parse()
+		if err != nil {
+			warn("Error parsing record, ignoring: ", err)
+			continue
+		}
+		if cumul {
+			cout <- *recordPtr
+		} else {
+			if i < 1 {
+				cout <- *recordPtr
+			} else {
+				recordPtr.diff(oldRecordPtr, diffRecordPtr)
+				cout <- *diffRecordPtr
+			}
+			oldRecordPtr, recordPtr = recordPtr, oldRecordPtr
+		}
+	}
+	close(cout)
+}
@@ -0,0 +1,264 @@
+package procevents
+
+import (
+	"encoding/binary"
+	"fmt"
+	"syscall"
+	"time"
+
+	"system/getconf"
+)
+
+const (
+	// proc connector (linux/connector.h, linux/cn_proc.h)
+	netlinkConnector  = 11
+	cnIdxProc         = 1
+	cnValProc         = 1
+	procCnMcastListen = 1
+	cnMsgLen          = 20 // sizeof(struct cn_msg)
+	procEventFork     = 0x00000001
+	procEventExec     = 0x00000002
+	procEventExit     = 0x80000000
+	procEventDataOff  = cnMsgLen + 16 // offset of event_data in struct proc_event
+
+	// generic netlink (linux/genetlink.h)
+	genlHdrLen         = 4
+	genlIdCtrl         = 0x10
+	ctrlCmdGetFamily   = 3
+	ctrlAttrFamilyId   = 1
+	ctrlAttrFamilyName = 2
+
+	// taskstats (linux/taskstats.h)
+	taskstatsCmdGet                 = 1
+	taskstatsCmdAttrRegisterCpumask = 3
+	taskstatsTypeStats              = 3
+	taskstatsTypeAggrPid            = 4
+	taskstatsEtimeOff               = 144 // offsets in struct taskstats, stable since version 1
+	taskstatsUtimeOff               = 152
+	taskstatsStimeOff               = 160
+	taskstatsMinLen                 = 168
+
+	recvBufSize = 1 << 20
+)
+
+var nativeEndian = binary.NativeEndian
+
+/* Netlink helpers */
+
+func nlSocket(proto int, groups uint32) (fd int, err error) {
+	fd, err = syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_DGRAM, proto)
+	if err != nil {
+		return
+	}
+	err = syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF, recvBufSize)
+	if err == nil {
+		err = syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: groups})
+	}
+	if err != nil {
+		syscall.Close(fd)
+	}
+	return
+}
+
+func nlSend(fd int, msgType uint16, flags uint16, payload []byte) error {
+	b := make([]byte, syscall.NLMSG_HDRLEN, syscall.NLMSG_HDRLEN+len(payload))
+	nativeEndian.PutUint32(b[0:4], uint32(syscall.NLMSG_HDRLEN+len(payload)))
+	nativeEndian.PutUint16(b[4:6], msgType)
+	nativeEndian.PutUint16(b[6:8], flags)
+	b = append(b, payload...)
+	return syscall.Sendto(fd, b, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK})
+}
+
+func nlReceive(fd int, buf []byte) ([]syscall.NetlinkMessage, error) {
+	n, _, err := syscall.Recvfrom(fd, buf, 0)
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range msgs {
+		if m.Header.Type == syscall.NLMSG_ERROR && len(m.Data) >= 4 {
+			errno := int32(nativeEndian.Uint32(m.Data[0:4]))
+			if errno != 0 {
+				return nil, syscall.Errno(-errno)
+			}
+		}
+	}
+	return msgs, nil
+}
+
+func nlAttr(attrType uint16, data []byte) []byte {
+	attrLen := syscall.SizeofRtAttr + len(data)
+	b := make([]byte, syscall.SizeofRtAttr, (attrLen+syscall.RTA_ALIGNTO-1) & ^(syscall.RTA_ALIGNTO-1))
+	nativeEndian.PutUint16(b[0:2], uint16(attrLen))
+	nativeEndian.PutUint16(b[2:4], attrType)
+	b = append(b, data...)
+	return b[:cap(b)]
+}
+
+// nlParseAttrs returns the payload of the attributes found in b, by type.
+func nlParseAttrs(b []byte) map[uint16][]byte {
+	attrs := make(map[uint16][]byte)
+	for len(b) >= syscall.SizeofRtAttr {
+		attrLen := int(nativeEndian.Uint16(b[0:2]))
+		if attrLen < syscall.SizeofRtAttr || attrLen > len(b) {
+			break
+		}
+		attrs[nativeEndian.Uint16(b[2:4])] = b[syscall.SizeofRtAttr:attrLen]
+		alignedLen := (attrLen + syscall.RTA_ALIGNTO - 1) & ^(syscall.RTA_ALIGNTO - 1)
+		if alignedLen > len(b) {
+			break
+		}
+		b = b[alignedLen:]
+	}
+	return attrs
+}
+
+func genlPayload(cmd uint8, attrs ...[]byte) []byte {
+	b := []byte{cmd, 1, 0, 0} // cmd, version, reserved
+	for _, attr := range attrs {
+		b = append(b, attr...)
+	}
+	return b
+}
+
+// receiveLoop calls handle for each message received, until an unrecoverable error occurs.
+// Messages lost because of a receive buffer overrun are counted.
+func receiveLoop(fd int, name string, handle func(m syscall.NetlinkMessage)) {
+	buf := make([]byte, 1<<16)
+	for {
+		msgs, err := nlReceive(fd, buf)
+		if err == syscall.ENOBUFS {
+			count(eventsLostIdx, 1)
+			continue
+		}
+		if err != nil {
+			warn("Error receiving ", name, " messages, stopping: ", err)
+			syscall.Close(fd)
+			return
+		}
+		for _, m := range msgs {
+			handle(m)
+		}
+	}
+}
+
+/* Proc connector */
+
+func listenProcConnector() error {
+	fd, err := nlSocket(netlinkConnector, cnIdxProc)
+	if err != nil {
+		return err
+	}
+	msg := make([]byte, cnMsgLen+4)
+	nativeEndian.PutUint32(msg[0:4], cnIdxProc)
+	nativeEndian.PutUint32(msg[4:8], cnValProc)
+	nativeEndian.PutUint16(msg[16:18], 4)
+	nativeEndian.PutUint32(msg[cnMsgLen:], procCnMcastListen)
+	err = nlSend(fd, syscall.NLMSG_DONE, 0, msg)
+	if err != nil {
+		syscall.Close(fd)
+		return err
+	}
+	go receiveLoop(fd, "proc connector", handleProcEvent)
+	return nil
+}
+
+func handleProcEvent(m syscall.NetlinkMessage) {
+	if len(m.Data) < procEventDataOff+8 {
+		return
+	}
+	// pid and tgid of the process (child for forks) are the first or last two fields of event_data
+	data := m.Data[procEventDataOff:]
+	switch nativeEndian.Uint32(m.Data[cnMsgLen:]) {
+	case procEventFork:
+		if len(data) >= 16 && nativeEndian.Uint32(data[8:12]) == nativeEndian.Uint32(data[12:16]) {
+			count(procForksIdx, 1)
+		}
+	case procEventExec:
+		count(procExecsIdx, 1)
+	case procEventExit:
+		if nativeEndian.Uint32(data[0:4]) == nativeEndian.Uint32(data[4:8]) {
+			count(procExitsIdx, 1)
+		}
+	}
+}
+
+/* Taskstats */
+
+func taskstatsFamily(fd int) (uint16, error) {
+	err := nlSend(fd, genlIdCtrl, syscall.NLM_F_REQUEST, genlPayload(ctrlCmdGetFamily, nlAttr(ctrlAttrFamilyName, []byte("TASKSTATS\x00"))))
+	if err != nil {
+		return 0, err
+	}
+	msgs, err := nlReceive(fd, make([]byte, 1<<16))
+	if err != nil {
+		return 0, err
+	}
+	for _, m := range msgs {
+		if m.Header.Type != genlIdCtrl || len(m.Data) < genlHdrLen {
+			continue
+		}
+		id, ok := nlParseAttrs(m.Data[genlHdrLen:])[ctrlAttrFamilyId]
+		if ok && len(id) >= 2 {
+			return nativeEndian.Uint16(id), nil
+		}
+	}
+	return 0, fmt.Errorf("Taskstats family not found")
+}
+
+func listenTaskstats(short time.Duration) error {
+	fd, err := nlSocket(syscall.NETLINK_GENERIC, 0)
+	if err != nil {
+		return err
+	}
+	family, err := taskstatsFamily(fd)
+	if err == nil {
+		var nprocs uint
+		nprocs, err = getconf.GetNProcsConfigured()
+		if err == nil {
+			cpumask := fmt.Sprintf("0-%d\x00", nprocs-1)
+			err = nlSend(fd, family, syscall.NLM_F_REQUEST, genlPayload(taskstatsCmdGet, nlAttr(taskstatsCmdAttrRegisterCpumask, []byte(cpumask))))
+		}
+	}
+	if err != nil {
+		syscall.Close(fd)
+		return err
+	}
+	shortUs := uint64(short / time.Microsecond)
+	go receiveLoop(fd, "taskstats", func(m syscall.NetlinkMessage) {
+		if m.Header.Type != family || len(m.Data) < genlHdrLen {
+			return
+		}
+		aggr, ok := nlParseAttrs(m.Data[genlHdrLen:])[taskstatsTypeAggrPid]
+		if !ok {
+			return // exit of a whole thread group, already counted per task
+		}
+		stats := nlParseAttrs(aggr)[taskstatsTypeStats]
+		if len(stats) < taskstatsMinLen {
+			return
+		}
+		cpuUs := nativeEndian.Uint64(stats[taskstatsUtimeOff:]) + nativeEndian.Uint64(stats[taskstatsStimeOff:])
+		count(taskCpuIdx, cpuUs)
+		if nativeEndian.Uint64(stats[taskstatsEtimeOff:]) < shortUs {
+			count(taskShortIdx, 1)
+			count(taskShortCpuIdx, cpuUs)
+		}
+	})
+	return nil
+}
+
+// listen starts listening to the proc connector and to taskstats.
+func listen(short time.Duration) error {
+	err := listenProcConnector()
+	if err != nil {
+		return fmt.Errorf("proc connector: %s", err)
+	}
+	err = listenTaskstats(short)
+	if err != nil {
+		return fmt.Errorf("taskstats: %s", err)
+	}
+	return nil
+}
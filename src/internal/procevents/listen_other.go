@@ -0,0 +1,12 @@
+//go:build !linux
+
+package procevents
+
+import (
+	"errors"
+	"time"
+)
+
+func listen(short time.Duration) error {
+	return errors.New("Not supported on this platform")
+}
@@ -0,0 +1,202 @@
+package procevents
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"capture"
+	"internal/schedule"
+)
+
+const (
+	Separator = " "
+)
+
+const (
+	procForksIdx    = iota
+	procExecsIdx    = iota
+	procExitsIdx    = iota
+	taskCpuIdx      = iota
+	taskShortIdx    = iota
+	taskShortCpuIdx = iota
+	eventsLostIdx   = iota
+	fieldsCount     = iota
+)
+
+// Process events are counted from the proc connector, per process (threads are not counted).
+// Task times are reported by taskstats when each task exits, threads included.
+var allFieldsDefs = []fieldDef{
+	fieldDef{"proc", "forks", true},
+	fieldDef{"proc", "execs", true},
+	fieldDef{"proc", "exits", true},
+	fieldDef{"task", "cpu_us", true},
+	fieldDef{"task", "short", true},
+	fieldDef{"task", "short_cpu_us", true},
+	fieldDef{"events", "lost", true},
+}
+
+/* Header is a list of field names. */
+
+type header []string
+
+func makeHeader(fdl []fieldDef) header {
+	h := header(make([]string, 1+len(fdl)))
+	h[0] = "h"
+	for i, d := range fdl {
+		h[i+1] = d.String()
+	}
+	return h
+}
+
+func (h header) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	err = writeTo(w, strings.Join(h, Separator), &n)
+	return
+}
+
+func warn(v ...interface{}) {
+	log.Print("WARNING: ", fmt.Sprint(v...))
+}
+
+func writeTo(w io.Writer, v interface{}, p *int64) (err error) {
+	m, err := w.Write([]byte(fmt.Sprint(v)))
+	*p += int64(m)
+	return
+}
+
+/* Field Definition */
+
+type fieldDef struct {
+	category      string
+	name          string
+	isAccumulator bool
+}
+
+func (fd fieldDef) String() string { // implements fmt.Stringer
+	if fd.isAccumulator {
+		return fd.category + ":" + fd.name + "/a"
+	} else {
+		return fd.category + ":" + fd.name + "/i"
+	}
+}
+
+/* Counters, updated by the listeners */
+
+var counters [fieldsCount]uint64
+
+func count(i int, delta uint64) {
+	atomic.AddUint64(&counters[i], delta)
+}
+
+/* Record */
+
+var Header = makeHeader(allFieldsDefs)
+
+// Schema describes the fields of the records, for typed output.
+var Schema = capture.Register(capture.Schema{Collector: "procevents", Fields: Header[1:]})
+
+type Record struct {
+	capture.RecordInfo
+	isCumul bool
+	fields  []uint64
+}
+
+func newRecord(isCumul bool) *Record {
+	recordPtr := new(Record)
+	recordPtr.isCumul = isCumul
+	recordPtr.fields = make([]uint64, fieldsCount)
+	return recordPtr
+}
+
+func (recordPtr *Record) String() string { // implements fmt.Stringer
+	buf := new(bytes.Buffer)
+	recordPtr.WriteTo(buf)
+	return buf.String()
+}
+func (record Record) kind() string {
+	if record.isCumul {
+		return "a"
+	}
+	return "d"
+}
+func (record Record) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	err = writeTo(w, record.kind(), &n)
+	if err != nil {
+		return
+	}
+	for _, field := range record.fields {
+		err = writeTo(w, Separator, &n)
+		if err != nil {
+			return
+		}
+		err = writeTo(w, field, &n)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Samples returns the typed form of the record.
+func (record Record) Samples() []capture.Sample {
+	values := make([]uint64, len(record.fields))
+	copy(values, record.fields)
+	return []capture.Sample{{Time: record.Time, Kind: record.kind(), Values: values}}
+}
+func (recordPtr *Record) diff(prevRecord, diffRecord *Record) {
+	diffRecord.RecordInfo = recordPtr.RecordInfo
+	for i, field := range recordPtr.fields {
+		if allFieldsDefs[i].isAccumulator {
+			diffRecord.fields[i] = field - prevRecord.fields[i]
+		} else {
+			diffRecord.fields[i] = field
+		}
+	}
+	return
+}
+
+// snapshot copies the counters accumulated since the start of the listeners.
+func (recordPtr *Record) snapshot() {
+	defer recordPtr.EndRead(time.Now())
+	recordPtr.Time = time.Now()
+	for i := range recordPtr.fields {
+		recordPtr.fields[i] = atomic.LoadUint64(&counters[i])
+	}
+}
+
+/* Polling */
+
+// Poll starts listening to the kernel process events, then sends a Record in the channel
+// at each sampling time of the schedule.
+// Tasks living less than short are counted as short-lived.
+// If cumul is false, it prints the diff of the accumulators, instead of the accumulators themselves
+func Poll(sched *schedule.Schedule, short time.Duration, cumul bool, cout chan Record) {
+	err := listen(short)
+	if err != nil {
+		warn("Error listening to process events (root privileges required): ", err)
+		close(cout)
+		return
+	}
+	recordPtr := newRecord(true)
+	oldRecordPtr := newRecord(true)
+	diffRecordPtr := newRecord(false)
+	for i := 0; sched.Next(); i++ {
+		recordPtr.snapshot()
+		if cumul {
+			cout <- *recordPtr
+		} else {
+			if i < 1 {
+				cout <- *recordPtr
+			} else {
+				recordPtr.diff(oldRecordPtr, diffRecordPtr)
+				cout <- *diffRecordPtr
+			}
+			oldRecordPtr, recordPtr = recordPtr, oldRecordPtr
+		}
+	}
+	close(cout)
+}
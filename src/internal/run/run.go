@@ -0,0 +1,415 @@
+// Package run is the command line shared by the collector tools: the options of the schedule
+// and of the output, and the pipeline writing the records of a collector as text or gob.
+package run
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"capture"
+	"internal/output"
+	"internal/schedule"
+	"system/command"
+	"system/environ"
+	"system/sysctl"
+	"system/timesync"
+)
+
+// Record is a record sent by a collector: its text form, its typed form and its info.
+type Record interface {
+	io.WriterTo
+	Samples() []capture.Sample
+	Info() capture.RecordInfo
+}
+
+// Tool is the command line of a collector tool.
+// New defines the common options, to which the tool adds its own before calling Parse. Start
+// then starts the wrapped command, if any, before the tool starts polling, and Run writes the
+// records polled.
+type Tool struct {
+	Schedule *schedule.Schedule // set by Parse
+	Cumul    bool               // log cumulative counters, set by Parse
+	Command  *command.Command   // the wrapped command, set by Start, nil if none
+
+	// Comments, if not nil, writes comment lines before the header (text only).
+	Comments func(w io.Writer) error
+	// Annotate, if not nil, writes comment lines before each record output (text only).
+	Annotate func(w io.Writer, record Record) error
+
+	name      string
+	separator string
+	runID     string
+
+	usage            bool
+	period, duration time.Duration
+	forLength, then  time.Duration
+	plan             string
+	exact, align     bool
+	warmup, cooldown time.Duration
+	changes          bool
+	keepalive        time.Duration
+	ema              string
+	alpha            float64
+	baseline         time.Duration
+	baselineFile     string
+	time, env        bool
+	sysctls          string
+	runid            string
+	timesync, flags  bool
+	readtime         bool
+	suffix           string
+	gob              string
+	keyframes        int
+	outdir           string
+	utc              bool
+	chaos            string
+
+	perturber *output.Chaos
+}
+
+// New defines the common options of the tool of the given name, of which the records have
+// their columns separated by separator.
+func New(name string, separator string) *Tool {
+	t := &Tool{name: name, separator: separator}
+	flag.BoolVar(&t.usage, "usage", false, "prints this usage description")
+	// -h, -help, --help also automatically recognised
+	flag.DurationVar(&t.period, "interval", 1e9, "poll interval")                           // defaults to 1e9ns = 1s
+	flag.DurationVar(&t.duration, "duration", 0, "monitoring duration (unlimited if zero)") // defaults to unlimited
+	flag.DurationVar(&t.forLength, "for", 0, "length of the first stage of a staged run, sampling at interval (see then)")
+	flag.DurationVar(&t.then, "then", 0, "interval after the first stage of a staged run (see for)")
+	flag.StringVar(&t.plan, "plan", "", "run the stages of this plan one after the other, instead of interval and duration, as 5m@5s,30m@1s,5m@5s (length@interval)")
+	flag.BoolVar(&t.exact, "exact", false, "stop exactly at duration instead of completing the last interval")
+	flag.BoolVar(&t.align, "align", false, "take the samples at multiples of the interval (wall clock), to sample at the same instants as other tools")
+	flag.DurationVar(&t.warmup, "warmup", 0, "drop the samples of this warm-up phase at the start of the run")
+	flag.DurationVar(&t.cooldown, "cooldown", 0, "drop the samples of this cool-down phase at the end of the run (ignored if duration is unlimited)")
+	flag.BoolVar(&t.Cumul, "cumul", false, "log cumulative counters instead of delta")
+	flag.BoolVar(&t.changes, "changes", false, "output only the records that changed since the previous one output")
+	flag.DurationVar(&t.keepalive, "keepalive", 60e9, "with changes, output a record at least this often, even if unchanged (never if zero)")
+	flag.StringVar(&t.ema, "ema", "", "add the exponential moving averages of these fields (comma-separated, as cpu:user,cpu:system), named with the _ema suffix")
+	flag.Float64Var(&t.alpha, "alpha", 0.2, "with ema, weight of the current value in the averages (between 0 and 1)")
+	flag.DurationVar(&t.baseline, "baseline", 0, "add the fields in pct of their mean over this first part of the run (after warmup), named with the _pctbase suffix (none if zero)")
+	flag.StringVar(&t.baselineFile, "baseline-file", "", "add the fields in pct of their mean over this reference capture (text or gob, with the same fields and kind), instead of the first part of the run")
+	flag.BoolVar(&t.time, "time", true, "add timestamp prefix")
+	flag.BoolVar(&t.env, "env", false, "print a description of the host environment (as comment lines) before the header")
+	flag.StringVar(&t.sysctls, "sysctls", "", "print the values of these kernel parameters as comment lines before the header, and again before a record when they changed (text only), as net.core.somaxconn,vm.swappiness")
+	flag.StringVar(&t.runid, "runid", "", "identifier of the run, given with env and in the gob stream, to correlate the captures of several tools (a new UUID if empty)")
+	flag.BoolVar(&t.timesync, "timesync", false, "add clock synchronization status and offset (in us) after the timestamp")
+	flag.BoolVar(&t.flags, "flags", false, "add a flags column after the timestamp: ok, or the anomalies detected in the record (counter-reset, clock-jump, partial-parse, or timeout if its sources took longer than the interval to read)")
+	flag.BoolVar(&t.readtime, "readtime", false, "add a column after the timestamp with the time spent reading the sources of the record, in us, to see when a pressured system or a hung mount inflates it (text only)")
+	flag.StringVar(&t.suffix, "suffix", "", "append an integrity suffix to each line: crc32 or len (none if empty)")
+	flag.StringVar(&t.gob, "gob", "", "write a gob stream of typed records to this destination (file, '-', tcp:host:port or unix:path) instead of text")
+	flag.IntVar(&t.keyframes, "keyframes", 0, "with gob, delta-encode the values, with full values every this number of samples (no delta encoding if zero)")
+	flag.StringVar(&t.outdir, "outdir", "", "write the text output to outdir/<host>/<date>/"+name+".log instead of the standard output, switching file at midnight")
+	flag.BoolVar(&t.utc, "utc", false, "switch the outdir file at midnight UTC instead of local time")
+	flag.StringVar(&t.chaos, "chaos", "", "perturb the output, to test its receivers: fail (drop), delay or garble (precede with a malformed line, text only) the records, as fail=0.01,delay=200ms,garble=0.05 (none if empty)")
+	return t
+}
+
+// Parse parses the command line, prints the usage description and exits if asked to, and
+// prepares the schedule of the run.
+func (t *Tool) Parse() {
+	flag.Parse()
+	if t.usage {
+		flag.PrintDefaults()
+		os.Exit(0)
+	}
+	err := schedule.Check(t.period, t.duration)
+	if err != nil {
+		log.Fatal(err)
+	}
+	t.runID = t.runid
+	if t.runID == "" {
+		t.runID, err = capture.NewRunID()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if t.plan != "" {
+		t.Schedule, err = schedule.NewPlan(t.plan)
+		if err != nil {
+			log.Fatal("Invalid plan: ", err)
+		}
+	} else {
+		t.Schedule = schedule.New(t.period, t.duration, t.exact)
+		if t.forLength > 0 || t.then > 0 {
+			err = t.Schedule.Then(t.forLength, t.then)
+			if err != nil {
+				log.Fatal("Invalid staged run: ", err)
+			}
+		}
+	}
+	if t.align {
+		t.Schedule.Align()
+	}
+	if t.chaos != "" {
+		t.perturber, err = output.NewChaos(t.chaos)
+		if err != nil {
+			log.Fatal("Invalid chaos: ", err)
+		}
+		if t.perturber.Garbles() && t.gob != "" {
+			log.Fatal("Invalid chaos: garble is for the text output only")
+		}
+	}
+}
+
+// Start starts the command given after the options, if any, the run being stopped when it exits.
+func (t *Tool) Start() {
+	if flag.NArg() == 0 {
+		return
+	}
+	var cmdOut io.Writer = os.Stdout
+	if t.outdir == "" && (t.gob == "" || t.gob == "-") {
+		cmdOut = os.Stderr // the records are written to the standard output
+	}
+	var err error
+	t.Command, err = command.Start(flag.Args(), cmdOut, t.Schedule.Stop)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// pipeline flags the records, filters them and adds their companion fields.
+type pipeline struct {
+	window   *schedule.Window
+	changes  *output.ChangeFilter
+	flagger  *output.Flagger
+	smoother *output.Smoother
+	baseline *output.Baseline
+}
+
+func (t *Tool) newPipeline(schema capture.Schema) *pipeline {
+	p := &pipeline{window: t.Schedule.Window(t.warmup, t.cooldown)}
+	if t.changes {
+		p.changes = output.NewChangeFilter(t.keepalive)
+	}
+	if t.flags {
+		p.flagger = output.NewFlagger(schema, t.Schedule.ShortestPeriod())
+	}
+	if t.ema != "" {
+		var err error
+		p.smoother, err = output.NewSmoother(schema, strings.Split(t.ema, ","), t.alpha)
+		if err != nil {
+			log.Fatal("Invalid ema: ", err)
+		}
+	}
+	if t.baselineFile != "" {
+		inFile, err := os.Open(t.baselineFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		p.baseline, err = output.ReadBaseline(schema, inFile)
+		inFile.Close()
+		if err != nil {
+			log.Fatal("Invalid baseline-file: ", err)
+		}
+	} else if t.baseline > 0 {
+		p.baseline = output.NewBaseline(schema, t.baseline)
+	}
+	return p
+}
+
+// fields returns the names of the companion fields.
+func (p *pipeline) fields() []string {
+	return append(p.smoother.Fields(), p.baseline.Fields()...)
+}
+
+// process runs a record through the pipeline, all the records polled being given in order.
+// It returns the flags of the record, whether it is to be output and its samples, with their
+// companion fields. The averages are computed over all the records, to follow the sampling
+// interval, and the baseline over the records of the window, whether changed or not.
+func (p *pipeline) process(record Record) (flags string, keep bool, samples []capture.Sample) {
+	info := record.Info()
+	samples = record.Samples()
+	flags = p.flagger.Flags(info, samples)
+	smoothed := p.smoother.Smooth(samples)
+	if !p.window.Contains(info.Time) {
+		return
+	}
+	based, err := p.baseline.Apply(info.Time, smoothed)
+	if err != nil {
+		log.Fatal("Invalid baseline: ", err)
+	}
+	if !p.changes.Keep(info.Time, samples) {
+		return
+	}
+	return flags, true, based
+}
+
+// Run writes the records of the schema received from cout, until it is closed, then returns
+// the exit code of the wrapped command, 0 if none, for main to exit with once the outputs are
+// closed.
+// The header is the one of the text output, without the time, sync, flags and read time columns.
+func Run[R Record](t *Tool, schema capture.Schema, header io.WriterTo, cout chan R) int {
+	p := t.newPipeline(schema)
+	if t.gob != "" {
+		w, err := output.Open(t.gob)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer w.Close()
+		gw := t.newGobWriter(w, schema, p)
+		for record := range cout {
+			t.writeGob(gw, p, record)
+		}
+		return t.Command.ExitCode()
+	}
+	var dest io.Writer = os.Stdout
+	var layout *output.LayoutWriter
+	if t.outdir != "" {
+		var err error
+		layout, err = output.NewLayoutWriter(t.outdir, t.name, t.utc)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer layout.Close()
+		dest = layout
+	}
+	out, err := output.NewSuffixWriter(dest, t.suffix, t.separator)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tw := t.newTextWriter(out, schema, header, p)
+	if layout != nil {
+		layout.EndHeader()
+	}
+	for record := range cout {
+		tw.write(p, record)
+		if layout != nil {
+			layout.EndRecord()
+		}
+	}
+	return t.Command.ExitCode()
+}
+
+/* Gob output */
+
+func (t *Tool) newGobWriter(w io.Writer, schema capture.Schema, p *pipeline) *capture.GobWriter {
+	schema.RunID = t.runID
+	schema.Fields = append(schema.Fields, p.fields()...)
+	gw, err := capture.NewDeltaGobWriter(w, schema, t.keyframes)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return gw
+}
+
+func (t *Tool) writeGob(gw *capture.GobWriter, p *pipeline, record Record) {
+	_, keep, samples := p.process(record)
+	if !keep {
+		return
+	}
+	err := t.perturber.Perturb(nil)
+	if err != nil {
+		log.Print("WARNING: Error writing record, dropping it: ", err)
+		return
+	}
+	for _, sample := range samples {
+		err := gw.Write(sample)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+/* Text output */
+
+// textWriter writes the records as text, after the comment and header lines.
+type textWriter struct {
+	t           *Tool
+	out         io.Writer
+	signed      []bool // of the fields and companion fields
+	sysctlNames []string
+	sysctls     sysctl.Values
+}
+
+func (t *Tool) newTextWriter(out io.Writer, schema capture.Schema, header io.WriterTo, p *pipeline) *textWriter {
+	tw := &textWriter{t: t, out: out}
+	schema.Fields = append(append([]string(nil), schema.Fields...), p.fields()...)
+	tw.signed = schema.Signed()
+	capture.WriteSchemaComment(out, schema.Collector)
+	if t.env {
+		fmt.Fprint(out, capture.RunIDComment, t.runID, "\n")
+		environ.Write(out)
+	}
+	tw.sysctlNames = sysctl.ParseNames(t.sysctls)
+	if len(tw.sysctlNames) > 0 {
+		var err error
+		tw.sysctls, err = sysctl.Read(tw.sysctlNames)
+		if err != nil {
+			log.Fatal(err)
+		}
+		tw.sysctls.Write(out, tw.sysctlNames)
+	}
+	if t.Comments != nil {
+		err := t.Comments(out)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if t.time {
+		fmt.Fprint(out, "time", t.separator)
+	}
+	if t.timesync {
+		fmt.Fprint(out, strings.Join(timesync.Header, t.separator), t.separator)
+	}
+	if t.flags {
+		fmt.Fprint(out, output.FlagsHeader, t.separator)
+	}
+	if t.readtime {
+		fmt.Fprint(out, output.ReadTimeHeader, t.separator)
+	}
+	header.WriteTo(out)
+	for _, field := range p.fields() {
+		fmt.Fprint(out, t.separator, field)
+	}
+	fmt.Fprintln(out)
+	return tw
+}
+
+func (tw *textWriter) write(p *pipeline, record Record) {
+	t, out := tw.t, tw.out
+	flags, keep, samples := p.process(record)
+	if !keep || len(samples) == 0 {
+		return // no line of a bare timestamp for a record with no instance
+	}
+	err := t.perturber.Perturb(out)
+	if err != nil {
+		log.Print("WARNING: Error writing record, dropping it: ", err)
+		return
+	}
+	if len(tw.sysctlNames) > 0 {
+		current, err := sysctl.Read(tw.sysctlNames)
+		if err != nil {
+			log.Print("WARNING: Error reading sysctls, ignoring: ", err)
+		} else {
+			current.WriteDrift(out, tw.sysctls)
+			tw.sysctls = current
+		}
+	}
+	if t.Annotate != nil {
+		t.Annotate(out, record)
+	}
+	if t.time {
+		fmt.Fprint(out, record.Info().Time.Format(capture.TextTimeFormat), t.separator)
+	}
+	if t.timesync {
+		timesync.Write(out, t.separator)
+	}
+	if t.flags {
+		fmt.Fprint(out, flags, t.separator)
+	}
+	if t.readtime {
+		fmt.Fprint(out, output.ReadTime(record.Info().ReadTime), t.separator)
+	}
+	if len(p.fields()) > 0 {
+		output.WriteSamples(out, samples, tw.signed, t.separator)
+	} else {
+		record.WriteTo(out)
+	}
+	fmt.Fprintln(out)
+}
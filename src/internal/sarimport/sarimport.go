@@ -0,0 +1,239 @@
+// Package sarimport converts the statistics of sysstat (sar) data files, as exported in JSON by
+// `sadf -j`, into the samples of the collectors, to analyze them with the same tools as captures.
+// The binary data files are not read directly, their layout changing with the sysstat versions.
+package sarimport
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"capture"
+	"internal/cpustat"
+	"internal/loadavg"
+	"internal/meminfo"
+	"internal/netstat"
+	"internal/vmstat"
+)
+
+/* Section definition */
+
+// sectionDef gives the collector into which a section of the statistics is imported.
+// The section is an object, or an array of objects with an instance given by instanceKey, of
+// which only allInstance is kept if not empty.
+// The keys of the section are those of the sar columns (see jsonKey), except those of keys,
+// given by sar column name, for the sections of which the columns are named otherwise.
+type sectionDef struct {
+	path        []string // keys of the section, from the statistics of one sample
+	schema      capture.Schema
+	kind        string
+	instanceKey string
+	allInstance string
+	keys        map[string]string
+}
+
+// allCpuKeys are the keys of `sar -u ALL`, of which the columns are %usr and %sys instead of
+// %user and %system.
+var allCpuKeys = map[string]string{"%user": "usr", "%system": "sys"}
+
+var sectionsDefs = []sectionDef{
+	sectionDef{[]string{"cpu-load"}, cpustat.Schema, "p", "cpu", "all", nil},
+	sectionDef{[]string{"cpu-load-all"}, cpustat.Schema, "p", "cpu", "all", allCpuKeys},
+	sectionDef{[]string{"process-and-context-switch"}, cpustat.Schema, "p", "", "", nil},
+	sectionDef{[]string{"memory"}, meminfo.Schema, "d", "", "", nil},
+	sectionDef{[]string{"queue"}, loadavg.Schema, "d", "", "", nil},
+	sectionDef{[]string{"network", "net-dev"}, netstat.Schema, "d", "iface", "", nil},
+	sectionDef{[]string{"network", "net-edev"}, netstat.Schema, "d", "iface", "", nil},
+	sectionDef{[]string{"paging"}, vmstat.Schema, "d", "", "", nil},
+	sectionDef{[]string{"swap-pages"}, vmstat.Schema, "d", "", "", nil},
+}
+
+// key returns the key of a sar column in the section.
+func (sd sectionDef) key(sarName string) string {
+	if key, ok := sd.keys[sarName]; ok {
+		return key
+	}
+	return jsonKey(sarName)
+}
+
+// jsonKey returns the key of a sar column in the JSON of sadf, as "rxkB" for "rxkB/s",
+// "memfree" for "kbmemfree" or "user" for "%user".
+func jsonKey(sarName string) string {
+	key := strings.TrimSuffix(strings.TrimPrefix(sarName, "%"), "/s")
+	if strings.HasPrefix(key, "kb") {
+		key = key[2:]
+	}
+	return key
+}
+
+// scale converts a sar value into the unit of the field: the rates into counts over the
+// interval, the kB into bytes, and the load averages into hundredths.
+func scale(sarName, field string, value float64, interval time.Duration) uint64 {
+	if strings.HasSuffix(sarName, "/s") {
+		value *= interval.Seconds()
+		if strings.Contains(sarName, "kB") && strings.Contains(field, ":bytes") {
+			value *= 1024
+		}
+	}
+	if strings.HasPrefix(sarName, "ldavg-") {
+		value *= 100
+	}
+	if value < 0 {
+		return 0
+	}
+	return uint64(math.Floor(value + 0.5))
+}
+
+/* Statistics */
+
+type timestamp struct {
+	Date     string `json:"date"`
+	Time     string `json:"time"`
+	UTC      int    `json:"utc"`
+	Interval int    `json:"interval"` // seconds
+}
+
+func (ts timestamp) parse() (t time.Time, err error) {
+	loc := time.Local
+	if ts.UTC != 0 {
+		loc = time.UTC
+	}
+	return time.ParseInLocation("2006-01-02 15:04:05", ts.Date+" "+ts.Time, loc)
+}
+
+type statistics map[string]json.RawMessage
+
+type document struct {
+	Sysstat struct {
+		Hosts []struct {
+			Nodename   string       `json:"nodename"`
+			Statistics []statistics `json:"statistics"`
+		} `json:"hosts"`
+	} `json:"sysstat"`
+}
+
+// section returns the objects of the section of the statistics, empty if missing.
+func (stats statistics) section(path []string) (objects []map[string]interface{}, err error) {
+	raw, ok := stats[path[0]]
+	for _, key := range path[1:] {
+		if !ok {
+			break
+		}
+		var inner statistics
+		err = json.Unmarshal(raw, &inner)
+		if err != nil {
+			return
+		}
+		raw, ok = inner[key]
+	}
+	if !ok {
+		return
+	}
+	var value interface{}
+	err = json.Unmarshal(raw, &value)
+	if err != nil {
+		return
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		objects = append(objects, v)
+	case []interface{}:
+		for _, item := range v {
+			if object, ok := item.(map[string]interface{}); ok {
+				objects = append(objects, object)
+			}
+		}
+	}
+	return
+}
+
+/* Import */
+
+// sampleKey identifies the sample being built, merged from the sections of the same collector.
+type sampleKey struct {
+	collector string
+	instance  string
+}
+
+// Read reads the JSON output of `sadf -j` and calls emit with each imported sample, and the
+// schema of its collector, in the order of the statistics.
+// The fields without an equivalent sar column are zero.
+func Read(r io.Reader, emit func(schema capture.Schema, sample capture.Sample) error) (err error) {
+	var doc document
+	err = json.NewDecoder(r).Decode(&doc)
+	if err != nil {
+		return
+	}
+	for _, host := range doc.Sysstat.Hosts {
+		for _, stats := range host.Statistics {
+			err = readStatistics(stats, emit)
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+func readStatistics(stats statistics, emit func(schema capture.Schema, sample capture.Sample) error) (err error) {
+	var ts timestamp
+	err = json.Unmarshal(stats["timestamp"], &ts)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp: %v", err)
+	}
+	t, err := ts.parse()
+	if err != nil {
+		return
+	}
+	interval := time.Duration(ts.Interval) * time.Second
+	samples := make(map[sampleKey]*capture.Sample)
+	var keys []sampleKey
+	schemas := make(map[string]capture.Schema)
+	for _, sd := range sectionsDefs {
+		objects, err := stats.section(sd.path)
+		if err != nil {
+			return err
+		}
+		for _, object := range objects {
+			var instance string
+			if sd.instanceKey != "" {
+				instance = fmt.Sprint(object[sd.instanceKey])
+				if sd.allInstance != "" {
+					if instance != sd.allInstance {
+						continue
+					}
+					instance = ""
+				}
+			}
+			key := sampleKey{sd.schema.Collector, instance}
+			sample, ok := samples[key]
+			if !ok {
+				sample = &capture.Sample{Time: t, Instance: instance, Kind: sd.kind, Values: make([]uint64, len(sd.schema.Fields))}
+				samples[key] = sample
+				keys = append(keys, key)
+				schemas[sd.schema.Collector] = sd.schema
+			}
+			for i, field := range sd.schema.Fields {
+				sarName, ok := capture.EquivalentName(sd.schema.Collector, field, capture.Sar)
+				if !ok {
+					continue
+				}
+				value, ok := object[sd.key(sarName)].(float64)
+				if !ok {
+					continue
+				}
+				sample.Values[i] = scale(sarName, field, value, interval)
+			}
+		}
+	}
+	for _, key := range keys {
+		err = emit(schemas[key.collector], *samples[key])
+		if err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,325 @@
+package schedstat
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"capture"
+	"internal/schedule"
+)
+
+const (
+	defaultProcSchedstat = "/proc/schedstat"
+	allCpus              = "all" // instance of the sums over all cpus
+	firstFieldsCol       = 7     // of a cpu line, after the cpu name and the yield/schedule/wakeup counts
+	Separator            = " "
+)
+
+const (
+	// cpuN yld_count 0 sched_count sched_goidle ttwu_count ttwu_local, then:
+	runTimeIdx    = iota
+	waitTimeIdx   = iota
+	timeslicesIdx = iota
+	fieldsCount   = iota
+)
+
+var allFieldsDefs = []fieldDef{
+	fieldDef{"sched", "run_ns", true},
+	fieldDef{"sched", "wait_ns", true},
+	fieldDef{"sched", "timeslices", true},
+}
+
+/* Header is a list of field names. */
+
+type header []string
+
+func makeHeader(fdl []fieldDef) header {
+	h := header(make([]string, 2+len(fdl)))
+	h[0] = "cpu"
+	h[1] = "h"
+	for i, d := range fdl {
+		h[i+2] = d.String()
+	}
+	return h
+}
+
+func (h header) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	err = writeTo(w, strings.Join(h, Separator), &n)
+	return
+}
+
+var procSchedstat string = defaultProcSchedstat
+
+func warn(v ...interface{}) {
+	log.Print("WARNING: ", fmt.Sprint(v...))
+}
+
+func init() {
+	fsRoot := os.Getenv("FS_ROOT")
+	if fsRoot != "" {
+		procSchedstat = path.Join(fsRoot, defaultProcSchedstat)
+	}
+}
+
+func writeTo(w io.Writer, v interface{}, p *int64) (err error) {
+	m, err := w.Write([]byte(fmt.Sprint(v)))
+	*p += int64(m)
+	return
+}
+
+/* Field Definition */
+
+type fieldDef struct {
+	category      string
+	name          string
+	isAccumulator bool
+}
+
+func (fd fieldDef) String() string { // implements fmt.Stringer
+	if fd.isAccumulator {
+		return fd.category + ":" + fd.name + "/a"
+	} else {
+		return fd.category + ":" + fd.name + "/i"
+	}
+}
+
+/* Record */
+
+var Header = makeHeader(allFieldsDefs)
+
+// Schema describes the fields of the records, for typed output.
+var Schema = capture.Register(capture.Schema{Collector: "schedstat", Fields: Header[2:]})
+
+type Record struct {
+	capture.RecordInfo
+	isCumul, isRel bool
+	fields         []uint64   // summed over all cpus
+	cpus           []string   // cpu names, in the order of /proc/schedstat
+	cpuFields      [][]uint64 // fields per cpu
+}
+
+func newRecord(isCumul, isRel bool) *Record {
+	recordPtr := new(Record)
+	recordPtr.isCumul = isCumul
+	recordPtr.isRel = isRel
+	recordPtr.fields = make([]uint64, fieldsCount)
+	return recordPtr
+}
+
+func (recordPtr *Record) String() string { // implements fmt.Stringer
+	buf := new(bytes.Buffer)
+	recordPtr.WriteTo(buf)
+	return buf.String()
+}
+func (record Record) kind() string {
+	if record.isCumul {
+		return "a"
+	}
+	if record.isRel {
+		return "p"
+	}
+	return "d"
+}
+func (record Record) writeFieldsTo(w io.Writer, cpu string, fields []uint64, p *int64) (err error) {
+	err = writeTo(w, cpu+Separator+record.kind(), p)
+	if err != nil {
+		return
+	}
+	for _, field := range fields {
+		err = writeTo(w, Separator, p)
+		if err != nil {
+			return
+		}
+		err = writeTo(w, field, p)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// WriteTo writes the sums over all the cpus on an "all" line, followed by one line per cpu.
+func (record Record) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	err = record.writeFieldsTo(w, allCpus, record.fields, &n)
+	if err != nil {
+		return
+	}
+	for i, cpu := range record.cpus {
+		err = writeTo(w, "\n", &n)
+		if err != nil {
+			return
+		}
+		err = record.writeFieldsTo(w, cpu, record.cpuFields[i], &n)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+func (record Record) sample(instance string, fields []uint64) capture.Sample {
+	values := make([]uint64, fieldsCount)
+	copy(values, fields)
+	return capture.Sample{Time: record.Time, Instance: instance, Kind: record.kind(), Values: values}
+}
+
+// Samples returns the typed form of the record.
+func (record Record) Samples() []capture.Sample {
+	samples := []capture.Sample{record.sample(allCpus, record.fields)}
+	for i, cpu := range record.cpus {
+		samples = append(samples, record.sample(cpu, record.cpuFields[i]))
+	}
+	return samples
+}
+
+// diffFields computes the diffs of the fields, returning whether an accumulator went backwards.
+func diffFields(fields, prevFields, diffFields []uint64) (reset bool) {
+	for i, field := range fields {
+		if allFieldsDefs[i].isAccumulator {
+			reset = reset || field < prevFields[i]
+			diffFields[i] = field - prevFields[i]
+		} else {
+			diffFields[i] = field
+		}
+	}
+	return
+}
+func (recordPtr *Record) diff(prevRecord, diffRecord *Record) {
+	diffRecord.RecordInfo = recordPtr.RecordInfo
+	diffRecord.CounterReset = diffFields(recordPtr.fields, prevRecord.fields, diffRecord.fields)
+	// cpus going online or offline change the lines of /proc/schedstat
+	diffRecord.cpus = recordPtr.cpus
+	diffRecord.cpuFields = make([][]uint64, len(recordPtr.cpus))
+	prevCpuFields := make(map[string][]uint64, len(prevRecord.cpus))
+	for i, cpu := range prevRecord.cpus {
+		prevCpuFields[cpu] = prevRecord.cpuFields[i]
+	}
+	for i, cpu := range recordPtr.cpus {
+		diffRecord.cpuFields[i] = make([]uint64, fieldsCount)
+		prevFields, ok := prevCpuFields[cpu]
+		if !ok {
+			prevFields = make([]uint64, fieldsCount)
+		}
+		if diffFields(recordPtr.cpuFields[i], prevFields, diffRecord.cpuFields[i]) {
+			diffRecord.CounterReset = true
+		}
+	}
+	return
+}
+
+// relFields converts the run and wait times into percentages of the elapsed time of cpus.
+// The wait time may exceed 100%, with several tasks waiting for the same cpu.
+func relFields(fields []uint64, elapsed time.Duration, cpus int) {
+	base := uint64(elapsed) * uint64(cpus)
+	if base == 0 {
+		return
+	}
+	for _, i := range []int{runTimeIdx, waitTimeIdx} {
+		fields[i] = fields[i] * 100 / base
+	}
+}
+func (diffRecordPtr *Record) rel(elapsed time.Duration) {
+	relFields(diffRecordPtr.fields, elapsed, len(diffRecordPtr.cpus))
+	for _, fields := range diffRecordPtr.cpuFields {
+		relFields(fields, elapsed, 1)
+	}
+	return
+}
+
+// parseCpuLine parses a cpu line, as "cpu0 0 0 102 38 51 23 1020440 98312 64".
+func (recordPtr *Record) parseCpuLine(parsedFields []string) (err error) {
+	if len(parsedFields) < firstFieldsCol+fieldsCount {
+		return fmt.Errorf("unexpected %s line: %q", parsedFields[0], strings.Join(parsedFields, " "))
+	}
+	fields := make([]uint64, fieldsCount)
+	for i, str := range parsedFields[firstFieldsCol : firstFieldsCol+fieldsCount] {
+		fields[i], err = strconv.ParseUint(str, 10, 64)
+		if err != nil {
+			return
+		}
+		recordPtr.fields[i] += fields[i]
+	}
+	recordPtr.cpus = append(recordPtr.cpus, parsedFields[0])
+	recordPtr.cpuFields = append(recordPtr.cpuFields, fields)
+	return
+}
+
+func (recordPtr *Record) parse() (err error) {
+	defer recordPtr.EndRead(time.Now())
+	inFile, err := os.Open(procSchedstat)
+	if err != nil {
+		return
+	}
+	defer inFile.Close()
+	recordPtr.Time = time.Now()
+	for i := range recordPtr.fields {
+		recordPtr.fields[i] = 0
+	}
+	recordPtr.cpus = nil
+	recordPtr.cpuFields = nil
+	scanner := bufio.NewScanner(inFile)
+	for scanner.Scan() {
+		parsedFields := strings.Fields(scanner.Text())
+		if len(parsedFields) == 0 {
+			continue
+		}
+		switch {
+		case parsedFields[0] == "version":
+			// cpu lines have their current layout since version 15 (Linux 2.6.30)
+			version := 0
+			if len(parsedFields) > 1 {
+				version, _ = strconv.Atoi(parsedFields[1])
+			}
+			if version < 15 {
+				return fmt.Errorf("unsupported %s version: %q", procSchedstat, scanner.Text())
+			}
+		case strings.HasPrefix(parsedFields[0], "cpu"):
+			err = recordPtr.parseCpuLine(parsedFields)
+			if err != nil {
+				return
+			}
+		}
+	}
+	err = scanner.Err()
+	return
+}
+
+/* Polling */
+
+// Poll sends a Record in the channel at each sampling time of the schedule.
+// If cumul is false, it prints the diff of the accumulators, instead of the accumulators themselves
+// If rel is true, the run and wait times of the diffs are given in percentage of the elapsed time
+func Poll(sched *schedule.Schedule, cumul bool, rel bool, cout chan Record) {
+	recordPtr := newRecord(true, false)
+	oldRecordPtr := newRecord(true, false)
+	diffRecordPtr := newRecord(false, rel)
+	for i := 0; sched.Next(); i++ {
+		err := recordPtr.parse()
+		if err != nil {
+			warn("Error parsing record, ignoring: ", err)
+			continue
+		}
+		if cumul {
+			cout <- *recordPtr
+		} else {
+			if i < 1 {
+				cout <- *recordPtr
+			} else {
+				recordPtr.diff(oldRecordPtr, diffRecordPtr)
+				if rel {
+					diffRecordPtr.rel(recordPtr.Time.Sub(oldRecordPtr.Time))
+				}
+				cout <- *diffRecordPtr
+			}
+			oldRecordPtr, recordPtr = recordPtr, oldRecordPtr
+		}
+	}
+	close(cout)
+}
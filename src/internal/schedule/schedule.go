@@ -0,0 +1,265 @@
+package schedule
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MinPeriod is the shortest period accepted, below the resolution of most kernel counters
+// (clock ticks are 10ms on most systems).
+const MinPeriod = 10 * time.Millisecond
+
+// Schedule paces a polling loop: the first sample is taken immediately, the following
+// ones every period.
+// With a non-zero duration, the number of intervals is duration/period, rounded up
+// (the last interval is completed) or, if exact, rounded down (the run never goes
+// beyond duration). In delta mode, the number of delta records is thus exactly the
+// number of intervals, whatever the time spent sampling.
+// A run may have several stages, the period changing after the intervals of each one.
+type Schedule struct {
+	period    time.Duration // of the first stage
+	duration  time.Duration
+	exact     bool
+	intervals int // negative if unlimited
+	i         int // samples already scheduled
+	next      time.Time
+	aligned   bool
+	stages    []stage // nil if the period never changes
+	stop      chan struct{}
+	stopOnce  sync.Once
+	final     bool // the last sample, taken on stop, was scheduled
+}
+
+// stage is a part of a run with the same period.
+type stage struct {
+	period    time.Duration
+	intervals int // negative if unlimited (last stage only)
+}
+
+// countIntervals returns the number of intervals of period in duration, rounded up unless exact.
+func countIntervals(duration time.Duration, period time.Duration, exact bool) int {
+	intervals := int(duration / period)
+	if !exact && duration%period != 0 {
+		intervals++
+	}
+	return intervals
+}
+
+func New(period time.Duration, duration time.Duration, exact bool) *Schedule {
+	s := &Schedule{period: period, duration: duration, exact: exact, intervals: -1, stop: make(chan struct{})}
+	if duration > 0 && period > 0 {
+		s.intervals = countIntervals(duration, period, exact)
+	}
+	return s
+}
+
+// Check validates the period and duration of a run: the period must be at least MinPeriod
+// and, if the duration is limited, it must not be shorter than the period.
+func Check(period time.Duration, duration time.Duration) error {
+	if period < MinPeriod {
+		return fmt.Errorf("interval %v shorter than %v", period, MinPeriod)
+	}
+	if duration < 0 {
+		return fmt.Errorf("negative duration %v", duration)
+	}
+	if duration > 0 && duration < period {
+		return fmt.Errorf("duration %v shorter than interval %v", duration, period)
+	}
+	return nil
+}
+
+// Then makes a staged run: samples are taken every period for the first length of the run
+// (rounded up to a whole number of periods), then every nextPeriod until its end.
+// With a limited duration, the first stage must end before the end of the run.
+func (s *Schedule) Then(length time.Duration, nextPeriod time.Duration) error {
+	if nextPeriod < MinPeriod {
+		return fmt.Errorf("interval %v shorter than %v", nextPeriod, MinPeriod)
+	}
+	if length < s.period {
+		return fmt.Errorf("first stage length %v shorter than interval %v", length, s.period)
+	}
+	first := stage{s.period, countIntervals(length, s.period, false)}
+	second := stage{nextPeriod, -1}
+	if s.intervals >= 0 {
+		rest := s.duration - time.Duration(first.intervals)*s.period
+		if rest <= 0 {
+			return fmt.Errorf("first stage length %v not shorter than duration %v", length, s.duration)
+		}
+		second.intervals = countIntervals(rest, nextPeriod, s.exact)
+		s.intervals = first.intervals + second.intervals
+	}
+	s.stages = []stage{first, second}
+	return nil
+}
+
+// NewPlan returns the schedule of a run made of stages run one after the other, as
+// "5m@5s,30m@1s,5m@5s": 5 minutes sampling every 5 seconds, then 30 minutes every second, then
+// 5 minutes every 5 seconds. The length of each stage is rounded up to a whole number of periods.
+func NewPlan(plan string) (s *Schedule, err error) {
+	s = &Schedule{stop: make(chan struct{})}
+	for _, stageStr := range strings.Split(plan, ",") {
+		parts := strings.Split(strings.TrimSpace(stageStr), "@")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("stage %q not as length@interval", stageStr)
+		}
+		var length, period time.Duration
+		length, err = time.ParseDuration(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		period, err = time.ParseDuration(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		err = Check(period, length)
+		if err != nil {
+			return nil, fmt.Errorf("stage %q: %v", stageStr, err)
+		}
+		if length == 0 {
+			return nil, fmt.Errorf("stage %q without length", stageStr)
+		}
+		s.stages = append(s.stages, stage{period, countIntervals(length, period, false)})
+		s.intervals += s.stages[len(s.stages)-1].intervals
+		s.duration += length
+	}
+	s.period = s.stages[0].period
+	return
+}
+
+// periodOf returns the period of the interval ending with the sample i (counting from 0).
+func (s *Schedule) periodOf(i int) time.Duration {
+	end := 0
+	for _, st := range s.stages {
+		end += st.intervals
+		if st.intervals < 0 || i <= end {
+			return st.period
+		}
+	}
+	return s.period
+}
+
+// ShortestPeriod returns the shortest period of the run.
+func (s *Schedule) ShortestPeriod() time.Duration {
+	shortest := s.period
+	for _, st := range s.stages {
+		if st.period < shortest {
+			shortest = st.period
+		}
+	}
+	return shortest
+}
+
+// Align delays the first sample to the next multiple of the period since the epoch, so that
+// the tools started with the same period sample at the same instants, whatever their start time.
+func (s *Schedule) Align() {
+	s.aligned = true
+}
+
+// Stop ends the run early: the pending Next returns at once, for a last sample, and the
+// following one returns false. It may be called from any goroutine.
+func (s *Schedule) Stop() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
+
+// sleep waits for d, and returns false if the run is stopped meanwhile.
+func (s *Schedule) sleep(d time.Duration) bool {
+	select {
+	case <-s.stop:
+		return false
+	default:
+	}
+	if d <= 0 {
+		return true
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-s.stop:
+		return false
+	}
+}
+
+// Next waits until the next sampling time, and returns false once the run is over.
+func (s *Schedule) Next() bool {
+	if s.final || s.intervals >= 0 && s.i > s.intervals {
+		return false
+	}
+	if s.i == 0 {
+		s.next = time.Now()
+		if s.aligned && s.period > 0 {
+			aligned := s.next.Truncate(s.period)
+			if aligned.Before(s.next) {
+				aligned = aligned.Add(s.period)
+			}
+			if s.sleep(aligned.Sub(s.next)) {
+				s.next = aligned
+			} else {
+				s.final = true
+			}
+		}
+	} else {
+		s.next = s.next.Add(s.periodOf(s.i))
+		if !s.sleep(s.next.Sub(time.Now())) {
+			s.final = true
+			s.next = time.Now()
+		}
+	}
+	s.i++
+	return true
+}
+
+// Length returns the planned length of the run, zero if unlimited.
+func (s *Schedule) Length() time.Duration {
+	if s.intervals < 0 {
+		return 0
+	}
+	if s.stages != nil {
+		var length time.Duration
+		for _, st := range s.stages {
+			length += time.Duration(st.intervals) * st.period
+		}
+		return length
+	}
+	return time.Duration(s.intervals) * s.period
+}
+
+/* Exclusion window */
+
+// Window excludes the samples of the warm-up and cool-down phases of a run.
+type Window struct {
+	period           time.Duration
+	length           time.Duration // zero if unlimited
+	warmup, cooldown time.Duration
+	start            time.Time
+}
+
+// Window returns a window excluding the samples scheduled during the first warmup of the run
+// and, if the run has a limited duration, during its last cooldown.
+// In a staged run, sample times are rounded to the shortest period.
+func (s *Schedule) Window(warmup time.Duration, cooldown time.Duration) *Window {
+	return &Window{s.ShortestPeriod(), s.Length(), warmup, cooldown, time.Time{}}
+}
+
+// Contains reports whether a sample taken at t is to be kept.
+// The first sample given marks the start of the run. Sample times are rounded to their
+// scheduled time, so that sampling jitter does not move samples across the boundaries.
+func (w *Window) Contains(t time.Time) bool {
+	if w.start.IsZero() {
+		w.start = t
+	}
+	elapsed := t.Sub(w.start)
+	if w.period > 0 {
+		elapsed = (elapsed + w.period/2) / w.period * w.period
+	}
+	if elapsed < w.warmup {
+		return false
+	}
+	if w.length > 0 && w.cooldown > 0 && elapsed > w.length-w.cooldown {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,105 @@
+package schedule
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TestAlign runs aligned schedules, checking that every sample is scheduled at a multiple of
+// the period, and that the samples are as many as when not aligned.
+func TestAlign(t *testing.T) {
+	for _, test := range []struct {
+		period, duration time.Duration
+		samples          int
+	}{
+		{10 * time.Millisecond, 50 * time.Millisecond, 6},
+		{30 * time.Millisecond, 90 * time.Millisecond, 4},
+		{30 * time.Millisecond, 100 * time.Millisecond, 5}, // last interval completed
+		{70 * time.Millisecond, 70 * time.Millisecond, 2},
+	} {
+		s := New(test.period, test.duration, false)
+		s.Align()
+		samples := 0
+		for s.Next() {
+			samples++
+			if s.next.Truncate(test.period) != s.next {
+				t.Errorf("period %v: sample %d at %v, not aligned", test.period, samples, s.next)
+			}
+		}
+		if samples != test.samples {
+			t.Errorf("period %v, duration %v: %d samples instead of %d", test.period, test.duration, samples, test.samples)
+		}
+	}
+}
+
+// TestAlignStopped stops an aligned schedule while it waits for its first sample, which must
+// then be taken at once, as the last one.
+func TestAlignStopped(t *testing.T) {
+	s := New(time.Hour, 0, false)
+	s.Align()
+	s.Stop()
+	start := time.Now()
+	if !s.Next() {
+		t.Fatal("no sample on stop")
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("first sample waited for %v", time.Since(start))
+	}
+	if s.Next() {
+		t.Error("sample after the last one")
+	}
+}
+
+// TestWindow gives the samples of runs with a warm-up and a cool-down to their window, with
+// sampling jitter, checking which are kept.
+func TestWindow(t *testing.T) {
+	start := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	for _, test := range []struct {
+		name             string
+		s                *Schedule
+		warmup, cooldown time.Duration
+		jitter           []time.Duration // of the samples, in turn
+		kept             string          // for each sample, 1 if kept
+	}{
+		{"none", New(time.Second, 5*time.Second, false), 0, 0, nil, "111111"},
+		{"warmup", New(time.Second, 5*time.Second, false), 2 * time.Second, 0, nil, "001111"},
+		{"cooldown", New(time.Second, 5*time.Second, false), 0, 2 * time.Second, nil, "111100"},
+		{"both", New(time.Second, 5*time.Second, false), time.Second, time.Second, nil, "011110"},
+		{"jitter", New(time.Second, 5*time.Second, false), 2 * time.Second, 2 * time.Second,
+			[]time.Duration{0, 400 * time.Millisecond, -400 * time.Millisecond}, "001100"},
+		{"unlimited", New(time.Second, 0, false), time.Second, 2 * time.Second, nil, "011111"},
+		{"not a multiple", New(time.Second, 5*time.Second, false), 1500 * time.Millisecond, 1500 * time.Millisecond, nil, "001100"},
+		{"staged", mustThen(New(time.Second, 6*time.Second, false), 2*time.Second, 2*time.Second), 2 * time.Second, 2 * time.Second,
+			nil, "00110"}, // samples at 0, 1, 2, 4 and 6s
+	} {
+		w := test.s.Window(test.warmup, test.cooldown)
+		kept := ""
+		elapsed := time.Duration(0)
+		for i := 0; i < len(test.kept); i++ {
+			jitter := time.Duration(0)
+			if len(test.jitter) > 0 {
+				jitter = test.jitter[i%len(test.jitter)]
+			}
+			if i > 0 {
+				elapsed += test.s.periodOf(i)
+			}
+			if w.Contains(start.Add(elapsed + jitter)) {
+				kept += "1"
+			} else {
+				kept += "0"
+			}
+		}
+		if kept != test.kept {
+			t.Errorf("%s: kept %s instead of %s", test.name, kept, test.kept)
+		}
+	}
+}
+
+func mustThen(s *Schedule, length time.Duration, nextPeriod time.Duration) *Schedule {
+	err := s.Then(length, nextPeriod)
+	if err != nil {
+		panic(fmt.Sprint(err))
+	}
+	return s
+}
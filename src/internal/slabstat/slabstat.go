@@ -0,0 +1,309 @@
+package slabstat
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"capture"
+	"internal/schedule"
+)
+
+const (
+	defaultProcSlabinfo = "/proc/slabinfo"
+	allCaches           = "all" // instance of the totals over all caches
+	Separator           = " "
+)
+
+const (
+	sizeIdx       = iota
+	activeObjsIdx = iota
+	objsIdx       = iota
+	fieldsCount   = iota
+)
+
+// columns of /proc/slabinfo (version 2.x):
+// name <active_objs> <num_objs> <objsize> <objperslab> <pagesperslab> : tunables ... : slabdata <active_slabs> <num_slabs> <sharedavail>
+const (
+	activeObjsCol   = 1
+	numObjsCol      = 2
+	pagesPerSlabCol = 5
+	numSlabsCol     = 14
+	columnsCount    = 16
+)
+
+var allFieldsDefs = []fieldDef{
+	fieldDef{"slab", "size_kb", false},
+	fieldDef{"slab", "active_objs", false},
+	fieldDef{"slab", "objs", false},
+}
+
+/* Header is a list of field names. */
+
+type header []string
+
+func makeHeader(fdl []fieldDef) header {
+	h := header(make([]string, 2+len(fdl)))
+	h[0] = "cache"
+	h[1] = "h"
+	for i, d := range fdl {
+		h[i+2] = d.String()
+	}
+	return h
+}
+
+func (h header) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	err = writeTo(w, strings.Join(h, Separator), &n)
+	return
+}
+
+var procSlabinfo string = defaultProcSlabinfo
+var pageSizeKb uint64 = uint64(os.Getpagesize()) / 1024
+
+func warn(v ...interface{}) {
+	log.Print("WARNING: ", fmt.Sprint(v...))
+}
+
+func init() {
+	fsRoot := os.Getenv("FS_ROOT")
+	if fsRoot != "" {
+		procSlabinfo = path.Join(fsRoot, defaultProcSlabinfo)
+	}
+}
+
+func writeTo(w io.Writer, v interface{}, p *int64) (err error) {
+	m, err := w.Write([]byte(fmt.Sprint(v)))
+	*p += int64(m)
+	return
+}
+
+/* Field Definition */
+
+type fieldDef struct {
+	category      string
+	name          string
+	isAccumulator bool
+}
+
+func (fd fieldDef) String() string { // implements fmt.Stringer
+	if fd.isAccumulator {
+		return fd.category + ":" + fd.name + "/a"
+	} else {
+		return fd.category + ":" + fd.name + "/i"
+	}
+}
+
+/* Record */
+
+var Header = makeHeader(allFieldsDefs)
+
+// Schema describes the fields of the records, for typed output.
+var Schema = capture.Register(capture.Schema{Collector: "slabstat", Fields: Header[2:]})
+
+type Record struct {
+	capture.RecordInfo
+	isCumul     bool
+	fields      []uint64   // totals over all the caches
+	topCaches   int        // number of caches to keep
+	caches      []string   // names of the biggest caches, by decreasing size
+	cacheFields [][]uint64 // fields of the biggest caches
+}
+
+func newRecord(isCumul bool, topCaches int) *Record {
+	recordPtr := new(Record)
+	recordPtr.isCumul = isCumul
+	recordPtr.fields = make([]uint64, fieldsCount)
+	recordPtr.topCaches = topCaches
+	return recordPtr
+}
+
+func (recordPtr *Record) String() string { // implements fmt.Stringer
+	buf := new(bytes.Buffer)
+	recordPtr.WriteTo(buf)
+	return buf.String()
+}
+func (record Record) kind() string {
+	if record.isCumul {
+		return "a"
+	}
+	return "d"
+}
+func (record Record) writeFieldsTo(w io.Writer, cache string, fields []uint64, p *int64) (err error) {
+	err = writeTo(w, cache+Separator+record.kind(), p)
+	if err != nil {
+		return
+	}
+	for _, field := range fields {
+		err = writeTo(w, Separator, p)
+		if err != nil {
+			return
+		}
+		err = writeTo(w, field, p)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// WriteTo writes the totals on an "all" line, followed by one line per biggest cache.
+func (record Record) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	err = record.writeFieldsTo(w, allCaches, record.fields, &n)
+	if err != nil {
+		return
+	}
+	for i, cache := range record.caches {
+		err = writeTo(w, "\n", &n)
+		if err != nil {
+			return
+		}
+		err = record.writeFieldsTo(w, cache, record.cacheFields[i], &n)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+func (record Record) sample(instance string, fields []uint64) capture.Sample {
+	values := make([]uint64, fieldsCount)
+	copy(values, fields)
+	return capture.Sample{Time: record.Time, Instance: instance, Kind: record.kind(), Values: values}
+}
+
+// Samples returns the typed form of the record.
+func (record Record) Samples() []capture.Sample {
+	samples := []capture.Sample{record.sample(allCaches, record.fields)}
+	for i, cache := range record.caches {
+		samples = append(samples, record.sample(cache, record.cacheFields[i]))
+	}
+	return samples
+}
+func diffFields(fields, prevFields, diffFields []uint64) {
+	for i, field := range fields {
+		if allFieldsDefs[i].isAccumulator {
+			diffFields[i] = field - prevFields[i]
+		} else {
+			diffFields[i] = field
+		}
+	}
+}
+func (recordPtr *Record) diff(prevRecord, diffRecord *Record) {
+	diffRecord.RecordInfo = recordPtr.RecordInfo
+	diffFields(recordPtr.fields, prevRecord.fields, diffRecord.fields)
+	diffRecord.caches = recordPtr.caches
+	diffRecord.cacheFields = make([][]uint64, len(recordPtr.caches))
+	for i := range recordPtr.caches {
+		diffRecord.cacheFields[i] = make([]uint64, fieldsCount)
+		// all the fields being instant values, the previous ones are not needed
+		diffFields(recordPtr.cacheFields[i], make([]uint64, fieldsCount), diffRecord.cacheFields[i])
+	}
+	return
+}
+
+// parseLineToFields parses a cache line into the fields of the cache.
+func parseLineToFields(line string) (cache string, fields []uint64, err error) {
+	parsedFields := strings.Fields(line)
+	if len(parsedFields) < columnsCount || strings.HasPrefix(parsedFields[0], "#") {
+		return
+	}
+	values := make(map[int]uint64, 4)
+	for _, col := range []int{activeObjsCol, numObjsCol, pagesPerSlabCol, numSlabsCol} {
+		values[col], err = strconv.ParseUint(parsedFields[col], 10, 64)
+		if err != nil {
+			return
+		}
+	}
+	fields = make([]uint64, fieldsCount)
+	fields[sizeIdx] = values[numSlabsCol] * values[pagesPerSlabCol] * pageSizeKb
+	fields[activeObjsIdx] = values[activeObjsCol]
+	fields[objsIdx] = values[numObjsCol]
+	return parsedFields[0], fields, nil
+}
+
+func (recordPtr *Record) parse() (err error) {
+	defer recordPtr.EndRead(time.Now())
+	inFile, err := os.Open(procSlabinfo)
+	if err != nil {
+		return
+	}
+	defer inFile.Close()
+	recordPtr.Time = time.Now()
+	for i := range recordPtr.fields {
+		recordPtr.fields[i] = 0
+	}
+	var caches []string
+	var cacheFields [][]uint64
+	scanner := bufio.NewScanner(inFile)
+	for scanner.Scan() {
+		cache, fields, lineErr := parseLineToFields(scanner.Text())
+		if lineErr != nil {
+			return lineErr
+		}
+		if fields == nil {
+			continue
+		}
+		for i, field := range fields {
+			recordPtr.fields[i] += field
+		}
+		caches = append(caches, cache)
+		cacheFields = append(cacheFields, fields)
+	}
+	err = scanner.Err()
+	if err != nil {
+		return
+	}
+	idx := make([]int, len(caches))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(a, b int) bool { return cacheFields[idx[a]][sizeIdx] > cacheFields[idx[b]][sizeIdx] })
+	if len(idx) > recordPtr.topCaches {
+		idx = idx[:recordPtr.topCaches]
+	}
+	recordPtr.caches = make([]string, len(idx))
+	recordPtr.cacheFields = make([][]uint64, len(idx))
+	for i, j := range idx {
+		recordPtr.caches[i] = caches[j]
+		recordPtr.cacheFields[i] = cacheFields[j]
+	}
+	return
+}
+
+/* Polling */
+
+// Poll sends a Record in the channel at each sampling time of the schedule.
+// If cumul is false, it prints the diff of the accumulators, instead of the accumulators themselves
+// (all the fields being instant values, it only changes the kind of the records).
+// The topCaches biggest caches are added after the totals.
+func Poll(sched *schedule.Schedule, cumul bool, topCaches int, cout chan Record) {
+	recordPtr := newRecord(true, topCaches)
+	oldRecordPtr := newRecord(true, topCaches)
+	diffRecordPtr := newRecord(false, topCaches)
+	for i := 0; sched.Next(); i++ {
+		err := recordPtr.parse()
+		if err != nil {
+			warn("Error parsing record, ignoring: ", err)
+			continue
+		}
+		if cumul {
+			cout <- *recordPtr
+		} else {
+			if i < 1 {
+				cout <- *recordPtr
+			} else {
+				recordPtr.diff(oldRecordPtr, diffRecordPtr)
+				cout <- *diffRecordPtr
+			}
+			oldRecordPtr, recordPtr = recordPtr, oldRecordPtr
+		}
+	}
+	close(cout)
+}
@@ -0,0 +1,422 @@
+package snmpstat
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"capture"
+	"internal/schedule"
+)
+
+const (
+	defaultProcNetSnmp    = "/proc/net/snmp"
+	defaultProcNetNetstat = "/proc/net/netstat"
+	Separator             = " "
+)
+
+const (
+	ipInReceivesIdx    = iota
+	ipInHdrErrorsIdx   = iota
+	ipInAddrErrorsIdx  = iota
+	ipInDiscardsIdx    = iota
+	ipInDeliversIdx    = iota
+	ipOutRequestsIdx   = iota
+	ipOutDiscardsIdx   = iota
+	ipOutNoRoutesIdx   = iota
+	icmpInMsgsIdx      = iota
+	icmpInErrorsIdx    = iota
+	icmpOutMsgsIdx     = iota
+	icmpOutErrorsIdx   = iota
+	tcpActiveOpensIdx  = iota
+	tcpPassiveOpensIdx = iota
+	tcpAttemptFailsIdx = iota
+	tcpEstabResetsIdx  = iota
+	tcpCurrEstabIdx    = iota
+	tcpInSegsIdx       = iota
+	tcpOutSegsIdx      = iota
+	tcpRetransSegsIdx  = iota
+	tcpInErrsIdx       = iota
+	tcpOutRstsIdx      = iota
+	udpInDatagramsIdx  = iota
+	udpNoPortsIdx      = iota
+	udpInErrorsIdx     = iota
+	udpOutDatagramsIdx = iota
+	udpRcvbufErrorsIdx = iota
+	udpSndbufErrorsIdx = iota
+	// extended fields, from /proc/net/netstat
+	tcpExtListenOverflowsIdx = iota
+	tcpExtListenDropsIdx     = iota
+	tcpExtSynRetransIdx      = iota
+	tcpExtTimeoutsIdx        = iota
+	tcpExtFastRetransIdx     = iota
+	tcpExtLostRetransmitIdx  = iota
+	tcpExtAbortOnDataIdx     = iota
+	tcpExtAbortOnTimeoutIdx  = iota
+	tcpExtBacklogDropIdx     = iota
+	tcpExtPruneCalledIdx     = iota
+	tcpExtRcvPrunedIdx       = iota
+	tcpExtOfoPrunedIdx       = iota
+	tcpExtTimeWaitIdx        = iota
+	ipExtInNoRoutesIdx       = iota
+	ipExtInOctetsIdx         = iota
+	ipExtOutOctetsIdx        = iota
+	fieldsCount              = iota
+	snmpFieldsCount          = tcpExtListenOverflowsIdx // fields of /proc/net/snmp only
+)
+
+var allFieldsDefs = []fieldDef{
+	fieldDef{"ip", "in_receives", true},
+	fieldDef{"ip", "in_hdr_errors", true},
+	fieldDef{"ip", "in_addr_errors", true},
+	fieldDef{"ip", "in_discards", true},
+	fieldDef{"ip", "in_delivers", true},
+	fieldDef{"ip", "out_requests", true},
+	fieldDef{"ip", "out_discards", true},
+	fieldDef{"ip", "out_no_routes", true},
+	fieldDef{"icmp", "in_msgs", true},
+	fieldDef{"icmp", "in_errors", true},
+	fieldDef{"icmp", "out_msgs", true},
+	fieldDef{"icmp", "out_errors", true},
+	fieldDef{"tcp", "active_opens", true},
+	fieldDef{"tcp", "passive_opens", true},
+	fieldDef{"tcp", "attempt_fails", true},
+	fieldDef{"tcp", "estab_resets", true},
+	fieldDef{"tcp", "curr_estab", false},
+	fieldDef{"tcp", "in_segs", true},
+	fieldDef{"tcp", "out_segs", true},
+	fieldDef{"tcp", "retrans_segs", true},
+	fieldDef{"tcp", "in_errs", true},
+	fieldDef{"tcp", "out_rsts", true},
+	fieldDef{"udp", "in_datagrams", true},
+	fieldDef{"udp", "no_ports", true},
+	fieldDef{"udp", "in_errors", true},
+	fieldDef{"udp", "out_datagrams", true},
+	fieldDef{"udp", "rcvbuf_errors", true},
+	fieldDef{"udp", "sndbuf_errors", true},
+	fieldDef{"tcpext", "listen_overflows", true},
+	fieldDef{"tcpext", "listen_drops", true},
+	fieldDef{"tcpext", "syn_retrans", true},
+	fieldDef{"tcpext", "timeouts", true},
+	fieldDef{"tcpext", "fast_retrans", true},
+	fieldDef{"tcpext", "lost_retransmit", true},
+	fieldDef{"tcpext", "abort_on_data", true},
+	fieldDef{"tcpext", "abort_on_timeout", true},
+	fieldDef{"tcpext", "backlog_drop", true},
+	fieldDef{"tcpext", "prune_called", true},
+	fieldDef{"tcpext", "rcv_pruned", true},
+	fieldDef{"tcpext", "ofo_pruned", true},
+	fieldDef{"tcpext", "time_wait", true},
+	fieldDef{"ipext", "in_no_routes", true},
+	fieldDef{"ipext", "in_octets", true},
+	fieldDef{"ipext", "out_octets", true},
+}
+
+func init() {
+	addColumnDef("Ip", "InReceives", ipInReceivesIdx)
+	addColumnDef("Ip", "InHdrErrors", ipInHdrErrorsIdx)
+	addColumnDef("Ip", "InAddrErrors", ipInAddrErrorsIdx)
+	addColumnDef("Ip", "InDiscards", ipInDiscardsIdx)
+	addColumnDef("Ip", "InDelivers", ipInDeliversIdx)
+	addColumnDef("Ip", "OutRequests", ipOutRequestsIdx)
+	addColumnDef("Ip", "OutDiscards", ipOutDiscardsIdx)
+	addColumnDef("Ip", "OutNoRoutes", ipOutNoRoutesIdx)
+	addColumnDef("Icmp", "InMsgs", icmpInMsgsIdx)
+	addColumnDef("Icmp", "InErrors", icmpInErrorsIdx)
+	addColumnDef("Icmp", "OutMsgs", icmpOutMsgsIdx)
+	addColumnDef("Icmp", "OutErrors", icmpOutErrorsIdx)
+	addColumnDef("Tcp", "ActiveOpens", tcpActiveOpensIdx)
+	addColumnDef("Tcp", "PassiveOpens", tcpPassiveOpensIdx)
+	addColumnDef("Tcp", "AttemptFails", tcpAttemptFailsIdx)
+	addColumnDef("Tcp", "EstabResets", tcpEstabResetsIdx)
+	addColumnDef("Tcp", "CurrEstab", tcpCurrEstabIdx)
+	addColumnDef("Tcp", "InSegs", tcpInSegsIdx)
+	addColumnDef("Tcp", "OutSegs", tcpOutSegsIdx)
+	addColumnDef("Tcp", "RetransSegs", tcpRetransSegsIdx)
+	addColumnDef("Tcp", "InErrs", tcpInErrsIdx)
+	addColumnDef("Tcp", "OutRsts", tcpOutRstsIdx)
+	addColumnDef("Udp", "InDatagrams", udpInDatagramsIdx)
+	addColumnDef("Udp", "NoPorts", udpNoPortsIdx)
+	addColumnDef("Udp", "InErrors", udpInErrorsIdx)
+	addColumnDef("Udp", "OutDatagrams", udpOutDatagramsIdx)
+	addColumnDef("Udp", "RcvbufErrors", udpRcvbufErrorsIdx)
+	addColumnDef("Udp", "SndbufErrors", udpSndbufErrorsIdx)
+	addColumnDef("TcpExt", "ListenOverflows", tcpExtListenOverflowsIdx)
+	addColumnDef("TcpExt", "ListenDrops", tcpExtListenDropsIdx)
+	addColumnDef("TcpExt", "TCPSynRetrans", tcpExtSynRetransIdx)
+	addColumnDef("TcpExt", "TCPTimeouts", tcpExtTimeoutsIdx)
+	addColumnDef("TcpExt", "TCPFastRetrans", tcpExtFastRetransIdx)
+	addColumnDef("TcpExt", "TCPLostRetransmit", tcpExtLostRetransmitIdx)
+	addColumnDef("TcpExt", "TCPAbortOnData", tcpExtAbortOnDataIdx)
+	addColumnDef("TcpExt", "TCPAbortOnTimeout", tcpExtAbortOnTimeoutIdx)
+	addColumnDef("TcpExt", "TCPBacklogDrop", tcpExtBacklogDropIdx)
+	addColumnDef("TcpExt", "PruneCalled", tcpExtPruneCalledIdx)
+	addColumnDef("TcpExt", "RcvPruned", tcpExtRcvPrunedIdx)
+	addColumnDef("TcpExt", "OfoPruned", tcpExtOfoPrunedIdx)
+	addColumnDef("TcpExt", "TW", tcpExtTimeWaitIdx)
+	addColumnDef("IpExt", "InNoRoutes", ipExtInNoRoutesIdx)
+	addColumnDef("IpExt", "InOctets", ipExtInOctetsIdx)
+	addColumnDef("IpExt", "OutOctets", ipExtOutOctetsIdx)
+}
+
+/* Header is a list of field names. */
+
+type header []string
+
+func makeHeader(fdl []fieldDef) header {
+	h := header(make([]string, 1+len(fdl)))
+	h[0] = "h"
+	for i, d := range fdl {
+		h[i+1] = d.String()
+	}
+	return h
+}
+
+// fieldsDefs returns the definitions of the fields output, with the extended ones if ext is true.
+func fieldsDefs(ext bool) []fieldDef {
+	if ext {
+		return allFieldsDefs
+	}
+	return allFieldsDefs[:snmpFieldsCount]
+}
+
+// NewHeader returns the header of the records, with the extended fields if ext is true.
+func NewHeader(ext bool) io.WriterTo {
+	return makeHeader(fieldsDefs(ext))
+}
+
+// NewSchema returns the schema of the records, with the extended fields if ext is true.
+func NewSchema(ext bool) capture.Schema {
+	return capture.Schema{Collector: "snmpstat", Fields: makeHeader(fieldsDefs(ext))[1:]}
+}
+
+func (h header) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	err = writeTo(w, strings.Join(h, Separator), &n)
+	return
+}
+
+var procNetSnmp string = defaultProcNetSnmp
+var procNetNetstat string = defaultProcNetNetstat
+
+func warn(v ...interface{}) {
+	log.Print("WARNING: ", fmt.Sprint(v...))
+}
+
+func init() {
+	fsRoot := os.Getenv("FS_ROOT")
+	if fsRoot != "" {
+		procNetSnmp = path.Join(fsRoot, defaultProcNetSnmp)
+		procNetNetstat = path.Join(fsRoot, defaultProcNetNetstat)
+	}
+}
+
+// parseLinesToFields parses a pair of lines, the first one naming the columns of the second one:
+//
+//	Tcp: RtoAlgorithm RtoMin ...
+//	Tcp: 1 200 ...
+func parseLinesToFields(fileName string, namesLine string, valuesLine string, targetSlice []uint) (err error) {
+	names := strings.Fields(namesLine)
+	values := strings.Fields(valuesLine)
+	if len(names) == 0 || len(names) != len(values) || names[0] != values[0] {
+		return fmt.Errorf("mismatched lines in %s: %q, %q", fileName, namesLine, valuesLine)
+	}
+	protocol := strings.TrimSuffix(names[0], ":")
+	var uint64field uint64
+	for i, name := range names[1:] {
+		fieldIdx, ok := columnsDefs[protocol+":"+name]
+		if !ok {
+			continue
+		}
+		uint64field, err = strconv.ParseUint(values[i+1], 10, 0)
+		if err != nil {
+			return
+		}
+		targetSlice[fieldIdx] = uint(uint64field)
+	}
+	return
+}
+
+func writeTo(w io.Writer, v interface{}, p *int64) (err error) {
+	m, err := w.Write([]byte(fmt.Sprint(v)))
+	*p += int64(m)
+	return
+}
+
+/* Field Definition */
+
+type fieldDef struct {
+	category      string
+	name          string
+	isAccumulator bool
+}
+
+func (fd fieldDef) String() string { // implements fmt.Stringer
+	if fd.isAccumulator {
+		return fd.category + ":" + fd.name + "/a"
+	} else {
+		return fd.category + ":" + fd.name + "/i"
+	}
+}
+
+/* Column definition */
+
+// columnsDefs gives the field index of each "Protocol:Column" kept.
+var columnsDefs = make(map[string]uint, fieldsCount)
+
+func addColumnDef(protocol string, column string, fieldIdx uint) {
+	columnsDefs[protocol+":"+column] = fieldIdx
+}
+
+/* Record */
+
+var Header = NewHeader(false)
+
+// Schema describes the fields of the records, for typed output.
+var Schema = capture.Register(NewSchema(false))
+
+type Record struct {
+	capture.RecordInfo
+	isCumul bool
+	isExt   bool
+	fields  []uint
+}
+
+func newRecord(isCumul bool, isExt bool) *Record {
+	recordPtr := new(Record)
+	recordPtr.isCumul = isCumul
+	recordPtr.isExt = isExt
+	recordPtr.fields = make([]uint, fieldsCount)
+	return recordPtr
+}
+
+func (recordPtr *Record) String() string { // implements fmt.Stringer
+	buf := new(bytes.Buffer)
+	recordPtr.WriteTo(buf)
+	return buf.String()
+}
+func (record Record) kind() string {
+	if record.isCumul {
+		return "a"
+	}
+	return "d"
+}
+
+// values returns the fields to be output, without the extended ones if not requested.
+func (record Record) values() []uint {
+	if record.isExt {
+		return record.fields
+	}
+	return record.fields[:snmpFieldsCount]
+}
+func (record Record) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	err = writeTo(w, record.kind(), &n)
+	if err != nil {
+		return
+	}
+	for _, field := range record.values() {
+		err = writeTo(w, Separator, &n)
+		if err != nil {
+			return
+		}
+		err = writeTo(w, field, &n)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Samples returns the typed form of the record.
+func (record Record) Samples() []capture.Sample {
+	values := make([]uint64, len(record.values()))
+	for i, field := range record.values() {
+		values[i] = uint64(field)
+	}
+	return []capture.Sample{{Time: record.Time, Kind: record.kind(), Values: values}}
+}
+func (recordPtr *Record) diff(prevRecord, diffRecord *Record) {
+	diffRecord.RecordInfo = recordPtr.RecordInfo
+	for i, field := range recordPtr.fields {
+		if allFieldsDefs[i].isAccumulator {
+			diffRecord.fields[i] = field - prevRecord.fields[i]
+		} else {
+			diffRecord.fields[i] = field
+		}
+	}
+	return
+}
+
+func (recordPtr *Record) parseFile(fileName string) (err error) {
+	inFile, err := os.Open(fileName)
+	if err != nil {
+		return
+	}
+	defer inFile.Close()
+	scanner := bufio.NewScanner(inFile)
+	for scanner.Scan() {
+		namesLine := scanner.Text()
+		if !scanner.Scan() {
+			break
+		}
+		err = parseLinesToFields(fileName, namesLine, scanner.Text(), recordPtr.fields)
+		if err != nil {
+			return
+		}
+	}
+	err = scanner.Err()
+	return
+}
+
+// parse parses the protocol counters and, if the record is extended, the TcpExt and IpExt ones.
+func (recordPtr *Record) parse() (err error) {
+	defer recordPtr.EndRead(time.Now())
+	recordPtr.Time = time.Now()
+	for i, _ := range recordPtr.fields {
+		recordPtr.fields[i] = 0
+	}
+	err = recordPtr.parseFile(procNetSnmp)
+	if err != nil {
+		return
+	}
+	if recordPtr.isExt {
+		err = recordPtr.parseFile(procNetNetstat)
+	}
+	return
+}
+
+/* Polling */
+
+// Poll sends a Record in the channel at each sampling time of the schedule.
+// If cumul is false, it prints the diff of the accumulators, instead of the accumulators themselves
+// If ext is true, the extended TCP and IP counters of /proc/net/netstat are added.
+func Poll(sched *schedule.Schedule, cumul bool, ext bool, cout chan Record) {
+	recordPtr := newRecord(true, ext)
+	oldRecordPtr := newRecord(true, ext)
+	diffRecordPtr := newRecord(false, ext)
+	for i := 0; sched.Next(); i++ {
+		err := recordPtr.parse()
+		if err != nil {
+			warn("Error parsing record, ignoring: ", err)
+			continue
+		}
+		if cumul {
+			cout <- *recordPtr
+		} else {
+			if i < 1 {
+				cout <- *recordPtr
+			} else {
+				recordPtr.diff(oldRecordPtr, diffRecordPtr)
+				cout <- *diffRecordPtr
+			}
+			oldRecordPtr, recordPtr = recordPtr, oldRecordPtr
+		}
+	}
+	close(cout)
+}
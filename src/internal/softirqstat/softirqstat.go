@@ -0,0 +1,362 @@
+package softirqstat
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"capture"
+	"internal/schedule"
+)
+
+const (
+	defaultProcSoftirqs = "/proc/softirqs"
+	Separator           = " "
+)
+
+const (
+	hiIdx       = iota
+	timerIdx    = iota
+	netTxIdx    = iota
+	netRxIdx    = iota
+	blockIdx    = iota
+	irqPollIdx  = iota
+	taskletIdx  = iota
+	schedIdx    = iota
+	hrtimerIdx  = iota
+	rcuIdx      = iota
+	fieldsCount = iota
+)
+
+var allFieldsDefs = []fieldDef{
+	fieldDef{"softirq", "hi", true},
+	fieldDef{"softirq", "timer", true},
+	fieldDef{"softirq", "net_tx", true},
+	fieldDef{"softirq", "net_rx", true},
+	fieldDef{"softirq", "block", true},
+	fieldDef{"softirq", "irq_poll", true},
+	fieldDef{"softirq", "tasklet", true},
+	fieldDef{"softirq", "sched", true},
+	fieldDef{"softirq", "hrtimer", true},
+	fieldDef{"softirq", "rcu", true},
+}
+
+func init() {
+	addLineDef("HI", hiIdx)
+	addLineDef("TIMER", timerIdx)
+	addLineDef("NET_TX", netTxIdx)
+	addLineDef("NET_RX", netRxIdx)
+	addLineDef("BLOCK", blockIdx)
+	addLineDef("IRQ_POLL", irqPollIdx)
+	addLineDef("BLOCK_IOPOLL", irqPollIdx) // before Linux 4.5
+	addLineDef("TASKLET", taskletIdx)
+	addLineDef("SCHED", schedIdx)
+	addLineDef("HRTIMER", hrtimerIdx)
+	addLineDef("RCU", rcuIdx)
+}
+
+/* Header is a list of field names. */
+
+type header []string
+
+func makeHeader(fdl []fieldDef) header {
+	h := header(make([]string, 1+len(fdl)))
+	h[0] = "h"
+	for i, d := range fdl {
+		h[i+1] = d.String()
+	}
+	return h
+}
+
+func (h header) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	err = writeTo(w, strings.Join(h, Separator), &n)
+	return
+}
+
+var procSoftirqs string = defaultProcSoftirqs
+
+func warn(v ...interface{}) {
+	log.Print("WARNING: ", fmt.Sprint(v...))
+}
+
+func init() {
+	fsRoot := os.Getenv("FS_ROOT")
+	if fsRoot != "" {
+		procSoftirqs = path.Join(fsRoot, defaultProcSoftirqs)
+	}
+}
+
+func writeTo(w io.Writer, v interface{}, p *int64) (err error) {
+	m, err := w.Write([]byte(fmt.Sprint(v)))
+	*p += int64(m)
+	return
+}
+
+/* Field Definition */
+
+type fieldDef struct {
+	category      string
+	name          string
+	isAccumulator bool
+}
+
+func (fd fieldDef) String() string { // implements fmt.Stringer
+	if fd.isAccumulator {
+		return fd.category + ":" + fd.name + "/a"
+	} else {
+		return fd.category + ":" + fd.name + "/i"
+	}
+}
+
+/* Line definition */
+
+type lineDef struct {
+	prefix   string
+	fieldIdx uint
+}
+
+var linesDefs = make(map[string]lineDef, fieldsCount+1)
+
+func addLineDef(prefix string, fieldIdx uint) {
+	linesDefs[prefix] = lineDef{prefix, fieldIdx}
+}
+
+/* Record */
+
+var Header = NewHeader(false)
+
+// Schema describes the fields of the records, for typed output.
+var Schema = capture.Register(capture.Schema{Collector: "softirqstat", Fields: makeHeader(allFieldsDefs)[1:]})
+
+// NewHeader returns the header of the records, prefixed by the cpu column in percpu mode.
+func NewHeader(percpu bool) io.WriterTo {
+	h := makeHeader(allFieldsDefs)
+	if percpu {
+		h = append(header{"cpu"}, h...)
+	}
+	return h
+}
+
+type Record struct {
+	capture.RecordInfo
+	isCumul   bool
+	fields    []uint   // summed over all cpus
+	cpus      []string // cpu names, in the order of /proc/softirqs, only parsed in percpu mode
+	cpuFields [][]uint // fields per cpu, only parsed in percpu mode
+}
+
+func newRecord(isCumul bool, percpu bool) *Record {
+	recordPtr := new(Record)
+	recordPtr.isCumul = isCumul
+	recordPtr.fields = make([]uint, fieldsCount)
+	if percpu {
+		recordPtr.cpus = []string{}
+	}
+	return recordPtr
+}
+
+func (recordPtr *Record) String() string { // implements fmt.Stringer
+	buf := new(bytes.Buffer)
+	recordPtr.WriteTo(buf)
+	return buf.String()
+}
+func (record Record) kind() string {
+	if record.isCumul {
+		return "a"
+	}
+	return "d"
+}
+func (record Record) writeFieldsTo(w io.Writer, fields []uint, p *int64) (err error) {
+	err = writeTo(w, record.kind(), p)
+	if err != nil {
+		return
+	}
+	for _, field := range fields {
+		err = writeTo(w, Separator, p)
+		if err != nil {
+			return
+		}
+		err = writeTo(w, field, p)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// WriteTo writes the record on one line or, in percpu mode, on one line for all the cpus
+// ("all") followed by one line per cpu.
+func (record Record) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	if record.cpus == nil {
+		err = record.writeFieldsTo(w, record.fields, &n)
+		return
+	}
+	err = writeTo(w, "all"+Separator, &n)
+	if err != nil {
+		return
+	}
+	err = record.writeFieldsTo(w, record.fields, &n)
+	if err != nil {
+		return
+	}
+	for i, cpu := range record.cpus {
+		err = writeTo(w, "\n"+cpu+Separator, &n)
+		if err != nil {
+			return
+		}
+		err = record.writeFieldsTo(w, record.cpuFields[i], &n)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+func (record Record) sample(instance string, fields []uint) capture.Sample {
+	values := make([]uint64, len(fields))
+	for i, field := range fields {
+		values[i] = uint64(field)
+	}
+	return capture.Sample{Time: record.Time, Instance: instance, Kind: record.kind(), Values: values}
+}
+
+// Samples returns the typed form of the record.
+func (record Record) Samples() []capture.Sample {
+	if record.cpus == nil {
+		return []capture.Sample{record.sample("", record.fields)}
+	}
+	samples := []capture.Sample{record.sample("all", record.fields)}
+	for i, cpu := range record.cpus {
+		samples = append(samples, record.sample(cpu, record.cpuFields[i]))
+	}
+	return samples
+}
+func diffFields(fields, prevFields, diffFields []uint) {
+	for i, field := range fields {
+		if allFieldsDefs[i].isAccumulator {
+			diffFields[i] = field - prevFields[i]
+		} else {
+			diffFields[i] = field
+		}
+	}
+}
+func (recordPtr *Record) diff(prevRecord, diffRecord *Record) {
+	diffRecord.RecordInfo = recordPtr.RecordInfo
+	diffFields(recordPtr.fields, prevRecord.fields, diffRecord.fields)
+	if recordPtr.cpus == nil {
+		return
+	}
+	// cpus going online or offline change the columns of /proc/softirqs
+	diffRecord.cpus = recordPtr.cpus
+	diffRecord.cpuFields = make([][]uint, len(recordPtr.cpus))
+	prevCpuFields := make(map[string][]uint, len(prevRecord.cpus))
+	for i, cpu := range prevRecord.cpus {
+		prevCpuFields[cpu] = prevRecord.cpuFields[i]
+	}
+	for i, cpu := range recordPtr.cpus {
+		diffRecord.cpuFields[i] = make([]uint, fieldsCount)
+		prevFields, ok := prevCpuFields[cpu]
+		if !ok {
+			prevFields = make([]uint, fieldsCount)
+		}
+		diffFields(recordPtr.cpuFields[i], prevFields, diffRecord.cpuFields[i])
+	}
+	return
+}
+
+// parseLineToFields parses a softirq line, as "NET_RX: 4175 1023", into the sum and per cpu fields.
+func (recordPtr *Record) parseLineToFields(line string) (err error) {
+	parsedFields := strings.Fields(line)
+	if len(parsedFields) == 0 {
+		return
+	}
+	ld, ok := linesDefs[strings.TrimSuffix(parsedFields[0], ":")]
+	if !ok {
+		return
+	}
+	var uint64field uint64
+	for i, str := range parsedFields[1:] {
+		uint64field, err = strconv.ParseUint(str, 10, 0)
+		if err != nil {
+			return
+		}
+		recordPtr.fields[ld.fieldIdx] += uint(uint64field)
+		if recordPtr.cpus != nil && i < len(recordPtr.cpus) {
+			recordPtr.cpuFields[i][ld.fieldIdx] = uint(uint64field)
+		}
+	}
+	return
+}
+
+func (recordPtr *Record) parse() (err error) {
+	defer recordPtr.EndRead(time.Now())
+	inFile, err := os.Open(procSoftirqs)
+	if err != nil {
+		return
+	}
+	defer inFile.Close()
+	recordPtr.Time = time.Now()
+	for i, _ := range recordPtr.fields {
+		recordPtr.fields[i] = 0
+	}
+	scanner := bufio.NewScanner(inFile)
+	if !scanner.Scan() {
+		err = scanner.Err()
+		if err == nil {
+			err = fmt.Errorf("empty %s", procSoftirqs)
+		}
+		return
+	}
+	if recordPtr.cpus != nil {
+		// header line: CPU0 CPU1 ...
+		recordPtr.cpus = strings.Fields(strings.ToLower(scanner.Text()))
+		recordPtr.cpuFields = make([][]uint, len(recordPtr.cpus))
+		for i := range recordPtr.cpuFields {
+			recordPtr.cpuFields[i] = make([]uint, fieldsCount)
+		}
+	}
+	for scanner.Scan() {
+		err = recordPtr.parseLineToFields(scanner.Text())
+		if err != nil {
+			return
+		}
+	}
+	err = scanner.Err()
+	return
+}
+
+/* Polling */
+
+// Poll sends a Record in the channel at each sampling time of the schedule.
+// If cumul is false, it prints the diff of the accumulators, instead of the accumulators themselves
+// If percpu is true, the counts of each cpu are added, after the counts summed over all cpus.
+func Poll(sched *schedule.Schedule, cumul bool, percpu bool, cout chan Record) {
+	recordPtr := newRecord(true, percpu)
+	oldRecordPtr := newRecord(true, percpu)
+	diffRecordPtr := newRecord(false, percpu)
+	for i := 0; sched.Next(); i++ {
+		err := recordPtr.parse()
+		if err != nil {
+			warn("Error parsing record, ignoring: ", err)
+			continue
+		}
+		if cumul {
+			cout <- *recordPtr
+		} else {
+			if i < 1 {
+				cout <- *recordPtr
+			} else {
+				recordPtr.diff(oldRecordPtr, diffRecordPtr)
+				cout <- *diffRecordPtr
+			}
+			oldRecordPtr, recordPtr = recordPtr, oldRecordPtr
+		}
+	}
+	close(cout)
+}
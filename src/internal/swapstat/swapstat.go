@@ -0,0 +1,262 @@
+package swapstat
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"capture"
+	"internal/schedule"
+)
+
+const (
+	defaultProcSwaps = "/proc/swaps"
+	Separator        = " "
+)
+
+const (
+	// Filename Type Size Used Priority, then the computed change of Used
+	sizeIdx      = iota
+	usedIdx      = iota
+	priorityIdx  = iota
+	usedDeltaIdx = iota
+	fieldsCount  = iota
+)
+
+var allFieldsDefs = []fieldDef{
+	fieldDef{"swap", "size_kb", false, false},
+	fieldDef{"swap", "used_kb", false, false},
+	fieldDef{"swap", "priority", false, true},
+	fieldDef{"swap", "used_delta_kb", false, true},
+}
+
+/* Header is a list of field names. */
+
+type header []string
+
+func makeHeader(fdl []fieldDef) header {
+	h := header(make([]string, 2+len(fdl)))
+	h[0] = "device"
+	h[1] = "h"
+	for i, d := range fdl {
+		h[i+2] = d.String()
+	}
+	return h
+}
+
+func (h header) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	err = writeTo(w, strings.Join(h, Separator), &n)
+	return
+}
+
+var procSwaps string = defaultProcSwaps
+
+func warn(v ...interface{}) {
+	log.Print("WARNING: ", fmt.Sprint(v...))
+}
+
+func init() {
+	fsRoot := os.Getenv("FS_ROOT")
+	if fsRoot != "" {
+		procSwaps = path.Join(fsRoot, defaultProcSwaps)
+	}
+	capture.RegisterModel("swapstat", capture.Model{Capacities: []string{"swap:size_kb"}})
+}
+
+// parseLineToFields parses a swap device line, as "/dev/sda2 partition 8388604 1024 -2".
+func (recordPtr *Record) parseLineToFields(line string) (err error) {
+	parsedFields := strings.Fields(line)
+	if len(parsedFields) < 5 || parsedFields[0] == "Filename" {
+		return
+	}
+	fields := make([]int64, fieldsCount)
+	for i, str := range parsedFields[2:5] {
+		fields[i], err = strconv.ParseInt(str, 10, 64)
+		if err != nil {
+			return
+		}
+	}
+	recordPtr.fieldsMap[parsedFields[0]] = fields
+	return
+}
+
+func writeTo(w io.Writer, v interface{}, p *int64) (err error) {
+	m, err := w.Write([]byte(fmt.Sprint(v)))
+	*p += int64(m)
+	return
+}
+
+/* Field Definition */
+
+type fieldDef struct {
+	category      string
+	name          string
+	isAccumulator bool
+	isSigned      bool
+}
+
+func (fd fieldDef) String() string { // implements fmt.Stringer
+	if fd.isAccumulator {
+		return fd.category + ":" + fd.name + "/a"
+	} else if fd.isSigned {
+		return fd.category + ":" + fd.name + capture.SignedSuffix
+	} else {
+		return fd.category + ":" + fd.name + "/i"
+	}
+}
+
+/* Record */
+
+var Header = makeHeader(allFieldsDefs)
+
+// Schema describes the fields of the records, for typed output.
+// The priority and the change of used space may be negative: they are signed fields.
+var Schema = capture.Register(capture.Schema{Collector: "swapstat", Fields: Header[2:]})
+
+type Record struct {
+	capture.RecordInfo
+	isCumul   bool
+	fieldsMap map[string][]int64 // key is the swap device or file name
+}
+
+func newRecord(isCumul bool) *Record {
+	recordPtr := new(Record)
+	recordPtr.isCumul = isCumul
+	recordPtr.fieldsMap = make(map[string][]int64)
+	return recordPtr
+}
+
+func (record Record) deviceNames() []string {
+	names := make([]string, 0, len(record.fieldsMap))
+	for name := range record.fieldsMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (recordPtr *Record) String() string { // implements fmt.Stringer
+	buf := new(bytes.Buffer)
+	recordPtr.WriteTo(buf)
+	return buf.String()
+}
+func (record Record) kind() string {
+	if record.isCumul {
+		return "a"
+	}
+	return "d"
+}
+func (record Record) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	for i, device := range record.deviceNames() {
+		if i > 0 {
+			err = writeTo(w, "\n", &n)
+			if err != nil {
+				return
+			}
+		}
+		err = writeTo(w, device+Separator+record.kind(), &n)
+		if err != nil {
+			return
+		}
+		for _, field := range record.fieldsMap[device] {
+			err = writeTo(w, Separator, &n)
+			if err != nil {
+				return
+			}
+			err = writeTo(w, field, &n)
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// Samples returns the typed form of the record, one sample per swap device.
+func (record Record) Samples() []capture.Sample {
+	samples := make([]capture.Sample, 0, len(record.fieldsMap))
+	for _, device := range record.deviceNames() {
+		values := make([]uint64, fieldsCount)
+		for i, field := range record.fieldsMap[device] {
+			values[i] = uint64(field)
+		}
+		samples = append(samples, capture.Sample{Time: record.Time, Instance: device, Kind: record.kind(), Values: values})
+	}
+	return samples
+}
+
+// diff copies the fields, all instant values, and sets the change of the used space since
+// the previous record (the whole used space for a device just activated).
+func (recordPtr *Record) diff(prevRecord, diffRecord *Record) {
+	diffRecord.RecordInfo = recordPtr.RecordInfo
+	diffRecord.fieldsMap = make(map[string][]int64, len(recordPtr.fieldsMap))
+	for device, fields := range recordPtr.fieldsMap {
+		diffFields := make([]int64, fieldsCount)
+		copy(diffFields, fields)
+		diffFields[usedDeltaIdx] = fields[usedIdx]
+		prevFields, ok := prevRecord.fieldsMap[device]
+		if ok {
+			diffFields[usedDeltaIdx] -= prevFields[usedIdx]
+		}
+		diffRecord.fieldsMap[device] = diffFields
+	}
+	return
+}
+
+func (recordPtr *Record) parse() (err error) {
+	defer recordPtr.EndRead(time.Now())
+	inFile, err := os.Open(procSwaps)
+	if err != nil {
+		return
+	}
+	defer inFile.Close()
+	recordPtr.Time = time.Now()
+	recordPtr.fieldsMap = make(map[string][]int64, len(recordPtr.fieldsMap))
+	scanner := bufio.NewScanner(inFile)
+	for scanner.Scan() {
+		err = recordPtr.parseLineToFields(scanner.Text())
+		if err != nil {
+			return
+		}
+	}
+	err = scanner.Err()
+	return
+}
+
+/* Polling */
+
+// Poll sends a Record in the channel at each sampling time of the schedule.
+// If cumul is false, it prints the diff of the accumulators, instead of the accumulators themselves
+// (all the fields being instant values, only the change of the used space is then computed).
+func Poll(sched *schedule.Schedule, cumul bool, cout chan Record) {
+	recordPtr := newRecord(true)
+	oldRecordPtr := newRecord(true)
+	diffRecordPtr := newRecord(false)
+	for i := 0; sched.Next(); i++ {
+		err := recordPtr.parse()
+		if err != nil {
+			warn("Error parsing record, ignoring: ", err)
+			continue
+		}
+		if cumul {
+			cout <- *recordPtr
+		} else {
+			if i < 1 {
+				cout <- *recordPtr
+			} else {
+				recordPtr.diff(oldRecordPtr, diffRecordPtr)
+				cout <- *diffRecordPtr
+			}
+			oldRecordPtr, recordPtr = recordPtr, oldRecordPtr
+		}
+	}
+	close(cout)
+}
@@ -0,0 +1,119 @@
+package tcprtt
+
+import (
+	"encoding/binary"
+	"syscall"
+)
+
+const (
+	// sock_diag (linux/sock_diag.h, linux/inet_diag.h, linux/tcp.h)
+	netlinkSockDiag  = 4
+	sockDiagByFamily = 20
+	inetDiagReqLen   = 56 // sizeof(struct inet_diag_req_v2)
+	inetDiagMsgLen   = 72 // sizeof(struct inet_diag_msg)
+	inetDiagInfo     = 2
+	tcpEstablished   = 1
+	tcpInfoRttOff    = 68 // offset of tcpi_rtt in struct tcp_info
+	inetDiagSportOff = 4  // offsets of the ports (big endian) in struct inet_diag_msg
+	inetDiagDportOff = 6
+	recvBufSize      = 1 << 20
+)
+
+var nativeEndian = binary.NativeEndian
+
+// request returns the request of a dump of the established TCP sockets of the family, with
+// their tcp_info.
+func request(family uint8) []byte {
+	b := make([]byte, syscall.NLMSG_HDRLEN+inetDiagReqLen)
+	nativeEndian.PutUint32(b[0:4], uint32(len(b)))
+	nativeEndian.PutUint16(b[4:6], sockDiagByFamily)
+	nativeEndian.PutUint16(b[6:8], syscall.NLM_F_REQUEST|syscall.NLM_F_DUMP)
+	req := b[syscall.NLMSG_HDRLEN:]
+	req[0] = family
+	req[1] = syscall.IPPROTO_TCP
+	req[2] = 1 << (inetDiagInfo - 1)
+	nativeEndian.PutUint32(req[4:8], 1<<tcpEstablished)
+	return b
+}
+
+// parseAttrs returns the payload of the attributes found in b, by type.
+func parseAttrs(b []byte) map[uint16][]byte {
+	attrs := make(map[uint16][]byte)
+	for len(b) >= syscall.SizeofRtAttr {
+		attrLen := int(nativeEndian.Uint16(b[0:2]))
+		if attrLen < syscall.SizeofRtAttr || attrLen > len(b) {
+			break
+		}
+		attrs[nativeEndian.Uint16(b[2:4])] = b[syscall.SizeofRtAttr:attrLen]
+		alignedLen := (attrLen + syscall.RTA_ALIGNTO - 1) & ^(syscall.RTA_ALIGNTO - 1)
+		if alignedLen > len(b) {
+			break
+		}
+		b = b[alignedLen:]
+	}
+	return attrs
+}
+
+// dumpFamily appends the established TCP connections of the family to conns.
+func dumpFamily(fd int, family uint8, conns []conn) ([]conn, error) {
+	err := syscall.Sendto(fd, request(family), 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK})
+	if err != nil {
+		return conns, err
+	}
+	buf := make([]byte, 1<<16)
+	for {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return conns, err
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return conns, err
+		}
+		for _, m := range msgs {
+			switch m.Header.Type {
+			case syscall.NLMSG_DONE:
+				return conns, nil
+			case syscall.NLMSG_ERROR:
+				if len(m.Data) >= 4 {
+					if errno := int32(nativeEndian.Uint32(m.Data[0:4])); errno != 0 {
+						return conns, syscall.Errno(-errno)
+					}
+				}
+				continue
+			}
+			if len(m.Data) < inetDiagMsgLen {
+				continue
+			}
+			info, ok := parseAttrs(m.Data[inetDiagMsgLen:])[inetDiagInfo]
+			if !ok || len(info) < tcpInfoRttOff+4 {
+				continue
+			}
+			conns = append(conns, conn{
+				sport: binary.BigEndian.Uint16(m.Data[inetDiagSportOff:]),
+				dport: binary.BigEndian.Uint16(m.Data[inetDiagDportOff:]),
+				rttUs: nativeEndian.Uint32(info[tcpInfoRttOff:]),
+			})
+		}
+	}
+}
+
+// dump returns the established TCP connections (IPv4 and IPv6), with their smoothed RTT.
+func dump() (conns []conn, err error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_DGRAM, netlinkSockDiag)
+	if err != nil {
+		return
+	}
+	defer syscall.Close(fd)
+	err = syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF, recvBufSize)
+	if err != nil {
+		return
+	}
+	for _, family := range []uint8{syscall.AF_INET, syscall.AF_INET6} {
+		conns, err = dumpFamily(fd, family, conns)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
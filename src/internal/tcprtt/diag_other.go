@@ -0,0 +1,9 @@
+//go:build !linux
+
+package tcprtt
+
+import "errors"
+
+func dump() (conns []conn, err error) {
+	return nil, errors.New("Not supported on this platform")
+}
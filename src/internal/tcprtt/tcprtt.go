@@ -0,0 +1,264 @@
+package tcprtt
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"capture"
+	"internal/schedule"
+)
+
+const (
+	Separator = " "
+)
+
+const (
+	connsIdx   = iota
+	p50Idx     = iota
+	p90Idx     = iota
+	p99Idx     = iota
+	maxIdx     = iota
+	bucketsIdx = iota // first bucket of the histogram
+)
+
+// bucketBounds are the upper bounds (excluded) of the buckets of the histogram, in us, the last
+// bucket counting the RTT over the last bound.
+var bucketBounds = []uint32{100, 250, 500, 1000, 2500, 5000, 10000, 25000, 50000, 100000, 250000, 500000}
+
+var fieldsCount = bucketsIdx + len(bucketBounds) + 1
+
+// The RTT are the smoothed RTT of the established connections, as estimated by the kernel
+// (tcpi_rtt), read at each sampling time: passive, no probe being sent.
+var allFieldsDefs = makeFieldsDefs()
+
+func makeFieldsDefs() []fieldDef {
+	fdl := []fieldDef{
+		fieldDef{"rtt", "conns", false},
+		fieldDef{"rtt", "p50_us", false},
+		fieldDef{"rtt", "p90_us", false},
+		fieldDef{"rtt", "p99_us", false},
+		fieldDef{"rtt", "max_us", false},
+	}
+	for _, bound := range bucketBounds {
+		fdl = append(fdl, fieldDef{"rtt", "lt_" + strconv.Itoa(int(bound)) + "us", false})
+	}
+	last := bucketBounds[len(bucketBounds)-1]
+	return append(fdl, fieldDef{"rtt", "ge_" + strconv.Itoa(int(last)) + "us", false})
+}
+
+/* Header is a list of field names. */
+
+type header []string
+
+func makeHeader(fdl []fieldDef) header {
+	h := header(make([]string, 1+len(fdl)))
+	h[0] = "h"
+	for i, d := range fdl {
+		h[i+1] = d.String()
+	}
+	return h
+}
+
+func (h header) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	err = writeTo(w, strings.Join(h, Separator), &n)
+	return
+}
+
+func warn(v ...interface{}) {
+	log.Print("WARNING: ", fmt.Sprint(v...))
+}
+
+func writeTo(w io.Writer, v interface{}, p *int64) (err error) {
+	m, err := w.Write([]byte(fmt.Sprint(v)))
+	*p += int64(m)
+	return
+}
+
+/* Field Definition */
+
+type fieldDef struct {
+	category      string
+	name          string
+	isAccumulator bool
+}
+
+func (fd fieldDef) String() string { // implements fmt.Stringer
+	if fd.isAccumulator {
+		return fd.category + ":" + fd.name + "/a"
+	} else {
+		return fd.category + ":" + fd.name + "/i"
+	}
+}
+
+/* Connections */
+
+// conn is an established TCP connection, with its local and remote ports.
+type conn struct {
+	sport, dport uint16
+	rttUs        uint32
+}
+
+// ParsePorts returns the ports of a comma separated list, as "443,5432".
+func ParsePorts(list string) (ports []uint16, err error) {
+	for _, str := range strings.Split(list, ",") {
+		if str == "" {
+			continue
+		}
+		port, err := strconv.ParseUint(str, 10, 16)
+		if err != nil {
+			return nil, err
+		}
+		ports = append(ports, uint16(port))
+	}
+	return
+}
+
+// matches reports whether the local or remote port of the connection is one of the ports, all
+// the connections matching if there are none.
+func (c conn) matches(ports []uint16) bool {
+	if len(ports) == 0 {
+		return true
+	}
+	for _, port := range ports {
+		if c.sport == port || c.dport == port {
+			return true
+		}
+	}
+	return false
+}
+
+// percentile returns the value of the sorted values under which are the pct of them.
+func percentile(sorted []uint32, pct int) uint64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return uint64(sorted[(len(sorted)-1)*pct/100])
+}
+
+/* Record */
+
+var Header = makeHeader(allFieldsDefs)
+
+// Schema describes the fields of the records, for typed output.
+var Schema = capture.Register(capture.Schema{Collector: "tcprtt", Fields: Header[1:]})
+
+type Record struct {
+	capture.RecordInfo
+	isCumul bool
+	fields  []uint64
+}
+
+func newRecord(isCumul bool) *Record {
+	recordPtr := new(Record)
+	recordPtr.isCumul = isCumul
+	recordPtr.fields = make([]uint64, fieldsCount)
+	return recordPtr
+}
+
+func (recordPtr *Record) String() string { // implements fmt.Stringer
+	buf := new(bytes.Buffer)
+	recordPtr.WriteTo(buf)
+	return buf.String()
+}
+func (record Record) kind() string {
+	if record.isCumul {
+		return "a"
+	}
+	return "d"
+}
+func (record Record) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	err = writeTo(w, record.kind(), &n)
+	if err != nil {
+		return
+	}
+	for _, field := range record.fields {
+		err = writeTo(w, Separator, &n)
+		if err != nil {
+			return
+		}
+		err = writeTo(w, field, &n)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Samples returns the typed form of the record.
+func (record Record) Samples() []capture.Sample {
+	values := make([]uint64, fieldsCount)
+	copy(values, record.fields)
+	return []capture.Sample{{Time: record.Time, Kind: record.kind(), Values: values}}
+}
+
+// diff copies the fields, all instant values.
+func (recordPtr *Record) diff(prevRecord, diffRecord *Record) {
+	diffRecord.RecordInfo = recordPtr.RecordInfo
+	copy(diffRecord.fields, recordPtr.fields)
+	return
+}
+
+// parse builds the histogram of the RTT of the connections matching the ports.
+func (recordPtr *Record) parse(ports []uint16) (err error) {
+	defer recordPtr.EndRead(time.Now())
+	conns, err := dump()
+	if err != nil {
+		return
+	}
+	recordPtr.Time = time.Now()
+	for i := range recordPtr.fields {
+		recordPtr.fields[i] = 0
+	}
+	rtts := make([]uint32, 0, len(conns))
+	for _, c := range conns {
+		if !c.matches(ports) {
+			continue
+		}
+		rtts = append(rtts, c.rttUs)
+		bucket := sort.Search(len(bucketBounds), func(i int) bool { return c.rttUs < bucketBounds[i] })
+		recordPtr.fields[bucketsIdx+bucket]++
+	}
+	sort.Slice(rtts, func(a, b int) bool { return rtts[a] < rtts[b] })
+	recordPtr.fields[connsIdx] = uint64(len(rtts))
+	recordPtr.fields[p50Idx] = percentile(rtts, 50)
+	recordPtr.fields[p90Idx] = percentile(rtts, 90)
+	recordPtr.fields[p99Idx] = percentile(rtts, 99)
+	recordPtr.fields[maxIdx] = percentile(rtts, 100)
+	return
+}
+
+/* Polling */
+
+// Poll sends a Record of the established TCP connections of which the local or remote port is
+// one of the ports (all if none) in the channel at each sampling time of the schedule.
+// All the fields being instant values, cumul only changes the kind of the records.
+func Poll(sched *schedule.Schedule, ports []uint16, cumul bool, cout chan Record) {
+	recordPtr := newRecord(true)
+	oldRecordPtr := newRecord(true)
+	diffRecordPtr := newRecord(false)
+	for i := 0; sched.Next(); i++ {
+		err := recordPtr.parse(ports)
+		if err != nil {
+			warn("Error parsing record, ignoring: ", err)
+			continue
+		}
+		if cumul {
+			cout <- *recordPtr
+		} else {
+			if i < 1 {
+				cout <- *recordPtr
+			} else {
+				recordPtr.diff(oldRecordPtr, diffRecordPtr)
+				cout <- *diffRecordPtr
+			}
+			oldRecordPtr, recordPtr = recordPtr, oldRecordPtr
+		}
+	}
+	close(cout)
+}
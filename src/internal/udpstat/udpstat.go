@@ -0,0 +1,329 @@
+package udpstat
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"capture"
+	"internal/schedule"
+)
+
+const (
+	defaultProcDir = "/proc"
+	Separator      = " "
+	allPorts       = "all"
+)
+
+const (
+	socketsIdx  = iota
+	rxQueueIdx  = iota
+	txQueueIdx  = iota
+	dropsIdx    = iota
+	fieldsCount = iota
+)
+
+// The sockets are those of /proc/net/udp and udp6, counted per local port. The queues are the
+// bytes waiting to be read by the application (rx) or sent (tx), the drops are the datagrams
+// dropped since the sockets were opened, a receive queue full being the usual cause.
+var allFieldsDefs = []fieldDef{
+	fieldDef{"udp", "sockets", false},
+	fieldDef{"queue", "rx_bytes", false},
+	fieldDef{"queue", "tx_bytes", false},
+	fieldDef{"udp", "drops", true},
+}
+
+// DefaultTop is the number of ports given after the "all" line, those of the longest receive
+// queues.
+const DefaultTop = 5
+
+/* Header is a list of field names. */
+
+type header []string
+
+func makeHeader(fdl []fieldDef) header {
+	h := header(make([]string, 2+len(fdl)))
+	h[0] = "port"
+	h[1] = "h"
+	for i, d := range fdl {
+		h[i+2] = d.String()
+	}
+	return h
+}
+
+func (h header) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	err = writeTo(w, strings.Join(h, Separator), &n)
+	return
+}
+
+var procDir string = defaultProcDir
+
+func warn(v ...interface{}) {
+	log.Print("WARNING: ", fmt.Sprint(v...))
+}
+
+func init() {
+	fsRoot := os.Getenv("FS_ROOT")
+	if fsRoot != "" {
+		procDir = path.Join(fsRoot, defaultProcDir)
+	}
+}
+
+func writeTo(w io.Writer, v interface{}, p *int64) (err error) {
+	m, err := w.Write([]byte(fmt.Sprint(v)))
+	*p += int64(m)
+	return
+}
+
+/* Field Definition */
+
+type fieldDef struct {
+	category      string
+	name          string
+	isAccumulator bool
+}
+
+func (fd fieldDef) String() string { // implements fmt.Stringer
+	if fd.isAccumulator {
+		return fd.category + ":" + fd.name + "/a"
+	} else {
+		return fd.category + ":" + fd.name + "/i"
+	}
+}
+
+/* Record */
+
+var Header = makeHeader(allFieldsDefs)
+
+// Schema describes the fields of the records, for typed output.
+var Schema = capture.Register(capture.Schema{Collector: "udpstat", Fields: Header[2:]})
+
+type Record struct {
+	capture.RecordInfo
+	isCumul     bool
+	portsFields map[string][]uint64 // per local port, all ports included
+	top         []string            // ports given after the "all" line, in order
+}
+
+func newRecord(isCumul bool) *Record {
+	recordPtr := new(Record)
+	recordPtr.isCumul = isCumul
+	recordPtr.portsFields = make(map[string][]uint64)
+	return recordPtr
+}
+
+// ports returns "all" followed by the ports of the longest receive queues.
+func (record Record) ports() []string {
+	return append([]string{allPorts}, record.top...)
+}
+
+func (recordPtr *Record) String() string { // implements fmt.Stringer
+	buf := new(bytes.Buffer)
+	recordPtr.WriteTo(buf)
+	return buf.String()
+}
+func (record Record) kind() string {
+	if record.isCumul {
+		return "a"
+	}
+	return "d"
+}
+
+// WriteTo writes one line for all the ports, then one line per port of the top.
+func (record Record) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	for i, port := range record.ports() {
+		if i > 0 {
+			err = writeTo(w, "\n", &n)
+			if err != nil {
+				return
+			}
+		}
+		err = writeTo(w, port+Separator+record.kind(), &n)
+		if err != nil {
+			return
+		}
+		for _, field := range record.fieldsOf(port) {
+			err = writeTo(w, Separator, &n)
+			if err != nil {
+				return
+			}
+			err = writeTo(w, field, &n)
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+func (record Record) fieldsOf(port string) []uint64 {
+	fields, ok := record.portsFields[port]
+	if !ok {
+		fields = make([]uint64, fieldsCount)
+	}
+	return fields
+}
+
+// Samples returns the typed form of the record, one sample per port of the top, after the
+// "all" sample.
+func (record Record) Samples() []capture.Sample {
+	ports := record.ports()
+	samples := make([]capture.Sample, 0, len(ports))
+	for _, port := range ports {
+		values := make([]uint64, fieldsCount)
+		copy(values, record.fieldsOf(port))
+		samples = append(samples, capture.Sample{Time: record.Time, Instance: port, Kind: record.kind(), Values: values})
+	}
+	return samples
+}
+
+// diff computes the diffs of the drops, those of the sockets closed since the previous record
+// being lost: the drops of a port are then counted from zero.
+func (recordPtr *Record) diff(prevRecord, diffRecord *Record) {
+	diffRecord.RecordInfo = recordPtr.RecordInfo
+	diffRecord.top = recordPtr.top
+	diffRecord.portsFields = make(map[string][]uint64, len(recordPtr.portsFields))
+	for port, fields := range recordPtr.portsFields {
+		prevFields := prevRecord.fieldsOf(port)
+		diffFields := make([]uint64, fieldsCount)
+		for i, field := range fields {
+			if allFieldsDefs[i].isAccumulator && field >= prevFields[i] {
+				diffFields[i] = field - prevFields[i]
+			} else {
+				diffFields[i] = field
+			}
+		}
+		diffRecord.portsFields[port] = diffFields
+	}
+	return
+}
+
+// parseSockets adds the sockets of /proc/net/udp or udp6 to their local ports and to all, from
+// the lines as "1: 00000000:0044 00000000:0000 07 00000000:00000000 00:00000000 00000000 0 0
+// 16324 2 0000000000000000 0", the ports and queues being given in hexadecimal.
+func (recordPtr *Record) parseSockets(fileName string) (err error) {
+	inFile, err := os.Open(fileName)
+	if os.IsNotExist(err) {
+		return nil // no IPv6
+	}
+	if err != nil {
+		return
+	}
+	defer inFile.Close()
+	scanner := bufio.NewScanner(inFile)
+	for scanner.Scan() {
+		parsedFields := strings.Fields(scanner.Text())
+		if len(parsedFields) < 13 || parsedFields[0] == "sl" {
+			continue
+		}
+		sep := strings.LastIndexByte(parsedFields[1], ':')
+		queues := strings.Split(parsedFields[4], ":")
+		if sep < 0 || len(queues) != 2 {
+			continue
+		}
+		port, err := strconv.ParseUint(parsedFields[1][sep+1:], 16, 16)
+		if err != nil {
+			return err
+		}
+		fields := make([]uint64, fieldsCount)
+		fields[socketsIdx] = 1
+		fields[txQueueIdx], err = strconv.ParseUint(queues[0], 16, 64)
+		if err != nil {
+			return err
+		}
+		fields[rxQueueIdx], err = strconv.ParseUint(queues[1], 16, 64)
+		if err != nil {
+			return err
+		}
+		fields[dropsIdx], err = strconv.ParseUint(parsedFields[12], 10, 64)
+		if err != nil {
+			return err
+		}
+		for _, key := range []string{strconv.FormatUint(port, 10), allPorts} {
+			sums, ok := recordPtr.portsFields[key]
+			if !ok {
+				sums = make([]uint64, fieldsCount)
+				recordPtr.portsFields[key] = sums
+			}
+			for i, field := range fields {
+				sums[i] += field
+			}
+		}
+	}
+	err = scanner.Err()
+	return
+}
+
+// selectTop selects the top ports of the longest receive queues, the ports of empty queues
+// being left out.
+func (recordPtr *Record) selectTop(top int) {
+	recordPtr.top = nil
+	for port, fields := range recordPtr.portsFields {
+		if port != allPorts && fields[rxQueueIdx] > 0 {
+			recordPtr.top = append(recordPtr.top, port)
+		}
+	}
+	sort.Slice(recordPtr.top, func(i, j int) bool {
+		qi := recordPtr.portsFields[recordPtr.top[i]][rxQueueIdx]
+		qj := recordPtr.portsFields[recordPtr.top[j]][rxQueueIdx]
+		if qi != qj {
+			return qi > qj
+		}
+		return recordPtr.top[i] < recordPtr.top[j]
+	})
+	if len(recordPtr.top) > top {
+		recordPtr.top = recordPtr.top[:top]
+	}
+}
+
+func (recordPtr *Record) parse(top int) (err error) {
+	defer recordPtr.EndRead(time.Now())
+	recordPtr.Time = time.Now()
+	recordPtr.portsFields = map[string][]uint64{allPorts: make([]uint64, fieldsCount)}
+	for _, name := range []string{"net/udp", "net/udp6"} {
+		err = recordPtr.parseSockets(path.Join(procDir, name))
+		if err != nil {
+			return
+		}
+	}
+	recordPtr.selectTop(top)
+	return
+}
+
+/* Polling */
+
+// Poll sends a Record in the channel at each sampling time of the schedule, with the top ports
+// of the longest receive queues after the line of all the ports.
+// If cumul is false, it prints the diff of the accumulators, instead of the accumulators themselves.
+func Poll(sched *schedule.Schedule, top int, cumul bool, cout chan Record) {
+	recordPtr := newRecord(true)
+	oldRecordPtr := newRecord(true)
+	diffRecordPtr := newRecord(false)
+	for i := 0; sched.Next(); i++ {
+		err := recordPtr.parse(top)
+		if err != nil {
+			warn("Error parsing record, ignoring: ", err)
+			continue
+		}
+		if cumul {
+			cout <- *recordPtr
+		} else {
+			if i < 1 {
+				cout <- *recordPtr
+			} else {
+				recordPtr.diff(oldRecordPtr, diffRecordPtr)
+				cout <- *diffRecordPtr
+			}
+			oldRecordPtr, recordPtr = recordPtr, oldRecordPtr
+		}
+	}
+	close(cout)
+}
@@ -0,0 +1,324 @@
+package userstat
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"capture"
+	"internal/schedule"
+	"system/hostproc"
+)
+
+const (
+	defaultProcDir = "/proc"
+	Separator      = " "
+)
+
+const (
+	utimeIdx    = iota
+	stimeIdx    = iota
+	rssIdx      = iota
+	procsIdx    = iota
+	threadsIdx  = iota
+	fieldsCount = iota
+)
+
+var allFieldsDefs = []fieldDef{
+	fieldDef{"cpu", "utime", true},
+	fieldDef{"cpu", "stime", true},
+	fieldDef{"mem", "rss_kb", false},
+	fieldDef{"proc", "count", false},
+	fieldDef{"proc", "threads", false},
+}
+
+/* Header is a list of field names. */
+
+type header []string
+
+func makeHeader(fdl []fieldDef) header {
+	h := header(make([]string, 2+len(fdl)))
+	h[0] = "user"
+	h[1] = "h"
+	for i, d := range fdl {
+		h[i+2] = d.String()
+	}
+	return h
+}
+
+func (h header) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	err = writeTo(w, strings.Join(h, Separator), &n)
+	return
+}
+
+var procDir string = defaultProcDir
+
+func warn(v ...interface{}) {
+	log.Print("WARNING: ", fmt.Sprint(v...))
+}
+
+func warnf(format string, v ...interface{}) {
+	log.Printf("WARNING: "+format, v...)
+}
+
+func init() {
+	fsRoot := os.Getenv("FS_ROOT")
+	if fsRoot != "" {
+		procDir = path.Join(fsRoot, defaultProcDir)
+	}
+}
+
+func writeTo(w io.Writer, v interface{}, p *int64) (err error) {
+	m, err := w.Write([]byte(fmt.Sprint(v)))
+	*p += int64(m)
+	return
+}
+
+/* Field Definition */
+
+type fieldDef struct {
+	category      string
+	name          string
+	isAccumulator bool
+}
+
+func (fd fieldDef) String() string { // implements fmt.Stringer
+	if fd.isAccumulator {
+		return fd.category + ":" + fd.name + "/a"
+	} else {
+		return fd.category + ":" + fd.name + "/i"
+	}
+}
+
+/* Record */
+
+var Header = makeHeader(allFieldsDefs)
+
+// Schema describes the fields of the records, for typed output.
+var Schema = capture.Register(capture.Schema{Collector: "userstat", Fields: Header[2:]})
+
+// process is the user and the fields of a process, of which the record counts one process.
+type process struct {
+	user   string
+	fields []uint64
+}
+
+type Record struct {
+	capture.RecordInfo
+	isCumul, isRel bool
+	processes      map[int]process     // per pid
+	usersFields    map[string][]uint64 // sums per user
+}
+
+func newRecord(isCumul, isRel bool) *Record {
+	recordPtr := new(Record)
+	recordPtr.isCumul = isCumul
+	recordPtr.isRel = isRel
+	recordPtr.processes = make(map[int]process)
+	recordPtr.usersFields = make(map[string][]uint64)
+	return recordPtr
+}
+
+func (record Record) users() []string {
+	users := make([]string, 0, len(record.usersFields))
+	for user := range record.usersFields {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+	return users
+}
+
+func (recordPtr *Record) String() string { // implements fmt.Stringer
+	buf := new(bytes.Buffer)
+	recordPtr.WriteTo(buf)
+	return buf.String()
+}
+func (record Record) kind() string {
+	if record.isCumul {
+		return "a"
+	}
+	if record.isRel {
+		return "p"
+	}
+	return "d"
+}
+
+// WriteTo writes one line per user having processes.
+func (record Record) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	for i, user := range record.users() {
+		if i > 0 {
+			err = writeTo(w, "\n", &n)
+			if err != nil {
+				return
+			}
+		}
+		err = writeTo(w, user+Separator+record.kind(), &n)
+		if err != nil {
+			return
+		}
+		for _, field := range record.usersFields[user] {
+			err = writeTo(w, Separator, &n)
+			if err != nil {
+				return
+			}
+			err = writeTo(w, field, &n)
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// Samples returns the typed form of the record, one sample per user.
+func (record Record) Samples() []capture.Sample {
+	samples := make([]capture.Sample, 0, len(record.usersFields))
+	for _, user := range record.users() {
+		values := make([]uint64, fieldsCount)
+		copy(values, record.usersFields[user])
+		samples = append(samples, capture.Sample{Time: record.Time, Instance: user, Kind: record.kind(), Values: values})
+	}
+	return samples
+}
+
+// sumUsers computes the sums per user of the fields of the processes.
+func (recordPtr *Record) sumUsers() {
+	recordPtr.usersFields = make(map[string][]uint64)
+	for _, proc := range recordPtr.processes {
+		fields, ok := recordPtr.usersFields[proc.user]
+		if !ok {
+			fields = make([]uint64, fieldsCount)
+			recordPtr.usersFields[proc.user] = fields
+		}
+		for i, field := range proc.fields {
+			fields[i] += field
+		}
+	}
+}
+
+// diff computes the diffs of each process, a process started since the previous record having
+// all its accumulators counted in the interval, then sums them per user, not to be affected
+// by the processes gone.
+func (recordPtr *Record) diff(prevRecord, diffRecord *Record) {
+	diffRecord.RecordInfo = recordPtr.RecordInfo
+	diffRecord.processes = make(map[int]process, len(recordPtr.processes))
+	for pid, proc := range recordPtr.processes {
+		prevFields := make([]uint64, fieldsCount)
+		if prevProc, ok := prevRecord.processes[pid]; ok && prevProc.user == proc.user {
+			prevFields = prevProc.fields
+		}
+		diffFields := make([]uint64, fieldsCount)
+		for i, field := range proc.fields {
+			if allFieldsDefs[i].isAccumulator {
+				diffRecord.CounterReset = diffRecord.CounterReset || field < prevFields[i]
+				diffFields[i] = field - prevFields[i]
+			} else {
+				diffFields[i] = field
+			}
+		}
+		diffRecord.processes[pid] = process{proc.user, diffFields}
+	}
+	diffRecord.sumUsers()
+	return
+}
+
+// rel converts the cpu times into percentages of the elapsed time of one cpu.
+func (diffRecordPtr *Record) rel(elapsed time.Duration) {
+	base := uint64(elapsed) * hostproc.ClkTck()
+	if base == 0 {
+		return
+	}
+	for _, fields := range diffRecordPtr.usersFields {
+		for _, i := range []int{utimeIdx, stimeIdx} {
+			fields[i] = fields[i] * 100 * uint64(time.Second) / base
+		}
+	}
+	return
+}
+
+func parsePid(pid int) (proc process, err error) {
+	uid, err := hostproc.ReadUid(pid)
+	if err != nil {
+		return
+	}
+	st, err := hostproc.ReadStat(pid)
+	if err != nil {
+		return
+	}
+	proc = process{hostproc.UserName(uid), make([]uint64, fieldsCount)}
+	proc.fields[utimeIdx] = st.Utime
+	proc.fields[stimeIdx] = st.Stime
+	proc.fields[rssIdx] = st.RssKb
+	proc.fields[procsIdx] = 1
+	proc.fields[threadsIdx] = st.Threads
+	return
+}
+
+// parse reads the processes of /proc but its own, the processes exiting meanwhile being ignored.
+func (recordPtr *Record) parse() (err error) {
+	defer recordPtr.EndRead(time.Now())
+	entries, err := ioutil.ReadDir(procDir)
+	if err != nil {
+		return
+	}
+	recordPtr.Time = time.Now()
+	recordPtr.processes = make(map[int]process, len(recordPtr.processes))
+	self := hostproc.SelfPid() // not to count itself, as seen from the host too
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || pid == self {
+			continue
+		}
+		proc, err := parsePid(pid)
+		if os.IsNotExist(err) {
+			continue // exited since listed
+		}
+		if err != nil {
+			return err
+		}
+		recordPtr.processes[pid] = proc
+	}
+	recordPtr.sumUsers()
+	return
+}
+
+/* Polling */
+
+// Poll sends a Record in the channel at each sampling time of the schedule.
+// If cumul is false, it prints the diff of the accumulators, instead of the accumulators themselves
+// If rel is true, the cpu times of the diffs are given in percentage of the elapsed time
+func Poll(sched *schedule.Schedule, cumul bool, rel bool, cout chan Record) {
+	recordPtr := newRecord(true, false)
+	oldRecordPtr := newRecord(true, false)
+	diffRecordPtr := newRecord(false, rel)
+	for i := 0; sched.Next(); i++ {
+		err := recordPtr.parse()
+		if err != nil {
+			warn("Error parsing record, ignoring: ", err)
+			continue
+		}
+		if cumul {
+			cout <- *recordPtr
+		} else {
+			if i < 1 {
+				cout <- *recordPtr
+			} else {
+				recordPtr.diff(oldRecordPtr, diffRecordPtr)
+				if rel {
+					diffRecordPtr.rel(recordPtr.Time.Sub(oldRecordPtr.Time))
+				}
+				cout <- *diffRecordPtr
+			}
+			oldRecordPtr, recordPtr = recordPtr, oldRecordPtr
+		}
+	}
+	close(cout)
+}
@@ -0,0 +1,290 @@
+package wifistat
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"capture"
+	"internal/schedule"
+)
+
+const (
+	defaultProcNetWireless = "/proc/net/wireless"
+	Separator              = " "
+)
+
+const (
+	// Inter-| sta-|   Quality        |   Discarded packets               | Missed | WE
+	//  face | tus | link level noise |  nwid  crypt   frag  retry   misc | beacon | 22
+	statusIdx       = iota
+	linkIdx         = iota
+	levelIdx        = iota
+	noiseIdx        = iota
+	discardNwidIdx  = iota
+	discardCryptIdx = iota
+	discardFragIdx  = iota
+	discardRetryIdx = iota
+	discardMiscIdx  = iota
+	missedBeaconIdx = iota
+	fieldsCount     = iota
+)
+
+// The link quality is in the unit of the driver (out of 70 for most), the signal and noise
+// levels in dBm, negative, the discarded packets and missed beacons are counted since the
+// interface was brought up.
+var allFieldsDefs = []fieldDef{
+	fieldDef{"wifi", "status", false, false},
+	fieldDef{"quality", "link", false, false},
+	fieldDef{"quality", "level_dbm", false, true},
+	fieldDef{"quality", "noise_dbm", false, true},
+	fieldDef{"discard", "nwid", true, false},
+	fieldDef{"discard", "crypt", true, false},
+	fieldDef{"discard", "frag", true, false},
+	fieldDef{"discard", "retry", true, false},
+	fieldDef{"discard", "misc", true, false},
+	fieldDef{"missed", "beacon", true, false},
+}
+
+/* Header is a list of field names. */
+
+type header []string
+
+func makeHeader(fdl []fieldDef) header {
+	h := header(make([]string, 2+len(fdl)))
+	h[0] = "interface"
+	h[1] = "h"
+	for i, d := range fdl {
+		h[i+2] = d.String()
+	}
+	return h
+}
+
+func (h header) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	err = writeTo(w, strings.Join(h, Separator), &n)
+	return
+}
+
+var procNetWireless string = defaultProcNetWireless
+
+func warn(v ...interface{}) {
+	log.Print("WARNING: ", fmt.Sprint(v...))
+}
+
+func init() {
+	fsRoot := os.Getenv("FS_ROOT")
+	if fsRoot != "" {
+		procNetWireless = path.Join(fsRoot, defaultProcNetWireless)
+	}
+}
+
+// IsAvailable returns true if the kernel gives the statistics of the wireless interfaces.
+func IsAvailable() bool {
+	_, err := os.Stat(procNetWireless)
+	return err == nil
+}
+
+// parseLineToFields parses an interface line, as
+// "wlan0: 0000   54.  -56.  -256        0      0      0      0     12        0", the quality
+// values being followed by a dot when updated since the previous read.
+func (recordPtr *Record) parseLineToFields(line string) (err error) {
+	parsedFields := strings.Fields(line)
+	if len(parsedFields) < 1+fieldsCount || !strings.HasSuffix(parsedFields[0], ":") {
+		return
+	}
+	fields := make([]int64, fieldsCount)
+	fields[statusIdx], err = strconv.ParseInt(parsedFields[1], 16, 64)
+	if err != nil {
+		return
+	}
+	for i, str := range parsedFields[2 : 1+fieldsCount] {
+		fields[linkIdx+i], err = strconv.ParseInt(strings.TrimSuffix(str, "."), 10, 64)
+		if err != nil {
+			return
+		}
+	}
+	recordPtr.fieldsMap[strings.TrimSuffix(parsedFields[0], ":")] = fields
+	return
+}
+
+func writeTo(w io.Writer, v interface{}, p *int64) (err error) {
+	m, err := w.Write([]byte(fmt.Sprint(v)))
+	*p += int64(m)
+	return
+}
+
+/* Field Definition */
+
+type fieldDef struct {
+	category      string
+	name          string
+	isAccumulator bool
+	isSigned      bool
+}
+
+func (fd fieldDef) String() string { // implements fmt.Stringer
+	if fd.isAccumulator {
+		return fd.category + ":" + fd.name + "/a"
+	} else if fd.isSigned {
+		return fd.category + ":" + fd.name + capture.SignedSuffix
+	} else {
+		return fd.category + ":" + fd.name + "/i"
+	}
+}
+
+/* Record */
+
+var Header = makeHeader(allFieldsDefs)
+
+// Schema describes the fields of the records, for typed output.
+// The levels are negative: they are signed fields.
+var Schema = capture.Register(capture.Schema{Collector: "wifistat", Fields: Header[2:]})
+
+type Record struct {
+	capture.RecordInfo
+	isCumul   bool
+	fieldsMap map[string][]int64 // key is the interface
+}
+
+func newRecord(isCumul bool) *Record {
+	recordPtr := new(Record)
+	recordPtr.isCumul = isCumul
+	recordPtr.fieldsMap = make(map[string][]int64)
+	return recordPtr
+}
+
+func (record Record) interfaces() []string {
+	names := make([]string, 0, len(record.fieldsMap))
+	for name := range record.fieldsMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (recordPtr *Record) String() string { // implements fmt.Stringer
+	buf := new(bytes.Buffer)
+	recordPtr.WriteTo(buf)
+	return buf.String()
+}
+func (record Record) kind() string {
+	if record.isCumul {
+		return "a"
+	}
+	return "d"
+}
+func (record Record) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	for i, iface := range record.interfaces() {
+		if i > 0 {
+			err = writeTo(w, "\n", &n)
+			if err != nil {
+				return
+			}
+		}
+		err = writeTo(w, iface+Separator+record.kind(), &n)
+		if err != nil {
+			return
+		}
+		for _, field := range record.fieldsMap[iface] {
+			err = writeTo(w, Separator, &n)
+			if err != nil {
+				return
+			}
+			err = writeTo(w, field, &n)
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// Samples returns the typed form of the record, one sample per interface.
+func (record Record) Samples() []capture.Sample {
+	samples := make([]capture.Sample, 0, len(record.fieldsMap))
+	for _, iface := range record.interfaces() {
+		values := make([]uint64, fieldsCount)
+		for i, field := range record.fieldsMap[iface] {
+			values[i] = uint64(field)
+		}
+		samples = append(samples, capture.Sample{Time: record.Time, Instance: iface, Kind: record.kind(), Values: values})
+	}
+	return samples
+}
+
+// diff computes the diffs of the accumulators, an interface brought up since the previous
+// record having all its discarded packets and missed beacons counted in the interval.
+func (recordPtr *Record) diff(prevRecord, diffRecord *Record) {
+	diffRecord.RecordInfo = recordPtr.RecordInfo
+	diffRecord.fieldsMap = make(map[string][]int64, len(recordPtr.fieldsMap))
+	for iface, fields := range recordPtr.fieldsMap {
+		prevFields, ok := prevRecord.fieldsMap[iface]
+		diffFields := make([]int64, fieldsCount)
+		for i, field := range fields {
+			if allFieldsDefs[i].isAccumulator && ok {
+				diffFields[i] = field - prevFields[i]
+			} else {
+				diffFields[i] = field
+			}
+		}
+		diffRecord.fieldsMap[iface] = diffFields
+	}
+	return
+}
+
+func (recordPtr *Record) parse() (err error) {
+	defer recordPtr.EndRead(time.Now())
+	inFile, err := os.Open(procNetWireless)
+	if err != nil {
+		return
+	}
+	defer inFile.Close()
+	recordPtr.Time = time.Now()
+	recordPtr.fieldsMap = make(map[string][]int64, len(recordPtr.fieldsMap))
+	scanner := bufio.NewScanner(inFile)
+	for scanner.Scan() {
+		err = recordPtr.parseLineToFields(scanner.Text())
+		if err != nil {
+			return
+		}
+	}
+	err = scanner.Err()
+	return
+}
+
+/* Polling */
+
+// Poll sends a Record in the channel at each sampling time of the schedule.
+// If cumul is false, it prints the diff of the accumulators, instead of the accumulators themselves.
+func Poll(sched *schedule.Schedule, cumul bool, cout chan Record) {
+	recordPtr := newRecord(true)
+	oldRecordPtr := newRecord(true)
+	diffRecordPtr := newRecord(false)
+	for i := 0; sched.Next(); i++ {
+		err := recordPtr.parse()
+		if err != nil {
+			warn("Error parsing record, ignoring: ", err)
+			continue
+		}
+		if cumul {
+			cout <- *recordPtr
+		} else {
+			if i < 1 {
+				cout <- *recordPtr
+			} else {
+				recordPtr.diff(oldRecordPtr, diffRecordPtr)
+				cout <- *diffRecordPtr
+			}
+			oldRecordPtr, recordPtr = recordPtr, oldRecordPtr
+		}
+	}
+	close(cout)
+}
@@ -0,0 +1,317 @@
+package zoneinfo
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"capture"
+	"internal/schedule"
+)
+
+const (
+	defaultProcZoneinfo = "/proc/zoneinfo"
+	Separator           = " "
+)
+
+const (
+	freeIdx         = iota
+	minIdx          = iota
+	lowIdx          = iota
+	highIdx         = iota
+	activeAnonIdx   = iota
+	inactiveAnonIdx = iota
+	activeFileIdx   = iota
+	inactiveFileIdx = iota
+	fieldsCount     = iota
+)
+
+var allFieldsDefs = []fieldDef{
+	fieldDef{"pages", "free", false},
+	fieldDef{"wmark", "min", false},
+	fieldDef{"wmark", "low", false},
+	fieldDef{"wmark", "high", false},
+	fieldDef{"lru", "active_anon", false},
+	fieldDef{"lru", "inactive_anon", false},
+	fieldDef{"lru", "active_file", false},
+	fieldDef{"lru", "inactive_file", false},
+}
+
+func init() {
+	addLineDef("nr_free_pages", freeIdx)
+	addLineDef("min", minIdx)
+	addLineDef("low", lowIdx)
+	addLineDef("high", highIdx)
+	addLineDef("nr_zone_active_anon", activeAnonIdx)
+	addLineDef("nr_zone_inactive_anon", inactiveAnonIdx)
+	addLineDef("nr_zone_active_file", activeFileIdx)
+	addLineDef("nr_zone_inactive_file", inactiveFileIdx)
+	// per zone before Linux 4.8, per node since then
+	addLineDef("nr_active_anon", activeAnonIdx)
+	addLineDef("nr_inactive_anon", inactiveAnonIdx)
+	addLineDef("nr_active_file", activeFileIdx)
+	addLineDef("nr_inactive_file", inactiveFileIdx)
+}
+
+/* Header is a list of field names. */
+
+type header []string
+
+func makeHeader(fdl []fieldDef) header {
+	h := header(make([]string, 2+len(fdl)))
+	h[0] = "zone"
+	h[1] = "h"
+	for i, d := range fdl {
+		h[i+2] = d.String()
+	}
+	return h
+}
+
+func (h header) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	err = writeTo(w, strings.Join(h, Separator), &n)
+	return
+}
+
+var procZoneinfo string = defaultProcZoneinfo
+
+func warn(v ...interface{}) {
+	log.Print("WARNING: ", fmt.Sprint(v...))
+}
+
+func init() {
+	fsRoot := os.Getenv("FS_ROOT")
+	if fsRoot != "" {
+		procZoneinfo = path.Join(fsRoot, defaultProcZoneinfo)
+	}
+}
+
+func writeTo(w io.Writer, v interface{}, p *int64) (err error) {
+	m, err := w.Write([]byte(fmt.Sprint(v)))
+	*p += int64(m)
+	return
+}
+
+/* Field Definition */
+
+type fieldDef struct {
+	category      string
+	name          string
+	isAccumulator bool
+}
+
+func (fd fieldDef) String() string { // implements fmt.Stringer
+	if fd.isAccumulator {
+		return fd.category + ":" + fd.name + "/a"
+	} else {
+		return fd.category + ":" + fd.name + "/i"
+	}
+}
+
+/* Line definition */
+
+type lineDef struct {
+	prefix   string
+	fieldIdx uint
+}
+
+var linesDefs = make(map[string]lineDef, 2*fieldsCount)
+
+func addLineDef(prefix string, fieldIdx uint) {
+	linesDefs[prefix] = lineDef{prefix, fieldIdx}
+}
+
+/* Record */
+
+var Header = makeHeader(allFieldsDefs)
+
+// Schema describes the fields of the records, for typed output.
+var Schema = capture.Register(capture.Schema{Collector: "zoneinfo", Fields: Header[2:]})
+
+type Record struct {
+	capture.RecordInfo
+	isCumul   bool
+	fieldsMap map[string][]uint64 // key is the zone, as "node0/Normal"
+}
+
+func newRecord(isCumul bool) *Record {
+	recordPtr := new(Record)
+	recordPtr.isCumul = isCumul
+	recordPtr.fieldsMap = make(map[string][]uint64)
+	return recordPtr
+}
+
+func (record Record) zoneNames() []string {
+	names := make([]string, 0, len(record.fieldsMap))
+	for name := range record.fieldsMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (recordPtr *Record) String() string { // implements fmt.Stringer
+	buf := new(bytes.Buffer)
+	recordPtr.WriteTo(buf)
+	return buf.String()
+}
+func (record Record) kind() string {
+	if record.isCumul {
+		return "a"
+	}
+	return "d"
+}
+func (record Record) WriteTo(w io.Writer) (n int64, err error) { // implements io.WriterTo
+	for i, zone := range record.zoneNames() {
+		if i > 0 {
+			err = writeTo(w, "\n", &n)
+			if err != nil {
+				return
+			}
+		}
+		err = writeTo(w, zone+Separator+record.kind(), &n)
+		if err != nil {
+			return
+		}
+		for _, field := range record.fieldsMap[zone] {
+			err = writeTo(w, Separator, &n)
+			if err != nil {
+				return
+			}
+			err = writeTo(w, field, &n)
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// Samples returns the typed form of the record, one sample per zone.
+func (record Record) Samples() []capture.Sample {
+	samples := make([]capture.Sample, 0, len(record.fieldsMap))
+	for _, zone := range record.zoneNames() {
+		values := make([]uint64, fieldsCount)
+		copy(values, record.fieldsMap[zone])
+		samples = append(samples, capture.Sample{Time: record.Time, Instance: zone, Kind: record.kind(), Values: values})
+	}
+	return samples
+}
+func (recordPtr *Record) diff(prevRecord, diffRecord *Record) {
+	diffRecord.RecordInfo = recordPtr.RecordInfo
+	diffRecord.fieldsMap = make(map[string][]uint64, len(recordPtr.fieldsMap))
+	for zone, fields := range recordPtr.fieldsMap {
+		prevFields, ok := prevRecord.fieldsMap[zone]
+		if !ok {
+			prevFields = make([]uint64, fieldsCount)
+		}
+		diffFields := make([]uint64, fieldsCount)
+		for i, field := range fields {
+			if allFieldsDefs[i].isAccumulator {
+				diffFields[i] = field - prevFields[i]
+			} else {
+				diffFields[i] = field
+			}
+		}
+		diffRecord.fieldsMap[zone] = diffFields
+	}
+	return
+}
+
+// parseZoneName parses a zone line, as "Node 0, zone   Normal", into a zone name, as "node0/Normal".
+func parseZoneName(line string) (zone string, ok bool) {
+	parsedFields := strings.Fields(line)
+	if len(parsedFields) != 4 || parsedFields[0] != "Node" || parsedFields[2] != "zone" {
+		return
+	}
+	return "node" + strings.TrimSuffix(parsedFields[1], ",") + "/" + parsedFields[3], true
+}
+
+// parse reads the fields of the zones having managed pages. The statistics of the node given
+// at the top of its first zone ("per-node stats") are not attributed to the zone.
+func (recordPtr *Record) parse() (err error) {
+	defer recordPtr.EndRead(time.Now())
+	inFile, err := os.Open(procZoneinfo)
+	if err != nil {
+		return
+	}
+	defer inFile.Close()
+	recordPtr.Time = time.Now()
+	recordPtr.fieldsMap = make(map[string][]uint64, len(recordPtr.fieldsMap))
+	var zone string
+	var fields []uint64
+	inNodeStats := false
+	scanner := bufio.NewScanner(inFile)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if name, ok := parseZoneName(line); ok {
+			zone = name
+			fields = make([]uint64, fieldsCount)
+			recordPtr.fieldsMap[zone] = fields
+			inNodeStats = false
+			continue
+		}
+		if fields == nil {
+			continue
+		}
+		if strings.HasPrefix(line, "  ") && !strings.HasPrefix(line, "   ") { // section of the zone
+			inNodeStats = strings.TrimSpace(line) == "per-node stats"
+		}
+		parsedFields := strings.Fields(line)
+		if inNodeStats || len(parsedFields) != 2 {
+			continue
+		}
+		if parsedFields[0] == "managed" && parsedFields[1] == "0" { // unpopulated zone
+			delete(recordPtr.fieldsMap, zone)
+			fields = nil
+			continue
+		}
+		ld, ok := linesDefs[parsedFields[0]]
+		if !ok {
+			continue
+		}
+		fields[ld.fieldIdx], err = strconv.ParseUint(parsedFields[1], 10, 64)
+		if err != nil {
+			return
+		}
+	}
+	err = scanner.Err()
+	return
+}
+
+/* Polling */
+
+// Poll sends a Record in the channel at each sampling time of the schedule.
+// If cumul is false, it prints the diff of the accumulators, instead of the accumulators themselves
+// (all the fields being instant values, it only changes the kind of the records).
+func Poll(sched *schedule.Schedule, cumul bool, cout chan Record) {
+	recordPtr := newRecord(true)
+	oldRecordPtr := newRecord(true)
+	diffRecordPtr := newRecord(false)
+	for i := 0; sched.Next(); i++ {
+		err := recordPtr.parse()
+		if err != nil {
+			warn("Error parsing record, ignoring: ", err)
+			continue
+		}
+		if cumul {
+			cout <- *recordPtr
+		} else {
+			if i < 1 {
+				cout <- *recordPtr
+			} else {
+				recordPtr.diff(oldRecordPtr, diffRecordPtr)
+				cout <- *diffRecordPtr
+			}
+			oldRecordPtr, recordPtr = recordPtr, oldRecordPtr
+		}
+	}
+	close(cout)
+}
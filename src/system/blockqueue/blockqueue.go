@@ -0,0 +1,95 @@
+// Package blockqueue reads the queue settings of the block devices, to annotate the captures
+// with the settings in effect, as comparisons of runs are meaningless if they changed.
+package blockqueue
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+const (
+	defaultSysBlockDir = "/sys/block"
+	CommentPrefix      = "# queue:"
+)
+
+var sysBlockDir string = defaultSysBlockDir
+
+func init() {
+	fsRoot := os.Getenv("FS_ROOT")
+	if fsRoot != "" {
+		sysBlockDir = path.Join(fsRoot, defaultSysBlockDir)
+	}
+}
+
+// the settings read, in the queue directory of each device
+var settingsNames = []string{"scheduler", "nr_requests", "read_ahead_kb"}
+
+// Settings are the queue settings of each device, as "scheduler=mq-deadline nr_requests=64 read_ahead_kb=128".
+type Settings map[string]string
+
+// readSetting returns the value of a setting, the scheduler in use being given in brackets in
+// the list of the available ones, as "[mq-deadline] kyber none".
+func readSetting(fileName string) (string, error) {
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return "", err
+	}
+	value := strings.TrimSpace(string(content))
+	if start := strings.IndexByte(value, '['); start >= 0 {
+		if end := strings.IndexByte(value[start:], ']'); end >= 0 {
+			value = value[start+1 : start+end]
+		}
+	}
+	return value, nil
+}
+
+// Read reads the queue settings of the block devices, the settings not available for a device
+// being left out.
+func Read() (settings Settings, err error) {
+	entries, err := ioutil.ReadDir(sysBlockDir)
+	if err != nil {
+		return
+	}
+	settings = make(Settings, len(entries))
+	for _, entry := range entries {
+		var values []string
+		for _, name := range settingsNames {
+			value, err := readSetting(path.Join(sysBlockDir, entry.Name(), "queue", name))
+			if err != nil {
+				continue
+			}
+			values = append(values, name+"="+value)
+		}
+		if len(values) > 0 {
+			settings[entry.Name()] = strings.Join(values, " ")
+		}
+	}
+	return
+}
+
+// Changed returns the devices of which the settings differ from the previous ones, in order.
+func (settings Settings) Changed(prev Settings) (devices []string) {
+	for device, values := range settings {
+		if prev[device] != values {
+			devices = append(devices, device)
+		}
+	}
+	sort.Strings(devices)
+	return
+}
+
+// Write writes the settings of the devices as comment lines ("# queue:sda: scheduler=none ...").
+func (settings Settings) Write(w io.Writer, devices []string) (err error) {
+	for _, device := range devices {
+		_, err = fmt.Fprint(w, CommentPrefix, device, ": ", settings[device], "\n")
+		if err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,82 @@
+package command
+
+import (
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+)
+
+// Command is a command run by a tool, which monitors the system for the command's lifetime.
+type Command struct {
+	cmd      *exec.Cmd
+	done     chan struct{}
+	exitCode int
+}
+
+// Start starts the command given by args, with the standard input and error of the tool and
+// stdout as standard output, then calls exited once the command has exited.
+// The interrupt and termination signals are forwarded to the command: the tool stops when the
+// command exits.
+func Start(args []string, stdout io.Writer, exited func()) (c *Command, err error) {
+	c = &Command{cmd: exec.Command(args[0], args[1:]...), done: make(chan struct{})}
+	c.cmd.Stdin = os.Stdin
+	c.cmd.Stdout = stdout
+	c.cmd.Stderr = os.Stderr
+	err = c.cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go c.forward(signals)
+	go func() {
+		err := c.cmd.Wait()
+		c.exitCode = exitCode(c.cmd.ProcessState, err)
+		signal.Stop(signals)
+		close(c.done)
+		exited()
+	}()
+	return
+}
+
+// forward sends the signals received by the tool to the command, until it has exited.
+func (c *Command) forward(signals chan os.Signal) {
+	for {
+		select {
+		case sig := <-signals:
+			c.cmd.Process.Signal(sig)
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// exitCode returns the exit code of the command, as a shell would: 128+n if killed by signal n.
+func exitCode(state *os.ProcessState, err error) int {
+	if state == nil {
+		log.Print("WARNING: Error waiting for command: ", err)
+		return 1
+	}
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return state.ExitCode()
+}
+
+// Pid returns the process id of the command.
+func (c *Command) Pid() int {
+	return c.cmd.Process.Pid
+}
+
+// ExitCode waits for the command to exit, and returns its exit code.
+// A nil *Command returns 0.
+func (c *Command) ExitCode() int {
+	if c == nil {
+		return 0
+	}
+	<-c.done
+	return c.exitCode
+}
@@ -0,0 +1,151 @@
+package environ
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultProcDir = "/proc"
+	CommentPrefix  = "# "
+)
+
+var procDir string = defaultProcDir
+
+func init() {
+	fsRoot := os.Getenv("FS_ROOT")
+	if fsRoot != "" {
+		procDir = path.Join(fsRoot, defaultProcDir)
+	}
+}
+
+// Entry is one item of the description of the environment.
+type Entry struct {
+	Key   string
+	Value string
+}
+
+func readTrimmed(name string) (string, error) {
+	b, err := ioutil.ReadFile(path.Join(procDir, name))
+	return strings.TrimSpace(string(b)), err
+}
+
+// cpuInfo returns the model of the first cpu and the number of cpus listed in /proc/cpuinfo.
+func cpuInfo() (model string, count int, err error) {
+	inFile, err := os.Open(path.Join(procDir, "cpuinfo"))
+	if err != nil {
+		return
+	}
+	defer inFile.Close()
+	scanner := bufio.NewScanner(inFile)
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch strings.TrimSpace(kv[0]) {
+		case "processor":
+			count++
+		case "model name":
+			if model == "" {
+				model = strings.TrimSpace(kv[1])
+			}
+		}
+	}
+	err = scanner.Err()
+	return
+}
+
+// memTotal returns the MemTotal line value of /proc/meminfo (e.g. "16318412 kB").
+func memTotal() (string, error) {
+	inFile, err := os.Open(path.Join(procDir, "meminfo"))
+	if err != nil {
+		return "", err
+	}
+	defer inFile.Close()
+	scanner := bufio.NewScanner(inFile)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "MemTotal:") {
+			return strings.TrimSpace(line[len("MemTotal:"):]), nil
+		}
+	}
+	return "", scanner.Err()
+}
+
+func interfaces() (string, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return "", err
+	}
+	names := make([]string, len(ifaces))
+	for i, iface := range ifaces {
+		names[i] = iface.Name
+	}
+	return strings.Join(names, ","), nil
+}
+
+// containerRuntime guesses the container runtime we are running in, "none" if not found.
+func containerRuntime() string {
+	if _, err := os.Stat("/.dockerenv"); err == nil {
+		return "docker"
+	}
+	if _, err := os.Stat("/run/.containerenv"); err == nil {
+		return "podman"
+	}
+	if container := os.Getenv("container"); container != "" {
+		return container // set by systemd-nspawn, lxc, ...
+	}
+	cgroup, err := readTrimmed("1/cgroup")
+	if err == nil {
+		for _, name := range []string{"kubepods", "docker", "containerd", "lxc"} {
+			if strings.Contains(cgroup, name) {
+				return name
+			}
+		}
+	}
+	return "none"
+}
+
+// Describe gathers the description of the environment.
+// Items that cannot be determined are left out.
+func Describe() (entries []Entry) {
+	add := func(key, value string, err error) {
+		if err == nil && value != "" {
+			entries = append(entries, Entry{key, value})
+		}
+	}
+	hostname, err := os.Hostname()
+	add("hostname", hostname, err)
+	add("os", runtime.GOOS+"/"+runtime.GOARCH, nil)
+	osrelease, err := readTrimmed("sys/kernel/osrelease")
+	add("kernel", osrelease, err)
+	model, count, err := cpuInfo()
+	add("cpu_model", model, err)
+	add("cpu_count", strconv.Itoa(count), err)
+	mem, err := memTotal()
+	add("mem_total", mem, err)
+	ifaces, err := interfaces()
+	add("interfaces", ifaces, err)
+	add("container", containerRuntime(), nil)
+	return
+}
+
+// Write writes the description of the environment as comment lines ("# key: value").
+func Write(w io.Writer) (err error) {
+	for _, entry := range Describe() {
+		_, err = fmt.Fprint(w, CommentPrefix, entry.Key, ": ", entry.Value, "\n")
+		if err != nil {
+			return
+		}
+	}
+	return
+}
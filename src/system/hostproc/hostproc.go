@@ -0,0 +1,86 @@
+// Package hostproc resolves what depends on the pid namespace or on the users of the host, when
+// the processes are read from the /proc of the host mounted in a container (FS_ROOT), so that
+// the records give what an admin sees from the host, not what the container sees.
+package hostproc
+
+import (
+	"bufio"
+	"os"
+	"os/user"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	defaultProcDir   = "/proc"
+	defaultEtcPasswd = "/etc/passwd"
+)
+
+var procDir string = defaultProcDir
+var etcPasswd string // the users of the host, if not those of the running process
+
+func init() {
+	fsRoot := os.Getenv("FS_ROOT")
+	if fsRoot != "" {
+		procDir = path.Join(fsRoot, defaultProcDir)
+		etcPasswd = path.Join(fsRoot, defaultEtcPasswd)
+	}
+}
+
+// SelfPid returns the pid of the running process as seen in the /proc read, the pid in the
+// namespace of the host when it is the /proc of the host mounted in a container: "self" is
+// resolved by the kernel in the pid namespace of the procfs mount.
+func SelfPid() int {
+	link, err := os.Readlink(path.Join(procDir, "self"))
+	if err == nil {
+		if pid, err := strconv.Atoi(link); err == nil && pid > 0 {
+			return pid
+		}
+	}
+	return os.Getpid() // not visible from this namespace, as not a pid then
+}
+
+var userNames = make(map[string]string) // per uid
+var userNamesMutex sync.Mutex
+
+// UserName returns the name of the user of the uid, from the users of the host with FS_ROOT,
+// or the uid itself if unknown. The names are read once per uid.
+func UserName(uid string) string {
+	userNamesMutex.Lock()
+	defer userNamesMutex.Unlock()
+	name, ok := userNames[uid]
+	if !ok {
+		name = lookupUserName(uid)
+		if name == "" {
+			name = uid
+		}
+		userNames[uid] = name
+	}
+	return name
+}
+
+// lookupUserName returns the name of the user of the uid, or an empty string if unknown.
+func lookupUserName(uid string) string {
+	if etcPasswd == "" {
+		if u, err := user.LookupId(uid); err == nil {
+			return u.Username
+		}
+		return ""
+	}
+	inFile, err := os.Open(etcPasswd)
+	if err != nil {
+		return ""
+	}
+	defer inFile.Close()
+	scanner := bufio.NewScanner(inFile)
+	for scanner.Scan() {
+		// name:password:uid:gid:gecos:home:shell
+		parsedFields := strings.Split(scanner.Text(), ":")
+		if len(parsedFields) > 2 && parsedFields[2] == uid {
+			return parsedFields[0]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,104 @@
+package hostproc
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"system/getconf"
+)
+
+// columns of /proc/<pid>/stat, counting from the state, after the command name
+const (
+	minfltCol  = 7
+	majfltCol  = 9
+	utimeCol   = 11
+	stimeCol   = 12
+	threadsCol = 17
+	vsizeCol   = 20 // in bytes
+	rssCol     = 21 // in pages
+)
+
+var clkTck uint64 = 100
+var clkTckOnce sync.Once
+var pageSizeKb uint64 = uint64(os.Getpagesize()) / 1024
+
+// ClkTck returns the number of clock ticks per second (USER_HZ) of the cpu times of the
+// processes, read from the system conf at the first call.
+func ClkTck() uint64 {
+	clkTckOnce.Do(func() {
+		res, err := getconf.GetClkTck()
+		if err != nil {
+			log.Printf("WARNING: Error getting CLK_TCK from system conf, using default value (%d): %s", clkTck, err)
+		} else {
+			clkTck = uint64(res)
+		}
+	})
+	return clkTck
+}
+
+// Stat is what the collectors read of /proc/<pid>/stat, the cpu times in clock ticks (see
+// ClkTck).
+type Stat struct {
+	Minflt, Majflt uint64
+	Utime, Stime   uint64
+	Threads        uint64
+	VszKb, RssKb   uint64
+}
+
+// ReadStat reads /proc/<pid>/stat, skipping the command name, which may contain spaces.
+func ReadStat(pid int) (st Stat, err error) {
+	fileName := path.Join(procDir, strconv.Itoa(pid), "stat")
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return
+	}
+	end := bytes.LastIndexByte(content, ')')
+	if end < 0 {
+		err = fmt.Errorf("unexpected content of %s: %q", fileName, content)
+		return
+	}
+	parsedFields := strings.Fields(string(content[end+1:]))
+	if len(parsedFields) <= rssCol {
+		err = fmt.Errorf("unexpected content of %s: %q", fileName, content)
+		return
+	}
+	values := make(map[int]uint64, 7)
+	for _, col := range []int{minfltCol, majfltCol, utimeCol, stimeCol, threadsCol, vsizeCol, rssCol} {
+		values[col], err = strconv.ParseUint(parsedFields[col], 10, 64)
+		if err != nil {
+			return
+		}
+	}
+	st.Minflt = values[minfltCol]
+	st.Majflt = values[majfltCol]
+	st.Utime = values[utimeCol]
+	st.Stime = values[stimeCol]
+	st.Threads = values[threadsCol]
+	st.VszKb = values[vsizeCol] / 1024
+	st.RssKb = values[rssCol] * pageSizeKb
+	return
+}
+
+// ReadUid returns the real uid of a process, from the "Uid:	1000	1000	1000	1000" line of its
+// status.
+func ReadUid(pid int) (uid string, err error) {
+	fileName := path.Join(procDir, strconv.Itoa(pid), "status")
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		parsedFields := strings.Fields(line)
+		if len(parsedFields) > 1 && parsedFields[0] == "Uid:" {
+			return parsedFields[1], nil
+		}
+	}
+	return "", fmt.Errorf("no Uid line in %s", fileName)
+}
@@ -0,0 +1,52 @@
+package nft
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+)
+
+const (
+	defaultNftCmd = "nft"
+)
+
+var nftCmd string = defaultNftCmd
+
+func init() {
+	nftCmd_var := os.Getenv("NFT_CMD")
+	if nftCmd_var != "" {
+		nftCmd = nftCmd_var
+	}
+}
+
+// Counter is a named counter object of nftables.
+type Counter struct {
+	Family  string `json:"family"`
+	Table   string `json:"table"`
+	Name    string `json:"name"`
+	Packets uint64 `json:"packets"`
+	Bytes   uint64 `json:"bytes"`
+}
+
+// ListCounters returns all the named counters, as listed by "nft -j list counters".
+func ListCounters() (counters []Counter, err error) {
+	out, err := exec.Command(nftCmd, "-j", "list", "counters").Output()
+	if err != nil {
+		return
+	}
+	var ruleset struct {
+		Nftables []struct {
+			Counter *Counter `json:"counter"`
+		} `json:"nftables"`
+	}
+	err = json.Unmarshal(out, &ruleset)
+	if err != nil {
+		return
+	}
+	for _, object := range ruleset.Nftables {
+		if object.Counter != nil {
+			counters = append(counters, *object.Counter)
+		}
+	}
+	return
+}
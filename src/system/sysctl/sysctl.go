@@ -0,0 +1,96 @@
+// Package sysctl reads kernel parameters, to annotate the captures with the values in effect
+// and with their changes during the run, as comparisons of runs are meaningless if they were
+// tuned meanwhile.
+package sysctl
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+const (
+	defaultProcSysDir = "/proc/sys"
+	CommentPrefix     = "# sysctl:"
+)
+
+var procSysDir string = defaultProcSysDir
+
+func init() {
+	fsRoot := os.Getenv("FS_ROOT")
+	if fsRoot != "" {
+		procSysDir = path.Join(fsRoot, defaultProcSysDir)
+	}
+}
+
+// Values are the values of the parameters, per name (as "net.core.somaxconn"), the values of
+// several numbers (as net.ipv4.tcp_rmem) being separated by single spaces.
+type Values map[string]string
+
+// ParseNames returns the names of a comma separated list, as "net.core.somaxconn,vm.swappiness".
+func ParseNames(list string) (names []string) {
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return
+}
+
+// fileName returns the path of a parameter, the dots of its name being separators of directories.
+func fileName(name string) string {
+	return path.Join(procSysDir, strings.Replace(name, ".", "/", -1))
+}
+
+// Read reads the values of the parameters.
+func Read(names []string) (values Values, err error) {
+	values = make(Values, len(names))
+	for _, name := range names {
+		content, err := ioutil.ReadFile(fileName(name))
+		if err != nil {
+			return nil, err
+		}
+		values[name] = strings.Join(strings.Fields(string(content)), " ")
+	}
+	return
+}
+
+// Changed returns the names of the parameters of which the values differ from the previous
+// ones, in order.
+func (values Values) Changed(prev Values) (names []string) {
+	for name, value := range values {
+		if prev[name] != value {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return
+}
+
+// Write writes the values of the parameters as comment lines ("# sysctl:vm.swappiness: 60").
+func (values Values) Write(w io.Writer, names []string) (err error) {
+	for _, name := range names {
+		_, err = fmt.Fprint(w, CommentPrefix, name, ": ", values[name], "\n")
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// WriteDrift writes the parameters changed since the previous values as comment lines, with
+// their previous values ("# sysctl:net.core.somaxconn: 4096 (was 128)").
+func (values Values) WriteDrift(w io.Writer, prev Values) (err error) {
+	for _, name := range values.Changed(prev) {
+		_, err = fmt.Fprint(w, CommentPrefix, name, ": ", values[name], " (was ", prev[name], ")\n")
+		if err != nil {
+			return
+		}
+	}
+	return
+}
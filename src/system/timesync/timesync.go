@@ -0,0 +1,41 @@
+package timesync
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Status is the clock synchronization status, as maintained in the kernel by ntpd or chronyd.
+type Status struct {
+	Synced   bool
+	Offset   time.Duration // estimated offset from the reference clock
+	EstError time.Duration // estimated error
+}
+
+// Header is the list of the names of the fields written by Write.
+var Header = []string{"sync:synced/i", "sync:offset_us/i"}
+
+var warnOnce sync.Once
+
+// Write writes the fields of the current status, each followed by sep.
+// If the status is not available, the fields are written as "-" (and a warning is logged once).
+func Write(w io.Writer, sep string) (err error) {
+	st, err := Get()
+	if err != nil {
+		warnOnce.Do(func() {
+			log.Print("WARNING: Error getting clock synchronization status: ", err)
+		})
+		_, err = io.WriteString(w, strings.Repeat("-"+sep, len(Header)))
+		return
+	}
+	synced := 0
+	if st.Synced {
+		synced = 1
+	}
+	_, err = fmt.Fprint(w, synced, sep, int64(st.Offset/time.Microsecond), sep)
+	return
+}
@@ -0,0 +1,29 @@
+package timesync
+
+import (
+	"syscall"
+	"time"
+)
+
+const (
+	staUnsync = 0x0040 // clock unsynchronized
+	staNano   = 0x2000 // offset in ns instead of us
+	timeError = 5      // clock not synchronized
+)
+
+// Get reads the status with adjtimex(2), without modifying anything.
+func Get() (st Status, err error) {
+	var tx syscall.Timex
+	state, err := syscall.Adjtimex(&tx)
+	if err != nil {
+		return
+	}
+	st.Synced = state != timeError && tx.Status&staUnsync == 0
+	if tx.Status&staNano != 0 {
+		st.Offset = time.Duration(tx.Offset)
+	} else {
+		st.Offset = time.Duration(tx.Offset) * time.Microsecond
+	}
+	st.EstError = time.Duration(tx.Esterror) * time.Microsecond
+	return
+}
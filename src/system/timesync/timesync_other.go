@@ -0,0 +1,11 @@
+//go:build !linux
+
+package timesync
+
+import (
+	"errors"
+)
+
+func Get() (Status, error) {
+	return Status{}, errors.New("Not supported on this platform")
+}